@@ -0,0 +1,148 @@
+// Package bip21 implements payment URIs, as defined in
+// https://github.com/bitcoin/bips/blob/master/bip-0021.mediawiki
+//
+//     bitcoin:<address>[?amount=<amount>][&label=<label>][&message=<message>]
+package bip21
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/VIVelev/btcd/btcutil"
+	"github.com/VIVelev/btcd/encoding"
+)
+
+const scheme = "bitcoin:"
+
+// URI is a parsed payment request.
+type URI struct {
+	Address   string
+	Amount    btcutil.Amount // 0 if not requested
+	Label     string
+	Message   string
+	Lightning string // BOLT11 invoice, for unified payment requests
+
+	// Other optional parameters, which are not interpreted.
+	Params map[string]string
+}
+
+// Parse parses and validates the payment URI.
+//
+// Returns error if the address is invalid, the amount is malformed,
+// or there is a required ("req-") parameter which is not understood.
+func Parse(s string) (*URI, error) {
+	if len(s) < len(scheme) || !strings.EqualFold(s[:len(scheme)], scheme) {
+		return nil, errors.New("Parse: the URI should start with " + scheme)
+	}
+	s = s[len(scheme):]
+
+	u := &URI{Params: make(map[string]string)}
+	var query string
+	if i := strings.IndexByte(s, '?'); i >= 0 {
+		s, query = s[:i], s[i+1:]
+	}
+	u.Address = s
+
+	seen := make(map[string]bool)
+	for _, param := range strings.Split(query, "&") {
+		if param == "" {
+			continue
+		}
+		kv := strings.SplitN(param, "=", 2)
+		key, err := url.PathUnescape(kv[0])
+		if err != nil {
+			return nil, err
+		}
+		var value string
+		if len(kv) == 2 {
+			if value, err = url.PathUnescape(kv[1]); err != nil {
+				return nil, err
+			}
+		}
+		if seen[key] {
+			return nil, errors.New("Parse: duplicate parameter " + key)
+		}
+		seen[key] = true
+
+		switch key {
+		case "amount":
+			if u.Amount, err = parseAmount(value); err != nil {
+				return nil, err
+			}
+		case "label":
+			u.Label = value
+		case "message":
+			u.Message = value
+		case "lightning":
+			u.Lightning = value
+		default:
+			if strings.HasPrefix(key, "req-") {
+				return nil, errors.New("Parse: unsupported required parameter " + key)
+			}
+			u.Params[key] = value
+		}
+	}
+
+	if u.Address == "" && u.Lightning == "" {
+		return nil, errors.New("Parse: missing address")
+	}
+	if u.Address != "" && !isValidAddress(u.Address) {
+		return nil, errors.New("Parse: invalid address " + u.Address)
+	}
+	return u, nil
+}
+
+func isValidAddress(addr string) bool {
+	if _, _, _, err := encoding.DecodeSegWitAddress(addr); err == nil {
+		return true
+	}
+	_, _, _, err := encoding.DecodeBase58Address(addr)
+	return err == nil
+}
+
+// parseAmount parses the decimal amount in BTC.
+func parseAmount(s string) (btcutil.Amount, error) {
+	a, err := btcutil.ParseAmountUnit(s, btcutil.AmountBTC)
+	if err != nil || !a.IsValid() {
+		return 0, errors.New("Parse: invalid amount " + s)
+	}
+	return a, nil
+}
+
+func escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// String returns the URI, the optional parameters are ordered by their names.
+func (u *URI) String() string {
+	var params []string
+	if u.Amount != 0 {
+		params = append(params, "amount="+u.Amount.DecimalString(btcutil.AmountBTC))
+	}
+	if u.Label != "" {
+		params = append(params, "label="+escape(u.Label))
+	}
+	if u.Message != "" {
+		params = append(params, "message="+escape(u.Message))
+	}
+	if u.Lightning != "" {
+		params = append(params, "lightning="+escape(u.Lightning))
+	}
+
+	keys := make([]string, 0, len(u.Params))
+	for k := range u.Params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		params = append(params, escape(k)+"="+escape(u.Params[k]))
+	}
+
+	s := scheme + u.Address
+	if len(params) > 0 {
+		s += "?" + strings.Join(params, "&")
+	}
+	return s
+}
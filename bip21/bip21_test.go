@@ -0,0 +1,88 @@
+package bip21
+
+import (
+	"testing"
+
+	"github.com/VIVelev/btcd/btcutil"
+)
+
+func TestParse(t *testing.T) {
+	// examples from BIP21, with a valid address
+	u, err := Parse("bitcoin:1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2?amount=50&label=Luke-Jr&message=Donation%20for%20project%20xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Address != "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2" || u.Amount != 5000000000 ||
+		u.Label != "Luke-Jr" || u.Message != "Donation for project xyz" {
+		t.Errorf("FAIL")
+	}
+
+	u, err = Parse("bitcoin:1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2?somethingyoudontunderstand=50&somethingelseyoudontget=999")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Params["somethingyoudontunderstand"] != "50" || u.Params["somethingelseyoudontget"] != "999" {
+		t.Errorf("FAIL")
+	}
+
+	u, err = Parse("BITCOIN:BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4?amount=0.00000001&lightning=lnbc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Amount != 1 || u.Lightning != "lnbc1" {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, s := range []string{
+		"bitcoin:1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2?req-somethingyoudontunderstand=50&req-somethingelseyoudontget=999",
+		"bitcoin:1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN3", // bad checksum
+		"bitcoin:1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2?amount=0.000000001",
+		"bitcoin:1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2?amount=1e3",
+		"bitcoin:1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2?amount=-1",
+		"bitcoin:1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2?amount=.",
+		"bitcoin:1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2?amount=21000000.00000001",
+		"bitcoin:1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2?label=a&label=b",
+		"bitcoin:",
+		"litecoin:1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2",
+	} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("FAIL: %s", s)
+		}
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	for s, want := range map[string]btcutil.Amount{
+		"20.3":       2030000000,
+		"0.1":        10000000,
+		".5":         50000000,
+		"1.":         100000000,
+		"0.12345678": 12345678,
+		"21000000":   2100000000000000,
+	} {
+		if got, err := parseAmount(s); err != nil || got != want {
+			t.Errorf("FAIL: %s", s)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	u := &URI{
+		Address: "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2",
+		Amount:  2030000000,
+		Label:   "Luke-Jr",
+		Message: "Donation for project xyz & more",
+		Params:  map[string]string{"b": "2", "a": "1"},
+	}
+	want := "bitcoin:1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2?amount=20.3&label=Luke-Jr&message=Donation%20for%20project%20xyz%20%26%20more&a=1&b=2"
+	if u.String() != want {
+		t.Errorf("FAIL: %s", u.String())
+	}
+
+	parsed, err := Parse(u.String())
+	if err != nil || parsed.Message != u.Message || parsed.Amount != u.Amount {
+		t.Errorf("FAIL")
+	}
+}
@@ -0,0 +1,94 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/VIVelev/btcd/utils"
+)
+
+// jsonBlock follows the layout of Bitcoin Core's getblockheader RPC.
+type jsonBlock struct {
+	Hash              string  `json:"hash"`
+	Version           uint32  `json:"version"`
+	VersionHex        string  `json:"versionHex"`
+	MerkleRoot        string  `json:"merkleroot"`
+	Time              uint32  `json:"time"`
+	Nonce             uint32  `json:"nonce"`
+	Bits              string  `json:"bits"`
+	Target            string  `json:"target"`
+	Difficulty        float64 `json:"difficulty"`
+	PreviousBlockHash string  `json:"previousblockhash,omitempty"`
+}
+
+// difficulty returns the difficulty with its fractional part, as Bitcoin Core reports it.
+func (b *Block) difficulty() float64 {
+	lowest := big.NewInt(0xffff)
+	power := new(big.Int).Exp(big.NewInt(256), big.NewInt(0x1d-3), nil)
+	lowest.Mul(lowest, power)
+	d, _ := new(big.Float).Quo(new(big.Float).SetInt(lowest), new(big.Float).SetInt(b.Target())).Float64()
+	return d
+}
+
+// MarshalJSON encodes the block header with its hash, target and difficulty.
+// The previous block hash is omitted for a genesis block.
+func (b *Block) MarshalJSON() ([]byte, error) {
+	v := jsonBlock{
+		Hash:       b.Id(),
+		Version:    b.Version,
+		VersionHex: fmt.Sprintf("%08x", b.Version),
+		MerkleRoot: hex.EncodeToString(b.MerkleRoot[:]),
+		Time:       b.Timestamp,
+		Nonce:      binary.LittleEndian.Uint32(b.Nonce[:]),
+		Bits:       hex.EncodeToString(utils.Reversed(b.Bits[:])),
+		Target:     fmt.Sprintf("%064x", b.Target()),
+		Difficulty: b.difficulty(),
+	}
+	if b.PrevBlock != [32]byte{} {
+		v.PreviousBlockHash = hex.EncodeToString(b.PrevBlock[:])
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON decodes the block header from its fields.
+//
+// Returns error if the given hash does not match the decoded header.
+func (b *Block) UnmarshalJSON(data []byte) error {
+	var v jsonBlock
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	*b = Block{Version: v.Version, Timestamp: v.Time}
+	binary.LittleEndian.PutUint32(b.Nonce[:], v.Nonce)
+	for _, f := range []struct {
+		s   string
+		dst []byte
+	}{
+		{v.MerkleRoot, b.MerkleRoot[:]},
+		{v.Bits, b.Bits[:]},
+	} {
+		h, err := hex.DecodeString(f.s)
+		if err != nil || len(h) != len(f.dst) {
+			return errors.New("Block.UnmarshalJSON: invalid hex field " + f.s)
+		}
+		copy(f.dst, h)
+	}
+	copy(b.Bits[:], utils.Reversed(b.Bits[:]))
+	if v.PreviousBlockHash != "" {
+		h, err := hex.DecodeString(v.PreviousBlockHash)
+		if err != nil || len(h) != 32 {
+			return errors.New("Block.UnmarshalJSON: invalid previousblockhash")
+		}
+		copy(b.PrevBlock[:], h)
+	}
+
+	if v.Hash != "" && v.Hash != b.Id() {
+		return errors.New("Block.UnmarshalJSON: hash mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestMarshalJSON(t *testing.T) {
+	block := new(Block).Unmarshal(bytes.NewReader(MainGenesisBlockBytes))
+	b, err := json.Marshal(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"hash":"000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f","version":1,"versionHex":"00000001",` +
+		`"merkleroot":"4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b","time":1231006505,"nonce":2083236893,` +
+		`"bits":"1d00ffff","target":"00000000ffff0000000000000000000000000000000000000000000000000000","difficulty":1}`
+	if string(b) != want {
+		t.Errorf("FAIL: %s", b)
+	}
+
+	var decoded Block
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	m := decoded.Marshal()
+	if !bytes.Equal(m[:], MainGenesisBlockBytes) {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	block := new(Block).Unmarshal(bytes.NewReader(blockBytes))
+	b, _ := json.Marshal(block)
+	var decoded Block
+	if err := json.Unmarshal(b, &decoded); err != nil || decoded != *block {
+		t.Errorf("FAIL")
+	}
+
+	var v map[string]interface{}
+	json.Unmarshal(b, &v)
+	v["nonce"] = 0
+	b, _ = json.Marshal(v)
+	if err := json.Unmarshal(b, &decoded); err == nil {
+		t.Errorf("FAIL")
+	}
+}
@@ -0,0 +1,218 @@
+// Package btcutil provides bitcoin specific convenience types.
+package btcutil
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Amount is a quantity of bitcoin in satoshi (1e-8 of a bitcoin).
+//
+// Amounts are exact, they are parsed and formatted as decimals, without going through floats.
+type Amount int64
+
+const (
+	// SatoshiPerBitcoin is the number of satoshi in one bitcoin.
+	SatoshiPerBitcoin Amount = 1e8
+	// MaxMoney is the maximum amount of satoshi that can ever exist, MAX_MONEY in Bitcoin Core.
+	MaxMoney Amount = 21e6 * SatoshiPerBitcoin
+)
+
+// AmountUnit is a unit in which amounts are parsed and formatted.
+type AmountUnit int
+
+const (
+	AmountBTC AmountUnit = iota
+	AmountMilliBTC
+	AmountMicroBTC
+	AmountSatoshi
+)
+
+// decimals is the number of satoshi digits after the decimal point in each unit.
+var decimals = map[AmountUnit]int{
+	AmountBTC:      8,
+	AmountMilliBTC: 5,
+	AmountMicroBTC: 2,
+	AmountSatoshi:  0,
+}
+
+// String returns the symbol of the unit.
+func (u AmountUnit) String() string {
+	switch u {
+	case AmountBTC:
+		return "BTC"
+	case AmountMilliBTC:
+		return "mBTC"
+	case AmountMicroBTC:
+		return "µBTC"
+	case AmountSatoshi:
+		return "sat"
+	}
+	return "unknown unit"
+}
+
+// units are the symbols accepted by ParseAmount, in lower case.
+var units = map[string]AmountUnit{
+	"btc":     AmountBTC,
+	"mbtc":    AmountMilliBTC,
+	"µbtc":    AmountMicroBTC,
+	"ubtc":    AmountMicroBTC,
+	"sat":     AmountSatoshi,
+	"sats":    AmountSatoshi,
+	"satoshi": AmountSatoshi,
+}
+
+// ParseAmount parses a decimal amount, optionally followed by a unit, for example
+// "0.00012345 BTC", "1.5 mBTC" or "12345 sat". Amounts without a unit are in BTC.
+//
+// Returns error if the amount has more decimals than the unit allows,
+// or is outside of the range [-MaxMoney, MaxMoney].
+func ParseAmount(s string) (Amount, error) {
+	fields := strings.Fields(s)
+	switch len(fields) {
+	case 1:
+		return ParseAmountUnit(fields[0], AmountBTC)
+	case 2:
+		u, ok := units[strings.ToLower(fields[1])]
+		if !ok {
+			return 0, errors.New("ParseAmount: unknown unit " + fields[1])
+		}
+		return ParseAmountUnit(fields[0], u)
+	}
+	return 0, errors.New("ParseAmount: invalid amount " + s)
+}
+
+// ParseAmountUnit parses the decimal number s, given in the unit.
+func ParseAmountUnit(s string, u AmountUnit) (Amount, error) {
+	invalid := errors.New("ParseAmount: invalid amount " + s)
+	n, ok := decimals[u]
+	if !ok {
+		return 0, errors.New("ParseAmount: unknown unit")
+	}
+
+	negative := strings.HasPrefix(s, "-")
+	digits := strings.TrimPrefix(s, "-")
+	whole, frac := digits, ""
+	if i := strings.IndexByte(digits, '.'); i >= 0 {
+		whole, frac = digits[:i], digits[i+1:]
+	}
+	if whole == "" && frac == "" || len(frac) > n || !isDigits(whole) || !isDigits(frac) {
+		return 0, invalid
+	}
+	frac += strings.Repeat("0", n-len(frac))
+
+	sat, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil || Amount(sat) > MaxMoney {
+		return 0, invalid
+	}
+	if negative {
+		sat = -sat
+	}
+	return Amount(sat), nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// DecimalString returns the amount as decimal number in the unit, without trailing zeros.
+func (a Amount) DecimalString(u AmountUnit) string {
+	n := decimals[u]
+	var sign string
+	abs := uint64(a)
+	if a < 0 {
+		sign, abs = "-", uint64(-a)
+	}
+
+	div := uint64(1)
+	for i := 0; i < n; i++ {
+		div *= 10
+	}
+	s := sign + strconv.FormatUint(abs/div, 10)
+	if frac := abs % div; frac != 0 {
+		s += "." + strings.TrimRight(strconv.FormatUint(frac+div, 10)[1:], "0")
+	}
+	return s
+}
+
+// Format returns the amount in the unit, followed by the unit symbol, for example "0.5 BTC".
+func (a Amount) Format(u AmountUnit) string {
+	return a.DecimalString(u) + " " + u.String()
+}
+
+// String returns the amount in BTC.
+func (a Amount) String() string {
+	return a.Format(AmountBTC)
+}
+
+// IsValid returns whether the amount is in the range [0, MaxMoney].
+func (a Amount) IsValid() bool {
+	return 0 <= a && a <= MaxMoney
+}
+
+// Add returns a + b, or error on overflow.
+func (a Amount) Add(b Amount) (Amount, error) {
+	c := a + b
+	if (b > 0 && c < a) || (b < 0 && c > a) {
+		return 0, errors.New("Amount.Add: overflow")
+	}
+	return c, nil
+}
+
+// Sub returns a - b, or error on overflow.
+func (a Amount) Sub(b Amount) (Amount, error) {
+	c := a - b
+	if (b > 0 && c > a) || (b < 0 && c < a) {
+		return 0, errors.New("Amount.Sub: overflow")
+	}
+	return c, nil
+}
+
+// Mul returns a * n, or error on overflow.
+func (a Amount) Mul(n int64) (Amount, error) {
+	if a == 0 || n == 0 {
+		return 0, nil
+	}
+	c := a * Amount(n)
+	if c/Amount(n) != a || (a == -1 && n == -1<<63) || (n == -1 && a == -1<<63) {
+		return 0, errors.New("Amount.Mul: overflow")
+	}
+	return c, nil
+}
+
+// Sum returns the sum of the amounts, or error on overflow.
+func Sum(amounts ...Amount) (sum Amount, err error) {
+	for _, a := range amounts {
+		if sum, err = sum.Add(a); err != nil {
+			return 0, err
+		}
+	}
+	return sum, nil
+}
+
+// MarshalJSON encodes the amount as a number of BTC with 8 decimals, as Bitcoin Core RPC does.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	s := a.DecimalString(AmountBTC)
+	if i := strings.IndexByte(s, '.'); i < 0 {
+		s += ".00000000"
+	} else {
+		s += strings.Repeat("0", 8-(len(s)-i-1))
+	}
+	return []byte(s), nil
+}
+
+// UnmarshalJSON decodes a number of BTC, for example 0.00012345.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	v, err := ParseAmountUnit(string(data), AmountBTC)
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
@@ -0,0 +1,112 @@
+package btcutil
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	for s, want := range map[string]Amount{
+		"0.00012345 BTC": 12345,
+		"0.00012345":     12345,
+		"1 BTC":          SatoshiPerBitcoin,
+		"20.3":           2030000000,
+		".5":             50000000,
+		"1.5 mBTC":       150000,
+		"0.00001 mBTC":   1,
+		"12.34 µBTC":     1234,
+		"12 uBTC":        1200,
+		"12345 sat":      12345,
+		"-0.1 btc":       -10000000,
+		"21000000":       MaxMoney,
+	} {
+		if got, err := ParseAmount(s); err != nil || got != want {
+			t.Errorf("FAIL: %s", s)
+		}
+	}
+}
+
+func TestParseAmountInvalid(t *testing.T) {
+	for _, s := range []string{
+		"0.000000001",
+		"0.000001 mBTC",
+		"1.5 sat",
+		"1e3",
+		"1,5",
+		".",
+		"",
+		"1 BTC extra",
+		"1 ETH",
+		"21000000.00000001",
+		"99999999999999999999",
+	} {
+		if _, err := ParseAmount(s); err == nil {
+			t.Errorf("FAIL: %s", s)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	a := Amount(12345)
+	if a.String() != "0.00012345 BTC" || a.Format(AmountMilliBTC) != "0.12345 mBTC" ||
+		a.Format(AmountMicroBTC) != "123.45 µBTC" || a.Format(AmountSatoshi) != "12345 sat" {
+		t.Errorf("FAIL")
+	}
+	if Amount(-150000000).String() != "-1.5 BTC" || Amount(0).String() != "0 BTC" {
+		t.Errorf("FAIL")
+	}
+	if MaxMoney.DecimalString(AmountBTC) != "21000000" {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	if c, err := Amount(1).Add(2); err != nil || c != 3 {
+		t.Errorf("FAIL")
+	}
+	if _, err := Amount(math.MaxInt64).Add(1); err == nil {
+		t.Errorf("FAIL")
+	}
+	if _, err := Amount(math.MinInt64).Sub(1); err == nil {
+		t.Errorf("FAIL")
+	}
+	if c, err := Amount(3).Sub(5); err != nil || c != -2 {
+		t.Errorf("FAIL")
+	}
+	if _, err := Amount(math.MaxInt64 / 2).Mul(3); err == nil {
+		t.Errorf("FAIL")
+	}
+	if c, err := SatoshiPerBitcoin.Mul(6); err != nil || c != 6*SatoshiPerBitcoin {
+		t.Errorf("FAIL")
+	}
+	if _, err := Sum(MaxMoney, math.MaxInt64); err == nil {
+		t.Errorf("FAIL")
+	}
+	if Amount(-1).IsValid() || (MaxMoney + 1).IsValid() || !MaxMoney.IsValid() {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestJSON(t *testing.T) {
+	for a, want := range map[Amount]string{
+		12345:             "0.00012345",
+		SatoshiPerBitcoin: "1.00000000",
+		-150000000:        "-1.50000000",
+		0:                 "0.00000000",
+		MaxMoney:          "21000000.00000000",
+	} {
+		b, err := json.Marshal(a)
+		if err != nil || string(b) != want {
+			t.Errorf("FAIL: %s", b)
+		}
+		var got Amount
+		if err := json.Unmarshal(b, &got); err != nil || got != a {
+			t.Errorf("FAIL: %s", b)
+		}
+	}
+	var a Amount
+	if err := json.Unmarshal([]byte(`"1"`), &a); err == nil {
+		t.Errorf("FAIL")
+	}
+}
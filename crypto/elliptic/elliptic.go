@@ -150,8 +150,8 @@ func Marshal(curve Curve, x, y *big.Int) []byte {
 	byteSize := (curve.Params().BitSize + 7) / 8
 	ret := make([]byte, 2*byteSize+1)
 	ret[0] = 4
-	copy(ret[1:byteSize+1], x.Bytes())
-	copy(ret[byteSize+1:], y.Bytes())
+	x.FillBytes(ret[1 : byteSize+1])
+	y.FillBytes(ret[byteSize+1:])
 	return ret
 }
 
@@ -165,32 +165,50 @@ func MarshalCompressed(curve Curve, x, y *big.Int) []byte {
 	} else {
 		ret[0] = 3
 	}
-	copy(ret[1:], x.Bytes())
+	x.FillBytes(ret[1:])
 	return ret
 }
 
 // Unmarshal deserializes a point (x,y)
 func Unmarshal(curve Curve, buf []byte) (x, y *big.Int, err error) {
 	byteSize := (curve.Params().BitSize + 7) / 8
+	p := curve.Params().P
+
+	if len(buf) == 0 {
+		return nil, nil, errors.New("Unmarshal: empty buffer")
+	}
 
 	// Uncompressed unmarshal.
 	if buf[0] == 4 {
+		if len(buf) != 2*byteSize+1 {
+			return nil, nil, errors.New("Unmarshal: invalid length")
+		}
 		x = new(big.Int).SetBytes(buf[1 : byteSize+1])
 		y = new(big.Int).SetBytes(buf[byteSize+1:])
+		if x.Cmp(p) >= 0 || y.Cmp(p) >= 0 || !curve.IsOnCurve(x, y) {
+			return nil, nil, errors.New("Unmarshal: point is not on the curve")
+		}
 		return
 	}
 
 	if buf[0] != 2 && buf[0] != 3 {
 		return nil, nil, errors.New("Unmarshal: invalid prefix byte")
 	}
+	if len(buf) != byteSize+1 {
+		return nil, nil, errors.New("Unmarshal: invalid length")
+	}
 
 	// Compressed unmarshal.
-	p := curve.Params().P
 	isEven := buf[0] == 2
 	x = new(big.Int).SetBytes(buf[1 : byteSize+1])
+	if x.Cmp(p) >= 0 {
+		return nil, nil, errors.New("Unmarshal: point is not on the curve")
+	}
 	// y^2 = x^3 + a*x + b (mod p)
 	y = curve.Params().polynomial(x)
-	y.ModSqrt(y, p)
+	if y.ModSqrt(y, p) == nil {
+		return nil, nil, errors.New("Unmarshal: point is not on the curve")
+	}
 	y.Mod(y, p)
 
 	if (y.Bit(0) == 0) == isEven {
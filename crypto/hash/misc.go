@@ -11,3 +11,14 @@ func Hash160(data []byte) [20]byte {
 	buf := Sha256(data)
 	return Ripemd160(buf[:])
 }
+
+// TaggedHash returns sha256(sha256(tag) || sha256(tag) || msg), as defined in BIP340.
+// The tag makes hashes used for different purposes independent of each other.
+func TaggedHash(tag string, msgs ...[]byte) [32]byte {
+	tagHash := Sha256([]byte(tag))
+	buf := append(tagHash[:], tagHash[:]...)
+	for _, msg := range msgs {
+		buf = append(buf, msg...)
+	}
+	return Sha256(buf)
+}
@@ -0,0 +1,8 @@
+package hash
+
+import "crypto/sha1"
+
+// Sha1 is only used by the OP_SHA1 opcode of Script.
+func Sha1(data []byte) [20]byte {
+	return sha1.Sum(data)
+}
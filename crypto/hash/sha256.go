@@ -122,8 +122,9 @@ func pad(data []byte) []byte {
 	// len of data in bits
 	l := uint64(len(data) * 8)
 
-	// append just "1" to the end of data
-	data = append(data, 0b10000000)
+	// append just "1" to the end of data,
+	// copying it first so the caller's backing array is left untouched
+	data = append(append(make([]byte, 0, len(data)+72), data...), 0b10000000)
 
 	// follow by k zero bits, where k is the smallest, non-negative solution to:
 	// l + 1 + k = 448 mod 512
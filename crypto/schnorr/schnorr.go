@@ -0,0 +1,106 @@
+// Package schnorr implements the Schnorr signatures over secp256k1, as defined in
+// https://github.com/bitcoin/bips/blob/master/bip-0340.mediawiki
+//
+// Public keys are x-only: the 32 byte x coordinate of the point with an even y coordinate.
+package schnorr
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/VIVelev/btcd/crypto/ecdsa"
+	"github.com/VIVelev/btcd/crypto/elliptic"
+	"github.com/VIVelev/btcd/crypto/hash"
+)
+
+var curve = elliptic.Secp256k1
+
+// XOnly returns the x-only encoding of the public key.
+func XOnly(pub *ecdsa.PublicKey) (ret [32]byte) {
+	pub.X.FillBytes(ret[:])
+	return
+}
+
+// liftX returns the point with the x coordinate and an even y coordinate.
+func liftX(x []byte) (*big.Int, *big.Int, error) {
+	return elliptic.Unmarshal(curve, append([]byte{0x02}, x...))
+}
+
+// challenge returns int(hashBIP0340/challenge(r || pubKey || msg)) mod n.
+func challenge(r, pubKey, msg []byte) *big.Int {
+	h := hash.TaggedHash("BIP0340/challenge", r, pubKey, msg)
+	e := new(big.Int).SetBytes(h[:])
+	return e.Mod(e, curve.N)
+}
+
+// Sign returns the signature of the message with the private key.
+// auxRand is fresh randomness mixed into the nonce, it may be all zeros,
+// at the cost of protection against side-channel attacks.
+func Sign(priv *ecdsa.PrivateKey, msg []byte, auxRand [32]byte) (sig [64]byte, err error) {
+	n := curve.N
+	if priv.D.Sign() <= 0 || priv.D.Cmp(n) >= 0 {
+		return sig, errors.New("Sign: the secret should be in the range [1, n-1]")
+	}
+	px, py := curve.ScalarBaseMult(priv.D)
+	d := new(big.Int).Set(priv.D)
+	if py.Bit(0) == 1 {
+		d.Sub(n, d)
+	}
+	var pubKey, secret [32]byte
+	px.FillBytes(pubKey[:])
+	d.FillBytes(secret[:])
+
+	// the nonce is derived from the secret, masked with the auxiliary randomness, and the message
+	t := hash.TaggedHash("BIP0340/aux", auxRand[:])
+	for i := range t {
+		t[i] ^= secret[i]
+	}
+	rand := hash.TaggedHash("BIP0340/nonce", t[:], pubKey[:], msg)
+	k := new(big.Int).SetBytes(rand[:])
+	k.Mod(k, n)
+	if k.Sign() == 0 {
+		return sig, errors.New("Sign: the nonce is zero")
+	}
+	rx, ry := curve.ScalarBaseMult(k)
+	if ry.Bit(0) == 1 {
+		k.Sub(n, k)
+	}
+
+	rx.FillBytes(sig[:32])
+	e := challenge(sig[:32], pubKey[:], msg)
+	s := e.Mul(e, d)
+	s.Add(s, k)
+	s.Mod(s, n)
+	s.FillBytes(sig[32:])
+
+	if !Verify(pubKey[:], msg, sig[:]) {
+		return [64]byte{}, errors.New("Sign: the signature doesn't verify")
+	}
+	return sig, nil
+}
+
+// Verify reports whether sig is a valid signature of the message by the x-only public key.
+func Verify(pubKey, msg, sig []byte) bool {
+	if len(pubKey) != 32 || len(sig) != 64 {
+		return false
+	}
+	px, py, err := liftX(pubKey)
+	if err != nil {
+		return false
+	}
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+	if r.Cmp(curve.P) >= 0 || s.Cmp(curve.N) >= 0 {
+		return false
+	}
+
+	// R = s*G - e*P
+	e := challenge(sig[:32], pubKey, msg)
+	sx, sy := curve.ScalarBaseMult(s)
+	ex, ey := curve.ScalarMult(px, py, e.Sub(curve.N, e))
+	rx, ry := curve.Add(sx, sy, ex, ey)
+	if rx.Sign() == 0 && ry.Sign() == 0 {
+		return false
+	}
+	return ry.Bit(0) == 0 && rx.Cmp(r) == 0
+}
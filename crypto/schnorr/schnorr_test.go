@@ -0,0 +1,81 @@
+package schnorr
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/VIVelev/btcd/crypto/ecdsa"
+	"github.com/VIVelev/btcd/crypto/elliptic"
+)
+
+func decode(s string) []byte {
+	b, _ := hex.DecodeString(s)
+	return b
+}
+
+// test vectors from https://github.com/bitcoin/bips/blob/master/bip-0340/test-vectors.csv
+var vectors = []struct {
+	secret, pubKey, auxRand, msg, sig string
+}{
+	{
+		"0000000000000000000000000000000000000000000000000000000000000003",
+		"F9308A019258C31049344F85F89D5229B531C845836F99B08601F113BCE036F9",
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"E907831F80848D1069A5371B402410364BDF1C5F8307B0084C55F1CE2DCA821525F66A4A85EA8B71E482A74F382D2CE5EBEEE8FDB2172F477DF4900D310536C0",
+	},
+	{
+		"B7E151628AED2A6ABF7158809CF4F3C762E7160F38B4DA56A784D9045190CFEF",
+		"DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		"0000000000000000000000000000000000000000000000000000000000000001",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"6896BD60EEAE296DB48A229FF71DFE071BDE413E6D43F917DC8DCF8C78DE33418906D11AC976ABCCB20B091292BFF4EA897EFCB639EA871CFA95F6DE339E4B0A",
+	},
+}
+
+func TestSign(t *testing.T) {
+	for _, v := range vectors {
+		priv := ecdsa.GenerateKeyFromSecret(elliptic.Secp256k1, new(big.Int).SetBytes(decode(v.secret)))
+		pubKey := XOnly(&priv.PublicKey)
+		if !bytes.Equal(pubKey[:], decode(v.pubKey)) {
+			t.Errorf("FAIL")
+		}
+		var auxRand [32]byte
+		copy(auxRand[:], decode(v.auxRand))
+		sig, err := Sign(priv, decode(v.msg), auxRand)
+		if err != nil || !bytes.Equal(sig[:], decode(v.sig)) {
+			t.Errorf("FAIL")
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	for _, v := range vectors {
+		pubKey, msg, sig := decode(v.pubKey), decode(v.msg), decode(v.sig)
+		if !Verify(pubKey, msg, sig) {
+			t.Errorf("FAIL")
+		}
+
+		// a different message
+		other := append([]byte{}, msg...)
+		other[0] ^= 1
+		if Verify(pubKey, other, sig) {
+			t.Errorf("FAIL")
+		}
+		// s is not below the group order
+		bad := append([]byte{}, sig...)
+		elliptic.Secp256k1.N.FillBytes(bad[32:])
+		if Verify(pubKey, msg, bad) {
+			t.Errorf("FAIL")
+		}
+		// the public key is not on the curve
+		if Verify(decode("EEFDEA4CDB677750A420FEE807EACF21EB9898AE79B9768766E4FAA04A2D4A34"), msg, sig) {
+			t.Errorf("FAIL")
+		}
+		if Verify(pubKey[1:], msg, sig) || Verify(pubKey, msg, sig[1:]) {
+			t.Errorf("FAIL")
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package descriptor
+
+// Descriptor checksums, as defined in
+// https://github.com/bitcoin/bips/blob/master/bip-0380.mediawiki#checksum
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	inputCharset    = "0123456789()[],'/*abcdefgh@:$%{}IJKLMNOPQRSTUVWXYZ&+-.;<=>?!^_|~ijklmnopqrstuvwxyzABCDEFGH`#\"\\ "
+	checksumCharset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+)
+
+func polymod(symbols []uint64) uint64 {
+	generator := [5]uint64{0xf5dee51989, 0xa9fdca3312, 0x1bab10e32d, 0x3706b1677a, 0x644d626ffd}
+	chk := uint64(1)
+	for _, v := range symbols {
+		top := chk >> 35
+		chk = (chk&0x7ffffffff)<<5 ^ v
+		for i := 0; i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+// expand converts the descriptor into symbols, each character contributes its position
+// in the first 32 characters of its group, and every 3 characters contribute their group numbers.
+func expand(desc string) ([]uint64, error) {
+	var symbols, groups []uint64
+	for _, c := range desc {
+		v := strings.IndexRune(inputCharset, c)
+		if v < 0 {
+			return nil, errors.New("invalid character in descriptor")
+		}
+		symbols = append(symbols, uint64(v&31))
+		groups = append(groups, uint64(v>>5))
+		if len(groups) == 3 {
+			symbols = append(symbols, groups[0]*9+groups[1]*3+groups[2])
+			groups = groups[:0]
+		}
+	}
+	switch len(groups) {
+	case 1:
+		symbols = append(symbols, groups[0])
+	case 2:
+		symbols = append(symbols, groups[0]*3+groups[1])
+	}
+	return symbols, nil
+}
+
+// Checksum returns the 8 character checksum of the descriptor (without the "#").
+func Checksum(desc string) (string, error) {
+	symbols, err := expand(desc)
+	if err != nil {
+		return "", err
+	}
+	c := polymod(append(symbols, 0, 0, 0, 0, 0, 0, 0, 0)) ^ 1
+
+	ret := make([]byte, 8)
+	for i := range ret {
+		ret[i] = checksumCharset[(c>>(5*(7-i)))&31]
+	}
+	return string(ret), nil
+}
+
+// splitChecksum separates the descriptor from its checksum, and verifies the checksum if present.
+func splitChecksum(s string) (string, error) {
+	i := strings.IndexByte(s, '#')
+	if i < 0 {
+		return s, nil
+	}
+	desc, checksum := s[:i], s[i+1:]
+	if len(checksum) != 8 {
+		return "", errors.New("checksum should be 8 characters long")
+	}
+	want, err := Checksum(desc)
+	if err != nil {
+		return "", err
+	}
+	if checksum != want {
+		return "", errors.New("checksums don't match, expected " + want)
+	}
+	return desc, nil
+}
@@ -0,0 +1,495 @@
+// Package descriptor implements output script descriptors, as defined in
+// https://github.com/bitcoin/bips/blob/master/bip-0380.mediawiki and BIPs 381 through 386.
+//
+// A descriptor describes a set of output scripts, for example
+//     wpkh([d34db33f/84'/0'/0']xpub.../0/*)#checksum
+// which can be expanded to the concrete scripts at given derivation indices.
+package descriptor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/VIVelev/btcd/crypto/hash"
+	"github.com/VIVelev/btcd/encoding"
+	"github.com/VIVelev/btcd/script"
+)
+
+// context is where a script expression appears, which restricts what is allowed in it.
+type context int
+
+const (
+	topContext context = iota
+	shContext
+	wpkhContext
+	wshContext
+	trContext
+)
+
+const (
+	maxScriptElementSize = 520
+	maxTaprootTreeDepth  = 128
+)
+
+// Descriptor is a parsed output script descriptor.
+type Descriptor struct {
+	root *expr
+}
+
+// expr is a SCRIPT expression, such as "pkh(KEY)" or "sh(SCRIPT)".
+type expr struct {
+	name      string
+	keys      []*keyExpr
+	threshold int       // multi and sortedmulti
+	sub       *expr     // sh and wsh
+	tree      *treeExpr // tr, nil without script tree
+	addr      string    // addr
+	raw       string    // raw, as given in hex
+	ctx       context
+}
+
+// treeExpr is a taproot script tree, either a leaf script or a {left,right} branch.
+type treeExpr struct {
+	leaf        *expr
+	left, right *treeExpr
+}
+
+// Parse parses the descriptor, verifying its checksum if present.
+func Parse(s string) (*Descriptor, error) {
+	desc, err := splitChecksum(s)
+	if err != nil {
+		return nil, err
+	}
+	root, err := parseExpr(desc, topContext)
+	if err != nil {
+		return nil, err
+	}
+	return &Descriptor{root}, nil
+}
+
+// splitFunc splits "name(args)" into name and args.
+func splitFunc(s string) (name, args string, err error) {
+	open := strings.IndexByte(s, '(')
+	if open < 0 || !strings.HasSuffix(s, ")") {
+		return "", "", errors.New("invalid expression " + s)
+	}
+	return s[:open], s[open+1 : len(s)-1], nil
+}
+
+// splitArgs splits s at the commas which are not nested in brackets.
+func splitArgs(s string) []string {
+	var args []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				args = append(args, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(args, s[start:])
+}
+
+func parseExpr(s string, ctx context) (*expr, error) {
+	name, args, err := splitFunc(s)
+	if err != nil {
+		return nil, err
+	}
+	e := &expr{name: name, ctx: ctx}
+
+	switch name {
+	case "pk", "pkh":
+		if e.keys, err = parseKeys([]string{args}, ctx); err != nil {
+			return nil, err
+		}
+	case "wpkh":
+		if ctx != topContext && ctx != shContext {
+			return nil, errors.New("wpkh() can only be at the top level or inside sh()")
+		}
+		if e.keys, err = parseKeys([]string{args}, wpkhContext); err != nil {
+			return nil, err
+		}
+	case "multi", "sortedmulti":
+		if ctx == trContext {
+			return nil, errors.New(name + "() is not allowed in tapscript")
+		}
+		parts := splitArgs(args)
+		if e.threshold, err = parseThreshold(parts[0]); err != nil {
+			return nil, err
+		}
+		if e.keys, err = parseKeys(parts[1:], ctx); err != nil {
+			return nil, err
+		}
+		maxKeys := 16
+		switch ctx {
+		case topContext:
+			// bare multisig is only standard with up to 3 keys
+			maxKeys = 3
+		case wshContext:
+			maxKeys = 20
+		}
+		if n := len(e.keys); e.threshold < 1 || e.threshold > n || n > maxKeys {
+			return nil, errors.New("invalid multisig threshold or number of keys")
+		}
+	case "sh":
+		if ctx != topContext {
+			return nil, errors.New("sh() can only be at the top level")
+		}
+		if e.sub, err = parseExpr(args, shContext); err != nil {
+			return nil, err
+		}
+	case "wsh":
+		if ctx != topContext && ctx != shContext {
+			return nil, errors.New("wsh() can only be at the top level or inside sh()")
+		}
+		if e.sub, err = parseExpr(args, wshContext); err != nil {
+			return nil, err
+		}
+	case "tr":
+		if ctx != topContext {
+			return nil, errors.New("tr() can only be at the top level")
+		}
+		parts := splitArgs(args)
+		if len(parts) > 2 {
+			return nil, errors.New("tr() takes a key and an optional script tree")
+		}
+		if e.keys, err = parseKeys(parts[:1], trContext); err != nil {
+			return nil, err
+		}
+		if len(parts) == 2 {
+			if e.tree, err = parseTree(parts[1], 0); err != nil {
+				return nil, err
+			}
+		}
+	case "addr":
+		if ctx != topContext {
+			return nil, errors.New("addr() can only be at the top level")
+		}
+		if _, err = addressScript(args); err != nil {
+			return nil, err
+		}
+		e.addr = args
+	case "raw":
+		if ctx != topContext {
+			return nil, errors.New("raw() can only be at the top level")
+		}
+		raw, err := hex.DecodeString(args)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = script.ParseRaw(raw); err != nil {
+			return nil, err
+		}
+		e.raw = args
+	default:
+		return nil, errors.New("unknown script expression " + name + "()")
+	}
+	return e, nil
+}
+
+// parseThreshold parses the threshold of multi() as an unsigned decimal number, without a sign.
+func parseThreshold(s string) (int, error) {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return 0, errors.New("invalid multisig threshold " + s)
+		}
+	}
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, errors.New("invalid multisig threshold " + s)
+	}
+	return int(n), nil
+}
+
+func parseKeys(args []string, ctx context) ([]*keyExpr, error) {
+	if len(args) == 0 || (len(args) == 1 && args[0] == "") {
+		return nil, errors.New("missing key")
+	}
+	keys := make([]*keyExpr, len(args))
+	for i, a := range args {
+		k, err := parseKey(a, ctx)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = k
+	}
+	return keys, nil
+}
+
+func parseTree(s string, depth int) (*treeExpr, error) {
+	if depth > maxTaprootTreeDepth {
+		return nil, errors.New("taproot script tree is too deep")
+	}
+	if !strings.HasPrefix(s, "{") {
+		leaf, err := parseExpr(s, trContext)
+		if err != nil {
+			return nil, err
+		}
+		return &treeExpr{leaf: leaf}, nil
+	}
+
+	if !strings.HasSuffix(s, "}") {
+		return nil, errors.New("taproot script tree is missing '}'")
+	}
+	parts := splitArgs(s[1 : len(s)-1])
+	if len(parts) != 2 {
+		return nil, errors.New("taproot script tree branch should have two children")
+	}
+	left, err := parseTree(parts[0], depth+1)
+	if err != nil {
+		return nil, err
+	}
+	right, err := parseTree(parts[1], depth+1)
+	if err != nil {
+		return nil, err
+	}
+	return &treeExpr{left: left, right: right}, nil
+}
+
+// addressScript returns the script locking coins to the base58 or segwit address.
+func addressScript(addr string) (script.Script, error) {
+	if version, program, _, err := encoding.DecodeSegWitAddress(addr); err == nil {
+		return script.NewWitnessScript(version, program), nil
+	}
+	h160, scriptHash, _, err := encoding.DecodeBase58Address(addr)
+	if err != nil {
+		return nil, errors.New("invalid address " + addr)
+	}
+	if scriptHash {
+		return script.NewP2SHScript(h160), nil
+	}
+	return script.NewP2PKHScript(h160), nil
+}
+
+// IsRange returns whether the descriptor contains wildcard keys,
+// and so expands to different scripts at different indices.
+func (d *Descriptor) IsRange() bool {
+	return d.root.isRange()
+}
+
+func (e *expr) isRange() bool {
+	for _, k := range e.keys {
+		if k.isRange() {
+			return true
+		}
+	}
+	if e.sub != nil && e.sub.isRange() {
+		return true
+	}
+	return e.tree != nil && e.tree.isRange()
+}
+
+func (t *treeExpr) isRange() bool {
+	if t.leaf != nil {
+		return t.leaf.isRange()
+	}
+	return t.left.isRange() || t.right.isRange()
+}
+
+// Expand returns the output script at the derivation index. The index is ignored
+// for descriptors which are not ranged.
+func (d *Descriptor) Expand(index uint32) (script.Script, error) {
+	return d.root.expand(index)
+}
+
+// ExpandRange returns the output scripts at the derivation indices [start, end).
+func (d *Descriptor) ExpandRange(start, end uint32) ([]script.Script, error) {
+	var scripts []script.Script
+	for i := start; i < end; i++ {
+		s, err := d.Expand(i)
+		if err != nil {
+			return nil, err
+		}
+		scripts = append(scripts, s)
+	}
+	return scripts, nil
+}
+
+func (e *expr) expand(index uint32) (script.Script, error) {
+	switch e.name {
+	case "pk":
+		var pub []byte
+		if e.ctx == trContext {
+			xonly, err := e.keys[0].xOnlyPubKeyAt(index)
+			if err != nil {
+				return nil, err
+			}
+			pub = xonly[:]
+		} else {
+			var err error
+			if pub, err = e.keys[0].pubKeyAt(index); err != nil {
+				return nil, err
+			}
+		}
+		s := script.Script{}
+		s = s.AddBytes(pub)
+		return s.Add(script.OP_CHECKSIG), nil
+	case "pkh", "wpkh":
+		pub, err := e.keys[0].pubKeyAt(index)
+		if err != nil {
+			return nil, err
+		}
+		if e.name == "pkh" {
+			return script.NewP2PKHScript(hash.Hash160(pub)), nil
+		}
+		return script.NewP2WPKHScript(hash.Hash160(pub)), nil
+	case "multi", "sortedmulti":
+		pubs := make([][]byte, len(e.keys))
+		for i, k := range e.keys {
+			var err error
+			if pubs[i], err = k.pubKeyAt(index); err != nil {
+				return nil, err
+			}
+		}
+		if e.name == "sortedmulti" {
+			sort.Slice(pubs, func(i, j int) bool {
+				return bytes.Compare(pubs[i], pubs[j]) < 0
+			})
+		}
+		return script.NewMultisigScript(e.threshold, pubs)
+	case "sh", "wsh":
+		sub, err := e.sub.expand(index)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := sub.Raw()
+		if err != nil {
+			return nil, err
+		}
+		if e.name == "sh" {
+			if len(raw) > maxScriptElementSize {
+				return nil, errors.New("redeem script is larger than 520 bytes")
+			}
+			return script.NewP2SHScript(hash.Hash160(raw)), nil
+		}
+		return script.NewP2WSHScript(hash.Sha256(raw)), nil
+	case "tr":
+		internalKey, err := e.keys[0].xOnlyPubKeyAt(index)
+		if err != nil {
+			return nil, err
+		}
+		var merkleRoot []byte
+		if e.tree != nil {
+			root, err := e.tree.hash(index)
+			if err != nil {
+				return nil, err
+			}
+			merkleRoot = root[:]
+		}
+		outputKey, _, err := script.TaprootOutputKey(internalKey, merkleRoot)
+		if err != nil {
+			return nil, err
+		}
+		return script.NewP2TRScript(outputKey), nil
+	case "addr":
+		return addressScript(e.addr)
+	case "raw":
+		raw, _ := hex.DecodeString(e.raw)
+		return script.ParseRaw(raw)
+	}
+	return nil, errors.New("unknown script expression " + e.name + "()")
+}
+
+func (t *treeExpr) hash(index uint32) ([32]byte, error) {
+	if t.leaf != nil {
+		s, err := t.leaf.expand(index)
+		if err != nil {
+			return [32]byte{}, err
+		}
+		return script.TapLeafHash(script.TapscriptLeafVersion, s)
+	}
+	left, err := t.left.hash(index)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	right, err := t.right.hash(index)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return script.TapBranchHash(left, right), nil
+}
+
+// Address returns the address of the output script at the derivation index.
+// Returns error for descriptors without an address, such as bare multisig or raw scripts.
+func (d *Descriptor) Address(index uint32, testnet bool) (string, error) {
+	e := d.root
+	if e.name == "addr" {
+		return e.addr, nil
+	}
+	if e.name != "pkh" && e.name != "wpkh" && e.name != "sh" && e.name != "wsh" && e.name != "tr" {
+		return "", errors.New(e.name + "() has no address")
+	}
+
+	s, err := e.expand(index)
+	if err != nil {
+		return "", err
+	}
+	switch e.name {
+	case "pkh":
+		var h160 [20]byte
+		copy(h160[:], s.GetBytes(2))
+		return encoding.PubKeyHashAddress(h160, testnet), nil
+	case "sh":
+		var h160 [20]byte
+		copy(h160[:], s.GetBytes(1))
+		return encoding.ScriptHashAddress(h160, testnet), nil
+	case "tr":
+		return encoding.SegWitAddress(1, s.GetBytes(1), testnet)
+	default:
+		return encoding.SegWitAddress(0, s.GetBytes(1), testnet)
+	}
+}
+
+// String returns the canonical text of the descriptor, followed by its checksum.
+// Hardened derivation steps are written with "'".
+func (d *Descriptor) String() string {
+	desc := d.root.String()
+	checksum, _ := Checksum(desc)
+	return desc + "#" + checksum
+}
+
+func (e *expr) String() string {
+	var args []string
+	switch e.name {
+	case "multi", "sortedmulti":
+		args = append(args, strconv.Itoa(e.threshold))
+		for _, k := range e.keys {
+			args = append(args, k.String())
+		}
+	case "sh":
+		args = append(args, e.sub.String())
+	case "wsh":
+		args = append(args, e.sub.String())
+	case "wpkh":
+		args = append(args, e.keys[0].String())
+	case "tr":
+		args = append(args, e.keys[0].String())
+		if e.tree != nil {
+			args = append(args, e.tree.String())
+		}
+	case "addr":
+		args = append(args, e.addr)
+	case "raw":
+		args = append(args, e.raw)
+	default:
+		args = append(args, e.keys[0].String())
+	}
+	return e.name + "(" + strings.Join(args, ",") + ")"
+}
+
+func (t *treeExpr) String() string {
+	if t.leaf != nil {
+		return t.leaf.String()
+	}
+	return "{" + t.left.String() + "," + t.right.String() + "}"
+}
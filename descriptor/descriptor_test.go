@@ -0,0 +1,212 @@
+package descriptor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/VIVelev/btcd/hdkey"
+)
+
+func TestChecksum(t *testing.T) {
+	if c, _ := Checksum("raw(deadbeef)"); c != "89f8spxm" {
+		t.Errorf("FAIL: %s", c)
+	}
+	if _, err := Parse("raw(deadbeef)#89f8spxm"); err != nil {
+		t.Errorf("FAIL: %s", err)
+	}
+	for _, s := range []string{
+		"raw(deadbeef)#89f8spxn",
+		"raw(deadbeef)#89f8spx",
+		"raw(deadbeef)#",
+	} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("FAIL: %s", s)
+		}
+	}
+
+	// which is allowed inside sh()
+	if _, err := Parse("sh(multi(1,03a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bd,03669b8afcec803a0d323e9a17f3ea8e68e8abe5a278020a929adbec52421adbd0,03a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bd,03669b8afcec803a0d323e9a17f3ea8e68e8abe5a278020a929adbec52421adbd0))"); err != nil {
+		t.Errorf("FAIL: %s", err)
+	}
+}
+
+func TestExpand(t *testing.T) {
+	// test vectors from BIPs 381 through 386
+	for _, tc := range []struct {
+		desc   string
+		index  uint32
+		script string
+	}{
+		{"pkh(02c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5)", 0,
+			"76a91406afd46bcdfd22ef94ac122aa11f241244a37ecc88ac"},
+		{"wpkh(02f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9)", 0,
+			"00147dd65592d0ab2fe0d0257d571abf032cd9db93dc"},
+		{"sh(wpkh(03fff97bd5755eeea420453a14355235d382f6472f8568a18b2f057a1460297556))", 0,
+			"a914cc6ffbc0bf31af759451068f90ba7a0272b6b33287"},
+		{"multi(1,022f8bde4d1a07209355b4a7250a5c5128e88b84bddc619ab7cba8d569b240efe4,025cbdf0646e5db4eaa398f365f2ea7a0e3d419b7e0330e39ce92bddedcac4f9bc)", 0,
+			"5121022f8bde4d1a07209355b4a7250a5c5128e88b84bddc619ab7cba8d569b240efe421025cbdf0646e5db4eaa398f365f2ea7a0e3d419b7e0330e39ce92bddedcac4f9bc52ae"},
+		{"wsh(multi(2,03a0434d9e47f3c86235477c7b1ae6ae5d3442d49b1943c2b752a68e2a47e247c7,03774ae7f858a9411e5ef4246b70c65aac5649980be5c17891bbec17895da008cb,03d01115d548e7561b15c38f004d734633687cf4419620095bc5b0f47070afe85a))", 0,
+			"0020773d709598b76c4e3b575c08aad40658963f9322affc0f8c28d1d9a68d0c944a"},
+		{"tr(a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bd)", 0,
+			"512077aab6e066f8a7419c5ab714c12c67d25007ed55a43cadcacb4d7a970a093f11"},
+		{"raw(deadbeef)", 0, "deadbeef"},
+		{"addr(bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4)", 0,
+			"0014751e76e8199196d454941c45d1b3a323f1433bd6"},
+	} {
+		d, err := Parse(tc.desc)
+		if err != nil {
+			t.Errorf("FAIL: %s: %s", tc.desc, err)
+			continue
+		}
+		s, err := d.Expand(tc.index)
+		if err != nil {
+			t.Errorf("FAIL: %s: %s", tc.desc, err)
+			continue
+		}
+		raw, _ := s.Raw()
+		if hex.EncodeToString(raw) != tc.script {
+			t.Errorf("FAIL: %s: %x", tc.desc, raw)
+		}
+	}
+}
+
+func TestExpandRange(t *testing.T) {
+	xpub := "xpub6ERApfZwUNrhLCkDtcHTcxd75RbzS1ed54G1LkBUHQVHQKqhMkhgbmJbZRkrgZw4koxb5JaHWkY4ALHY2grBGRjaDMzQLcgJvLJuZZvRcEL"
+	d, err := Parse("tr(" + xpub + "/0/*)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scripts, err := d.ExpandRange(0, 3)
+	if err != nil || len(scripts) != 3 {
+		t.Fatal(err)
+	}
+
+	// wildcards derive only the children up to 2^31-1, hardened or not
+	if _, err = d.Expand(hdkey.HardenedOffset); err == nil {
+		t.Errorf("FAIL")
+	}
+	master, _ := hdkey.NewMaster(bytes.Repeat([]byte{1}, 16), false)
+	hardened, err := Parse("wpkh(" + master.String() + "/0/*')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = hardened.Expand(hdkey.HardenedOffset - 1); err != nil {
+		t.Errorf("FAIL: %v", err)
+	}
+	if _, err = hardened.Expand(hdkey.HardenedOffset); err == nil {
+		t.Errorf("FAIL")
+	}
+
+	// the script at each index is the same as the one of the derived key
+	extKey, _ := hdkey.Parse(xpub)
+	for i, s := range scripts {
+		child, _ := extKey.Derive([]uint32{0, uint32(i)})
+		pub := child.PublicKey.MarshalCompressed()
+		d, _ := Parse("tr(" + hex.EncodeToString(pub[1:]) + ")")
+		want, _ := d.Expand(0)
+		got, _ := s.Raw()
+		wantRaw, _ := want.Raw()
+		if !bytes.Equal(got, wantRaw) {
+			t.Errorf("FAIL: %d", i)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	d, err := Parse("wpkh([d34db33f/84h/0h/0h]xpub6ERApfZwUNrhLCkDtcHTcxd75RbzS1ed54G1LkBUHQVHQKqhMkhgbmJbZRkrgZw4koxb5JaHWkY4ALHY2grBGRjaDMzQLcgJvLJuZZvRcEL/0/*)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "wpkh([d34db33f/84'/0'/0']xpub6ERApfZwUNrhLCkDtcHTcxd75RbzS1ed54G1LkBUHQVHQKqhMkhgbmJbZRkrgZw4koxb5JaHWkY4ALHY2grBGRjaDMzQLcgJvLJuZZvRcEL/0/*)"
+	checksum, _ := Checksum(want)
+	if d.String() != want+"#"+checksum {
+		t.Errorf("FAIL: %s", d.String())
+	}
+	if !d.IsRange() {
+		t.Errorf("FAIL")
+	}
+
+	// the canonical text parses back to the same descriptor
+	d2, err := Parse(d.String())
+	if err != nil || d2.String() != d.String() {
+		t.Errorf("FAIL")
+	}
+
+	// keys and raw scripts are printed as given
+	for _, desc := range []string{
+		"tr(03a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bd)",
+		"tr(a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bd,pk(03669b8afcec803a0d323e9a17f3ea8e68e8abe5a278020a929adbec52421adbd0))",
+		"raw(4c0107)",
+	} {
+		d, err := Parse(desc)
+		checksum, _ := Checksum(desc)
+		if err != nil || d.String() != desc+"#"+checksum {
+			t.Errorf("FAIL: %s", desc)
+		}
+	}
+}
+
+func TestTaprootTree(t *testing.T) {
+	d, err := Parse("tr(a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bd,{pk(669b8afcec803a0d323e9a17f3ea8e68e8abe5a278020a929adbec52421adbd0),pk(a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bd)})")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = d.Expand(0); err != nil {
+		t.Errorf("FAIL: %s", err)
+	}
+	if _, err = Parse(d.String()); err != nil {
+		t.Errorf("FAIL: %s", err)
+	}
+}
+
+func TestAddress(t *testing.T) {
+	d, _ := Parse("wpkh(0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798)")
+	addr, err := d.Address(0, false)
+	if err != nil || addr != "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4" {
+		t.Errorf("FAIL: %s", addr)
+	}
+	d, _ = Parse("raw(deadbeef)")
+	if _, err = d.Address(0, false); err == nil {
+		t.Errorf("FAIL")
+	}
+
+	// the raw script keeps its push opcodes
+	d, _ = Parse("raw(4c0107)")
+	s, err := d.Expand(0)
+	if raw, _ := s.Raw(); err != nil || hex.EncodeToString(raw) != "4c0107" {
+		t.Errorf("FAIL: %x", raw)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, s := range []string{
+		// uncompressed keys are not allowed in segwit
+		"wpkh(04a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bd5b8dec5235a0fa8722476c7709c02559e3aa73aa03918ba2d492eea75abea235)",
+		// x-only keys only in tr()
+		"pkh(a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bd)",
+		// sh() only at the top level
+		"sh(sh(pk(03a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bd)))",
+		// hardened derivation from an xpub
+		"wpkh(xpub6ERApfZwUNrhLCkDtcHTcxd75RbzS1ed54G1LkBUHQVHQKqhMkhgbmJbZRkrgZw4koxb5JaHWkY4ALHY2grBGRjaDMzQLcgJvLJuZZvRcEL/0/*')",
+		// threshold larger than the number of keys
+		"multi(3,03a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bd,03669b8afcec803a0d323e9a17f3ea8e68e8abe5a278020a929adbec52421adbd0)",
+		// bare multisig with more than 3 keys
+		"multi(1,03a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bd,03669b8afcec803a0d323e9a17f3ea8e68e8abe5a278020a929adbec52421adbd0,03a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bd,03669b8afcec803a0d323e9a17f3ea8e68e8abe5a278020a929adbec52421adbd0)",
+		// thresholds with a sign
+		"multi(+1,03a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bd)",
+		"multi(-0,03a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bd)",
+		"multi(,03a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bd)",
+		"foo(deadbeef)",
+		"pkh(",
+	} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("FAIL: %s", s)
+		}
+	}
+
+	// which is allowed inside sh()
+	if _, err := Parse("sh(multi(1,03a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bd,03669b8afcec803a0d323e9a17f3ea8e68e8abe5a278020a929adbec52421adbd0,03a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bd,03669b8afcec803a0d323e9a17f3ea8e68e8abe5a278020a929adbec52421adbd0))"); err != nil {
+		t.Errorf("FAIL: %s", err)
+	}
+}
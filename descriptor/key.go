@@ -0,0 +1,205 @@
+package descriptor
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/VIVelev/btcd/crypto/elliptic"
+	"github.com/VIVelev/btcd/encoding"
+	"github.com/VIVelev/btcd/hdkey"
+)
+
+type wildcard int
+
+const (
+	noWildcard wildcard = iota
+	unhardenedWildcard
+	hardenedWildcard
+)
+
+// keyExpr is a KEY expression, for example "[d34db33f/84'/0'/0']xpub.../0/*".
+type keyExpr struct {
+	// Key origin, optional.
+	hasOrigin   bool
+	fingerprint [4]byte
+	originPath  []uint32
+
+	// Either a constant key, given as a hex public key or a WIF private key,
+	pubKey []byte
+	xOnly  bool // pubKey was given as the 32 byte x-only key, in tr()
+	wif    string
+	// or an extended key with a derivation path.
+	extKey   *hdkey.ExtendedKey
+	path     []uint32
+	wildcard wildcard
+}
+
+// parseKey parses the KEY expression s, valid in the given context.
+func parseKey(s string, ctx context) (*keyExpr, error) {
+	k := new(keyExpr)
+
+	if strings.HasPrefix(s, "[") {
+		end := strings.IndexByte(s, ']')
+		if end < 0 {
+			return nil, errors.New("key origin is missing ']'")
+		}
+		origin := strings.SplitN(s[1:end], "/", 2)
+		fp, err := hex.DecodeString(origin[0])
+		if err != nil || len(fp) != 4 {
+			return nil, errors.New("key origin fingerprint should be 8 hex characters")
+		}
+		k.hasOrigin = true
+		copy(k.fingerprint[:], fp)
+		if len(origin) == 2 {
+			if k.originPath, err = hdkey.ParsePath(origin[1]); err != nil {
+				return nil, err
+			}
+		}
+		s = s[end+1:]
+	}
+
+	// hex encoded public key
+	if b, err := hex.DecodeString(s); err == nil {
+		if len(b) == 32 {
+			if ctx != trContext {
+				return nil, errors.New("x-only public keys are only allowed in tr()")
+			}
+			b = append([]byte{0x02}, b...)
+			k.xOnly = true
+		} else if len(b) == 65 && (ctx == wshContext || ctx == wpkhContext || ctx == trContext) {
+			return nil, errors.New("uncompressed public keys are not allowed in segwit")
+		}
+		if _, _, err := elliptic.Unmarshal(elliptic.Secp256k1, b); err != nil {
+			return nil, err
+		}
+		k.pubKey = b
+		return k, nil
+	}
+
+	// extended key, optionally followed by a derivation path
+	parts := strings.Split(s, "/")
+	if extKey, err := hdkey.Parse(parts[0]); err == nil {
+		k.extKey = extKey
+		elements := parts[1:]
+		if n := len(elements); n > 0 {
+			switch elements[n-1] {
+			case "*":
+				k.wildcard = unhardenedWildcard
+				elements = elements[:n-1]
+			case "*'", "*h", "*H":
+				k.wildcard = hardenedWildcard
+				elements = elements[:n-1]
+			}
+		}
+		for _, e := range elements {
+			index, err := hdkey.ParseIndex(e)
+			if err != nil {
+				return nil, err
+			}
+			k.path = append(k.path, index)
+		}
+		if !extKey.IsPrivate() && k.hasHardenedStep() {
+			return nil, errors.New("hardened derivation from an extended public key")
+		}
+		return k, nil
+	}
+
+	// private key in WIF format
+	priv, compressed, _, err := encoding.ParseWif(s)
+	if err != nil {
+		return nil, errors.New("invalid key " + s)
+	}
+	if !compressed && (ctx == wshContext || ctx == wpkhContext || ctx == trContext) {
+		return nil, errors.New("uncompressed private keys are not allowed in segwit")
+	}
+	k.wif = s
+	if compressed {
+		k.pubKey = priv.PublicKey.MarshalCompressed()
+	} else {
+		k.pubKey = priv.PublicKey.Marshal()
+	}
+	return k, nil
+}
+
+func (k *keyExpr) hasHardenedStep() bool {
+	for _, i := range k.path {
+		if i >= hdkey.HardenedOffset {
+			return true
+		}
+	}
+	return k.wildcard == hardenedWildcard
+}
+
+// isRange returns whether the key ends with a wildcard.
+func (k *keyExpr) isRange() bool {
+	return k.wildcard != noWildcard
+}
+
+// pubKeyAt returns the SEC public key, derived at the index for wildcard keys.
+func (k *keyExpr) pubKeyAt(index uint32) ([]byte, error) {
+	if k.extKey == nil {
+		return k.pubKey, nil
+	}
+
+	if k.isRange() && index >= hdkey.HardenedOffset {
+		return nil, errors.New("wildcard index should be less than 2^31")
+	}
+	path := k.path
+	switch k.wildcard {
+	case unhardenedWildcard:
+		path = append(append([]uint32{}, path...), index)
+	case hardenedWildcard:
+		path = append(append([]uint32{}, path...), index+hdkey.HardenedOffset)
+	}
+	derived, err := k.extKey.Derive(path)
+	if err != nil {
+		return nil, err
+	}
+	return derived.PublicKey.MarshalCompressed(), nil
+}
+
+// xOnlyPubKeyAt returns the 32 byte x-only public key, as used in taproot.
+func (k *keyExpr) xOnlyPubKeyAt(index uint32) (ret [32]byte, err error) {
+	pub, err := k.pubKeyAt(index)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	copy(ret[:], pub[1:33])
+	return ret, nil
+}
+
+func (k *keyExpr) String() string {
+	var b strings.Builder
+	if k.hasOrigin {
+		b.WriteString("[")
+		b.WriteString(hex.EncodeToString(k.fingerprint[:]))
+		for _, i := range k.originPath {
+			b.WriteString("/")
+			b.WriteString(hdkey.FormatIndex(i))
+		}
+		b.WriteString("]")
+	}
+
+	switch {
+	case k.wif != "":
+		b.WriteString(k.wif)
+	case k.xOnly:
+		b.WriteString(hex.EncodeToString(k.pubKey[1:]))
+	case k.extKey == nil:
+		b.WriteString(hex.EncodeToString(k.pubKey))
+	default:
+		b.WriteString(k.extKey.String())
+		for _, i := range k.path {
+			b.WriteString("/")
+			b.WriteString(hdkey.FormatIndex(i))
+		}
+		switch k.wildcard {
+		case unhardenedWildcard:
+			b.WriteString("/*")
+		case hardenedWildcard:
+			b.WriteString("/*'")
+		}
+	}
+	return b.String()
+}
@@ -4,7 +4,11 @@ package encoding
 
 import (
 	"bytes"
+	"errors"
 	"math/big"
+	"strings"
+
+	"github.com/VIVelev/btcd/crypto/hash"
 )
 
 const alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
@@ -60,5 +64,38 @@ func base58decode(s string) []byte {
 		exp.Exp(fiftyEight, p.SetUint64(uint64(max-i)), nil)
 		n.Add(n, v.Mul(v, exp))
 	}
-	return n.Bytes()
+
+	// handle the leading 0 bytes, each one is encoded as the first letter
+	numLeadingZeros := len(s) - len(strings.TrimLeft(s, alphabet[:1]))
+	return append(make([]byte, numLeadingZeros), n.Bytes()...)
+}
+
+// Base58CheckEncode encodes the payload in base58 with a 4 byte
+// Hash256 checksum appended to it.
+//
+// reference: https://en.bitcoin.it/wiki/Base58Check_encoding
+func Base58CheckEncode(payload []byte) string {
+	checksum := hash.Hash256(payload)
+	return base58encode(append(append([]byte{}, payload...), checksum[:4]...))
+}
+
+// Base58CheckDecode decodes s and returns the payload without the checksum.
+//
+// Returns error if s contains invalid characters or the checksum doesn't match.
+func Base58CheckDecode(s string) ([]byte, error) {
+	for i := range s {
+		if _, ok := alphabetInv[s[i]]; !ok {
+			return nil, errors.New("Base58CheckDecode: invalid character")
+		}
+	}
+	buf := base58decode(s)
+	if len(buf) < 4 {
+		return nil, errors.New("Base58CheckDecode: too short")
+	}
+	payload, check := buf[:len(buf)-4], buf[len(buf)-4:]
+	checksum := hash.Hash256(payload)
+	if !bytes.Equal(check, checksum[:4]) {
+		return nil, errors.New("Base58CheckDecode: checksums don't match")
+	}
+	return payload, nil
 }
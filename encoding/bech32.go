@@ -0,0 +1,203 @@
+package encoding
+
+// bech32 and bech32m encoding / decoding functions
+//
+// reference: https://github.com/bitcoin/bips/blob/master/bip-0173.mediawiki
+// reference: https://github.com/bitcoin/bips/blob/master/bip-0350.mediawiki
+
+import (
+	"errors"
+	"strings"
+)
+
+const bech32Alphabet = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+const (
+	bech32Const  = 1
+	bech32mConst = 0x2bc830a3
+)
+
+var bech32AlphabetInv map[byte]byte
+
+func init() {
+	bech32AlphabetInv = make(map[byte]byte, 32)
+	for i, c := range bech32Alphabet {
+		bech32AlphabetInv[byte(c)] = byte(i)
+	}
+}
+
+func bech32Polymod(values []byte) uint32 {
+	generator := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HrpExpand(hrp string) []byte {
+	ret := make([]byte, 0, 2*len(hrp)+1)
+	for i := range hrp {
+		ret = append(ret, hrp[i]>>5)
+	}
+	ret = append(ret, 0)
+	for i := range hrp {
+		ret = append(ret, hrp[i]&31)
+	}
+	return ret
+}
+
+// Bech32Encode encodes the 5-bit groups of data with the human readable part hrp.
+// If bech32m is true, the bech32m checksum constant is used.
+func Bech32Encode(hrp string, data []byte, bech32m bool) string {
+	c := uint32(bech32Const)
+	if bech32m {
+		c = bech32mConst
+	}
+	values := append(bech32HrpExpand(hrp), data...)
+	polymod := bech32Polymod(append(values, 0, 0, 0, 0, 0, 0)) ^ c
+
+	var b strings.Builder
+	b.WriteString(hrp)
+	b.WriteByte('1')
+	for _, d := range data {
+		b.WriteByte(bech32Alphabet[d])
+	}
+	for i := 0; i < 6; i++ {
+		b.WriteByte(bech32Alphabet[(polymod>>(5*(5-i)))&31])
+	}
+	return b.String()
+}
+
+// Bech32Decode decodes s into its human readable part and 5-bit groups of data.
+// The returned bech32m reports which checksum constant matched.
+func Bech32Decode(s string) (hrp string, data []byte, bech32m bool, err error) {
+	if len(s) > 90 {
+		return "", nil, false, errors.New("Bech32Decode: too long")
+	}
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, false, errors.New("Bech32Decode: mixed case")
+	}
+	s = strings.ToLower(s)
+
+	sep := strings.LastIndexByte(s, '1')
+	if sep < 1 || sep+7 > len(s) {
+		return "", nil, false, errors.New("Bech32Decode: invalid separator position")
+	}
+	hrp = s[:sep]
+	for i := range hrp {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", nil, false, errors.New("Bech32Decode: invalid character")
+		}
+	}
+	for i := sep + 1; i < len(s); i++ {
+		d, ok := bech32AlphabetInv[s[i]]
+		if !ok {
+			return "", nil, false, errors.New("Bech32Decode: invalid character")
+		}
+		data = append(data, d)
+	}
+
+	switch bech32Polymod(append(bech32HrpExpand(hrp), data...)) {
+	case bech32Const:
+		bech32m = false
+	case bech32mConst:
+		bech32m = true
+	default:
+		return "", nil, false, errors.New("Bech32Decode: invalid checksum")
+	}
+	return hrp, data[:len(data)-6], bech32m, nil
+}
+
+// convertBits regroups the bits of data from groups of fromBits to groups of toBits.
+func convertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	var acc, bits uint
+	var ret []byte
+	maxv := uint(1)<<toBits - 1
+	for _, v := range data {
+		if uint(v)>>fromBits != 0 {
+			return nil, errors.New("convertBits: invalid data range")
+		}
+		acc = acc<<fromBits | uint(v)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			ret = append(ret, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			ret = append(ret, byte(acc<<(toBits-bits)&maxv))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&maxv != 0 {
+		return nil, errors.New("convertBits: invalid padding")
+	}
+	return ret, nil
+}
+
+func segWitHrp(testnet bool) string {
+	if testnet {
+		return "tb"
+	}
+	return "bc"
+}
+
+// SegWitAddress returns the address of the witness program with the version.
+// Version 0 uses bech32 and versions 1 through 16 use bech32m.
+func SegWitAddress(version byte, program []byte, testnet bool) (string, error) {
+	if err := validateWitnessProgram(version, program); err != nil {
+		return "", err
+	}
+	data, _ := convertBits(program, 8, 5, true)
+	return Bech32Encode(segWitHrp(testnet), append([]byte{version}, data...), version != 0), nil
+}
+
+// DecodeSegWitAddress recovers the witness version and program from the address.
+func DecodeSegWitAddress(addr string) (version byte, program []byte, testnet bool, err error) {
+	hrp, data, bech32m, err := Bech32Decode(addr)
+	if err != nil {
+		return 0, nil, false, err
+	}
+	switch hrp {
+	case "bc":
+		testnet = false
+	case "tb":
+		testnet = true
+	default:
+		return 0, nil, false, errors.New("DecodeSegWitAddress: unknown human readable part")
+	}
+	if len(data) < 1 {
+		return 0, nil, false, errors.New("DecodeSegWitAddress: empty data")
+	}
+	version = data[0]
+	if (version == 0) == bech32m {
+		return 0, nil, false, errors.New("DecodeSegWitAddress: wrong checksum variant for the version")
+	}
+	program, err = convertBits(data[1:], 5, 8, false)
+	if err != nil {
+		return 0, nil, false, err
+	}
+	if err = validateWitnessProgram(version, program); err != nil {
+		return 0, nil, false, err
+	}
+	return version, program, testnet, nil
+}
+
+func validateWitnessProgram(version byte, program []byte) error {
+	if version > 16 {
+		return errors.New("invalid witness version")
+	}
+	if len(program) < 2 || len(program) > 40 {
+		return errors.New("invalid witness program length")
+	}
+	if version == 0 && len(program) != 20 && len(program) != 32 {
+		return errors.New("invalid witness program length for version 0")
+	}
+	return nil
+}
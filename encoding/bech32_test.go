@@ -0,0 +1,66 @@
+package encoding
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// Test vectors from BIP173 and BIP350.
+var segWitVectors = []struct {
+	address      string
+	version      byte
+	program      string
+	testnet      bool
+	canonicalize bool // whether the address is not in lower case
+}{
+	{"BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", 0, "751e76e8199196d454941c45d1b3a323f1433bd6", false, true},
+	{"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7", 0, "1863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262", true, false},
+	{"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", 1, "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798", false, false},
+}
+
+func TestDecodeSegWitAddress(t *testing.T) {
+	for _, v := range segWitVectors {
+		version, program, testnet, err := DecodeSegWitAddress(v.address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != v.version || hex.EncodeToString(program) != v.program || testnet != v.testnet {
+			t.Errorf("FAIL: %s", v.address)
+		}
+	}
+}
+
+func TestSegWitAddress(t *testing.T) {
+	for _, v := range segWitVectors {
+		program, _ := hex.DecodeString(v.program)
+		addr, err := SegWitAddress(v.version, program, v.testnet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.canonicalize {
+			if addr != "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4" {
+				t.Errorf("FAIL: %s", addr)
+			}
+		} else if addr != v.address {
+			t.Errorf("FAIL: %s", addr)
+		}
+	}
+}
+
+func TestDecodeSegWitAddressInvalid(t *testing.T) {
+	program, _ := hex.DecodeString("751e76e8199196d454941c45d1b3a323f1433bd6")
+	data, _ := convertBits(program, 8, 5, true)
+	// version 0 must use bech32, version 1 must use bech32m
+	for _, addr := range []string{
+		Bech32Encode("bc", append([]byte{0}, data...), true),
+		Bech32Encode("bc", append([]byte{1}, data...), false),
+		Bech32Encode("xy", append([]byte{0}, data...), false),
+		"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t5", // invalid checksum
+		"bc1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", // mixed case
+		"bc1gmk9yu", // empty data
+	} {
+		if _, _, _, err := DecodeSegWitAddress(addr); err == nil {
+			t.Errorf("FAIL: %s", addr)
+		}
+	}
+}
@@ -3,37 +3,73 @@ package encoding
 import (
 	"bytes"
 	"errors"
+	"math/big"
 
 	"github.com/VIVelev/btcd/crypto/ecdsa"
+	"github.com/VIVelev/btcd/crypto/elliptic"
 	"github.com/VIVelev/btcd/crypto/hash"
 )
 
+// Version bytes of the base58check addresses.
+const (
+	mainnetPubKeyHash = 0x00
+	testnetPubKeyHash = 0x6f
+	mainnetScriptHash = 0x05
+	testnetScriptHash = 0xc4
+)
+
 // Address returns the associated bitcoin address in base58check for
 // the public key as string.
 //
 // reference: https://en.bitcoin.it/wiki/Base58Check_encoding
 func Address(pub *ecdsa.PublicKey, compressed, testnet bool) string {
-	// this is the raw (non-encoded) bitcoin address
-	var netPkHashCheck [25]byte
 	if compressed {
-		src := hash.Hash160(pub.MarshalCompressed())
-		copy(netPkHashCheck[1:21], src[:])
-	} else {
-		src := hash.Hash160(pub.Marshal())
-		copy(netPkHashCheck[1:21], src[:])
+		return PubKeyHashAddress(hash.Hash160(pub.MarshalCompressed()), testnet)
 	}
+	return PubKeyHashAddress(hash.Hash160(pub.Marshal()), testnet)
+}
+
+// PubKeyHashAddress returns the Pay-to-PubkeyHash address of the public key hash.
+func PubKeyHashAddress(h160 [20]byte, testnet bool) string {
 	if testnet {
-		netPkHashCheck[0] = 0x6f
-	} else {
-		netPkHashCheck[0] = 0x00
+		return Base58CheckEncode(append([]byte{testnetPubKeyHash}, h160[:]...))
 	}
+	return Base58CheckEncode(append([]byte{mainnetPubKeyHash}, h160[:]...))
+}
 
-	// calculate the checksum
-	checksum := hash.Hash256(netPkHashCheck[:21])
-	copy(netPkHashCheck[21:], checksum[:4])
+// ScriptHashAddress returns the Pay-to-ScriptHash address of the script hash.
+//
+// reference: https://github.com/bitcoin/bips/blob/master/bip-0013.mediawiki
+func ScriptHashAddress(h160 [20]byte, testnet bool) string {
+	if testnet {
+		return Base58CheckEncode(append([]byte{testnetScriptHash}, h160[:]...))
+	}
+	return Base58CheckEncode(append([]byte{mainnetScriptHash}, h160[:]...))
+}
 
-	// encode in base58 and return
-	return base58encode(netPkHashCheck[:])
+// DecodeBase58Address recovers the hash from a Pay-to-PubkeyHash or a Pay-to-ScriptHash address.
+// scriptHash reports whether the address is Pay-to-ScriptHash.
+func DecodeBase58Address(s string) (h160 [20]byte, scriptHash, testnet bool, err error) {
+	payload, err := Base58CheckDecode(s)
+	if err != nil {
+		return [20]byte{}, false, false, err
+	}
+	if len(payload) != 21 {
+		return [20]byte{}, false, false, errors.New("DecodeBase58Address: invalid length")
+	}
+	switch payload[0] {
+	case mainnetPubKeyHash:
+	case testnetPubKeyHash:
+		testnet = true
+	case mainnetScriptHash:
+		scriptHash = true
+	case testnetScriptHash:
+		scriptHash, testnet = true, true
+	default:
+		return [20]byte{}, false, false, errors.New("DecodeBase58Address: unknown version")
+	}
+	copy(h160[:], payload[1:])
+	return h160, scriptHash, testnet, nil
 }
 
 // AddressToPubKeyHash recovers the public key hash from an address
@@ -85,3 +121,36 @@ func Wif(priv *ecdsa.PrivateKey, compressed, testnet bool) string {
 	copy(wif[size-4:], checksum[:4])
 	return base58encode(wif)
 }
+
+// ParseWif decodes a private key in WIF format.
+func ParseWif(s string) (priv *ecdsa.PrivateKey, compressed, testnet bool, err error) {
+	payload, err := Base58CheckDecode(s)
+	if err != nil {
+		return nil, false, false, err
+	}
+	switch len(payload) {
+	case 33:
+		compressed = false
+	case 34:
+		if payload[33] != 0x01 {
+			return nil, false, false, errors.New("ParseWif: invalid compression suffix")
+		}
+		compressed = true
+	default:
+		return nil, false, false, errors.New("ParseWif: invalid length")
+	}
+	switch payload[0] {
+	case 0x80:
+		testnet = false
+	case 0xef:
+		testnet = true
+	default:
+		return nil, false, false, errors.New("ParseWif: unknown version")
+	}
+
+	secret := new(big.Int).SetBytes(payload[1:33])
+	if secret.Sign() == 0 || secret.Cmp(elliptic.Secp256k1.N) >= 0 {
+		return nil, false, false, errors.New("ParseWif: private key out of range")
+	}
+	return ecdsa.GenerateKeyFromSecret(elliptic.Secp256k1, secret), compressed, testnet, nil
+}
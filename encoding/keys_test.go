@@ -1,11 +1,13 @@
 package encoding
 
 import (
+	"encoding/hex"
 	"strings"
 	"testing"
 
 	"github.com/VIVelev/btcd/crypto/ecdsa"
 	"github.com/VIVelev/btcd/crypto/elliptic"
+	"github.com/VIVelev/btcd/crypto/hash"
 )
 
 func TestAddress(t *testing.T) {
@@ -16,3 +18,48 @@ func TestAddress(t *testing.T) {
 		t.Errorf("FAIL")
 	}
 }
+
+func TestAddressToPubKeyHash(t *testing.T) {
+	priv := ecdsa.GenerateKey(elliptic.Secp256k1, "vivelev@icloud.comiamfrombetelgeuse")
+	want := hash.Hash160(priv.PublicKey.MarshalCompressed())
+
+	// mainnet addresses start with a 0x00 byte, which is encoded as a leading "1"
+	for _, testnet := range []bool{true, false} {
+		h160, err := AddressToPubKeyHash(Address(&priv.PublicKey, true, testnet))
+		if err != nil {
+			t.Error(err)
+		}
+		if h160 != want {
+			t.Errorf("FAIL")
+		}
+	}
+}
+
+func TestScriptHashAddress(t *testing.T) {
+	b, _ := hex.DecodeString("74d691da1574e6b3c192ecfb52cc8984ee7b6c56")
+	var h160 [20]byte
+	copy(h160[:], b)
+	addr := ScriptHashAddress(h160, false)
+	if addr != "3CLoMMyuoDQTPRD3XYZtCvgvkadrAdvdXh" {
+		t.Errorf("FAIL")
+	}
+	decoded, scriptHash, testnet, err := DecodeBase58Address(addr)
+	if err != nil || decoded != h160 || !scriptHash || testnet {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestParseWif(t *testing.T) {
+	priv := ecdsa.GenerateKey(elliptic.Secp256k1, "vivelev@icloud.comiamfrombetelgeuse")
+	for _, compressed := range []bool{true, false} {
+		for _, testnet := range []bool{true, false} {
+			parsed, c, tn, err := ParseWif(Wif(priv, compressed, testnet))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed.D.Cmp(priv.D) != 0 || c != compressed || tn != testnet {
+				t.Errorf("FAIL")
+			}
+		}
+	}
+}
@@ -0,0 +1,323 @@
+// Package hdkey implements hierarchical deterministic keys, as defined in
+// https://github.com/bitcoin/bips/blob/master/bip-0032.mediawiki
+package hdkey
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"math/big"
+
+	"github.com/VIVelev/btcd/crypto/ecdsa"
+	"github.com/VIVelev/btcd/crypto/elliptic"
+	"github.com/VIVelev/btcd/crypto/hash"
+	"github.com/VIVelev/btcd/encoding"
+)
+
+// HardenedOffset is the index of the first hardened child.
+const HardenedOffset = uint32(0x80000000)
+
+// serializedLen is the length of a serialized extended key (without the checksum):
+// [4 version][1 depth][4 parent fingerprint][4 child number][32 chain code][33 key]
+const serializedLen = 78
+
+// Version bytes of the serialized extended keys.
+var (
+	// BIP32
+	XprvVersion = [4]byte{0x04, 0x88, 0xad, 0xe4}
+	XpubVersion = [4]byte{0x04, 0x88, 0xb2, 0x1e}
+	TprvVersion = [4]byte{0x04, 0x35, 0x83, 0x94}
+	TpubVersion = [4]byte{0x04, 0x35, 0x87, 0xcf}
+	// BIP49, P2WPKH nested in P2SH
+	YprvVersion = [4]byte{0x04, 0x9d, 0x78, 0x78}
+	YpubVersion = [4]byte{0x04, 0x9d, 0x7c, 0xb2}
+	UprvVersion = [4]byte{0x04, 0x4a, 0x4e, 0x28}
+	UpubVersion = [4]byte{0x04, 0x4a, 0x52, 0x62}
+	// BIP84, native P2WPKH
+	ZprvVersion = [4]byte{0x04, 0xb2, 0x43, 0x0c}
+	ZpubVersion = [4]byte{0x04, 0xb2, 0x47, 0x46}
+	VprvVersion = [4]byte{0x04, 0x5f, 0x18, 0xbc}
+	VpubVersion = [4]byte{0x04, 0x5f, 0x1c, 0xf6}
+)
+
+// versionPairs maps each private version to its public counterpart.
+var versionPairs = map[[4]byte][4]byte{
+	XprvVersion: XpubVersion,
+	TprvVersion: TpubVersion,
+	YprvVersion: YpubVersion,
+	UprvVersion: UpubVersion,
+	ZprvVersion: ZpubVersion,
+	VprvVersion: VpubVersion,
+}
+
+// ExtendedKey is a private or public key extended with a chain code,
+// from which a tree of child keys can be derived.
+type ExtendedKey struct {
+	Version           [4]byte  // Determines the network and whether the key is private.
+	Depth             uint8    // 0 for the master key, 1 for its children, ...
+	ParentFingerprint [4]byte  // The first 4 bytes of Hash160 of the parent's public key.
+	ChildNumber       uint32   // The index of this key in its parent's children.
+	ChainCode         [32]byte // The extra 256 bits of entropy.
+
+	PrivateKey *ecdsa.PrivateKey // nil for public extended keys
+	PublicKey  *ecdsa.PublicKey
+}
+
+// NewMaster generates a master extended private key from the seed.
+// The seed should be between 128 and 512 bits long.
+func NewMaster(seed []byte, testnet bool) (*ExtendedKey, error) {
+	if len(seed) < 16 || len(seed) > 64 {
+		return nil, errors.New("NewMaster: seed should be between 128 and 512 bits")
+	}
+
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	I := mac.Sum(nil)
+
+	secret := new(big.Int).SetBytes(I[:32])
+	if secret.Sign() == 0 || secret.Cmp(elliptic.Secp256k1.N) >= 0 {
+		return nil, errors.New("NewMaster: invalid master key, use another seed")
+	}
+
+	key := new(ExtendedKey)
+	if testnet {
+		key.Version = TprvVersion
+	} else {
+		key.Version = XprvVersion
+	}
+	copy(key.ChainCode[:], I[32:])
+	key.PrivateKey = ecdsa.GenerateKeyFromSecret(elliptic.Secp256k1, secret)
+	key.PublicKey = &key.PrivateKey.PublicKey
+	return key, nil
+}
+
+// IsPrivate returns whether this is an extended private key.
+func (k *ExtendedKey) IsPrivate() bool {
+	return k.PrivateKey != nil
+}
+
+// Fingerprint returns the first 4 bytes of Hash160 of the public key.
+func (k *ExtendedKey) Fingerprint() (ret [4]byte) {
+	h160 := hash.Hash160(k.PublicKey.MarshalCompressed())
+	copy(ret[:], h160[:4])
+	return
+}
+
+// Neuter returns the extended public key corresponding to k, a copy of it if it is already public.
+// The returned key shares no memory with k.
+func (k *ExtendedKey) Neuter() (*ExtendedKey, error) {
+	version := k.Version
+	if k.IsPrivate() {
+		var ok bool
+		if version, ok = versionPairs[k.Version]; !ok {
+			return nil, errors.New("Neuter: unknown version")
+		}
+	}
+
+	pub := *k
+	pub.Version = version
+	pub.PrivateKey = nil
+	pub.PublicKey = &ecdsa.PublicKey{
+		Curve: k.PublicKey.Curve,
+		X:     new(big.Int).Set(k.PublicKey.X),
+		Y:     new(big.Int).Set(k.PublicKey.Y),
+	}
+	return &pub, nil
+}
+
+// Child derives the child key with the index i.
+// Indices starting from HardenedOffset derive hardened children,
+// which is only possible from extended private keys.
+//
+// Private keys derive private children and public keys derive public children.
+func (k *ExtendedKey) Child(i uint32) (*ExtendedKey, error) {
+	if k.Depth == 0xff {
+		return nil, errors.New("Child: maximum depth reached")
+	}
+	hardened := i >= HardenedOffset
+	if hardened && !k.IsPrivate() {
+		return nil, errors.New("Child: can't derive a hardened child from a public key")
+	}
+
+	// data = 0x00 || ser256(kpar) || ser32(i), for hardened children
+	// data = serP(Kpar) || ser32(i), for normal children
+	data := make([]byte, 37)
+	if hardened {
+		k.PrivateKey.D.FillBytes(data[1:33])
+	} else {
+		copy(data, k.PublicKey.MarshalCompressed())
+	}
+	binary.BigEndian.PutUint32(data[33:], i)
+
+	mac := hmac.New(sha512.New, k.ChainCode[:])
+	mac.Write(data)
+	I := mac.Sum(nil)
+
+	curve := elliptic.Secp256k1
+	il := new(big.Int).SetBytes(I[:32])
+	if il.Cmp(curve.N) >= 0 {
+		return nil, errors.New("Child: invalid child, proceed with the next index")
+	}
+
+	child := new(ExtendedKey)
+	child.Version = k.Version
+	child.Depth = k.Depth + 1
+	child.ParentFingerprint = k.Fingerprint()
+	child.ChildNumber = i
+	copy(child.ChainCode[:], I[32:])
+
+	if k.IsPrivate() {
+		// ki = parse256(IL) + kpar (mod n)
+		secret := il.Add(il, k.PrivateKey.D)
+		secret.Mod(secret, curve.N)
+		if secret.Sign() == 0 {
+			return nil, errors.New("Child: invalid child, proceed with the next index")
+		}
+		child.PrivateKey = ecdsa.GenerateKeyFromSecret(curve, secret)
+		child.PublicKey = &child.PrivateKey.PublicKey
+	} else {
+		// Ki = point(parse256(IL)) + Kpar
+		x, y := curve.ScalarBaseMult(il)
+		x, y = curve.Add(x, y, k.PublicKey.X, k.PublicKey.Y)
+		if x.Sign() == 0 && y.Sign() == 0 {
+			return nil, errors.New("Child: invalid child, proceed with the next index")
+		}
+		child.PublicKey = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+	}
+
+	return child, nil
+}
+
+// Derive derives the descendant key following the path of child indices.
+func (k *ExtendedKey) Derive(path []uint32) (*ExtendedKey, error) {
+	var err error
+	for _, i := range path {
+		k, err = k.Child(i)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// DerivePath derives the descendant key following the path in text format,
+// for example "m/84'/0'/0'/0/5".
+func (k *ExtendedKey) DerivePath(path string) (*ExtendedKey, error) {
+	p, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	return k.Derive(p)
+}
+
+// Marshal serializes k in the 78 byte format:
+//
+//	[4 version][1 depth][4 parent fingerprint][4 child number][32 chain code][33 key]
+func (k *ExtendedKey) Marshal() []byte {
+	buf := make([]byte, serializedLen)
+	copy(buf[:4], k.Version[:])
+	buf[4] = k.Depth
+	copy(buf[5:9], k.ParentFingerprint[:])
+	binary.BigEndian.PutUint32(buf[9:13], k.ChildNumber)
+	copy(buf[13:45], k.ChainCode[:])
+	if k.IsPrivate() {
+		// 0x00 || ser256(k)
+		k.PrivateKey.D.FillBytes(buf[46:])
+	} else {
+		// serP(K)
+		copy(buf[45:], k.PublicKey.MarshalCompressed())
+	}
+	return buf
+}
+
+// Unmarshal parses an extended key serialized in the 78 byte format.
+func (k *ExtendedKey) Unmarshal(buf []byte) (*ExtendedKey, error) {
+	if len(buf) != serializedLen {
+		return nil, errors.New("Unmarshal: invalid length")
+	}
+	// k is left untouched on error
+	var key ExtendedKey
+
+	copy(key.Version[:], buf[:4])
+	key.Depth = buf[4]
+	copy(key.ParentFingerprint[:], buf[5:9])
+	key.ChildNumber = binary.BigEndian.Uint32(buf[9:13])
+	copy(key.ChainCode[:], buf[13:45])
+
+	if key.Depth == 0 && (key.ParentFingerprint != [4]byte{} || key.ChildNumber != 0) {
+		return nil, errors.New("Unmarshal: master key with a parent")
+	}
+
+	_, isPrivate := versionPairs[key.Version]
+	isPublic := false
+	for _, v := range versionPairs {
+		isPublic = isPublic || v == key.Version
+	}
+
+	curve := elliptic.Secp256k1
+	switch {
+	case isPrivate:
+		if buf[45] != 0x00 {
+			return nil, errors.New("Unmarshal: invalid private key prefix")
+		}
+		secret := new(big.Int).SetBytes(buf[46:])
+		if secret.Sign() == 0 || secret.Cmp(curve.N) >= 0 {
+			return nil, errors.New("Unmarshal: private key out of range")
+		}
+		key.PrivateKey = ecdsa.GenerateKeyFromSecret(curve, secret)
+		key.PublicKey = &key.PrivateKey.PublicKey
+	case isPublic:
+		if buf[45] != 0x02 && buf[45] != 0x03 {
+			return nil, errors.New("Unmarshal: invalid public key prefix")
+		}
+		pub := &ecdsa.PublicKey{Curve: curve}
+		if _, err := pub.Unmarshal(buf[45:]); err != nil {
+			return nil, err
+		}
+		key.PublicKey = pub
+	default:
+		return nil, errors.New("Unmarshal: unknown version")
+	}
+
+	*k = key
+	return k, nil
+}
+
+// String returns the Base58Check encoding of k, for example "xprv...".
+func (k *ExtendedKey) String() string {
+	return encoding.Base58CheckEncode(k.Marshal())
+}
+
+// SetVersion changes the version bytes, keeping the private/public kind of the key.
+// This is used to switch between the xpub/ypub/zpub (and testnet) variants.
+func (k *ExtendedKey) SetVersion(private, public [4]byte) (*ExtendedKey, error) {
+	if versionPairs[private] != public {
+		return nil, errors.New("SetVersion: not a private/public version pair")
+	}
+	if k.IsPrivate() {
+		k.Version = private
+	} else {
+		k.Version = public
+	}
+	return k, nil
+}
+
+// IsTestnet returns whether the version of k is a testnet one.
+func (k *ExtendedKey) IsTestnet() bool {
+	for _, v := range [][4]byte{TprvVersion, TpubVersion, UprvVersion, UpubVersion, VprvVersion, VpubVersion} {
+		if k.Version == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse decodes an extended key from its Base58Check encoding.
+func Parse(s string) (*ExtendedKey, error) {
+	buf, err := encoding.Base58CheckDecode(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(ExtendedKey).Unmarshal(buf)
+}
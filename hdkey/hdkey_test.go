@@ -0,0 +1,262 @@
+package hdkey
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+const h = HardenedOffset
+
+// Test vectors from https://github.com/bitcoin/bips/blob/master/bip-0032.mediawiki#test-vectors
+var bip32Vectors = []struct {
+	seed string
+	path []uint32
+	xpub string
+	xprv string
+}{
+	// Test vector 1
+	{
+		"000102030405060708090a0b0c0d0e0f",
+		[]uint32{},
+		"xpub661MyMwAqRbcFtXgS5sYJABqqG9YLmC4Q1Rdap9gSE8NqtwybGhePY2gZ29ESFjqJoCu1Rupje8YtGqsefD265TMg7usUDFdp6W1EGMcet8",
+		"xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvNKmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHi",
+	},
+	{
+		"000102030405060708090a0b0c0d0e0f",
+		[]uint32{h},
+		"xpub68Gmy5EdvgibQVfPdqkBBCHxA5htiqg55crXYuXoQRKfDBFA1WEjWgP6LHhwBZeNK1VTsfTFUHCdrfp1bgwQ9xv5ski8PX9rL2dZXvgGDnw",
+		"xprv9uHRZZhk6KAJC1avXpDAp4MDc3sQKNxDiPvvkX8Br5ngLNv1TxvUxt4cV1rGL5hj6KCesnDYUhd7oWgT11eZG7XnxHrnYeSvkzY7d2bhkJ7",
+	},
+	{
+		"000102030405060708090a0b0c0d0e0f",
+		[]uint32{h, 1},
+		"xpub6ASuArnXKPbfEwhqN6e3mwBcDTgzisQN1wXN9BJcM47sSikHjJf3UFHKkNAWbWMiGj7Wf5uMash7SyYq527Hqck2AxYysAA7xmALppuCkwQ",
+		"xprv9wTYmMFdV23N2TdNG573QoEsfRrWKQgWeibmLntzniatZvR9BmLnvSxqu53Kw1UmYPxLgboyZQaXwTCg8MSY3H2EU4pWcQDnRnrVA1xe8fs",
+	},
+	{
+		"000102030405060708090a0b0c0d0e0f",
+		[]uint32{h, 1, h + 2},
+		"xpub6D4BDPcP2GT577Vvch3R8wDkScZWzQzMMUm3PWbmWvVJrZwQY4VUNgqFJPMM3No2dFDFGTsxxpG5uJh7n7epu4trkrX7x7DogT5Uv6fcLW5",
+		"xprv9z4pot5VBttmtdRTWfWQmoH1taj2axGVzFqSb8C9xaxKymcFzXBDptWmT7FwuEzG3ryjH4ktypQSAewRiNMjANTtpgP4mLTj34bhnZX7UiM",
+	},
+	{
+		"000102030405060708090a0b0c0d0e0f",
+		[]uint32{h, 1, h + 2, 2},
+		"xpub6FHa3pjLCk84BayeJxFW2SP4XRrFd1JYnxeLeU8EqN3vDfZmbqBqaGJAyiLjTAwm6ZLRQUMv1ZACTj37sR62cfN7fe5JnJ7dh8zL4fiyLHV",
+		"xprvA2JDeKCSNNZky6uBCviVfJSKyQ1mDYahRjijr5idH2WwLsEd4Hsb2Tyh8RfQMuPh7f7RtyzTtdrbdqqsunu5Mm3wDvUAKRHSC34sJ7in334",
+	},
+	{
+		"000102030405060708090a0b0c0d0e0f",
+		[]uint32{h, 1, h + 2, 2, 1000000000},
+		"xpub6H1LXWLaKsWFhvm6RVpEL9P4KfRZSW7abD2ttkWP3SSQvnyA8FSVqNTEcYFgJS2UaFcxupHiYkro49S8yGasTvXEYBVPamhGW6cFJodrTHy",
+		"xprvA41z7zogVVwxVSgdKUHDy1SKmdb533PjDz7J6N6mV6uS3ze1ai8FHa8kmHScGpWmj4WggLyQjgPie1rFSruoUihUZREPSL39UNdE3BBDu76",
+	},
+	// Test vector 2
+	{
+		"fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542",
+		[]uint32{},
+		"xpub661MyMwAqRbcFW31YEwpkMuc5THy2PSt5bDMsktWQcFF8syAmRUapSCGu8ED9W6oDMSgv6Zz8idoc4a6mr8BDzTJY47LJhkJ8UB7WEGuduB",
+		"xprv9s21ZrQH143K31xYSDQpPDxsXRTUcvj2iNHm5NUtrGiGG5e2DtALGdso3pGz6ssrdK4PFmM8NSpSBHNqPqm55Qn3LqFtT2emdEXVYsCzC2U",
+	},
+	{
+		"fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542",
+		[]uint32{0, h + 2147483647, 1, h + 2147483646, 2},
+		"xpub6FnCn6nSzZAw5Tw7cgR9bi15UV96gLZhjDstkXXxvCLsUXBGXPdSnLFbdpq8p9HmGsApME5hQTZ3emM2rnY5agb9rXpVGyy3bdW6EEgAtqt",
+		"xprvA2nrNbFZABcdryreWet9Ea4LvTJcGsqrMzxHx98MMrotbir7yrKCEXw7nadnHM8Dq38EGfSh6dqA9QWTyefMLEcBYJUuekgW4BYPJcr9E7j",
+	},
+	// Test vector 3, retention of leading zeros
+	{
+		"4b381541583be4423346c643850da4b320e46a87ae3d2a4e6da11eba819cd4acba45d239319ac14f863b8d5ab5a0d0c64d2e8a1e7d1457df2e5a3c51c73235be",
+		[]uint32{h},
+		"xpub68NZiKmJWnxxS6aaHmn81bvJeTESw724CRDs6HbuccFQN9Ku14VQrADWgqbhhTHBaohPX4CjNLf9fq9MYo6oDaPPLPxSb7gwQN3ih19Zm4Y",
+		"xprv9uPDJpEQgRQfDcW7BkF7eTya6RPxXeJCqCJGHuCJ4GiRVLzkTXBAJMu2qaMWPrS7AANYqdq6vcBcBUdJCVVFceUvJFjaPdGZ2y9WACViL4L",
+	},
+}
+
+func TestBip32Vectors(t *testing.T) {
+	for _, v := range bip32Vectors {
+		seed, _ := hex.DecodeString(v.seed)
+		master, err := NewMaster(seed, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		priv, err := master.Derive(v.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if priv.String() != v.xprv {
+			t.Errorf("FAIL: %s: got %s", FormatPath(v.path), priv.String())
+		}
+		pub, _ := priv.Neuter()
+		if pub.String() != v.xpub {
+			t.Errorf("FAIL: %s: got %s", FormatPath(v.path), pub.String())
+		}
+		if int(priv.Depth) != len(v.path) {
+			t.Errorf("FAIL")
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, v := range bip32Vectors {
+		priv, err := Parse(v.xprv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !priv.IsPrivate() || priv.String() != v.xprv {
+			t.Errorf("FAIL")
+		}
+		pub, err := Parse(v.xpub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pub.IsPrivate() || pub.String() != v.xpub {
+			t.Errorf("FAIL")
+		}
+	}
+}
+
+func TestPublicDerivation(t *testing.T) {
+	// Public parent to public child must match private parent to private child, neutered.
+	parent, _ := Parse("xprv9wTYmMFdV23N2TdNG573QoEsfRrWKQgWeibmLntzniatZvR9BmLnvSxqu53Kw1UmYPxLgboyZQaXwTCg8MSY3H2EU4pWcQDnRnrVA1xe8fs")
+	parentPub, _ := parent.Neuter()
+
+	privChild, _ := parent.Derive([]uint32{2, 1000000000})
+	want, _ := privChild.Neuter()
+	got, err := parentPub.Derive([]uint32{2, 1000000000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != want.String() {
+		t.Errorf("FAIL")
+	}
+
+	if _, err = parentPub.Child(h); err == nil {
+		t.Errorf("FAIL")
+	}
+
+	// neutering a public key returns a copy
+	again, _ := parentPub.Neuter()
+	if again == parentPub || again.String() != parentPub.String() {
+		t.Errorf("FAIL")
+	}
+	again.Depth++
+	if again.String() == parentPub.String() {
+		t.Errorf("FAIL")
+	}
+	if again.PublicKey == parentPub.PublicKey || again.PublicKey.X == parentPub.PublicKey.X {
+		t.Errorf("FAIL")
+	}
+	// and so does neutering a private key
+	if parentPub.PublicKey == parent.PublicKey || parentPub.PublicKey.X == parent.PublicKey.X {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestDerivePath(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	master, _ := NewMaster(seed, false)
+	key, err := master.DerivePath("m/0'/1/2h/2/1000000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.String() != bip32Vectors[5].xprv {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	path, err := ParsePath("m/84'/0'/0'/0/5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []uint32{h + 84, h, h, 0, 5}
+	if len(path) != len(want) {
+		t.Fatal("FAIL")
+	}
+	for i := range want {
+		if path[i] != want[i] {
+			t.Errorf("FAIL")
+		}
+	}
+	if FormatPath(path) != "m/84'/0'/0'/0/5" {
+		t.Errorf("FAIL")
+	}
+
+	if path, _ := ParsePath("m"); len(path) != 0 {
+		t.Errorf("FAIL")
+	}
+	for _, bad := range []string{"m/", "m/x", "m/2147483648", "m/-1", "m/1''", "m//1"} {
+		if _, err := ParsePath(bad); err == nil {
+			t.Errorf("FAIL: %s", bad)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	master, _ := Parse(bip32Vectors[0].xprv)
+	child, _ := master.Child(h)
+	if child.ParentFingerprint != master.Fingerprint() {
+		t.Errorf("FAIL")
+	}
+	if hex.EncodeToString(child.ParentFingerprint[:]) != "3442193e" {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestVersions(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	master, _ := NewMaster(seed, true)
+	if master.String()[:4] != "tprv" || !master.IsTestnet() {
+		t.Errorf("FAIL")
+	}
+	pub, _ := master.Neuter()
+	if pub.String()[:4] != "tpub" {
+		t.Errorf("FAIL")
+	}
+
+	pub.SetVersion(ZprvVersion, ZpubVersion)
+	zpub := pub.String()
+	if zpub[:4] != "zpub" {
+		t.Errorf("FAIL")
+	}
+	parsed, err := Parse(zpub)
+	if err != nil || parsed.IsPrivate() || parsed.IsTestnet() {
+		t.Errorf("FAIL")
+	}
+
+	if _, err := pub.SetVersion(XprvVersion, ZpubVersion); err == nil {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	xprv := bip32Vectors[0].xprv
+	// corrupt the checksum
+	if _, err := Parse(xprv[:len(xprv)-1] + "j"); err == nil {
+		t.Errorf("FAIL")
+	}
+	// invalid character
+	if _, err := Parse("0" + xprv[1:]); err == nil {
+		t.Errorf("FAIL")
+	}
+
+	// private key prefix with a public version
+	k, _ := Parse(xprv)
+	buf := k.Marshal()
+	copy(buf[:4], XpubVersion[:])
+	if _, err := new(ExtendedKey).Unmarshal(buf); err == nil {
+		t.Errorf("FAIL")
+	}
+	// zero depth with non-zero child number
+	buf = k.Marshal()
+	buf[12] = 1
+	if _, err := new(ExtendedKey).Unmarshal(buf); err == nil {
+		t.Errorf("FAIL")
+	}
+
+	// the receiver is left untouched on error
+	if _, err := k.Unmarshal(buf); err == nil || k.String() != xprv {
+		t.Errorf("FAIL")
+	}
+}
@@ -0,0 +1,67 @@
+package hdkey
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ParsePath parses a derivation path such as "m/84'/0'/0'/0/5".
+// Hardened indices are marked with a trailing ', h or H.
+// The leading "m/" is optional, so relative paths such as "0/5" are also accepted.
+func ParsePath(path string) ([]uint32, error) {
+	if path == "m" || path == "" {
+		return []uint32{}, nil
+	}
+	path = strings.TrimPrefix(path, "m/")
+
+	parts := strings.Split(path, "/")
+	ret := make([]uint32, len(parts))
+	for i, part := range parts {
+		index, err := ParseIndex(part)
+		if err != nil {
+			return nil, err
+		}
+		ret[i] = index
+	}
+	return ret, nil
+}
+
+// ParseIndex parses a single path element such as "84'" or "5".
+func ParseIndex(s string) (uint32, error) {
+	hardened := false
+	if n := len(s); n > 0 && (s[n-1] == '\'' || s[n-1] == 'h' || s[n-1] == 'H') {
+		hardened = true
+		s = s[:n-1]
+	}
+	if s == "" || s[0] == '+' || s[0] == '-' {
+		return 0, errors.New("ParseIndex: invalid path element")
+	}
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil || uint32(n) >= HardenedOffset {
+		return 0, errors.New("ParseIndex: invalid path element")
+	}
+	if hardened {
+		return uint32(n) + HardenedOffset, nil
+	}
+	return uint32(n), nil
+}
+
+// FormatPath returns the text format of the path, for example "m/84'/0'/0'/0/5".
+func FormatPath(path []uint32) string {
+	var b strings.Builder
+	b.WriteString("m")
+	for _, i := range path {
+		b.WriteString("/")
+		b.WriteString(FormatIndex(i))
+	}
+	return b.String()
+}
+
+// FormatIndex returns the text format of a single path element, for example "84'".
+func FormatIndex(i uint32) string {
+	if i >= HardenedOffset {
+		return strconv.FormatUint(uint64(i-HardenedOffset), 10) + "'"
+	}
+	return strconv.FormatUint(uint64(i), 10)
+}
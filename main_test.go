@@ -7,7 +7,10 @@ import (
 )
 
 func TestMakeTransaction(t *testing.T) {
-	transaction := makeTransaction()
+	transaction, err := makeTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	want, _ := hex.DecodeString("01000000019ed074528af57a31b514d4b22851caf546420d8212cfaf1d04548cce059d3868010000006b483045022100dd3c597790d17f0c98001f19b6952c2dafcc33f501293c890602fc7a2496e72702203468787c7668dde25d268cc7c5a20cc74ffb02defc0d2307a176c40674bc3a1201210335cbf18f4cd05242e649e09f0298122015ef3d4b3b8fe34b2b72508c100051810000000002eb470900000000001976a914ad346f8eb57dee9a37981716e498120ae80e44f788ac172a0600000000001976a914e1a49130622510dc1b408ff9d93728f3271c361d88ac00000000")
 	b, _ := transaction.Marshal()
@@ -2,8 +2,10 @@ package main
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
 
+	"github.com/VIVelev/btcd/btcutil"
 	"github.com/VIVelev/btcd/crypto/ecdsa"
 	"github.com/VIVelev/btcd/crypto/elliptic"
 	"github.com/VIVelev/btcd/encoding"
@@ -11,7 +13,7 @@ import (
 	"github.com/VIVelev/btcd/tx"
 )
 
-func makeTransaction() tx.Tx {
+func makeTransaction() (tx.Tx, error) {
 	// Generate your own private key, public key, and address.
 	priv := ecdsa.GenerateKey(elliptic.Secp256k1, "vivelev@icloud.comiamfrombetelgeuse")
 	// Use some secret of yours for the passphrase above.
@@ -42,20 +44,41 @@ func makeTransaction() tx.Tx {
 
 	// Decide how much coins to send.
 
-	myTotalCoinsInSatoshi, _ := txIn.Value() // 1 satoshi = 1e-8 bitcoin
-	fmt.Printf("I have %d satoshi.\n", myTotalCoinsInSatoshi)
+	myTotalCoinsInSatoshi, err := txIn.Value() // 1 satoshi = 1e-8 bitcoin
+	if err != nil {
+		return tx.Tx{}, err
+	}
+	fmt.Printf("I have %s.\n", myTotalCoinsInSatoshi)
 	// I will send 60% of my satoshi to myself. :)
-	targetAmount := uint64(0.6 * float64(myTotalCoinsInSatoshi))
+	targetAmount, err := myTotalCoinsInSatoshi.Mul(6)
+	if err != nil {
+		return tx.Tx{}, err
+	}
+	targetAmount /= 10
 	// Lets pay the miners!
-	fee := uint64(1500)
+	fee := btcutil.Amount(1500)
 	// Calculate the change amount, I will send this back to `address`.
-	changeAmount := myTotalCoinsInSatoshi - targetAmount - fee
+	spent, err := targetAmount.Add(fee)
+	if err != nil {
+		return tx.Tx{}, err
+	}
+	changeAmount, err := myTotalCoinsInSatoshi.Sub(spent)
+	if err != nil {
+		return tx.Tx{}, err
+	}
+	// Outputs can't be negative, then I don't have enough coins for the target and the fee.
+	if changeAmount < 0 || !changeAmount.IsValid() {
+		return tx.Tx{}, errors.New("makeTransaction: not enough coins for the target amount and the fee")
+	}
 
 	// Lets build the transaction outputs!
 
 	// Create the target transaction output
 	targetAddress := "mwJn1YPMq7y5F8J3LkC5Hxg9PHyZ5K4cFv"
-	targetH160, _ := encoding.AddressToPubKeyHash(targetAddress)
+	targetH160, err := encoding.AddressToPubKeyHash(targetAddress)
+	if err != nil {
+		return tx.Tx{}, err
+	}
 	targetScript := script.NewP2PKHScript(targetH160)
 	targetTxOut := tx.TxOut{
 		Amount:       targetAmount,
@@ -63,7 +86,10 @@ func makeTransaction() tx.Tx {
 	}
 
 	// Create the change transaction output
-	changeH160, _ := encoding.AddressToPubKeyHash(address)
+	changeH160, err := encoding.AddressToPubKeyHash(address)
+	if err != nil {
+		return tx.Tx{}, err
+	}
 	changeScript := script.NewP2PKHScript(changeH160)
 	changeTxOut := tx.TxOut{
 		Amount:       changeAmount,
@@ -80,11 +106,18 @@ func makeTransaction() tx.Tx {
 	}
 	// And sign the inputs please. In this way you verify that the money
 	// you are about to spend are, indeed, yours.
-	transaction.SignInput(0, priv)
+	if ok, err := transaction.SignInput(0, priv, tx.SighashAll); err != nil {
+		return tx.Tx{}, err
+	} else if !ok {
+		return tx.Tx{}, errors.New("makeTransaction: the signature doesn't verify")
+	}
 
 	// Print the hex of the transaction, so we can broadcast it to the network!
-	bytes, _ = transaction.Marshal()
+	bytes, err = transaction.Marshal()
+	if err != nil {
+		return tx.Tx{}, err
+	}
 	fmt.Printf("Tx's Hex: %s\n", hex.EncodeToString(bytes))
 
-	return transaction
+	return transaction, nil
 }
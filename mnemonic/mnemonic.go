@@ -0,0 +1,185 @@
+// Package mnemonic implements mnemonic seed phrases, as defined in
+// https://github.com/bitcoin/bips/blob/master/bip-0039.mediawiki
+package mnemonic
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha512"
+	_ "embed"
+	"encoding/binary"
+	"errors"
+	"strings"
+
+	"github.com/VIVelev/btcd/crypto/hash"
+	"github.com/VIVelev/btcd/hdkey"
+)
+
+//go:embed english.txt
+var english string
+
+var (
+	// wordlist is the English wordlist of 2048 words.
+	wordlist []string
+	// wordIndex is the inverse of wordlist.
+	wordIndex map[string]int
+)
+
+func init() {
+	wordlist = strings.Split(strings.TrimSpace(english), "\n")
+	if len(wordlist) != 2048 {
+		panic("mnemonic: the wordlist should have 2048 words")
+	}
+	wordIndex = make(map[string]int, len(wordlist))
+	for i, w := range wordlist {
+		wordIndex[w] = i
+	}
+}
+
+// NewEntropy returns bits of cryptographically secure random entropy.
+// bits should be a multiple of 32 between 128 and 256.
+func NewEntropy(bits int) ([]byte, error) {
+	if err := validateEntropyBits(bits); err != nil {
+		return nil, err
+	}
+	entropy := make([]byte, bits/8)
+	if _, err := rand.Read(entropy); err != nil {
+		return nil, err
+	}
+	return entropy, nil
+}
+
+func validateEntropyBits(bits int) error {
+	if bits < 128 || bits > 256 || bits%32 != 0 {
+		return errors.New("entropy should be a multiple of 32 bits between 128 and 256")
+	}
+	return nil
+}
+
+// FromEntropy returns the mnemonic sentence encoding the entropy.
+//
+// The entropy is followed by a checksum of its first len(entropy)*8/32 bits of sha256,
+// and the result is split into groups of 11 bits, each one indexing a word.
+func FromEntropy(entropy []byte) (string, error) {
+	bits := len(entropy) * 8
+	if err := validateEntropyBits(bits); err != nil {
+		return "", err
+	}
+
+	checksum := hash.Sha256(entropy)
+	data := append(append([]byte{}, entropy...), checksum[0])
+
+	numWords := (bits + bits/32) / 11
+	words := make([]string, numWords)
+	for i := range words {
+		words[i] = wordlist[readBits(data, i*11, 11)]
+	}
+	return strings.Join(words, " "), nil
+}
+
+// ToEntropy recovers the entropy from the mnemonic sentence.
+//
+// Returns error if a word is not in the wordlist or the checksum doesn't match.
+func ToEntropy(mnemonic string) ([]byte, error) {
+	words := strings.Fields(mnemonic)
+	numWords := len(words)
+	if numWords < 12 || numWords > 24 || numWords%3 != 0 {
+		return nil, errors.New("ToEntropy: the number of words should be 12, 15, 18, 21 or 24")
+	}
+
+	// each word carries 11 bits, of which 1/33 are the checksum
+	totalBits := numWords * 11
+	checksumBits := totalBits / 33
+	entropyBits := totalBits - checksumBits
+
+	data := make([]byte, (totalBits+7)/8)
+	for i, w := range words {
+		index, ok := wordIndex[w]
+		if !ok {
+			return nil, errors.New("ToEntropy: unknown word " + w)
+		}
+		writeBits(data, i*11, 11, index)
+	}
+
+	entropy := data[:entropyBits/8]
+	checksum := hash.Sha256(entropy)
+	if readBits(data, entropyBits, checksumBits) != readBits(checksum[:], 0, checksumBits) {
+		return nil, errors.New("ToEntropy: invalid checksum")
+	}
+	return append([]byte{}, entropy...), nil
+}
+
+// IsValid returns whether the mnemonic consists of known words and has a valid checksum.
+func IsValid(mnemonic string) bool {
+	_, err := ToEntropy(mnemonic)
+	return err == nil
+}
+
+// Seed derives the 512 bit seed from the mnemonic and the optional passphrase,
+// using PBKDF2-HMAC-SHA512 with 2048 iterations and "mnemonic"+passphrase as salt.
+//
+// The mnemonic is not validated, use IsValid for that. Both the mnemonic and the passphrase
+// should already be in the NFKD normal form (this is always the case for ASCII text).
+func Seed(mnemonic, passphrase string) []byte {
+	mnemonic = strings.Join(strings.Fields(mnemonic), " ")
+	return pbkdf2Sha512([]byte(mnemonic), []byte("mnemonic"+passphrase), 2048, 64)
+}
+
+// NewMaster validates the mnemonic and creates the BIP32 master key from its seed.
+func NewMaster(mnemonic, passphrase string, testnet bool) (*hdkey.ExtendedKey, error) {
+	if _, err := ToEntropy(mnemonic); err != nil {
+		return nil, err
+	}
+	return hdkey.NewMaster(Seed(mnemonic, passphrase), testnet)
+}
+
+// readBits returns n (<= 11) bits of buf, starting from the bit with the offset (big-endian).
+func readBits(buf []byte, offset, n int) (ret int) {
+	for i := offset; i < offset+n; i++ {
+		ret <<= 1
+		ret |= int(buf[i/8]>>(7-i%8)) & 1
+	}
+	return
+}
+
+// writeBits writes the lowest n bits of v in buf, starting from the bit with the offset (big-endian).
+func writeBits(buf []byte, offset, n, v int) {
+	for i := 0; i < n; i++ {
+		if v>>(n-1-i)&1 == 1 {
+			pos := offset + i
+			buf[pos/8] |= 1 << (7 - pos%8)
+		}
+	}
+}
+
+// pbkdf2Sha512 implements PBKDF2 with HMAC-SHA512 as the pseudorandom function.
+// Reference: https://www.rfc-editor.org/rfc/rfc8018#section-5.2
+func pbkdf2Sha512(password, salt []byte, iter, keyLen int) []byte {
+	prf := hmac.New(sha512.New, password)
+	hLen := prf.Size()
+	numBlocks := (keyLen + hLen - 1) / hLen
+
+	var dk []byte
+	var blockIndex [4]byte
+	for block := 1; block <= numBlocks; block++ {
+		// U1 = PRF(password, salt || INT(block))
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(blockIndex[:], uint32(block))
+		prf.Write(blockIndex[:])
+		u := prf.Sum(nil)
+
+		// T = U1 ^ U2 ^ ... ^ Uiter
+		t := append([]byte{}, u...)
+		for n := 1; n < iter; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range t {
+				t[i] ^= u[i]
+			}
+		}
+		dk = append(dk, t...)
+	}
+	return dk[:keyLen]
+}
@@ -0,0 +1,911 @@
+// Package psbt implements Partially Signed Bitcoin Transactions, version 0 as defined in
+// https://github.com/bitcoin/bips/blob/master/bip-0174.mediawiki and version 2 as defined in
+// https://github.com/bitcoin/bips/blob/master/bip-0370.mediawiki
+package psbt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/big"
+
+	"github.com/VIVelev/btcd/btcutil"
+	"github.com/VIVelev/btcd/encoding"
+	"github.com/VIVelev/btcd/hdkey"
+	"github.com/VIVelev/btcd/script"
+	"github.com/VIVelev/btcd/tx"
+	"github.com/VIVelev/btcd/utils"
+)
+
+var magic = []byte{'p', 's', 'b', 't', 0xff}
+
+// Global types
+const (
+	globalUnsignedTx       = 0x00
+	globalXPub             = 0x01
+	globalTxVersion        = 0x02
+	globalFallbackLockTime = 0x03
+	globalInputCount       = 0x04
+	globalOutputCount      = 0x05
+	globalTxModifiable     = 0x06
+	globalVersion          = 0xfb
+)
+
+// Input types
+const (
+	inNonWitnessUtxo         = 0x00
+	inWitnessUtxo            = 0x01
+	inPartialSig             = 0x02
+	inSighashType            = 0x03
+	inRedeemScript           = 0x04
+	inWitnessScript          = 0x05
+	inBip32Derivation        = 0x06
+	inFinalScriptSig         = 0x07
+	inFinalScriptWitness     = 0x08
+	inPreviousTxId           = 0x0e
+	inOutputIndex            = 0x0f
+	inSequence               = 0x10
+	inRequiredTimeLockTime   = 0x11
+	inRequiredHeightLockTime = 0x12
+	inTapKeySig              = 0x13
+	inTapInternalKey         = 0x17
+)
+
+// Output types
+const (
+	outRedeemScript    = 0x00
+	outWitnessScript   = 0x01
+	outBip32Derivation = 0x02
+	outAmount          = 0x03
+	outScript          = 0x04
+	outTapInternalKey  = 0x05
+)
+
+// Packet is a Partially Signed Bitcoin Transaction.
+type Packet struct {
+	Version uint32 // 0 or 2
+
+	// The unsigned transaction, only in version 0.
+	Tx *tx.Tx
+
+	// The transaction fields, only in version 2.
+	TxVersion        uint32
+	FallbackLockTime *uint32
+	TxModifiable     *uint8
+
+	XPubs    []XPub
+	Inputs   []Input
+	Outputs  []Output
+	Unknowns []KeyValue
+}
+
+// XPub is an extended public key used in the transaction, together with its origin.
+type XPub struct {
+	ExtendedKey *hdkey.ExtendedKey
+	Bip32Derivation
+}
+
+// Bip32Derivation is the origin of a public key: the fingerprint of the master key
+// and the derivation path from it.
+type Bip32Derivation struct {
+	PubKey      []byte
+	Fingerprint [4]byte
+	Path        []uint32
+}
+
+// PartialSig is a signature with its SEC public key.
+type PartialSig struct {
+	PubKey    []byte
+	Signature []byte
+}
+
+// KeyValue is a field which is not interpreted, but is passed through unchanged.
+// The Key includes the type.
+type KeyValue struct {
+	Key   []byte
+	Value []byte
+}
+
+type Input struct {
+	NonWitnessUtxo     *tx.Tx
+	WitnessUtxo        *tx.TxOut
+	PartialSigs        []PartialSig
+	SighashType        *uint32
+	RedeemScript       script.Script
+	WitnessScript      script.Script
+	Bip32Derivations   []Bip32Derivation
+	FinalScriptSig     script.Script
+	FinalScriptWitness [][]byte
+
+	// Only in version 2.
+	PreviousTxId           [32]byte
+	OutputIndex            uint32
+	Sequence               *uint32
+	RequiredTimeLockTime   *uint32
+	RequiredHeightLockTime *uint32
+
+	// Taproot
+	TapKeySig      []byte
+	TapInternalKey []byte
+
+	Unknowns []KeyValue
+}
+
+type Output struct {
+	RedeemScript     script.Script
+	WitnessScript    script.Script
+	Bip32Derivations []Bip32Derivation
+
+	// Only in version 2.
+	Amount btcutil.Amount
+	Script script.Script
+
+	// Taproot
+	TapInternalKey []byte
+
+	Unknowns []KeyValue
+}
+
+// Parse decodes the base64 encoded PSBT.
+func Parse(s string) (*Packet, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(Packet).Unmarshal(bytes.NewReader(b))
+}
+
+// Base64 returns the base64 encoding of the PSBT.
+func (p *Packet) Base64() (string, error) {
+	b, err := p.Marshal()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// readMap reads key-value pairs until the 0x00 separator.
+// Returns error on duplicate keys.
+func readMap(r *bytes.Reader) ([]KeyValue, error) {
+	var kvs []KeyValue
+	seen := make(map[string]bool)
+	for {
+		if r.Len() == 0 {
+			return nil, errors.New("psbt: unexpected end of data")
+		}
+		key, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(key) == 0 {
+			return kvs, nil
+		}
+		if seen[string(key)] {
+			return nil, errors.New("psbt: duplicate key " + hex.EncodeToString(key))
+		}
+		seen[string(key)] = true
+		value, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		kvs = append(kvs, KeyValue{key, value})
+	}
+}
+
+// readBytes reads bytes prefixed with their length as VarInt.
+func readBytes(r *bytes.Reader) ([]byte, error) {
+	if r.Len() == 0 {
+		return nil, errors.New("psbt: unexpected end of data")
+	}
+	n := encoding.DecodeVarInt(r)
+	if !n.IsInt64() || n.Int64() > int64(r.Len()) {
+		return nil, errors.New("psbt: unexpected end of data")
+	}
+	b := make([]byte, n.Int64())
+	io.ReadFull(r, b)
+	return b, nil
+}
+
+func writeBytes(buf *bytes.Buffer, b []byte) error {
+	n, err := encoding.EncodeVarInt(big.NewInt(int64(len(b))))
+	if err != nil {
+		return err
+	}
+	buf.Write(n)
+	buf.Write(b)
+	return nil
+}
+
+func writeKeyValue(buf *bytes.Buffer, key, value []byte) error {
+	if err := writeBytes(buf, key); err != nil {
+		return err
+	}
+	return writeBytes(buf, value)
+}
+
+func uint32Bytes(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return b
+}
+
+func parseUint32(value []byte) (uint32, error) {
+	if len(value) != 4 {
+		return 0, errors.New("psbt: value should be 4 bytes")
+	}
+	return binary.LittleEndian.Uint32(value), nil
+}
+
+func varIntBytes(v int) []byte {
+	b, _ := encoding.EncodeVarInt(big.NewInt(int64(v)))
+	return b
+}
+
+func parseVarInt(value []byte) (int, error) {
+	r := bytes.NewReader(value)
+	n := encoding.DecodeVarInt(r)
+	if len(value) == 0 || r.Len() != 0 || !n.IsInt64() || n.Int64() > 1<<16 {
+		return 0, errors.New("psbt: invalid count")
+	}
+	return int(n.Int64()), nil
+}
+
+// parseDerivation decodes the value <4 byte fingerprint> <32-bit little-endian path element>*
+func parseDerivation(pubKey, value []byte) (Bip32Derivation, error) {
+	if len(value) < 4 || len(value)%4 != 0 {
+		return Bip32Derivation{}, errors.New("psbt: invalid BIP32 derivation")
+	}
+	d := Bip32Derivation{PubKey: pubKey}
+	copy(d.Fingerprint[:], value)
+	for i := 4; i < len(value); i += 4 {
+		d.Path = append(d.Path, binary.LittleEndian.Uint32(value[i:]))
+	}
+	return d, nil
+}
+
+func (d *Bip32Derivation) value() []byte {
+	b := append([]byte{}, d.Fingerprint[:]...)
+	for _, i := range d.Path {
+		b = append(b, uint32Bytes(i)...)
+	}
+	return b
+}
+
+func validPubKey(b []byte) bool {
+	return (len(b) == 33 && (b[0] == 0x02 || b[0] == 0x03)) || (len(b) == 65 && b[0] == 0x04)
+}
+
+// parseTx parses the transaction, in the legacy format for the unsigned tx.
+func parseTx(value []byte, legacy bool) (*tx.Tx, error) {
+	r := bytes.NewReader(value)
+	var t *tx.Tx
+	var err error
+	if legacy {
+		t, err = new(tx.Tx).UnmarshalLegacy(r)
+	} else {
+		t, err = new(tx.Tx).Unmarshal(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if r.Len() != 0 {
+		return nil, errors.New("psbt: invalid transaction")
+	}
+	return t, nil
+}
+
+func parseTxOut(value []byte) (*tx.TxOut, error) {
+	if len(value) < 9 {
+		return nil, errors.New("psbt: invalid transaction output")
+	}
+	r := bytes.NewReader(value)
+	out := new(tx.TxOut).Unmarshal(r)
+	if r.Len() != 0 {
+		return nil, errors.New("psbt: invalid transaction output")
+	}
+	return out, nil
+}
+
+func parseWitness(value []byte) ([][]byte, error) {
+	r := bytes.NewReader(value)
+	n, err := readBytesCount(r)
+	if err != nil {
+		return nil, err
+	}
+	witness := make([][]byte, n)
+	for i := range witness {
+		if witness[i], err = readBytes(r); err != nil {
+			return nil, err
+		}
+	}
+	if r.Len() != 0 {
+		return nil, errors.New("psbt: invalid witness")
+	}
+	return witness, nil
+}
+
+func readBytesCount(r *bytes.Reader) (int, error) {
+	if r.Len() == 0 {
+		return 0, errors.New("psbt: unexpected end of data")
+	}
+	n := encoding.DecodeVarInt(r)
+	if !n.IsInt64() || n.Int64() > int64(r.Len()) {
+		return 0, errors.New("psbt: unexpected end of data")
+	}
+	return int(n.Int64()), nil
+}
+
+func witnessBytes(witness [][]byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	buf.Write(varIntBytes(len(witness)))
+	for _, item := range witness {
+		if err := writeBytes(buf, item); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal parses a PSBT from the Reader r.
+func (p *Packet) Unmarshal(r io.Reader) (*Packet, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(b, magic) {
+		return nil, errors.New("psbt: invalid magic bytes")
+	}
+	br := bytes.NewReader(b[len(magic):])
+
+	*p = Packet{}
+	global, err := readMap(br)
+	if err != nil {
+		return nil, err
+	}
+	var inputCount, outputCount int
+	var hasTxVersion, hasInputCount, hasOutputCount bool
+	for _, kv := range global {
+		key, value := kv.Key, kv.Value
+		switch key[0] {
+		case globalUnsignedTx:
+			if len(key) != 1 {
+				return nil, errors.New("psbt: invalid unsigned tx key")
+			}
+			if p.Tx, err = parseTx(value, true); err != nil {
+				return nil, err
+			}
+			for _, in := range p.Tx.TxIns {
+				if len(in.ScriptSig) != 0 || in.Witness != nil {
+					return nil, errors.New("psbt: unsigned tx should have empty scriptSigs and witnesses")
+				}
+			}
+		case globalXPub:
+			extKey, err := new(hdkey.ExtendedKey).Unmarshal(key[1:])
+			if err != nil {
+				return nil, err
+			}
+			d, err := parseDerivation(nil, value)
+			if err != nil {
+				return nil, err
+			}
+			p.XPubs = append(p.XPubs, XPub{extKey, d})
+		case globalTxVersion:
+			if len(key) != 1 {
+				return nil, errors.New("psbt: invalid tx version key")
+			}
+			if p.TxVersion, err = parseUint32(value); err != nil {
+				return nil, err
+			}
+			hasTxVersion = true
+		case globalFallbackLockTime:
+			v, err := parseUint32(value)
+			if err != nil || len(key) != 1 {
+				return nil, errors.New("psbt: invalid fallback locktime")
+			}
+			p.FallbackLockTime = &v
+		case globalInputCount:
+			if len(key) != 1 {
+				return nil, errors.New("psbt: invalid input count key")
+			}
+			if inputCount, err = parseVarInt(value); err != nil {
+				return nil, err
+			}
+			hasInputCount = true
+		case globalOutputCount:
+			if len(key) != 1 {
+				return nil, errors.New("psbt: invalid output count key")
+			}
+			if outputCount, err = parseVarInt(value); err != nil {
+				return nil, err
+			}
+			hasOutputCount = true
+		case globalTxModifiable:
+			if len(key) != 1 || len(value) != 1 {
+				return nil, errors.New("psbt: invalid tx modifiable flags")
+			}
+			p.TxModifiable = &value[0]
+		case globalVersion:
+			if len(key) != 1 {
+				return nil, errors.New("psbt: invalid version key")
+			}
+			if p.Version, err = parseUint32(value); err != nil {
+				return nil, err
+			}
+		default:
+			p.Unknowns = append(p.Unknowns, kv)
+		}
+	}
+
+	switch p.Version {
+	case 0:
+		if p.Tx == nil {
+			return nil, errors.New("psbt: missing unsigned tx")
+		}
+		if hasTxVersion || hasInputCount || hasOutputCount || p.FallbackLockTime != nil || p.TxModifiable != nil {
+			return nil, errors.New("psbt: version 2 fields in a version 0 PSBT")
+		}
+		inputCount, outputCount = len(p.Tx.TxIns), len(p.Tx.TxOuts)
+	case 2:
+		if p.Tx != nil {
+			return nil, errors.New("psbt: unsigned tx in a version 2 PSBT")
+		}
+		if !hasTxVersion || !hasInputCount || !hasOutputCount {
+			return nil, errors.New("psbt: missing version 2 fields")
+		}
+	default:
+		return nil, errors.New("psbt: unsupported version")
+	}
+
+	p.Inputs = make([]Input, inputCount)
+	for i := range p.Inputs {
+		if err = p.Inputs[i].unmarshal(br, p.Version); err != nil {
+			return nil, err
+		}
+		if err = p.checkNonWitnessUtxo(i); err != nil {
+			return nil, err
+		}
+	}
+	p.Outputs = make([]Output, outputCount)
+	for i := range p.Outputs {
+		if err = p.Outputs[i].unmarshal(br, p.Version); err != nil {
+			return nil, err
+		}
+	}
+	if br.Len() != 0 {
+		return nil, errors.New("psbt: trailing data")
+	}
+	return p, nil
+}
+
+// prevOut returns the outpoint spent by the input with the index.
+func (p *Packet) prevOut(index int) (prevTxId [32]byte, prevIndex uint32) {
+	if p.Version == 0 {
+		in := p.Tx.TxIns[index]
+		return in.PrevTxId, in.PrevIndex
+	}
+	in := p.Inputs[index]
+	return in.PreviousTxId, in.OutputIndex
+}
+
+// checkNonWitnessUtxo returns error if the non-witness UTXO of the input isn't the spent transaction.
+func (p *Packet) checkNonWitnessUtxo(index int) error {
+	utxo := p.Inputs[index].NonWitnessUtxo
+	if utxo == nil {
+		return nil
+	}
+	prevTxId, prevIndex := p.prevOut(index)
+	id, err := utxo.Id()
+	if err != nil {
+		return err
+	}
+	if id != hex.EncodeToString(prevTxId[:]) {
+		return errors.New("psbt: non-witness UTXO doesn't match the spent transaction")
+	}
+	if int(prevIndex) >= len(utxo.TxOuts) {
+		return errors.New("psbt: spent output index out of range")
+	}
+	return nil
+}
+
+func (in *Input) unmarshal(r *bytes.Reader, version uint32) error {
+	kvs, err := readMap(r)
+	if err != nil {
+		return err
+	}
+	var hasPreviousTxId, hasOutputIndex bool
+	for _, kv := range kvs {
+		key, value := kv.Key, kv.Value
+		if version == 0 && len(key) > 1 && isVersion2Type(key[0], inPreviousTxId, inOutputIndex, inSequence, inRequiredTimeLockTime, inRequiredHeightLockTime) {
+			in.Unknowns = append(in.Unknowns, kv)
+			continue
+		}
+		if !isKeyLenValid(key, inputKeyLens) {
+			return errors.New("psbt: invalid input key " + hex.EncodeToString(key))
+		}
+		switch key[0] {
+		case inNonWitnessUtxo:
+			if in.NonWitnessUtxo, err = parseTx(value, false); err != nil {
+				return err
+			}
+		case inWitnessUtxo:
+			if in.WitnessUtxo, err = parseTxOut(value); err != nil {
+				return err
+			}
+		case inPartialSig:
+			if !validPubKey(key[1:]) {
+				return errors.New("psbt: invalid partial signature public key")
+			}
+			in.PartialSigs = append(in.PartialSigs, PartialSig{key[1:], value})
+		case inSighashType:
+			v, err := parseUint32(value)
+			if err != nil {
+				return err
+			}
+			in.SighashType = &v
+		case inRedeemScript:
+			if in.RedeemScript, err = script.ParseRaw(value); err != nil {
+				return err
+			}
+		case inWitnessScript:
+			if in.WitnessScript, err = script.ParseRaw(value); err != nil {
+				return err
+			}
+		case inBip32Derivation:
+			if !validPubKey(key[1:]) {
+				return errors.New("psbt: invalid BIP32 derivation public key")
+			}
+			d, err := parseDerivation(key[1:], value)
+			if err != nil {
+				return err
+			}
+			in.Bip32Derivations = append(in.Bip32Derivations, d)
+		case inFinalScriptSig:
+			if in.FinalScriptSig, err = script.ParseRaw(value); err != nil {
+				return err
+			}
+		case inFinalScriptWitness:
+			if in.FinalScriptWitness, err = parseWitness(value); err != nil {
+				return err
+			}
+		case inPreviousTxId, inOutputIndex, inSequence, inRequiredTimeLockTime, inRequiredHeightLockTime:
+			if version != 2 {
+				return errors.New("psbt: version 2 input field in a version 0 PSBT")
+			}
+			if key[0] == inPreviousTxId {
+				if len(value) != 32 {
+					return errors.New("psbt: previous txid should be 32 bytes")
+				}
+				copy(in.PreviousTxId[:], utils.Reversed(value))
+				hasPreviousTxId = true
+				continue
+			}
+			v, err := parseUint32(value)
+			if err != nil {
+				return err
+			}
+			switch key[0] {
+			case inOutputIndex:
+				in.OutputIndex = v
+				hasOutputIndex = true
+			case inSequence:
+				in.Sequence = &v
+			case inRequiredTimeLockTime:
+				if v < 500000000 {
+					return errors.New("psbt: required time locktime should be at least 500000000")
+				}
+				in.RequiredTimeLockTime = &v
+			case inRequiredHeightLockTime:
+				if v == 0 || v >= 500000000 {
+					return errors.New("psbt: required height locktime should be between 1 and 499999999")
+				}
+				in.RequiredHeightLockTime = &v
+			}
+		case inTapKeySig:
+			if len(value) != 64 && len(value) != 65 {
+				return errors.New("psbt: taproot key signature should be 64 or 65 bytes")
+			}
+			in.TapKeySig = value
+		case inTapInternalKey:
+			if len(value) != 32 {
+				return errors.New("psbt: taproot internal key should be 32 bytes")
+			}
+			in.TapInternalKey = value
+		default:
+			in.Unknowns = append(in.Unknowns, kv)
+		}
+	}
+	if version == 2 && (!hasPreviousTxId || !hasOutputIndex) {
+		return errors.New("psbt: version 2 input is missing the previous txid or output index")
+	}
+	return nil
+}
+
+func (out *Output) unmarshal(r *bytes.Reader, version uint32) error {
+	kvs, err := readMap(r)
+	if err != nil {
+		return err
+	}
+	var hasAmount, hasScript bool
+	for _, kv := range kvs {
+		key, value := kv.Key, kv.Value
+		if version == 0 && len(key) > 1 && isVersion2Type(key[0], outAmount, outScript) {
+			out.Unknowns = append(out.Unknowns, kv)
+			continue
+		}
+		if !isKeyLenValid(key, outputKeyLens) {
+			return errors.New("psbt: invalid output key " + hex.EncodeToString(key))
+		}
+		switch key[0] {
+		case outRedeemScript:
+			if out.RedeemScript, err = script.ParseRaw(value); err != nil {
+				return err
+			}
+		case outWitnessScript:
+			if out.WitnessScript, err = script.ParseRaw(value); err != nil {
+				return err
+			}
+		case outBip32Derivation:
+			if !validPubKey(key[1:]) {
+				return errors.New("psbt: invalid BIP32 derivation public key")
+			}
+			d, err := parseDerivation(key[1:], value)
+			if err != nil {
+				return err
+			}
+			out.Bip32Derivations = append(out.Bip32Derivations, d)
+		case outAmount:
+			if version != 2 {
+				return errors.New("psbt: version 2 output field in a version 0 PSBT")
+			}
+			if len(value) != 8 {
+				return errors.New("psbt: amount should be 8 bytes")
+			}
+			out.Amount = btcutil.Amount(binary.LittleEndian.Uint64(value))
+			if !out.Amount.IsValid() {
+				return errors.New("psbt: amount out of range")
+			}
+			hasAmount = true
+		case outScript:
+			if version != 2 {
+				return errors.New("psbt: version 2 output field in a version 0 PSBT")
+			}
+			if out.Script, err = script.ParseRaw(value); err != nil {
+				return err
+			}
+			hasScript = true
+		case outTapInternalKey:
+			if len(value) != 32 {
+				return errors.New("psbt: taproot internal key should be 32 bytes")
+			}
+			out.TapInternalKey = value
+		default:
+			out.Unknowns = append(out.Unknowns, kv)
+		}
+	}
+	if version == 2 && (!hasAmount || !hasScript) {
+		return errors.New("psbt: version 2 output is missing the amount or script")
+	}
+	return nil
+}
+
+// inputKeyLens and outputKeyLens are the valid key lengths of the known types,
+// keys with public keys can have two lengths (compressed or uncompressed).
+var (
+	inputKeyLens = map[byte][]int{
+		inNonWitnessUtxo:         {1},
+		inWitnessUtxo:            {1},
+		inPartialSig:             {34, 66},
+		inSighashType:            {1},
+		inRedeemScript:           {1},
+		inWitnessScript:          {1},
+		inBip32Derivation:        {34, 66},
+		inFinalScriptSig:         {1},
+		inFinalScriptWitness:     {1},
+		inPreviousTxId:           {1},
+		inOutputIndex:            {1},
+		inSequence:               {1},
+		inRequiredTimeLockTime:   {1},
+		inRequiredHeightLockTime: {1},
+		inTapKeySig:              {1},
+		inTapInternalKey:         {1},
+	}
+	outputKeyLens = map[byte][]int{
+		outRedeemScript:    {1},
+		outWitnessScript:   {1},
+		outBip32Derivation: {34, 66},
+		outAmount:          {1},
+		outScript:          {1},
+		outTapInternalKey:  {1},
+	}
+)
+
+// isVersion2Type returns whether t is one of the types of the version 2 fields.
+// BIP174 predates them, so in version 0 the keys of these types which are longer
+// than those of the version 2 fields are unknown keys.
+func isVersion2Type(t byte, types ...byte) bool {
+	for _, v := range types {
+		if t == v {
+			return true
+		}
+	}
+	return false
+}
+
+func isKeyLenValid(key []byte, keyLens map[byte][]int) bool {
+	lens, ok := keyLens[key[0]]
+	if !ok {
+		return true
+	}
+	for _, l := range lens {
+		if len(key) == l {
+			return true
+		}
+	}
+	return false
+}
+
+// Marshal serializes the PSBT. The known fields are written in the order of their types,
+// followed by the unknown fields.
+func (p *Packet) Marshal() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	buf.Write(magic)
+
+	if p.Version == 0 {
+		if p.Tx == nil {
+			return nil, errors.New("psbt: missing unsigned tx")
+		}
+		unsignedTx := *p.Tx
+		unsignedTx.SegWit = false
+		b, err := unsignedTx.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		writeKeyValue(buf, []byte{globalUnsignedTx}, b)
+	}
+	for _, x := range p.XPubs {
+		writeKeyValue(buf, append([]byte{globalXPub}, x.ExtendedKey.Marshal()...), x.value())
+	}
+	if p.Version == 2 {
+		writeKeyValue(buf, []byte{globalTxVersion}, uint32Bytes(p.TxVersion))
+		if p.FallbackLockTime != nil {
+			writeKeyValue(buf, []byte{globalFallbackLockTime}, uint32Bytes(*p.FallbackLockTime))
+		}
+		writeKeyValue(buf, []byte{globalInputCount}, varIntBytes(len(p.Inputs)))
+		writeKeyValue(buf, []byte{globalOutputCount}, varIntBytes(len(p.Outputs)))
+		if p.TxModifiable != nil {
+			writeKeyValue(buf, []byte{globalTxModifiable}, []byte{*p.TxModifiable})
+		}
+	}
+	if p.Version != 0 {
+		writeKeyValue(buf, []byte{globalVersion}, uint32Bytes(p.Version))
+	}
+	for _, kv := range p.Unknowns {
+		writeKeyValue(buf, kv.Key, kv.Value)
+	}
+	buf.WriteByte(0x00)
+
+	for i := range p.Inputs {
+		if err := p.Inputs[i].marshal(buf, p.Version); err != nil {
+			return nil, err
+		}
+	}
+	for i := range p.Outputs {
+		if err := p.Outputs[i].marshal(buf, p.Version); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func writeScript(buf *bytes.Buffer, key byte, s script.Script) error {
+	raw, err := s.Raw()
+	if err != nil {
+		return err
+	}
+	return writeKeyValue(buf, []byte{key}, raw)
+}
+
+func (in *Input) marshal(buf *bytes.Buffer, version uint32) error {
+	if in.NonWitnessUtxo != nil {
+		b, err := in.NonWitnessUtxo.Marshal()
+		if err != nil {
+			return err
+		}
+		writeKeyValue(buf, []byte{inNonWitnessUtxo}, b)
+	}
+	if in.WitnessUtxo != nil {
+		b, err := in.WitnessUtxo.Marshal()
+		if err != nil {
+			return err
+		}
+		writeKeyValue(buf, []byte{inWitnessUtxo}, b)
+	}
+	for _, sig := range in.PartialSigs {
+		writeKeyValue(buf, append([]byte{inPartialSig}, sig.PubKey...), sig.Signature)
+	}
+	if in.SighashType != nil {
+		writeKeyValue(buf, []byte{inSighashType}, uint32Bytes(*in.SighashType))
+	}
+	if in.RedeemScript != nil {
+		if err := writeScript(buf, inRedeemScript, in.RedeemScript); err != nil {
+			return err
+		}
+	}
+	if in.WitnessScript != nil {
+		if err := writeScript(buf, inWitnessScript, in.WitnessScript); err != nil {
+			return err
+		}
+	}
+	for _, d := range in.Bip32Derivations {
+		writeKeyValue(buf, append([]byte{inBip32Derivation}, d.PubKey...), d.value())
+	}
+	if in.FinalScriptSig != nil {
+		if err := writeScript(buf, inFinalScriptSig, in.FinalScriptSig); err != nil {
+			return err
+		}
+	}
+	if in.FinalScriptWitness != nil {
+		b, err := witnessBytes(in.FinalScriptWitness)
+		if err != nil {
+			return err
+		}
+		writeKeyValue(buf, []byte{inFinalScriptWitness}, b)
+	}
+	if version == 2 {
+		writeKeyValue(buf, []byte{inPreviousTxId}, utils.Reversed(append([]byte{}, in.PreviousTxId[:]...)))
+		writeKeyValue(buf, []byte{inOutputIndex}, uint32Bytes(in.OutputIndex))
+		if in.Sequence != nil {
+			writeKeyValue(buf, []byte{inSequence}, uint32Bytes(*in.Sequence))
+		}
+		if in.RequiredTimeLockTime != nil {
+			writeKeyValue(buf, []byte{inRequiredTimeLockTime}, uint32Bytes(*in.RequiredTimeLockTime))
+		}
+		if in.RequiredHeightLockTime != nil {
+			writeKeyValue(buf, []byte{inRequiredHeightLockTime}, uint32Bytes(*in.RequiredHeightLockTime))
+		}
+	}
+	if in.TapKeySig != nil {
+		writeKeyValue(buf, []byte{inTapKeySig}, in.TapKeySig)
+	}
+	if in.TapInternalKey != nil {
+		writeKeyValue(buf, []byte{inTapInternalKey}, in.TapInternalKey)
+	}
+	for _, kv := range in.Unknowns {
+		writeKeyValue(buf, kv.Key, kv.Value)
+	}
+	return buf.WriteByte(0x00)
+}
+
+func (out *Output) marshal(buf *bytes.Buffer, version uint32) error {
+	if out.RedeemScript != nil {
+		if err := writeScript(buf, outRedeemScript, out.RedeemScript); err != nil {
+			return err
+		}
+	}
+	if out.WitnessScript != nil {
+		if err := writeScript(buf, outWitnessScript, out.WitnessScript); err != nil {
+			return err
+		}
+	}
+	for _, d := range out.Bip32Derivations {
+		writeKeyValue(buf, append([]byte{outBip32Derivation}, d.PubKey...), d.value())
+	}
+	if version == 2 {
+		amount := make([]byte, 8)
+		binary.LittleEndian.PutUint64(amount, uint64(out.Amount))
+		writeKeyValue(buf, []byte{outAmount}, amount)
+		if err := writeScript(buf, outScript, out.Script); err != nil {
+			return err
+		}
+	}
+	if out.TapInternalKey != nil {
+		writeKeyValue(buf, []byte{outTapInternalKey}, out.TapInternalKey)
+	}
+	for _, kv := range out.Unknowns {
+		writeKeyValue(buf, kv.Key, kv.Value)
+	}
+	return buf.WriteByte(0x00)
+}
@@ -0,0 +1,440 @@
+package psbt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/VIVelev/btcd/crypto/ecdsa"
+	"github.com/VIVelev/btcd/crypto/elliptic"
+	"github.com/VIVelev/btcd/crypto/hash"
+	"github.com/VIVelev/btcd/script"
+	"github.com/VIVelev/btcd/tx"
+)
+
+// BIP174 test vector: PSBT with one P2PKH input, which has a non-witness UTXO
+const bip174Vector = "cHNidP8BAHUCAAAAASaBcTce3/KF6Tet7qSze3gADAVmy7OtZGQXE8pCFxv2AAAAAAD+////AtPf9QUAAAAAGXapFNDFmQPFusKGh2DpD9UhpGZap2UgiKwA4fUFAAAAABepFDVF5uM7gyxHBQ8k0+65PJwDlIvHh7MuEwAAAQD9pQEBAAAAAAECiaPHHqtNIOA3G7ukzGmPopXJRjr6Ljl/hTPMti+VZ+UBAAAAFxYAFL4Y0VKpsBIDna89p95PUzSe7LmF/////4b4qkOnHf8USIk6UwpyN+9rRgi7st0tAXHmOuxqSJC0AQAAABcWABT+Pp7xp0XpdNkCxDVZQ6vLNL1TU/////8CAMLrCwAAAAAZdqkUhc/xCX/Z4Ai7NK9wnGIZeziXikiIrHL++E4sAAAAF6kUM5cluiHv1irHU6m80GfWx6ajnQWHAkcwRAIgJxK+IuAnDzlPVoMR3HyppolwuAJf3TskAinwf4pfOiQCIAGLONfc0xTnNMkna9b7QPZzMlvEuqFEyADS8vAtsnZcASED0uFWdJQbrUqZY3LLh+GFbTZSYG2YVi/jnF6efkE/IQUCSDBFAiEA0SuFLYXc2WHS9fSrZgZU327tzHlMDDPOXMMJ/7X85Y0CIGczio4OFyXBl/saiK9Z9R5E5CVbIBZ8hoQDHAXR8lkqASECI7cr7vCWXRC+B3jv7NYfysb3mk6haTkzgHNEZPhPKrMAAAAAAAAA"
+
+// The test vectors of BIP174, the valid PSBTs serialize back to the same bytes.
+var bip174Valid = []string{
+	"70736274ff0100750200000001268171371edff285e937adeea4b37b78000c0566cbb3ad64641713ca42171bf60000000000feffffff02d3dff505000000001976a914d0c59903c5bac2868760e90fd521a4665aa7652088ac00e1f5050000000017a9143545e6e33b832c47050f24d3eeb93c9c03948bc787b32e1300000100fda5010100000000010289a3c71eab4d20e0371bbba4cc698fa295c9463afa2e397f8533ccb62f9567e50100000017160014be18d152a9b012039daf3da7de4f53349eecb985ffffffff86f8aa43a71dff1448893a530a7237ef6b4608bbb2dd2d0171e63aec6a4890b40100000017160014fe3e9ef1a745e974d902c4355943abcb34bd5353ffffffff0200c2eb0b000000001976a91485cff1097fd9e008bb34af709c62197b38978a4888ac72fef84e2c00000017a914339725ba21efd62ac753a9bcd067d6c7a6a39d05870247304402202712be22e0270f394f568311dc7ca9a68970b8025fdd3b240229f07f8a5f3a240220018b38d7dcd314e734c9276bd6fb40f673325bc4baa144c800d2f2f02db2765c012103d2e15674941bad4a996372cb87e1856d3652606d98562fe39c5e9e7e413f210502483045022100d12b852d85dcd961d2f5f4ab660654df6eedcc794c0c33ce5cc309ffb5fce58d022067338a8e0e1725c197fb1a88af59f51e44e4255b20167c8684031c05d1f2592a01210223b72beef0965d10be0778efecd61fcac6f79a4ea169393380734464f84f2ab300000000000000",
+	"70736274ff0100a00200000002ab0949a08c5af7c49b8212f417e2f15ab3f5c33dcf153821a8139f877a5b7be40000000000feffffffab0949a08c5af7c49b8212f417e2f15ab3f5c33dcf153821a8139f877a5b7be40100000000feffffff02603bea0b000000001976a914768a40bbd740cbe81d988e71de2a4d5c71396b1d88ac8e240000000000001976a9146f4620b553fa095e721b9ee0efe9fa039cca459788ac000000000001076a47304402204759661797c01b036b25928948686218347d89864b719e1f7fcf57d1e511658702205309eabf56aa4d8891ffd111fdf1336f3a29da866d7f8486d75546ceedaf93190121035cdc61fc7ba971c0b501a646a2a83b102cb43881217ca682dc86e2d73fa882920001012000e1f5050000000017a9143545e6e33b832c47050f24d3eeb93c9c03948bc787010416001485d13537f2e265405a34dbafa9e3dda01fb82308000000",
+	"70736274ff0100750200000001268171371edff285e937adeea4b37b78000c0566cbb3ad64641713ca42171bf60000000000feffffff02d3dff505000000001976a914d0c59903c5bac2868760e90fd521a4665aa7652088ac00e1f5050000000017a9143545e6e33b832c47050f24d3eeb93c9c03948bc787b32e1300000100fda5010100000000010289a3c71eab4d20e0371bbba4cc698fa295c9463afa2e397f8533ccb62f9567e50100000017160014be18d152a9b012039daf3da7de4f53349eecb985ffffffff86f8aa43a71dff1448893a530a7237ef6b4608bbb2dd2d0171e63aec6a4890b40100000017160014fe3e9ef1a745e974d902c4355943abcb34bd5353ffffffff0200c2eb0b000000001976a91485cff1097fd9e008bb34af709c62197b38978a4888ac72fef84e2c00000017a914339725ba21efd62ac753a9bcd067d6c7a6a39d05870247304402202712be22e0270f394f568311dc7ca9a68970b8025fdd3b240229f07f8a5f3a240220018b38d7dcd314e734c9276bd6fb40f673325bc4baa144c800d2f2f02db2765c012103d2e15674941bad4a996372cb87e1856d3652606d98562fe39c5e9e7e413f210502483045022100d12b852d85dcd961d2f5f4ab660654df6eedcc794c0c33ce5cc309ffb5fce58d022067338a8e0e1725c197fb1a88af59f51e44e4255b20167c8684031c05d1f2592a01210223b72beef0965d10be0778efecd61fcac6f79a4ea169393380734464f84f2ab30000000001030401000000000000",
+	"70736274ff0100a00200000002ab0949a08c5af7c49b8212f417e2f15ab3f5c33dcf153821a8139f877a5b7be40000000000feffffffab0949a08c5af7c49b8212f417e2f15ab3f5c33dcf153821a8139f877a5b7be40100000000feffffff02603bea0b000000001976a914768a40bbd740cbe81d988e71de2a4d5c71396b1d88ac8e240000000000001976a9146f4620b553fa095e721b9ee0efe9fa039cca459788ac00000000000100df0200000001268171371edff285e937adeea4b37b78000c0566cbb3ad64641713ca42171bf6000000006a473044022070b2245123e6bf474d60c5b50c043d4c691a5d2435f09a34a7662a9dc251790a022001329ca9dacf280bdf30740ec0390422422c81cb45839457aeb76fc12edd95b3012102657d118d3357b8e0f4c2cd46db7b39f6d9c38d9a70abcb9b2de5dc8dbfe4ce31feffffff02d3dff505000000001976a914d0c59903c5bac2868760e90fd521a4665aa7652088ac00e1f5050000000017a9143545e6e33b832c47050f24d3eeb93c9c03948bc787b32e13000001012000e1f5050000000017a9143545e6e33b832c47050f24d3eeb93c9c03948bc787010416001485d13537f2e265405a34dbafa9e3dda01fb8230800220202ead596687ca806043edc3de116cdf29d5e9257c196cd055cf698c8d02bf24e9910b4a6ba670000008000000080020000800022020394f62be9df19952c5587768aeb7698061ad2c4a25c894f47d8c162b4d7213d0510b4a6ba6700000080010000800200008000",
+	"70736274ff0100550200000001279a2323a5dfb51fc45f220fa58b0fc13e1e3342792a85d7e36cd6333b5cbc390000000000ffffffff01a05aea0b000000001976a914ffe9c0061097cc3b636f2cb0460fa4fc427d2b4588ac0000000000010120955eea0b0000000017a9146345200f68d189e1adc0df1c4d16ea8f14c0dbeb87220203b1341ccba7683b6af4f1238cd6e97e7167d569fac47f1e48d47541844355bd4646304302200424b58effaaa694e1559ea5c93bbfd4a89064224055cdf070b6771469442d07021f5c8eb0fea6516d60b8acb33ad64ede60e8785bfb3aa94b99bdf86151db9a9a010104220020771fd18ad459666dd49f3d564e3dbc42f4c84774e360ada16816a8ed488d5681010547522103b1341ccba7683b6af4f1238cd6e97e7167d569fac47f1e48d47541844355bd462103de55d1e1dac805e3f8a58c1fbf9b94c02f3dbaafe127fefca4995f26f82083bd52ae220603b1341ccba7683b6af4f1238cd6e97e7167d569fac47f1e48d47541844355bd4610b4a6ba67000000800000008004000080220603de55d1e1dac805e3f8a58c1fbf9b94c02f3dbaafe127fefca4995f26f82083bd10b4a6ba670000008000000080050000800000",
+	"70736274ff01003f0200000001ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff0000000000ffffffff010000000000000000036a010000000000000a0f0102030405060708090f0102030405060708090a0b0c0d0e0f0000",
+	"70736274ff01003f0200000001ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff0000000000ffffffff010000000000000000036a010000000000002206030d097466b7f59162ac4d90bf65f2a31a8bad82fcd22e98138dcf279401939bd104ffffffff0a0f0102030405060708090f0102030405060708090a0b0c0d0e0f0000",
+	// an unsigned tx without inputs, in the legacy format
+	"70736274ff01002001000000000100000000000000000d6a0b68656c6c6f20776f726c64000000000000",
+}
+
+var bip174Invalid = []string{
+	// wire format, not PSBT format
+	"0200000001268171371edff285e937adeea4b37b78000c0566cbb3ad64641713ca42171bf6000000006a473044022070b2245123e6bf474d60c5b50c043d4c691a5d2435f09a34a7662a9dc251790a022001329ca9dacf280bdf30740ec0390422422c81cb45839457aeb76fc12edd95b3012102657d118d3357b8e0f4c2cd46db7b39f6d9c38d9a70abcb9b2de5dc8dbfe4ce31feffffff02d3dff505000000001976a914d0c59903c5bac2868760e90fd521a4665aa7652088ac00e1f5050000000017a9143545e6e33b832c47050f24d3eeb93c9c03948bc787b32e1300",
+	// missing outputs
+	"70736274ff0100750200000001268171371edff285e937adeea4b37b78000c0566cbb3ad64641713ca42171bf60000000000feffffff02d3dff505000000001976a914d0c59903c5bac2868760e90fd521a4665aa7652088ac00e1f5050000000017a9143545e6e33b832c47050f24d3eeb93c9c03948bc787b32e1300000100fda5010100000000010289a3c71eab4d20e0371bbba4cc698fa295c9463afa2e397f8533ccb62f9567e50100000017160014be18d152a9b012039daf3da7de4f53349eecb985ffffffff86f8aa43a71dff1448893a530a7237ef6b4608bbb2dd2d0171e63aec6a4890b40100000017160014fe3e9ef1a745e974d902c4355943abcb34bd5353ffffffff0200c2eb0b000000001976a91485cff1097fd9e008bb34af709c62197b38978a4888ac72fef84e2c00000017a914339725ba21efd62ac753a9bcd067d6c7a6a39d05870247304402202712be22e0270f394f568311dc7ca9a68970b8025fdd3b240229f07f8a5f3a240220018b38d7dcd314e734c9276bd6fb40f673325bc4baa144c800d2f2f02db2765c012103d2e15674941bad4a996372cb87e1856d3652606d98562fe39c5e9e7e413f210502483045022100d12b852d85dcd961d2f5f4ab660654df6eedcc794c0c33ce5cc309ffb5fce58d022067338a8e0e1725c197fb1a88af59f51e44e4255b20167c8684031c05d1f2592a01210223b72beef0965d10be0778efecd61fcac6f79a4ea169393380734464f84f2ab30000000000",
+	// filled in scriptSig in unsigned tx
+	"70736274ff0100fd0a010200000002ab0949a08c5af7c49b8212f417e2f15ab3f5c33dcf153821a8139f877a5b7be4000000006a47304402204759661797c01b036b25928948686218347d89864b719e1f7fcf57d1e511658702205309eabf56aa4d8891ffd111fdf1336f3a29da866d7f8486d75546ceedaf93190121035cdc61fc7ba971c0b501a646a2a83b102cb43881217ca682dc86e2d73fa88292feffffffab0949a08c5af7c49b8212f417e2f15ab3f5c33dcf153821a8139f877a5b7be40100000000feffffff02603bea0b000000001976a914768a40bbd740cbe81d988e71de2a4d5c71396b1d88ac8e240000000000001976a9146f4620b553fa095e721b9ee0efe9fa039cca459788ac00000000000001012000e1f5050000000017a9143545e6e33b832c47050f24d3eeb93c9c03948bc787010416001485d13537f2e265405a34dbafa9e3dda01fb82308000000",
+	// no unsigned tx
+	"70736274ff000100fda5010100000000010289a3c71eab4d20e0371bbba4cc698fa295c9463afa2e397f8533ccb62f9567e50100000017160014be18d152a9b012039daf3da7de4f53349eecb985ffffffff86f8aa43a71dff1448893a530a7237ef6b4608bbb2dd2d0171e63aec6a4890b40100000017160014fe3e9ef1a745e974d902c4355943abcb34bd5353ffffffff0200c2eb0b000000001976a91485cff1097fd9e008bb34af709c62197b38978a4888ac72fef84e2c00000017a914339725ba21efd62ac753a9bcd067d6c7a6a39d05870247304402202712be22e0270f394f568311dc7ca9a68970b8025fdd3b240229f07f8a5f3a240220018b38d7dcd314e734c9276bd6fb40f673325bc4baa144c800d2f2f02db2765c012103d2e15674941bad4a996372cb87e1856d3652606d98562fe39c5e9e7e413f210502483045022100d12b852d85dcd961d2f5f4ab660654df6eedcc794c0c33ce5cc309ffb5fce58d022067338a8e0e1725c197fb1a88af59f51e44e4255b20167c8684031c05d1f2592a01210223b72beef0965d10be0778efecd61fcac6f79a4ea169393380734464f84f2ab30000000000",
+	// duplicate keys in an input
+	"70736274ff0100750200000001268171371edff285e937adeea4b37b78000c0566cbb3ad64641713ca42171bf60000000000feffffff02d3dff505000000001976a914d0c59903c5bac2868760e90fd521a4665aa7652088ac00e1f5050000000017a9143545e6e33b832c47050f24d3eeb93c9c03948bc787b32e1300000100fda5010100000000010289a3c71eab4d20e0371bbba4cc698fa295c9463afa2e397f8533ccb62f9567e50100000017160014be18d152a9b012039daf3da7de4f53349eecb985ffffffff86f8aa43a71dff1448893a530a7237ef6b4608bbb2dd2d0171e63aec6a4890b40100000017160014fe3e9ef1a745e974d902c4355943abcb34bd5353ffffffff0200c2eb0b000000001976a91485cff1097fd9e008bb34af709c62197b38978a4888ac72fef84e2c00000017a914339725ba21efd62ac753a9bcd067d6c7a6a39d05870247304402202712be22e0270f394f568311dc7ca9a68970b8025fdd3b240229f07f8a5f3a240220018b38d7dcd314e734c9276bd6fb40f673325bc4baa144c800d2f2f02db2765c012103d2e15674941bad4a996372cb87e1856d3652606d98562fe39c5e9e7e413f210502483045022100d12b852d85dcd961d2f5f4ab660654df6eedcc794c0c33ce5cc309ffb5fce58d022067338a8e0e1725c197fb1a88af59f51e44e4255b20167c8684031c05d1f2592a01210223b72beef0965d10be0778efecd61fcac6f79a4ea169393380734464f84f2ab30000000001003f0200000001ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff0000000000ffffffff010000000000000000036a010000000000000000",
+	// invalid global transaction typed key
+	"70736274ff020001550200000001279a2323a5dfb51fc45f220fa58b0fc13e1e3342792a85d7e36cd6333b5cbc390000000000ffffffff01a05aea0b000000001976a914ffe9c0061097cc3b636f2cb0460fa4fc427d2b4588ac0000000000010120955eea0b0000000017a9146345200f68d189e1adc0df1c4d16ea8f14c0dbeb87220203b1341ccba7683b6af4f1238cd6e97e7167d569fac47f1e48d47541844355bd4646304302200424b58effaaa694e1559ea5c93bbfd4a89064224055cdf070b6771469442d07021f5c8eb0fea6516d60b8acb33ad64ede60e8785bfb3aa94b99bdf86151db9a9a010104220020771fd18ad459666dd49f3d564e3dbc42f4c84774e360ada16816a8ed488d5681010547522103b1341ccba7683b6af4f1238cd6e97e7167d569fac47f1e48d47541844355bd462103de55d1e1dac805e3f8a58c1fbf9b94c02f3dbaafe127fefca4995f26f82083bd52ae220603b1341ccba7683b6af4f1238cd6e97e7167d569fac47f1e48d47541844355bd4610b4a6ba67000000800000008004000080220603de55d1e1dac805e3f8a58c1fbf9b94c02f3dbaafe127fefca4995f26f82083bd10b4a6ba670000008000000080050000800000",
+	// invalid input witness utxo typed key
+	"70736274ff0100550200000001279a2323a5dfb51fc45f220fa58b0fc13e1e3342792a85d7e36cd6333b5cbc390000000000ffffffff01a05aea0b000000001976a914ffe9c0061097cc3b636f2cb0460fa4fc427d2b4588ac000000000002010020955eea0b0000000017a9146345200f68d189e1adc0df1c4d16ea8f14c0dbeb87220203b1341ccba7683b6af4f1238cd6e97e7167d569fac47f1e48d47541844355bd4646304302200424b58effaaa694e1559ea5c93bbfd4a89064224055cdf070b6771469442d07021f5c8eb0fea6516d60b8acb33ad64ede60e8785bfb3aa94b99bdf86151db9a9a010104220020771fd18ad459666dd49f3d564e3dbc42f4c84774e360ada16816a8ed488d5681010547522103b1341ccba7683b6af4f1238cd6e97e7167d569fac47f1e48d47541844355bd462103de55d1e1dac805e3f8a58c1fbf9b94c02f3dbaafe127fefca4995f26f82083bd52ae220603b1341ccba7683b6af4f1238cd6e97e7167d569fac47f1e48d47541844355bd4610b4a6ba67000000800000008004000080220603de55d1e1dac805e3f8a58c1fbf9b94c02f3dbaafe127fefca4995f26f82083bd10b4a6ba670000008000000080050000800000",
+	// invalid pubkey length for input partial signature typed key
+	"70736274ff0100550200000001279a2323a5dfb51fc45f220fa58b0fc13e1e3342792a85d7e36cd6333b5cbc390000000000ffffffff01a05aea0b000000001976a914ffe9c0061097cc3b636f2cb0460fa4fc427d2b4588ac0000000000010120955eea0b0000000017a9146345200f68d189e1adc0df1c4d16ea8f14c0dbeb87210203b1341ccba7683b6af4f1238cd6e97e7167d569fac47f1e48d47541844355bd46304302200424b58effaaa694e1559ea5c93bbfd4a89064224055cdf070b6771469442d07021f5c8eb0fea6516d60b8acb33ad64ede60e8785bfb3aa94b99bdf86151db9a9a010104220020771fd18ad459666dd49f3d564e3dbc42f4c84774e360ada16816a8ed488d5681010547522103b1341ccba7683b6af4f1238cd6e97e7167d569fac47f1e48d47541844355bd462103de55d1e1dac805e3f8a58c1fbf9b94c02f3dbaafe127fefca4995f26f82083bd52ae220603b1341ccba7683b6af4f1238cd6e97e7167d569fac47f1e48d47541844355bd4610b4a6ba67000000800000008004000080220603de55d1e1dac805e3f8a58c1fbf9b94c02f3dbaafe127fefca4995f26f82083bd10b4a6ba670000008000000080050000800000",
+	// invalid redeemscript typed key
+	"70736274ff0100550200000001279a2323a5dfb51fc45f220fa58b0fc13e1e3342792a85d7e36cd6333b5cbc390000000000ffffffff01a05aea0b000000001976a914ffe9c0061097cc3b636f2cb0460fa4fc427d2b4588ac0000000000010120955eea0b0000000017a9146345200f68d189e1adc0df1c4d16ea8f14c0dbeb87220203b1341ccba7683b6af4f1238cd6e97e7167d569fac47f1e48d47541844355bd4646304302200424b58effaaa694e1559ea5c93bbfd4a89064224055cdf070b6771469442d07021f5c8eb0fea6516d60b8acb33ad64ede60e8785bfb3aa94b99bdf86151db9a9a01020400220020771fd18ad459666dd49f3d564e3dbc42f4c84774e360ada16816a8ed488d5681010547522103b1341ccba7683b6af4f1238cd6e97e7167d569fac47f1e48d47541844355bd462103de55d1e1dac805e3f8a58c1fbf9b94c02f3dbaafe127fefca4995f26f82083bd52ae220603b1341ccba7683b6af4f1238cd6e97e7167d569fac47f1e48d47541844355bd4610b4a6ba67000000800000008004000080220603de55d1e1dac805e3f8a58c1fbf9b94c02f3dbaafe127fefca4995f26f82083bd10b4a6ba670000008000000080050000800000",
+	// invalid witness script typed key
+	"70736274ff0100550200000001279a2323a5dfb51fc45f220fa58b0fc13e1e3342792a85d7e36cd6333b5cbc390000000000ffffffff01a05aea0b000000001976a914ffe9c0061097cc3b636f2cb0460fa4fc427d2b4588ac0000000000010120955eea0b0000000017a9146345200f68d189e1adc0df1c4d16ea8f14c0dbeb87220203b1341ccba7683b6af4f1238cd6e97e7167d569fac47f1e48d47541844355bd4646304302200424b58effaaa694e1559ea5c93bbfd4a89064224055cdf070b6771469442d07021f5c8eb0fea6516d60b8acb33ad64ede60e8785bfb3aa94b99bdf86151db9a9a010104220020771fd18ad459666dd49f3d564e3dbc42f4c84774e360ada16816a8ed488d568102050047522103b1341ccba7683b6af4f1238cd6e97e7167d569fac47f1e48d47541844355bd462103de55d1e1dac805e3f8a58c1fbf9b94c02f3dbaafe127fefca4995f26f82083bd52ae220603b1341ccba7683b6af4f1238cd6e97e7167d569fac47f1e48d47541844355bd4610b4a6ba67000000800000008004000080220603de55d1e1dac805e3f8a58c1fbf9b94c02f3dbaafe127fefca4995f26f82083bd10b4a6ba670000008000000080050000800000",
+	// invalid bip32 typed key
+	"70736274ff0100550200000001279a2323a5dfb51fc45f220fa58b0fc13e1e3342792a85d7e36cd6333b5cbc390000000000ffffffff01a05aea0b000000001976a914ffe9c0061097cc3b636f2cb0460fa4fc427d2b4588ac0000000000010120955eea0b0000000017a9146345200f68d189e1adc0df1c4d16ea8f14c0dbeb87220203b1341ccba7683b6af4f1238cd6e97e7167d569fac47f1e48d47541844355bd4646304302200424b58effaaa694e1559ea5c93bbfd4a89064224055cdf070b6771469442d07021f5c8eb0fea6516d60b8acb33ad64ede60e8785bfb3aa94b99bdf86151db9a9a010104220020771fd18ad459666dd49f3d564e3dbc42f4c84774e360ada16816a8ed488d5681010547522103b1341ccba7683b6af4f1238cd6e97e7167d569fac47f1e48d47541844355bd462103de55d1e1dac805e3f8a58c1fbf9b94c02f3dbaafe127fefca4995f26f82083bd52ae210603b1341ccba7683b6af4f1238cd6e97e7167d569fac47f1e48d47541844355bd10b4a6ba67000000800000008004000080220603de55d1e1dac805e3f8a58c1fbf9b94c02f3dbaafe127fefca4995f26f82083bd10b4a6ba670000008000000080050000800000",
+	// invalid non-witness utxo typed key
+	"70736274ff01009a020000000258e87a21b56daf0c23be8e7070456c336f7cbaa5c8757924f545887bb2abdd750000000000ffffffff838d0427d0ec650a68aa46bb0b098aea4422c071b2ca78352a077959d07cea1d0100000000ffffffff0270aaf00800000000160014d85c2b71d0060b09c9886aeb815e50991dda124d00e1f5050000000016001400aea9a2e5f0f876a588df5546e8742d1d87008f0000000000020000bb0200000001aad73931018bd25f84ae400b68848be09db706eac2ac18298babee71ab656f8b0000000048473044022058f6fc7c6a33e1b31548d481c826c015bd30135aad42cd67790dab66d2ad243b02204a1ced2604c6735b6393e5b41691dd78b00f0c5942fb9f751856faa938157dba01feffffff0280f0fa020000000017a9140fb9463421696b82c833af241c78c17ddbde493487d0f20a270100000017a91429ca74f8a08f81999428185c97b5d852e4063f6187650000000107da00473044022074018ad4180097b873323c0015720b3684cc8123891048e7dbcd9b55ad679c99022073d369b740e3eb53dcefa33823c8070514ca55a7dd9544f157c167913261118c01483045022100f61038b308dc1da865a34852746f015772934208c6d24454393cd99bdf2217770220056e675a675a6d0a02b85b14e5e29074d8a25a9b5760bea2816f661910a006ea01475221029583bf39ae0a609747ad199addd634fa6108559d6c5cd39b4c2183f1ab96e07f2102dab61ff49a14db6a7d02b0cd1fbb78fc4b18312b5b4e54dae4dba2fbfef536d752ae0001012000c2eb0b0000000017a914b7f5faf40e3d40a5a459b1db3535f2b72fa921e8870107232200208c2353173743b595dfb4a07b72ba8e42e3797da74e87fe7d9d7497e3b20289030108da0400473044022062eb7a556107a7c73f45ac4ab5a1dddf6f7075fb1275969a7f383efff784bcb202200c05dbb7470dbf2f08557dd356c7325c1ed30913e996cd3840945db12228da5f01473044022065f45ba5998b59a27ffe1a7bed016af1f1f90d54b3aa8f7450aa5f56a25103bd02207f724703ad1edb96680b284b56d4ffcb88f7fb759eabbe08aa30f29b851383d20147522103089dc10c7ac6db54f91329af617333db388cead0c231f723379d1b99030b02dc21023add904f3d6dcf59ddb906b0dee23529b7ffb9ed50e5e86151926860221f0e7352ae00220203a9a4c37f5996d3aa25dbac6b570af0650394492942460b354753ed9eeca5877110d90c6a4f000000800000008004000080002202027f6399757d2eff55a136ad02c684b1838b6556e5f1b6b34282a94b6b5005109610d90c6a4f00000080000000800500008000",
+	// invalid final scriptsig typed key
+	"70736274ff01009a020000000258e87a21b56daf0c23be8e7070456c336f7cbaa5c8757924f545887bb2abdd750000000000ffffffff838d0427d0ec650a68aa46bb0b098aea4422c071b2ca78352a077959d07cea1d0100000000ffffffff0270aaf00800000000160014d85c2b71d0060b09c9886aeb815e50991dda124d00e1f5050000000016001400aea9a2e5f0f876a588df5546e8742d1d87008f00000000000100bb0200000001aad73931018bd25f84ae400b68848be09db706eac2ac18298babee71ab656f8b0000000048473044022058f6fc7c6a33e1b31548d481c826c015bd30135aad42cd67790dab66d2ad243b02204a1ced2604c6735b6393e5b41691dd78b00f0c5942fb9f751856faa938157dba01feffffff0280f0fa020000000017a9140fb9463421696b82c833af241c78c17ddbde493487d0f20a270100000017a91429ca74f8a08f81999428185c97b5d852e4063f618765000000020700da00473044022074018ad4180097b873323c0015720b3684cc8123891048e7dbcd9b55ad679c99022073d369b740e3eb53dcefa33823c8070514ca55a7dd9544f157c167913261118c01483045022100f61038b308dc1da865a34852746f015772934208c6d24454393cd99bdf2217770220056e675a675a6d0a02b85b14e5e29074d8a25a9b5760bea2816f661910a006ea01475221029583bf39ae0a609747ad199addd634fa6108559d6c5cd39b4c2183f1ab96e07f2102dab61ff49a14db6a7d02b0cd1fbb78fc4b18312b5b4e54dae4dba2fbfef536d752ae0001012000c2eb0b0000000017a914b7f5faf40e3d40a5a459b1db3535f2b72fa921e8870107232200208c2353173743b595dfb4a07b72ba8e42e3797da74e87fe7d9d7497e3b20289030108da0400473044022062eb7a556107a7c73f45ac4ab5a1dddf6f7075fb1275969a7f383efff784bcb202200c05dbb7470dbf2f08557dd356c7325c1ed30913e996cd3840945db12228da5f01473044022065f45ba5998b59a27ffe1a7bed016af1f1f90d54b3aa8f7450aa5f56a25103bd02207f724703ad1edb96680b284b56d4ffcb88f7fb759eabbe08aa30f29b851383d20147522103089dc10c7ac6db54f91329af617333db388cead0c231f723379d1b99030b02dc21023add904f3d6dcf59ddb906b0dee23529b7ffb9ed50e5e86151926860221f0e7352ae00220203a9a4c37f5996d3aa25dbac6b570af0650394492942460b354753ed9eeca5877110d90c6a4f000000800000008004000080002202027f6399757d2eff55a136ad02c684b1838b6556e5f1b6b34282a94b6b5005109610d90c6a4f00000080000000800500008000",
+	// invalid final script witness typed key
+	"70736274ff01009a020000000258e87a21b56daf0c23be8e7070456c336f7cbaa5c8757924f545887bb2abdd750000000000ffffffff838d0427d0ec650a68aa46bb0b098aea4422c071b2ca78352a077959d07cea1d0100000000ffffffff0270aaf00800000000160014d85c2b71d0060b09c9886aeb815e50991dda124d00e1f5050000000016001400aea9a2e5f0f876a588df5546e8742d1d87008f00000000000100bb0200000001aad73931018bd25f84ae400b68848be09db706eac2ac18298babee71ab656f8b0000000048473044022058f6fc7c6a33e1b31548d481c826c015bd30135aad42cd67790dab66d2ad243b02204a1ced2604c6735b6393e5b41691dd78b00f0c5942fb9f751856faa938157dba01feffffff0280f0fa020000000017a9140fb9463421696b82c833af241c78c17ddbde493487d0f20a270100000017a91429ca74f8a08f81999428185c97b5d852e4063f6187650000000107da00473044022074018ad4180097b873323c0015720b3684cc8123891048e7dbcd9b55ad679c99022073d369b740e3eb53dcefa33823c8070514ca55a7dd9544f157c167913261118c01483045022100f61038b308dc1da865a34852746f015772934208c6d24454393cd99bdf2217770220056e675a675a6d0a02b85b14e5e29074d8a25a9b5760bea2816f661910a006ea01475221029583bf39ae0a609747ad199addd634fa6108559d6c5cd39b4c2183f1ab96e07f2102dab61ff49a14db6a7d02b0cd1fbb78fc4b18312b5b4e54dae4dba2fbfef536d752ae0001012000c2eb0b0000000017a914b7f5faf40e3d40a5a459b1db3535f2b72fa921e8870107232200208c2353173743b595dfb4a07b72ba8e42e3797da74e87fe7d9d7497e3b2028903020800da0400473044022062eb7a556107a7c73f45ac4ab5a1dddf6f7075fb1275969a7f383efff784bcb202200c05dbb7470dbf2f08557dd356c7325c1ed30913e996cd3840945db12228da5f01473044022065f45ba5998b59a27ffe1a7bed016af1f1f90d54b3aa8f7450aa5f56a25103bd02207f724703ad1edb96680b284b56d4ffcb88f7fb759eabbe08aa30f29b851383d20147522103089dc10c7ac6db54f91329af617333db388cead0c231f723379d1b99030b02dc21023add904f3d6dcf59ddb906b0dee23529b7ffb9ed50e5e86151926860221f0e7352ae00220203a9a4c37f5996d3aa25dbac6b570af0650394492942460b354753ed9eeca5877110d90c6a4f000000800000008004000080002202027f6399757d2eff55a136ad02c684b1838b6556e5f1b6b34282a94b6b5005109610d90c6a4f00000080000000800500008000",
+	// invalid pubkey in output BIP32 derivation paths typed key
+	"70736274ff01009a020000000258e87a21b56daf0c23be8e7070456c336f7cbaa5c8757924f545887bb2abdd750000000000ffffffff838d0427d0ec650a68aa46bb0b098aea4422c071b2ca78352a077959d07cea1d0100000000ffffffff0270aaf00800000000160014d85c2b71d0060b09c9886aeb815e50991dda124d00e1f5050000000016001400aea9a2e5f0f876a588df5546e8742d1d87008f00000000000100bb0200000001aad73931018bd25f84ae400b68848be09db706eac2ac18298babee71ab656f8b0000000048473044022058f6fc7c6a33e1b31548d481c826c015bd30135aad42cd67790dab66d2ad243b02204a1ced2604c6735b6393e5b41691dd78b00f0c5942fb9f751856faa938157dba01feffffff0280f0fa020000000017a9140fb9463421696b82c833af241c78c17ddbde493487d0f20a270100000017a91429ca74f8a08f81999428185c97b5d852e4063f6187650000000107da00473044022074018ad4180097b873323c0015720b3684cc8123891048e7dbcd9b55ad679c99022073d369b740e3eb53dcefa33823c8070514ca55a7dd9544f157c167913261118c01483045022100f61038b308dc1da865a34852746f015772934208c6d24454393cd99bdf2217770220056e675a675a6d0a02b85b14e5e29074d8a25a9b5760bea2816f661910a006ea01475221029583bf39ae0a609747ad199addd634fa6108559d6c5cd39b4c2183f1ab96e07f2102dab61ff49a14db6a7d02b0cd1fbb78fc4b18312b5b4e54dae4dba2fbfef536d752ae0001012000c2eb0b0000000017a914b7f5faf40e3d40a5a459b1db3535f2b72fa921e8870107232200208c2353173743b595dfb4a07b72ba8e42e3797da74e87fe7d9d7497e3b20289030108da0400473044022062eb7a556107a7c73f45ac4ab5a1dddf6f7075fb1275969a7f383efff784bcb202200c05dbb7470dbf2f08557dd356c7325c1ed30913e996cd3840945db12228da5f01473044022065f45ba5998b59a27ffe1a7bed016af1f1f90d54b3aa8f7450aa5f56a25103bd02207f724703ad1edb96680b284b56d4ffcb88f7fb759eabbe08aa30f29b851383d20147522103089dc10c7ac6db54f91329af617333db388cead0c231f723379d1b99030b02dc21023add904f3d6dcf59ddb906b0dee23529b7ffb9ed50e5e86151926860221f0e7352ae00210203a9a4c37f5996d3aa25dbac6b570af0650394492942460b354753ed9eeca58710d90c6a4f000000800000008004000080002202027f6399757d2eff55a136ad02c684b1838b6556e5f1b6b34282a94b6b5005109610d90c6a4f00000080000000800500008000",
+	// invalid input sighash type typed key
+	"70736274ff0100730200000001301ae986e516a1ec8ac5b4bc6573d32f83b465e23ad76167d68b38e730b4dbdb0000000000ffffffff02747b01000000000017a91403aa17ae882b5d0d54b25d63104e4ffece7b9ea2876043993b0000000017a914b921b1ba6f722e4bfa83b6557a3139986a42ec8387000000000001011f00ca9a3b00000000160014d2d94b64ae08587eefc8eeb187c601e939f9037c0203000100000000010016001462e9e982fff34dd8239610316b090cd2a3b747cb000100220020876bad832f1d168015ed41232a9ea65a1815d9ef13c0ef8759f64b5b2b278a65010125512103b7ce23a01c5b4bf00a642537cdfabb315b668332867478ef51309d2bd57f8a8751ae00",
+	// invalid output redeemscript typed key
+	"70736274ff0100730200000001301ae986e516a1ec8ac5b4bc6573d32f83b465e23ad76167d68b38e730b4dbdb0000000000ffffffff02747b01000000000017a91403aa17ae882b5d0d54b25d63104e4ffece7b9ea2876043993b0000000017a914b921b1ba6f722e4bfa83b6557a3139986a42ec8387000000000001011f00ca9a3b00000000160014d2d94b64ae08587eefc8eeb187c601e939f9037c0002000016001462e9e982fff34dd8239610316b090cd2a3b747cb000100220020876bad832f1d168015ed41232a9ea65a1815d9ef13c0ef8759f64b5b2b278a65010125512103b7ce23a01c5b4bf00a642537cdfabb315b668332867478ef51309d2bd57f8a8751ae00",
+	// invalid output witnessScript typed key
+	"70736274ff0100730200000001301ae986e516a1ec8ac5b4bc6573d32f83b465e23ad76167d68b38e730b4dbdb0000000000ffffffff02747b01000000000017a91403aa17ae882b5d0d54b25d63104e4ffece7b9ea2876043993b0000000017a914b921b1ba6f722e4bfa83b6557a3139986a42ec8387000000000001011f00ca9a3b00000000160014d2d94b64ae08587eefc8eeb187c601e939f9037c00010016001462e9e982fff34dd8239610316b090cd2a3b747cb000100220020876bad832f1d168015ed41232a9ea65a1815d9ef13c0ef8759f64b5b2b278a6521010025512103b7ce23a01c5b4bf00a642537cdfabb315b668332867478ef51309d2bd57f8a8751ae00",
+}
+
+func TestParseBip174Vector(t *testing.T) {
+	p, err := Parse(bip174Vector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Version != 0 || len(p.Inputs) != 1 || len(p.Outputs) != 2 {
+		t.Errorf("FAIL")
+	}
+	if p.Inputs[0].NonWitnessUtxo == nil {
+		t.Errorf("FAIL")
+	}
+	s, err := p.Base64()
+	if err != nil || s != bip174Vector {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestBip174Vectors(t *testing.T) {
+	for _, h := range bip174Valid {
+		b, _ := hex.DecodeString(h)
+		p, err := new(Packet).Unmarshal(bytes.NewReader(b))
+		if err != nil {
+			t.Errorf("FAIL: %v", err)
+			continue
+		}
+		if again, err := p.Marshal(); err != nil || !bytes.Equal(again, b) {
+			t.Errorf("FAIL: %s", h)
+		}
+	}
+	for _, h := range bip174Invalid {
+		b, _ := hex.DecodeString(h)
+		if _, err := new(Packet).Unmarshal(bytes.NewReader(b)); err == nil {
+			t.Errorf("FAIL: %s", h)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	valid, _ := base64.StdEncoding.DecodeString(bip174Vector)
+	for _, b := range [][]byte{
+		// invalid magic
+		append([]byte("psbu"), valid[4:]...),
+		// truncated
+		valid[:len(valid)-1],
+		// trailing data
+		append(append([]byte{}, valid...), 0x00),
+		// no unsigned tx
+		{'p', 's', 'b', 't', 0xff, 0x00},
+		// duplicate version keys
+		{'p', 's', 'b', 't', 0xff, 0x01, 0xfb, 0x04, 0, 0, 0, 0, 0x01, 0xfb, 0x04, 0, 0, 0, 0, 0x00},
+		// version 2 without the transaction fields
+		{'p', 's', 'b', 't', 0xff, 0x01, 0xfb, 0x04, 2, 0, 0, 0, 0x00},
+		// an unsigned tx claiming 2^63 inputs
+		{'p', 's', 'b', 't', 0xff, 0x01, 0x00, 0x0d, 2, 0, 0, 0, 0xff, 0, 0, 0, 0, 0, 0, 0, 0x80, 0x00},
+		// an unsigned tx in the SegWit format
+		segWitUnsignedTx(),
+	} {
+		if _, err := new(Packet).Unmarshal(bytes.NewReader(b)); err == nil {
+			t.Errorf("FAIL: %x", b)
+		}
+	}
+
+	// non-witness UTXOs claiming 2^63 inputs, outputs and a witness element of 2^63 bytes
+	_, _, unsignedTx := fixture()
+	for _, utxo := range [][]byte{
+		{2, 0, 0, 0, 0xff, 0, 0, 0, 0, 0, 0, 0, 0x80},
+		append(append([]byte{2, 0, 0, 0, 0x01}, make([]byte, 41)...), 0xff, 0, 0, 0, 0, 0, 0, 0, 0x80),
+		append(append([]byte{2, 0, 0, 0, 0x00, 0x01, 0x01}, make([]byte, 41)...), 0x00, 0x01, 0xff, 0, 0, 0, 0, 0, 0, 0, 0x80),
+	} {
+		p, _ := New(unsignedTx, 0)
+		p.Inputs[0].Unknowns = []KeyValue{{[]byte{0x00}, utxo}}
+		b, _ := p.Marshal()
+		if _, err := new(Packet).Unmarshal(bytes.NewReader(b)); err == nil {
+			t.Errorf("FAIL: %x", utxo)
+		}
+	}
+}
+
+// segWitUnsignedTx returns the BIP174 test vector with its unsigned tx in the SegWit format.
+func segWitUnsignedTx() []byte {
+	valid, _ := base64.StdEncoding.DecodeString(bip174Vector)
+	// [magic][0x01 0x00][0x75][unsigned tx]..., the tx has 1 input
+	unsignedTx := valid[8 : 8+0x75]
+	b := append([]byte{}, valid[:7]...)
+	b = append(b, 0x75+3)
+	b = append(b, unsignedTx[:4]...)
+	b = append(b, 0x00, 0x01)
+	b = append(b, unsignedTx[4:len(unsignedTx)-4]...)
+	b = append(b, 0x00)
+	b = append(b, unsignedTx[len(unsignedTx)-4:]...)
+	return append(b, valid[8+0x75:]...)
+}
+
+// fixture returns a key, a transaction paying to its P2PKH and P2WPKH scripts,
+// and an unsigned transaction spending both outputs.
+func fixture() (*ecdsa.PrivateKey, *tx.Tx, *tx.Tx) {
+	priv := ecdsa.GenerateKeyFromSecret(elliptic.Secp256k1, big.NewInt(8675309))
+	h160 := hash.Hash160(priv.PublicKey.MarshalCompressed())
+
+	prevTx := &tx.Tx{
+		Version: 1,
+		TxIns:   []tx.TxIn{{Sequence: 0xffffffff}},
+		TxOuts: []tx.TxOut{
+			{Amount: 50000, ScriptPubKey: script.NewP2PKHScript(h160)},
+			{Amount: 60000, ScriptPubKey: script.NewP2WPKHScript(h160)},
+		},
+	}
+	id, _ := prevTx.Id()
+	b, _ := hex.DecodeString(id)
+
+	unsignedTx := &tx.Tx{Version: 2}
+	for i := uint32(0); i < 2; i++ {
+		in := tx.TxIn{PrevIndex: i, Sequence: 0xfffffffd}
+		copy(in.PrevTxId[:], b)
+		unsignedTx.TxIns = append(unsignedTx.TxIns, in)
+	}
+	unsignedTx.TxOuts = []tx.TxOut{{Amount: 100000, ScriptPubKey: script.NewP2WPKHScript(h160)}}
+	return priv, prevTx, unsignedTx
+}
+
+func TestRoles(t *testing.T) {
+	priv, prevTx, unsignedTx := fixture()
+
+	p, err := New(unsignedTx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.SetNonWitnessUtxo(0, prevTx); err != nil {
+		t.Fatal(err)
+	}
+	p.SetWitnessUtxo(1, prevTx.TxOuts[1])
+
+	// two signers, each signing one input of its own copy
+	s, _ := p.Base64()
+	p1, _ := Parse(s)
+	p2, _ := Parse(s)
+	if err = p1.Sign(0, priv); err != nil {
+		t.Fatal(err)
+	}
+	if err = p2.Sign(1, priv); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err = Combine(p1, p2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Inputs[0].PartialSigs) != 1 || len(p.Inputs[1].PartialSigs) != 1 {
+		t.Errorf("FAIL")
+	}
+	if err = p.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	signedTx, err := p.Extract()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// P2PKH
+	spk := prevTx.TxOuts[0].ScriptPubKey
+	sighash, _ := signedTx.SighashLegacy(0, spk, tx.SighashAll)
+	combined := signedTx.TxIns[0].ScriptSig.Add(spk...)
+	if combined.Eval(&script.MockChecker{Sighash: sighash[:]}, nil) != nil {
+		t.Errorf("FAIL")
+	}
+	// P2WPKH
+	spk = prevTx.TxOuts[1].ScriptPubKey
+	sighash, _ = signedTx.SighashBip143(1, spk, prevTx.TxOuts[1].Amount, tx.SighashAll)
+	if spk.Eval(&script.MockChecker{Sighash: sighash[:]}, signedTx.TxIns[1].Witness) != nil {
+		t.Errorf("FAIL")
+	}
+	if !signedTx.SegWit || len(signedTx.TxIns[1].ScriptSig) != 0 {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestSignWrongKey(t *testing.T) {
+	_, prevTx, unsignedTx := fixture()
+	p, _ := New(unsignedTx, 0)
+	p.SetNonWitnessUtxo(0, prevTx)
+
+	other := ecdsa.GenerateKeyFromSecret(elliptic.Secp256k1, big.NewInt(42))
+	if err := p.Sign(0, other); err == nil {
+		t.Errorf("FAIL")
+	}
+	// the UTXO of the second input is missing
+	if err := p.Sign(1, other); err == nil {
+		t.Errorf("FAIL")
+	}
+	// the UTXO is not the spent transaction
+	if err := p.SetNonWitnessUtxo(1, unsignedTx); err == nil {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestFinalizeMultisig(t *testing.T) {
+	priv1 := ecdsa.GenerateKeyFromSecret(elliptic.Secp256k1, big.NewInt(1))
+	priv2 := ecdsa.GenerateKeyFromSecret(elliptic.Secp256k1, big.NewInt(2))
+	pub1, pub2 := priv1.PublicKey.MarshalCompressed(), priv2.PublicKey.MarshalCompressed()
+	redeemScript, _ := script.NewMultisigScript(2, [][]byte{pub1, pub2})
+	raw, _ := redeemScript.Raw()
+
+	_, prevTx, unsignedTx := fixture()
+	prevTx.TxOuts[0].ScriptPubKey = script.NewP2SHScript(hash.Hash160(raw))
+	unsignedTx.TxIns = unsignedTx.TxIns[:1]
+	id, _ := prevTx.Id()
+	b, _ := hex.DecodeString(id)
+	copy(unsignedTx.TxIns[0].PrevTxId[:], b)
+
+	p, _ := New(unsignedTx, 0)
+	if err := p.SetNonWitnessUtxo(0, prevTx); err != nil {
+		t.Fatal(err)
+	}
+	p.Inputs[0].RedeemScript = redeemScript
+
+	// sign in the reverse order of the keys
+	if err := p.Sign(0, priv2); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Finalize(); err == nil {
+		t.Errorf("FAIL")
+	}
+	if err := p.Sign(0, priv1); err != nil {
+		t.Fatal(err)
+	}
+	sig2, sig1 := p.Inputs[0].PartialSigs[0].Signature, p.Inputs[0].PartialSigs[1].Signature
+	if err := p.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+
+	scriptSig := p.Inputs[0].FinalScriptSig
+	if len(scriptSig) != 4 || scriptSig[0] != script.OP_0 {
+		t.Fatal("FAIL")
+	}
+	if !bytes.Equal(scriptSig.GetBytes(1), sig1) || !bytes.Equal(scriptSig.GetBytes(2), sig2) ||
+		!bytes.Equal(scriptSig.GetBytes(3), raw) {
+		t.Errorf("FAIL")
+	}
+	if p.Inputs[0].RedeemScript != nil || p.Inputs[0].PartialSigs != nil {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestSignP2WSH(t *testing.T) {
+	priv1 := ecdsa.GenerateKeyFromSecret(elliptic.Secp256k1, big.NewInt(1))
+	priv2 := ecdsa.GenerateKeyFromSecret(elliptic.Secp256k1, big.NewInt(2))
+	pub1, pub2 := priv1.PublicKey.MarshalCompressed(), priv2.PublicKey.MarshalCompressed()
+	witnessScript, _ := script.NewMultisigScript(2, [][]byte{pub1, pub2})
+	raw, _ := witnessScript.Raw()
+
+	_, prevTx, unsignedTx := fixture()
+	spk := script.NewP2WSHScript(hash.Sha256(raw))
+	p, _ := New(unsignedTx, 0)
+	p.SetWitnessUtxo(1, tx.TxOut{Amount: prevTx.TxOuts[1].Amount, ScriptPubKey: spk})
+
+	if err := p.Sign(1, priv1); err == nil {
+		t.Errorf("FAIL")
+	}
+	p.Inputs[1].WitnessScript = witnessScript
+	other := ecdsa.GenerateKeyFromSecret(elliptic.Secp256k1, big.NewInt(42))
+	if err := p.Sign(1, other); err == nil {
+		t.Errorf("FAIL")
+	}
+	if err := p.Sign(1, priv1); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Sign(1, priv2); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.finalizeInput(1); err != nil {
+		t.Fatal(err)
+	}
+
+	// the witness is <empty dummy> <sig 1> <sig 2> <witness script>
+	witness := p.Inputs[1].FinalScriptWitness
+	if len(witness) != 4 || len(witness[0]) != 0 || !bytes.Equal(witness[3], raw) {
+		t.Fatal("FAIL")
+	}
+	signedTx, _ := p.UnsignedTx()
+	sighash, _ := signedTx.SighashBip143(1, witnessScript, prevTx.TxOuts[1].Amount, tx.SighashAll)
+	flags := script.VerifyWitness | script.VerifyNullDummy
+	if script.VerifyScript(script.Script{}, spk, witness, &script.MockChecker{Sighash: sighash[:]}, flags) != nil {
+		t.Errorf("FAIL")
+	}
+	// the witness script has to match the program
+	witness[3] = witness[3][1:]
+	if script.VerifyScript(script.Script{}, spk, witness, &script.MockChecker{Sighash: sighash[:]}, flags) == nil {
+		t.Errorf("FAIL")
+	}
+
+	// the key has to be one of the keys of the script, not just be pushed by it
+	witnessScript = script.Script{}
+	witnessScript = witnessScript.AddBytes(pub1)
+	witnessScript = witnessScript.Add(script.OP_DROP, script.OP_1)
+	raw, _ = witnessScript.Raw()
+	p, _ = New(unsignedTx, 0)
+	p.SetWitnessUtxo(1, tx.TxOut{Amount: prevTx.TxOuts[1].Amount, ScriptPubKey: script.NewP2WSHScript(hash.Sha256(raw))})
+	p.Inputs[1].WitnessScript = witnessScript
+	if err := p.Sign(1, priv1); err == nil {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestVersion2(t *testing.T) {
+	_, prevTx, unsignedTx := fixture()
+	unsignedTx.LockTime = 100
+
+	p, err := New(unsignedTx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetWitnessUtxo(1, prevTx.TxOuts[1])
+	s, err := p.Base64()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err = Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := p.UnsignedTx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotId, _ := got.Id()
+	wantId, _ := unsignedTx.Id()
+	if gotId != wantId {
+		t.Errorf("FAIL")
+	}
+
+	// the required locktimes of the inputs take precedence over the fallback
+	height, time := uint32(200), uint32(500000001)
+	p.Inputs[0].RequiredHeightLockTime = &height
+	p.Inputs[1].RequiredHeightLockTime = &height
+	p.Inputs[1].RequiredTimeLockTime = &time
+	if got, _ = p.UnsignedTx(); got.LockTime != 200 {
+		t.Errorf("FAIL")
+	}
+	p.Inputs[0].RequiredHeightLockTime = nil
+	p.Inputs[0].RequiredTimeLockTime = &time
+	p.Inputs[1].RequiredTimeLockTime = nil
+	if _, err = p.UnsignedTx(); err == nil {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestUnknownPassthrough(t *testing.T) {
+	_, _, unsignedTx := fixture()
+	p, _ := New(unsignedTx, 0)
+	p.Unknowns = []KeyValue{{[]byte{0xfc, 0x01}, []byte{0xde, 0xad}}}
+	p.Inputs[0].Unknowns = []KeyValue{{[]byte{0x0a, 0x01}, []byte{0xbe, 0xef}}}
+
+	b, _ := p.Marshal()
+	q, err := new(Packet).Unmarshal(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(q.Unknowns) != 1 || !bytes.Equal(q.Unknowns[0].Value, []byte{0xde, 0xad}) {
+		t.Errorf("FAIL")
+	}
+	if len(q.Inputs[0].Unknowns) != 1 || !bytes.Equal(q.Inputs[0].Unknowns[0].Key, []byte{0x0a, 0x01}) {
+		t.Errorf("FAIL")
+	}
+	b2, _ := q.Marshal()
+	if !bytes.Equal(b, b2) {
+		t.Errorf("FAIL")
+	}
+}
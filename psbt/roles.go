@@ -0,0 +1,576 @@
+package psbt
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/VIVelev/btcd/crypto/ecdsa"
+	"github.com/VIVelev/btcd/crypto/hash"
+	"github.com/VIVelev/btcd/script"
+	"github.com/VIVelev/btcd/tx"
+)
+
+// New creates a PSBT with the given version (0 or 2) for the unsigned transaction.
+//
+// This is the Creator role.
+func New(unsignedTx *tx.Tx, version uint32) (*Packet, error) {
+	for _, in := range unsignedTx.TxIns {
+		if len(in.ScriptSig) != 0 || in.Witness != nil {
+			return nil, errors.New("New: the transaction should be unsigned")
+		}
+	}
+
+	p := &Packet{
+		Version: version,
+		Inputs:  make([]Input, len(unsignedTx.TxIns)),
+		Outputs: make([]Output, len(unsignedTx.TxOuts)),
+	}
+	switch version {
+	case 0:
+		t := *unsignedTx
+		t.SegWit = false
+		p.Tx = &t
+	case 2:
+		p.TxVersion = unsignedTx.Version
+		lockTime := unsignedTx.LockTime
+		p.FallbackLockTime = &lockTime
+		for i, in := range unsignedTx.TxIns {
+			sequence := in.Sequence
+			p.Inputs[i].PreviousTxId = in.PrevTxId
+			p.Inputs[i].OutputIndex = in.PrevIndex
+			p.Inputs[i].Sequence = &sequence
+		}
+		for i, out := range unsignedTx.TxOuts {
+			p.Outputs[i].Amount = out.Amount
+			p.Outputs[i].Script = out.ScriptPubKey
+		}
+	default:
+		return nil, errors.New("New: unsupported version")
+	}
+	return p, nil
+}
+
+// UnsignedTx returns the transaction described by the PSBT, without signatures.
+//
+// For version 2, the locktime is determined from the required locktimes of the inputs.
+func (p *Packet) UnsignedTx() (*tx.Tx, error) {
+	if p.Version == 0 {
+		t := *p.Tx
+		t.TxIns = append([]tx.TxIn{}, p.Tx.TxIns...)
+		t.TxOuts = append([]tx.TxOut{}, p.Tx.TxOuts...)
+		return &t, nil
+	}
+
+	lockTime, err := p.lockTime()
+	if err != nil {
+		return nil, err
+	}
+	t := &tx.Tx{Version: p.TxVersion, LockTime: lockTime}
+	for _, in := range p.Inputs {
+		sequence := uint32(0xffffffff)
+		if in.Sequence != nil {
+			sequence = *in.Sequence
+		}
+		t.TxIns = append(t.TxIns, tx.TxIn{
+			PrevTxId:  in.PreviousTxId,
+			PrevIndex: in.OutputIndex,
+			Sequence:  sequence,
+		})
+	}
+	for _, out := range p.Outputs {
+		t.TxOuts = append(t.TxOuts, tx.TxOut{Amount: out.Amount, ScriptPubKey: out.Script})
+	}
+	return t, nil
+}
+
+// lockTime determines the locktime of a version 2 PSBT, as defined in BIP370.
+// Height based locktimes are preferred when all inputs allow both.
+func (p *Packet) lockTime() (uint32, error) {
+	var hasRequirement bool
+	heightOk, timeOk := true, true
+	var height, time uint32
+	for _, in := range p.Inputs {
+		if in.RequiredHeightLockTime == nil && in.RequiredTimeLockTime == nil {
+			continue
+		}
+		hasRequirement = true
+		if in.RequiredHeightLockTime == nil {
+			heightOk = false
+		} else if *in.RequiredHeightLockTime > height {
+			height = *in.RequiredHeightLockTime
+		}
+		if in.RequiredTimeLockTime == nil {
+			timeOk = false
+		} else if *in.RequiredTimeLockTime > time {
+			time = *in.RequiredTimeLockTime
+		}
+	}
+
+	switch {
+	case !hasRequirement && p.FallbackLockTime != nil:
+		return *p.FallbackLockTime, nil
+	case !hasRequirement:
+		return 0, nil
+	case heightOk:
+		return height, nil
+	case timeOk:
+		return time, nil
+	}
+	return 0, errors.New("psbt: inputs require incompatible locktimes")
+}
+
+// SetNonWitnessUtxo sets the full transaction spent by the input with the index.
+//
+// This and the rest of the input and output fields are the Updater role.
+func (p *Packet) SetNonWitnessUtxo(index int, prevTx *tx.Tx) error {
+	p.Inputs[index].NonWitnessUtxo = prevTx
+	if err := p.checkNonWitnessUtxo(index); err != nil {
+		p.Inputs[index].NonWitnessUtxo = nil
+		return err
+	}
+	return nil
+}
+
+// SetWitnessUtxo sets the output spent by the segwit input with the index.
+func (p *Packet) SetWitnessUtxo(index int, out tx.TxOut) {
+	p.Inputs[index].WitnessUtxo = &out
+}
+
+// utxo returns the output spent by the input with the index.
+func (p *Packet) utxo(index int) (*tx.TxOut, error) {
+	in := &p.Inputs[index]
+	if in.WitnessUtxo != nil {
+		return in.WitnessUtxo, nil
+	}
+	if in.NonWitnessUtxo != nil {
+		_, prevIndex := p.prevOut(index)
+		return &in.NonWitnessUtxo.TxOuts[prevIndex], nil
+	}
+	return nil, errors.New("psbt: the input has no UTXO")
+}
+
+// Script patterns, matched on the serialized scripts.
+
+func isP2PKH(raw []byte) bool {
+	return len(raw) == 25 && raw[0] == 0x76 && raw[1] == 0xa9 && raw[2] == 20 &&
+		raw[23] == 0x88 && raw[24] == 0xac
+}
+
+func isP2SH(raw []byte) bool {
+	return len(raw) == 23 && raw[0] == 0xa9 && raw[1] == 20 && raw[22] == 0x87
+}
+
+func isP2WPKH(raw []byte) bool {
+	return len(raw) == 22 && raw[0] == 0x00 && raw[1] == 20
+}
+
+func isP2WSH(raw []byte) bool {
+	return len(raw) == 34 && raw[0] == 0x00 && raw[1] == 32
+}
+
+// Sign adds a partial signature with the private key to the input with the index.
+// Supports P2PKH, P2WPKH, P2WSH, P2SH-P2WPKH and P2SH inputs, signed with the sighash type
+// of the input, SIGHASH_ALL if it has none.
+//
+// This is the Signer role.
+func (p *Packet) Sign(index int, priv *ecdsa.PrivateKey) error {
+	in := &p.Inputs[index]
+	if in.FinalScriptSig != nil || in.FinalScriptWitness != nil {
+		return errors.New("Sign: the input is already finalized")
+	}
+	hashType := tx.SighashAll
+	if in.SighashType != nil {
+		hashType = *in.SighashType
+	}
+	// the sighash type is a single byte at the end of the signature
+	if hashType == 0 || hashType > 0xff {
+		return errors.New("Sign: invalid sighash type")
+	}
+	utxo, err := p.utxo(index)
+	if err != nil {
+		return err
+	}
+	t, err := p.UnsignedTx()
+	if err != nil {
+		return err
+	}
+	sec := priv.PublicKey.MarshalCompressed()
+	h160 := hash.Hash160(sec)
+
+	scriptCode := utxo.ScriptPubKey
+	raw, err := scriptCode.Raw()
+	if err != nil {
+		return err
+	}
+	if isP2SH(raw) {
+		if in.RedeemScript == nil {
+			return errors.New("Sign: missing redeem script")
+		}
+		redeemRaw, err := in.RedeemScript.Raw()
+		if err != nil {
+			return err
+		}
+		if h := hash.Hash160(redeemRaw); !bytes.Equal(h[:], raw[2:22]) {
+			return errors.New("Sign: redeem script doesn't match the script hash")
+		}
+		scriptCode, raw = in.RedeemScript, redeemRaw
+	}
+
+	var sighash [32]byte
+	switch {
+	case isP2WPKH(raw):
+		if !bytes.Equal(h160[:], raw[2:]) {
+			return errors.New("Sign: the key doesn't match the input")
+		}
+		sighash, err = t.SighashBip143(index, scriptCode, utxo.Amount, hashType)
+	case isP2WSH(raw):
+		if in.WitnessScript == nil {
+			return errors.New("Sign: missing witness script")
+		}
+		var witnessRaw []byte
+		if witnessRaw, err = in.WitnessScript.Raw(); err != nil {
+			return err
+		}
+		if h := hash.Sha256(witnessRaw); !bytes.Equal(h[:], raw[2:]) {
+			return errors.New("Sign: witness script doesn't match the script hash")
+		}
+		if !hasPubKey(in.WitnessScript, sec) {
+			return errors.New("Sign: the key doesn't match the input")
+		}
+		sighash, err = t.SighashBip143(index, in.WitnessScript, utxo.Amount, hashType)
+	case isP2PKH(raw):
+		if !bytes.Equal(h160[:], raw[3:23]) {
+			return errors.New("Sign: the key doesn't match the input")
+		}
+		sighash, err = t.SighashLegacy(index, scriptCode, hashType)
+	default:
+		if !hasPubKey(scriptCode, sec) {
+			return errors.New("Sign: the key doesn't match the input")
+		}
+		sighash, err = t.SighashLegacy(index, scriptCode, hashType)
+	}
+	if err != nil {
+		return err
+	}
+
+	sig := append(priv.Sign(sighash[:]).Marshal(), byte(hashType))
+	for i := range in.PartialSigs {
+		if bytes.Equal(in.PartialSigs[i].PubKey, sec) {
+			in.PartialSigs[i].Signature = sig
+			return nil
+		}
+	}
+	in.PartialSigs = append(in.PartialSigs, PartialSig{sec, sig})
+	return nil
+}
+
+// hasPubKey returns whether s is a pubkey or multisig script with the public key sec.
+func hasPubKey(s script.Script, sec []byte) bool {
+	class, sol := script.Classify(s)
+	if class != script.ClassPubKey && class != script.ClassMultisig {
+		return false
+	}
+	for _, pub := range sol.PubKeys {
+		if bytes.Equal(pub, sec) {
+			return true
+		}
+	}
+	return false
+}
+
+// Combine merges the PSBTs for the same transaction into one, taking the union of their fields.
+//
+// This is the Combiner role.
+func Combine(packets ...*Packet) (*Packet, error) {
+	if len(packets) == 0 {
+		return nil, errors.New("Combine: no PSBTs")
+	}
+	b, err := packets[0].Marshal()
+	if err != nil {
+		return nil, err
+	}
+	ret, err := new(Packet).Unmarshal(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	want, err := txId(ret)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range packets[1:] {
+		id, err := txId(p)
+		if err != nil {
+			return nil, err
+		}
+		if p.Version != ret.Version || id != want ||
+			len(p.Inputs) != len(ret.Inputs) || len(p.Outputs) != len(ret.Outputs) {
+			return nil, errors.New("Combine: the PSBTs are for different transactions")
+		}
+
+		for _, x := range p.XPubs {
+			if !hasXPub(ret.XPubs, x) {
+				ret.XPubs = append(ret.XPubs, x)
+			}
+		}
+		ret.Unknowns = combineUnknowns(ret.Unknowns, p.Unknowns)
+		for i := range p.Inputs {
+			ret.Inputs[i].combine(&p.Inputs[i])
+		}
+		for i := range p.Outputs {
+			ret.Outputs[i].combine(&p.Outputs[i])
+		}
+	}
+	return ret, nil
+}
+
+func txId(p *Packet) (string, error) {
+	t, err := p.UnsignedTx()
+	if err != nil {
+		return "", err
+	}
+	return t.Id()
+}
+
+func hasXPub(xpubs []XPub, x XPub) bool {
+	for _, y := range xpubs {
+		if bytes.Equal(y.ExtendedKey.Marshal(), x.ExtendedKey.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+func combineUnknowns(a, b []KeyValue) []KeyValue {
+	for _, kv := range b {
+		found := false
+		for _, x := range a {
+			if bytes.Equal(x.Key, kv.Key) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			a = append(a, kv)
+		}
+	}
+	return a
+}
+
+func combineDerivations(a, b []Bip32Derivation) []Bip32Derivation {
+	for _, d := range b {
+		found := false
+		for _, x := range a {
+			if bytes.Equal(x.PubKey, d.PubKey) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			a = append(a, d)
+		}
+	}
+	return a
+}
+
+func (in *Input) combine(other *Input) {
+	if in.NonWitnessUtxo == nil {
+		in.NonWitnessUtxo = other.NonWitnessUtxo
+	}
+	if in.WitnessUtxo == nil {
+		in.WitnessUtxo = other.WitnessUtxo
+	}
+	for _, sig := range other.PartialSigs {
+		found := false
+		for _, x := range in.PartialSigs {
+			if bytes.Equal(x.PubKey, sig.PubKey) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			in.PartialSigs = append(in.PartialSigs, sig)
+		}
+	}
+	if in.SighashType == nil {
+		in.SighashType = other.SighashType
+	}
+	if in.RedeemScript == nil {
+		in.RedeemScript = other.RedeemScript
+	}
+	if in.WitnessScript == nil {
+		in.WitnessScript = other.WitnessScript
+	}
+	in.Bip32Derivations = combineDerivations(in.Bip32Derivations, other.Bip32Derivations)
+	if in.FinalScriptSig == nil {
+		in.FinalScriptSig = other.FinalScriptSig
+	}
+	if in.FinalScriptWitness == nil {
+		in.FinalScriptWitness = other.FinalScriptWitness
+	}
+	if in.Sequence == nil {
+		in.Sequence = other.Sequence
+	}
+	if in.RequiredTimeLockTime == nil {
+		in.RequiredTimeLockTime = other.RequiredTimeLockTime
+	}
+	if in.RequiredHeightLockTime == nil {
+		in.RequiredHeightLockTime = other.RequiredHeightLockTime
+	}
+	if in.TapKeySig == nil {
+		in.TapKeySig = other.TapKeySig
+	}
+	if in.TapInternalKey == nil {
+		in.TapInternalKey = other.TapInternalKey
+	}
+	in.Unknowns = combineUnknowns(in.Unknowns, other.Unknowns)
+}
+
+func (out *Output) combine(other *Output) {
+	if out.RedeemScript == nil {
+		out.RedeemScript = other.RedeemScript
+	}
+	if out.WitnessScript == nil {
+		out.WitnessScript = other.WitnessScript
+	}
+	out.Bip32Derivations = combineDerivations(out.Bip32Derivations, other.Bip32Derivations)
+	if out.TapInternalKey == nil {
+		out.TapInternalKey = other.TapInternalKey
+	}
+	out.Unknowns = combineUnknowns(out.Unknowns, other.Unknowns)
+}
+
+// Finalize builds the final scriptSig and witness of every input from its partial signatures.
+// Supports P2PKH, P2WPKH, multisig, and their P2SH and P2WSH wrappings.
+// Inputs which are already finalized are skipped.
+//
+// This is the Finalizer role.
+func (p *Packet) Finalize() error {
+	for i := range p.Inputs {
+		if err := p.finalizeInput(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Packet) finalizeInput(index int) error {
+	in := &p.Inputs[index]
+	if in.FinalScriptSig != nil || in.FinalScriptWitness != nil {
+		return nil
+	}
+	utxo, err := p.utxo(index)
+	if err != nil {
+		return err
+	}
+	raw, err := utxo.ScriptPubKey.Raw()
+	if err != nil {
+		return err
+	}
+
+	// the scriptSig pushes of P2SH wrapped inputs end with the redeem script
+	var redeemPush []byte
+	if isP2SH(raw) {
+		if in.RedeemScript == nil {
+			return errors.New("Finalize: missing redeem script")
+		}
+		if redeemPush, err = in.RedeemScript.Raw(); err != nil {
+			return err
+		}
+		raw = redeemPush
+	}
+
+	var scriptSig script.Script
+	var witness [][]byte
+	switch {
+	case isP2PKH(raw), isP2WPKH(raw):
+		if len(in.PartialSigs) != 1 {
+			return errors.New("Finalize: expected one partial signature")
+		}
+		sig := in.PartialSigs[0]
+		if isP2WPKH(raw) {
+			witness = [][]byte{sig.Signature, sig.PubKey}
+		} else {
+			scriptSig = scriptSig.AddBytes(sig.Signature, sig.PubKey)
+		}
+	case isP2WSH(raw):
+		if in.WitnessScript == nil {
+			return errors.New("Finalize: missing witness script")
+		}
+		witnessRaw, err := in.WitnessScript.Raw()
+		if err != nil {
+			return err
+		}
+		sigs, err := multisigSigs(in, witnessRaw)
+		if err != nil {
+			return err
+		}
+		witness = append(append([][]byte{{}}, sigs...), witnessRaw)
+	default:
+		sigs, err := multisigSigs(in, raw)
+		if err != nil {
+			return err
+		}
+		scriptSig = script.Script{script.OP_0}
+		scriptSig = scriptSig.AddBytes(sigs...)
+	}
+	if redeemPush != nil {
+		scriptSig = scriptSig.AddBytes(redeemPush)
+	}
+
+	if scriptSig != nil {
+		in.FinalScriptSig = scriptSig
+	}
+	in.FinalScriptWitness = witness
+	in.PartialSigs = nil
+	in.SighashType = nil
+	in.RedeemScript = nil
+	in.WitnessScript = nil
+	in.Bip32Derivations = nil
+	return nil
+}
+
+// multisigSigs returns the signatures for the multisig script, in the order of its keys.
+func multisigSigs(in *Input, raw []byte) ([][]byte, error) {
+	s, err := script.ParseRaw(raw)
+	if err != nil {
+		return nil, err
+	}
+	m, pubKeys, ok := s.IsMultisig()
+	if !ok {
+		return nil, errors.New("Finalize: unsupported script")
+	}
+	var sigs [][]byte
+	for _, pub := range pubKeys {
+		for _, sig := range in.PartialSigs {
+			if bytes.Equal(sig.PubKey, pub) && len(sigs) < m {
+				sigs = append(sigs, sig.Signature)
+			}
+		}
+	}
+	if len(sigs) < m {
+		return nil, errors.New("Finalize: not enough signatures")
+	}
+	return sigs, nil
+}
+
+// Extract returns the signed transaction, all inputs should be finalized.
+//
+// This is the Transaction Extractor role.
+func (p *Packet) Extract() (*tx.Tx, error) {
+	t, err := p.UnsignedTx()
+	if err != nil {
+		return nil, err
+	}
+	for i, in := range p.Inputs {
+		if in.FinalScriptSig == nil && in.FinalScriptWitness == nil {
+			return nil, errors.New("Extract: the input is not finalized")
+		}
+		t.TxIns[i].ScriptSig = in.FinalScriptSig
+		if in.FinalScriptWitness != nil {
+			t.SegWit = true
+			t.TxIns[i].Witness = in.FinalScriptWitness
+		}
+	}
+	return t, nil
+}
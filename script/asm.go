@@ -0,0 +1,160 @@
+package script
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// sighashNames are the names of the hash types in the ASM format.
+var sighashNames = map[byte]string{
+	0x01: "ALL",
+	0x02: "NONE",
+	0x03: "SINGLE",
+	0x81: "ALL|ANYONECANPAY",
+	0x82: "NONE|ANYONECANPAY",
+	0x83: "SINGLE|ANYONECANPAY",
+}
+
+// opcodesByName maps the names of the opcodes, with and without the OP_ prefix, to them.
+var opcodesByName = func() map[string]opcode {
+	m := map[string]opcode{
+		// the names of the timelock opcodes before BIP65 and BIP112
+		"OP_NOP2": OP_CHECKLOCKTIMEVERIFY,
+		"NOP2":    OP_CHECKLOCKTIMEVERIFY,
+		"OP_NOP3": OP_CHECKSEQUENCEVERIFY,
+		"NOP3":    OP_CHECKSEQUENCEVERIFY,
+	}
+	for op, name := range OpcodeNames {
+		m[name] = op
+		m[strings.TrimPrefix(name, "OP_")] = op
+	}
+	return m
+}()
+
+// Disassemble returns the script in the ASM format of Bitcoin Core:
+// pushes of up to 4 bytes are shown as numbers, larger ones as hex and the opcodes by their names.
+//     `OP_DUP OP_HASH160 89abcdefabbaabbaabbaabbaabbaabbaabbaabba OP_EQUALVERIFY OP_CHECKSIG`
+func Disassemble(s Script) string {
+	return disassemble(s, false)
+}
+
+// DisassembleSighash is like Disassemble, but the pushes which are signatures with a defined hash type,
+// as in scriptSigs, are shown without the hash type byte, followed by its name:
+//     `3045...01[ALL] 02...`
+func DisassembleSighash(s Script) string {
+	return disassemble(s, true)
+}
+
+func disassemble(s Script, decodeSighash bool) string {
+	// the pushes of unspendable scripts are data, not signatures
+	decodeSighash = decodeSighash && !(len(s) > 0 && s[0] == OP_RETURN)
+	words := make([]string, len(s))
+	for i, cmd := range s {
+		if el, ok := asElement(cmd); ok {
+			cmd = el
+		}
+		switch cmd := cmd.(type) {
+		case opcode:
+			switch {
+			case cmd == OP_0:
+				words[i] = "0"
+			case cmd == OP_1NEGATE:
+				words[i] = "-1"
+			case OP_1 <= cmd && cmd <= OP_16:
+				words[i] = strconv.Itoa(int(cmd - OP_1 + 1))
+			case OpcodeNames[cmd] != "":
+				words[i] = OpcodeNames[cmd]
+			default:
+				words[i] = "OP_UNKNOWN"
+			}
+		case element:
+			if n, err := ParseScriptNum(cmd, false, maxNumSize); err == nil {
+				words[i] = strconv.FormatInt(int64(n), 10)
+				continue
+			}
+			if name, ok := sighashNames[cmd[len(cmd)-1]]; decodeSighash && ok && isValidSignatureEncoding(cmd) {
+				words[i] = hex.EncodeToString(cmd[:len(cmd)-1]) + "[" + name + "]"
+				continue
+			}
+			words[i] = cmd.String()
+		case unparsed:
+			words[i] = cmd.String()
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// Assemble parses the script from the ASM format of the Bitcoin Core tests, see script_tests.json.
+// The words are separated by whitespace and each is one of:
+//
+// Decimal numbers from -0xffffffff to 0xffffffff, optionally prefixed by "-",
+// which are pushed by the shortest command, for example 1 is OP_1.
+//
+// Raw bytes in hex prefixed by 0x, which are inserted into the script as they are,
+// for example "0x4c 0x01 0x07" is the push of 7 using OP_PUSHDATA1, which the script keeps.
+//
+// Strings in single quotes, which are pushed, for example 'Satoshi'.
+//
+// The names of the opcodes, with or without the OP_ prefix, for example OP_DUP or DUP.
+//
+// Unlike in Disassemble, pushes are not written in bare hex, it would be ambiguous with numbers.
+//
+// Returns error on an unknown word or if the pushes run past the end of the script.
+func Assemble(asm string) (Script, error) {
+	var raw []byte
+	for _, w := range strings.Fields(asm) {
+		if isDecimal(w) {
+			n, err := strconv.ParseInt(w, 10, 64)
+			if err != nil || n < -0xffffffff || n > 0xffffffff {
+				return nil, fmt.Errorf("Assemble: number %s out of range", w)
+			}
+			switch {
+			case n == 0:
+				raw = append(raw, byte(OP_0))
+			case n == -1:
+				raw = append(raw, byte(OP_1NEGATE))
+			case 1 <= n && n <= 16:
+				raw = append(raw, byte(OP_1)+byte(n-1))
+			default:
+				data := ScriptNum(n).Bytes()
+				raw = appendPush(raw, pushOpcode(len(data)), data)
+			}
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(w, "0x"):
+			b, err := hex.DecodeString(w[2:])
+			if err != nil || len(b) == 0 {
+				return nil, fmt.Errorf("Assemble: invalid raw bytes %s", w)
+			}
+			raw = append(raw, b...)
+		case len(w) >= 2 && w[0] == '\'' && w[len(w)-1] == '\'':
+			data := []byte(w[1 : len(w)-1])
+			raw = appendPush(raw, pushOpcode(len(data)), data)
+		default:
+			op, ok := opcodesByName[w]
+			if !ok {
+				return nil, fmt.Errorf("Assemble: unknown word %s", w)
+			}
+			raw = append(raw, byte(op))
+		}
+	}
+	return ParseRaw(raw)
+}
+
+// isDecimal returns whether the word is made of decimal digits, optionally prefixed by "-".
+func isDecimal(w string) bool {
+	w = strings.TrimPrefix(w, "-")
+	if w == "" {
+		return false
+	}
+	for _, c := range w {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,120 @@
+package script
+
+import (
+	"encoding/hex"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/VIVelev/btcd/crypto/ecdsa"
+	"github.com/VIVelev/btcd/crypto/elliptic"
+	"github.com/VIVelev/btcd/crypto/hash"
+)
+
+func TestAssemble(t *testing.T) {
+	for _, tc := range []struct {
+		asm  string
+		want string // the raw script in hex
+	}{
+		{"DUP HASH160 0x14 0x89abcdefabbaabbaabbaabbaabbaabbaabbaabba EQUALVERIFY CHECKSIG",
+			"76a91489abcdefabbaabbaabbaabbaabbaabbaabbaabba88ac"},
+		{"OP_DUP OP_HASH160 0x14 0x89abcdefabbaabbaabbaabbaabbaabbaabbaabba OP_EQUALVERIFY OP_CHECKSIG",
+			"76a91489abcdefabbaabbaabbaabbaabbaabbaabbaabba88ac"},
+		// numbers
+		{"0 -1 1 16 17 -2 1000 4294967295 -4294967295", "004f51600111018202e80305ffffffff0005ffffffff80"},
+		{"007 0000000001", "5751"},
+		// raw bytes, including non-minimal pushes
+		{"0x4c 0x01 0x07", "4c0107"},
+		{"0x4c00", "4c00"},
+		{"0x4e 0x01000000 0x07", "4e0100000007"},
+		{"0x4d 0x0902 0x" + strings.Repeat("00", 521), "4d0902" + strings.Repeat("00", 521)},
+		// strings
+		{"'Az' ''", "02417a00"},
+		// reserved, disabled and renamed opcodes
+		{"RESERVED VERIF CAT OP_MUL NOP2 NOP3 CHECKLOCKTIMEVERIFY", "50657e95b1b2b1"},
+		{"  1\t2\nADD  ", "515293"},
+		{"", ""},
+	} {
+		s, err := Assemble(tc.asm)
+		if err != nil {
+			t.Errorf("FAIL: %q: %v", tc.asm, err)
+			continue
+		}
+		if raw, _ := s.Raw(); hex.EncodeToString(raw) != tc.want {
+			t.Errorf("FAIL: %q: %x", tc.asm, raw)
+		}
+	}
+
+	for _, asm := range []string{
+		"OP_FOO",
+		"0x",
+		"0xabc",
+		// the push runs past the end of the script
+		"0x4c",
+		"0x02 0x01",
+		"4294967296",
+		"-4294967296",
+		"99999999999999999999",
+		// signed forms other than "-", and pushes in bare hex
+		"+5",
+		"-",
+		"--1",
+		"1e3",
+		"89abcdef",
+		"'unterminated",
+	} {
+		if _, err := Assemble(asm); err == nil {
+			t.Errorf("FAIL: %q", asm)
+		}
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	h160 := [20]byte{0x89, 0xab, 0xcd, 0xef}
+	for _, tc := range []struct {
+		s    Script
+		want string
+	}{
+		{NewP2PKHScript(h160), "OP_DUP OP_HASH160 89abcdef00000000000000000000000000000000 OP_EQUALVERIFY OP_CHECKSIG"},
+		{NewP2WPKHScript(h160), "0 89abcdef00000000000000000000000000000000"},
+		{Script{OP_0, OP_1NEGATE, element{17}, OP_CHECKSIGADD, OP_RESERVED, OP_CAT}, "0 -1 17 OP_CHECKSIGADD OP_RESERVED OP_CAT"},
+		{Script{OP_0, OP_IF, OP_VERNOTIF, OP_2DIV, opcode(0xba), opcode(0xbb)}, "0 OP_IF OP_VERNOTIF OP_2DIV OP_CHECKSIGADD OP_UNKNOWN"},
+		// pushes are shown by their data, whichever the push opcode
+		{Script{pushData{OP_PUSHDATA1, element{7}}, element{0, 0, 0, 0, 1}}, "7 0000000001"},
+	} {
+		if got := Disassemble(tc.s); got != tc.want {
+			t.Errorf("FAIL: %s", got)
+		}
+	}
+
+	// numbers and opcodes assemble back
+	s := Script{OP_0, OP_1NEGATE, element{17}, element{0x82}, OP_CHECKSIGADD, OP_RESERVED, OP_CAT}
+	if again, err := Assemble(Disassemble(s)); err != nil || !reflect.DeepEqual(again, s) {
+		t.Errorf("FAIL: %s", Disassemble(s))
+	}
+
+	sighash := hash.Sha256([]byte("asm"))
+	priv := ecdsa.GenerateKeyFromSecret(elliptic.Secp256k1, big.NewInt(1))
+	der := priv.Sign(sighash[:]).Marshal()
+	pubKey := priv.PublicKey.MarshalCompressed()
+	sig := append(append([]byte{}, der...), 0x81)
+	scriptSig := Script{element(sig), element(pubKey)}
+
+	want := hex.EncodeToString(der) + "[ALL|ANYONECANPAY] " + hex.EncodeToString(pubKey)
+	if got := DisassembleSighash(scriptSig); got != want {
+		t.Errorf("FAIL: %s", got)
+	}
+	if got := Disassemble(scriptSig); got != hex.EncodeToString(sig)+" "+hex.EncodeToString(pubKey) {
+		t.Errorf("FAIL: %s", got)
+	}
+	// undefined hash types and unspendable scripts are not decoded
+	undefined := Script{element(append(append([]byte{}, der...), 0x04))}
+	if got := DisassembleSighash(undefined); got != Disassemble(undefined) {
+		t.Errorf("FAIL: %s", got)
+	}
+	nullData := Script{OP_RETURN, element(sig)}
+	if got := DisassembleSighash(nullData); got != "OP_RETURN "+hex.EncodeToString(sig) {
+		t.Errorf("FAIL: %s", got)
+	}
+}
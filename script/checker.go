@@ -0,0 +1,75 @@
+package script
+
+import (
+	"github.com/VIVelev/btcd/crypto/ecdsa"
+	"github.com/VIVelev/btcd/crypto/elliptic"
+	"github.com/VIVelev/btcd/crypto/schnorr"
+)
+
+// SignatureChecker checks the signatures and the timelocks of the scripts against the spending transaction.
+type SignatureChecker interface {
+	// CheckSig returns whether sig, which ends with the hash type byte, is a valid ECDSA signature
+	// of the transaction by the SEC encoded public key. scriptCode is the part of the script
+	// the signature commits to, see BIP143 for the differences between the sigVersions.
+	CheckSig(sig, pubKey []byte, scriptCode Script, sigVersion SigVersion) bool
+	// CheckSchnorrSig returns whether sig, optionally followed by the hash type byte, is a valid
+	// BIP340 signature of the transaction by the x-only public key. sigVersion is either
+	// SigVersionTaproot or SigVersionTapscript.
+	CheckSchnorrSig(sig, pubKey []byte, sigVersion SigVersion, execData *TaprootExecData) bool
+	// CheckLockTime returns whether the transaction is locked until at least lockTime, see BIP65.
+	CheckLockTime(lockTime ScriptNum) bool
+	// CheckSequence returns whether the input is locked for at least the relative lock time
+	// of sequence, see BIP112.
+	CheckSequence(sequence ScriptNum) bool
+}
+
+// TaprootExecData is what the Taproot signatures commit to, besides the transaction, see BIP341.
+type TaprootExecData struct {
+	// Annex is the annex of the witness, nil if there is none.
+	Annex []byte
+	// LeafHash is the TapLeafHash of the executed leaf, nil for key path spends.
+	LeafHash []byte
+	// CodeSepPos is the position of the last executed OP_CODESEPARATOR in the leaf script,
+	// 0xffffffff if there is none.
+	CodeSepPos uint32
+}
+
+// CheckECDSASig returns whether sig, which ends with the hash type byte,
+// is a valid signature of the sighash by the SEC encoded public key.
+func CheckECDSASig(sig, secPubKey, sighash []byte) bool {
+	if len(sig) == 0 {
+		return false
+	}
+	pubKey := &ecdsa.PublicKey{Curve: elliptic.Secp256k1}
+	if _, err := pubKey.Unmarshal(secPubKey); err != nil {
+		return false
+	}
+	derSig, err := new(ecdsa.Signature).Unmarshal(sig[:len(sig)-1])
+	if err != nil {
+		return false
+	}
+	return derSig.Verify(pubKey, sighash)
+}
+
+// MockChecker is a SignatureChecker for tests. All of the signatures are checked against the same
+// Sighash, regardless of their hash types and what they commit to, and the timelocks against TxContext.
+type MockChecker struct {
+	TxContext
+	Sighash []byte
+}
+
+func (c *MockChecker) CheckSig(sig, pubKey []byte, _ Script, _ SigVersion) bool {
+	return CheckECDSASig(sig, pubKey, c.Sighash)
+}
+
+func (c *MockChecker) CheckSchnorrSig(sig, pubKey []byte, _ SigVersion, _ *TaprootExecData) bool {
+	return len(sig) >= 64 && schnorr.Verify(pubKey, c.Sighash, sig[:64])
+}
+
+// nullChecker is the SignatureChecker of scripts with no transaction, all of its checks fail.
+type nullChecker struct{}
+
+func (nullChecker) CheckSig([]byte, []byte, Script, SigVersion) bool                  { return false }
+func (nullChecker) CheckSchnorrSig([]byte, []byte, SigVersion, *TaprootExecData) bool { return false }
+func (nullChecker) CheckLockTime(ScriptNum) bool                                      { return false }
+func (nullChecker) CheckSequence(ScriptNum) bool                                      { return false }
@@ -0,0 +1,45 @@
+package script
+
+import "testing"
+
+// recordingChecker accepts all of the signatures and records the script codes they commit to.
+type recordingChecker struct {
+	nullChecker
+	scriptCodes []Script
+}
+
+func (c *recordingChecker) CheckSig(_, _ []byte, scriptCode Script, _ SigVersion) bool {
+	c.scriptCodes = append(c.scriptCodes, scriptCode)
+	return true
+}
+
+func TestCheckerScriptCode(t *testing.T) {
+	sig, pubKey := element{1, 2, 3}, element{4, 5, 6}
+	s := Script{sig, pubKey, OP_CHECKSIGVERIFY, OP_CODESEPARATOR, sig, pubKey, OP_CHECKSIG}
+	for _, tc := range []struct {
+		sigVersion SigVersion
+		want       []Script
+	}{
+		// legacy signatures are removed from the script code
+		{SigVersionBase, []Script{
+			{pubKey, OP_CHECKSIGVERIFY, OP_CODESEPARATOR, pubKey, OP_CHECKSIG},
+			{pubKey, OP_CHECKSIG},
+		}},
+		{SigVersionWitnessV0, []Script{
+			s,
+			{sig, pubKey, OP_CHECKSIG},
+		}},
+	} {
+		c := &recordingChecker{}
+		e := newEngine(c, 0)
+		e.sigVersion = tc.sigVersion
+		if e.execute(s) != nil || !e.success() || len(c.scriptCodes) != len(tc.want) {
+			t.Fatalf("FAIL")
+		}
+		for i, want := range tc.want {
+			if c.scriptCodes[i].String() != want.String() {
+				t.Errorf("FAIL")
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package script
+
+import (
+	"encoding/hex"
+	"encoding/json"
+)
+
+// Phase is the script of the spend an Engine is executing.
+type Phase int
+
+const (
+	PhaseScriptSig    Phase = iota // the scriptSig of the input
+	PhaseScriptPubKey              // the scriptPubKey of the spent output
+	PhaseRedeemScript              // the redeem script of P2SH, see BIP16
+	PhaseWitness                   // the witness script, the script of P2WPKH or the tapscript
+)
+
+var phaseNames = map[Phase]string{
+	PhaseScriptSig:    "scriptSig",
+	PhaseScriptPubKey: "scriptPubKey",
+	PhaseRedeemScript: "redeemScript",
+	PhaseWitness:      "witness",
+}
+
+func (p Phase) String() string {
+	return phaseNames[p]
+}
+
+// Phase returns which of the scripts of the spend is being executed.
+func (e *Engine) Phase() Phase {
+	return e.phase
+}
+
+// Script returns the script being executed.
+func (e *Engine) Script() Script {
+	return e.script
+}
+
+// PC returns the position in the script of the next command to be executed.
+func (e *Engine) PC() int {
+	return e.pc
+}
+
+// Command returns the next command to be executed in the ASM format, see Disassemble.
+// Returns "" once the verification is over.
+func (e *Engine) Command() string {
+	if e.done {
+		return ""
+	}
+	return Disassemble(e.cmds[:1])
+}
+
+// Stack returns a copy of the stack, its top is the last element.
+func (e *Engine) Stack() [][]byte {
+	return e.stack.bytes()
+}
+
+// AltStack returns a copy of the alt stack, its top is the last element.
+func (e *Engine) AltStack() [][]byte {
+	return e.altstack.bytes()
+}
+
+// CondStack returns for each of the enclosing conditionals, the innermost last,
+// whether its current branch is taken.
+func (e *Engine) CondStack() []bool {
+	return append([]bool{}, e.condStack...)
+}
+
+// TraceStep is the state of an Engine right after it has executed a command.
+type TraceStep struct {
+	Phase     Phase
+	PC        int    // the position of the command in the script
+	Command   string // the command in the ASM format
+	Stack     [][]byte
+	AltStack  [][]byte
+	CondStack []bool
+}
+
+type jsonTraceStep struct {
+	Phase     string   `json:"phase"`
+	PC        int      `json:"pc"`
+	Command   string   `json:"command"`
+	Stack     []string `json:"stack"`
+	AltStack  []string `json:"altstack"`
+	CondStack []bool   `json:"condstack"`
+}
+
+func (t TraceStep) MarshalJSON() ([]byte, error) {
+	toHex := func(items [][]byte) []string {
+		s := make([]string, len(items))
+		for i, item := range items {
+			s[i] = hex.EncodeToString(item)
+		}
+		return s
+	}
+	return json.Marshal(jsonTraceStep{
+		Phase:     t.Phase.String(),
+		PC:        t.PC,
+		Command:   t.Command,
+		Stack:     toHex(t.Stack),
+		AltStack:  toHex(t.AltStack),
+		CondStack: append([]bool{}, t.CondStack...),
+	})
+}
+
+// EnableTrace makes the engine record each of the commands it executes from now on, see Trace.
+func (e *Engine) EnableTrace() {
+	e.tracing = true
+}
+
+// Trace returns the steps recorded since EnableTrace, including the command which failed, if any.
+func (e *Engine) Trace() []TraceStep {
+	return e.trace
+}
+
+// record appends to the trace the command at pc, which has just been executed.
+func (e *Engine) record(pc int, cmd command) {
+	e.trace = append(e.trace, TraceStep{
+		Phase:     e.phase,
+		PC:        pc,
+		Command:   Disassemble(Script{cmd}),
+		Stack:     e.Stack(),
+		AltStack:  e.AltStack(),
+		CondStack: e.CondStack(),
+	})
+}
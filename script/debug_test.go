@@ -0,0 +1,103 @@
+package script
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/VIVelev/btcd/crypto/hash"
+)
+
+func TestStep(t *testing.T) {
+	redeemScript := Script{OP_1, OP_ADD, OP_3, OP_EQUAL}
+	raw, _ := redeemScript.Raw()
+	p2sh := NewP2SHScript(hash.Hash160(raw))
+	p2wsh := NewP2WSHScript(hash.Sha256(raw))
+
+	for _, tc := range []struct {
+		scriptSig, scriptPubKey Script
+		witness                 [][]byte
+		phases                  []Phase // of the executed commands
+	}{
+		{Script{OP_2, element(raw)}, p2sh, nil, []Phase{
+			PhaseScriptSig, PhaseScriptSig,
+			PhaseScriptPubKey, PhaseScriptPubKey, PhaseScriptPubKey,
+			PhaseRedeemScript, PhaseRedeemScript, PhaseRedeemScript, PhaseRedeemScript,
+		}},
+		{Script{}, p2wsh, [][]byte{{2}, raw}, []Phase{
+			PhaseScriptPubKey, PhaseScriptPubKey,
+			PhaseWitness, PhaseWitness, PhaseWitness, PhaseWitness,
+		}},
+	} {
+		e := NewEngine(tc.scriptSig, tc.scriptPubKey, tc.witness, nil, VerifyP2SH|VerifyWitness)
+		var phases []Phase
+		for {
+			phase, pc := e.Phase(), e.PC()
+			if e.Command() != Disassemble(e.Script()[pc:pc+1]) {
+				t.Errorf("FAIL: %s", e.Command())
+			}
+			phases = append(phases, phase)
+			done, err := e.Step()
+			if err != nil {
+				t.Errorf("FAIL: %v", err)
+			}
+			if done {
+				break
+			}
+		}
+		if !reflect.DeepEqual(phases, tc.phases) || e.Command() != "" {
+			t.Errorf("FAIL: %v", phases)
+		}
+		if !reflect.DeepEqual(e.Stack(), [][]byte{{1}}) {
+			t.Errorf("FAIL")
+		}
+		// the engine stays done
+		if done, err := e.Step(); !done || err != nil {
+			t.Errorf("FAIL")
+		}
+	}
+
+	// the scriptSig of P2SH has to be push only, which is checked before executing anything
+	e := NewEngine(Script{OP_2, OP_NOP, element(raw)}, p2sh, nil, nil, VerifySigPushOnly)
+	if done, err := e.Step(); !done || err != ErrSigPushOnly || e.Command() != "" {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestTrace(t *testing.T) {
+	e := NewEngine(Script{}, Script{OP_1, OP_IF, OP_2, OP_TOALTSTACK, OP_ENDIF, OP_0}, nil, nil, 0)
+	e.EnableTrace()
+	if e.Execute() != ErrEvalFalse {
+		t.Errorf("FAIL")
+	}
+	trace := e.Trace()
+	if len(trace) != 6 {
+		t.Fatalf("FAIL: %d", len(trace))
+	}
+	want := TraceStep{
+		Phase:     PhaseScriptPubKey,
+		PC:        3,
+		Command:   "OP_TOALTSTACK",
+		Stack:     [][]byte{},
+		AltStack:  [][]byte{{2}},
+		CondStack: []bool{true},
+	}
+	if !reflect.DeepEqual(trace[3], want) {
+		t.Errorf("FAIL: %+v", trace[3])
+	}
+	if last := trace[5]; last.Command != "0" || !reflect.DeepEqual(last.Stack, [][]byte{{}}) || len(last.CondStack) != 0 {
+		t.Errorf("FAIL: %+v", last)
+	}
+
+	b, err := json.Marshal(trace[3])
+	if err != nil || !bytes.Equal(b, []byte(`{"phase":"scriptPubKey","pc":3,"command":"OP_TOALTSTACK","stack":[],"altstack":["02"],"condstack":[true]}`)) {
+		t.Errorf("FAIL: %s", b)
+	}
+
+	// nothing is recorded unless enabled
+	e = NewEngine(Script{}, Script{OP_1}, nil, nil, 0)
+	if e.Execute() != nil || len(e.Trace()) != 0 {
+		t.Errorf("FAIL")
+	}
+}
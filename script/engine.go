@@ -0,0 +1,521 @@
+package script
+
+import (
+	"bytes"
+	"math/big"
+
+	"github.com/VIVelev/btcd/crypto/hash"
+	"github.com/VIVelev/btcd/encoding"
+)
+
+// Flags select the optional script verification rules.
+type Flags uint32
+
+const (
+	// VerifyMinimalIf requires the argument of OP_IF and OP_NOTIF in witness scripts
+	// to be either empty or exactly 0x01.
+	VerifyMinimalIf Flags = 1 << iota
+	// VerifyMinimalData requires the numeric operands to be minimally encoded.
+	VerifyMinimalData
+	// VerifyDiscourageUpgradableNops fails the scripts executing OP_NOP1 and OP_NOP4 through OP_NOP10,
+	// so that they can be given a meaning in a future soft fork.
+	VerifyDiscourageUpgradableNops
+	// VerifyNullDummy requires the extra element consumed by OP_CHECKMULTISIG to be empty, see BIP147.
+	VerifyNullDummy
+	// VerifyP2SH evaluates the redeem scripts of Pay-to-ScriptHash outputs, see BIP16.
+	VerifyP2SH
+	// VerifyWitness verifies the witness programs, see BIP141.
+	VerifyWitness
+	// VerifyTaproot verifies the witness v1 programs, which are Taproot outputs, see BIP341 and BIP342.
+	VerifyTaproot
+	// VerifyCheckLockTimeVerify enables OP_CHECKLOCKTIMEVERIFY, see BIP65. Otherwise it is OP_NOP2.
+	VerifyCheckLockTimeVerify
+	// VerifyCheckSequenceVerify enables OP_CHECKSEQUENCEVERIFY, see BIP112. Otherwise it is OP_NOP3.
+	VerifyCheckSequenceVerify
+	// VerifyStrictEncoding requires the signatures to be strictly DER encoded with a defined hash type,
+	// and the public keys to be either compressed or uncompressed SEC.
+	VerifyStrictEncoding
+	// VerifyDERSignatures requires the signatures to be strictly DER encoded, see BIP66.
+	VerifyDERSignatures
+	// VerifyLowS requires the S value of the signatures to be at most half the curve order, see BIP146.
+	VerifyLowS
+	// VerifySigPushOnly requires all of the scriptSigs to be push only, not just the P2SH ones.
+	VerifySigPushOnly
+	// VerifyCleanStack requires exactly a single element to be left on the stack, see BIP62.
+	// It is only meaningful together with VerifyP2SH and VerifyWitness.
+	VerifyCleanStack
+	// VerifyNullFail requires the signatures of failed signature checks to be empty, see BIP146.
+	VerifyNullFail
+	// VerifyWitnessPubKeyType requires the public keys in segwit v0 scripts to be compressed.
+	VerifyWitnessPubKeyType
+)
+
+// SigVersion is the kind of script being executed, which decides the applicable rules.
+type SigVersion int
+
+const (
+	SigVersionBase      SigVersion = iota // legacy scripts
+	SigVersionWitnessV0                   // segwit v0 scripts, see BIP141 and BIP143
+	SigVersionTaproot                     // Taproot key path spends, see BIP341
+	SigVersionTapscript                   // Taproot script path spends, see BIP342
+)
+
+// Engine verifies that the scriptSig and the witness of an input satisfy the scriptPubKey
+// of the output it spends.
+type Engine struct {
+	scriptSig, scriptPubKey Script
+	witness                 [][]byte
+
+	stack, altstack stack
+	// condStack holds for each of the nested conditionals whether its current branch is taken.
+	condStack []bool
+
+	phase Phase
+	// script is the script being executed, and cmds the part of it which is yet to be executed.
+	script Script
+	cmds   Script
+	// scriptCode is the part of the script signatures commit to,
+	// it starts after the last executed OP_CODESEPARATOR.
+	scriptCode Script
+	// pc is the position of the next command in the script
+	pc int
+	// opCount is the number of non-push opcodes in the script so far,
+	// including the public keys of the executed OP_CHECKMULTISIGs.
+	opCount    int
+	checker    SignatureChecker
+	flags      Flags
+	sigVersion SigVersion
+	// sigOpsBudget is what is left of the validation weight tapscripts can spend on signature checks.
+	sigOpsBudget int
+	execData     TaprootExecData
+
+	// next verifies the result of the script which has finished and loads the next one, if any.
+	// It is nil once all of the scripts of the spend have been loaded.
+	next func() error
+	// stackCopy is the stack left by the scriptSig, for the redeem script of P2SH.
+	stackCopy stack
+	done      bool
+	err       error // the reason the spend is invalid, once done
+
+	tracing bool
+	trace   []TraceStep
+}
+
+// NewEngine returns the engine verifying the spend of the scriptPubKey by the scriptSig and the witness,
+// with the optional verification rules selected by flags.
+// The signatures and the timelocks are checked against the spending transaction by the checker.
+// If it is nil, all of the checks fail.
+func NewEngine(scriptSig, scriptPubKey Script, witness [][]byte, checker SignatureChecker, flags Flags) *Engine {
+	e := newEngine(checker, flags)
+	e.scriptSig = scriptSig
+	e.scriptPubKey = scriptPubKey
+	e.witness = witness
+	e.next = e.start
+	e.advance()
+	return e
+}
+
+// newEngine returns an engine with no scripts loaded.
+func newEngine(checker SignatureChecker, flags Flags) *Engine {
+	if checker == nil {
+		checker = nullChecker{}
+	}
+	return &Engine{
+		checker: checker,
+		flags:   flags,
+	}
+}
+
+// Execute verifies the spend. Returns nil if it is valid, otherwise the ErrorCode
+// of the reason it is not.
+//
+// The scriptSig is executed first, and the scriptPubKey on the resulting stack.
+// Under VerifyP2SH, if the scriptPubKey is P2SH, the scriptSig has to be push only
+// and the redeem script (its last push) is executed on the rest of the pushes as well.
+// Under VerifyWitness, witness programs, either native or nested in P2SH, are verified
+// with the witness, see BIP141, the Taproot outputs only under VerifyTaproot.
+func (e *Engine) Execute() error {
+	for {
+		if done, err := e.Step(); done {
+			return err
+		}
+	}
+}
+
+// Step executes the next command of the spend, see Command. Once a script has finished,
+// its result is verified and the next script is loaded, see Phase.
+// Returns whether the verification is over, and if so, the same as Execute.
+func (e *Engine) Step() (done bool, err error) {
+	if e.done {
+		return true, e.err
+	}
+	pc, cmd := e.pc, e.cmds[0]
+	err = e.step()
+	if e.tracing {
+		e.record(pc, cmd)
+	}
+	if err != nil {
+		return e.stop(err)
+	}
+	return e.advance()
+}
+
+// advance loads the next script once the current one has finished, and so on,
+// as the scripts may be empty, until there is a command to execute or the verification is over.
+func (e *Engine) advance() (done bool, err error) {
+	for len(e.cmds) == 0 {
+		if e.next == nil {
+			return e.stop(nil)
+		}
+		// every OP_IF and OP_NOTIF has to be closed by an OP_ENDIF
+		if len(e.condStack) != 0 {
+			return e.stop(ErrUnbalancedConditional)
+		}
+		next := e.next
+		e.next = nil
+		if err := next(); err != nil {
+			return e.stop(err)
+		}
+	}
+	return false, nil
+}
+
+// stop ends the verification with the result.
+func (e *Engine) stop(err error) (done bool, _ error) {
+	e.done = true
+	e.err = err
+	return true, err
+}
+
+// start loads the scriptSig.
+func (e *Engine) start() error {
+	if e.flags&VerifySigPushOnly != 0 && !e.scriptSig.IsPushOnly() {
+		return ErrSigPushOnly
+	}
+	e.next = e.afterScriptSig
+	return e.load(PhaseScriptSig, e.scriptSig)
+}
+
+// afterScriptSig loads the scriptPubKey, which is executed on the stack left by the scriptSig.
+func (e *Engine) afterScriptSig() error {
+	e.stackCopy = append(stack{}, e.stack...)
+	e.next = e.afterScriptPubKey
+	return e.load(PhaseScriptPubKey, e.scriptPubKey)
+}
+
+// afterScriptPubKey verifies the witness program, or loads the redeem script of P2SH.
+func (e *Engine) afterScriptPubKey() error {
+	if !e.success() {
+		return ErrEvalFalse
+	}
+	if e.flags&VerifyWitness != 0 {
+		if version, program, ok := e.scriptPubKey.witnessProgram(); ok {
+			// native witness programs have to be spent with an empty scriptSig
+			if len(e.scriptSig) != 0 {
+				return ErrWitnessMalleated
+			}
+			return e.verifyWitnessProgram(version, program, false)
+		}
+	}
+
+	if e.flags&VerifyP2SH != 0 && e.scriptPubKey.IsP2SH() {
+		if !e.scriptSig.IsPushOnly() {
+			return ErrSigPushOnly
+		}
+		// the scriptPubKey would have failed if the scriptSig had not pushed anything
+		e.stack = e.stackCopy
+		top, _ := e.stack.Pop()
+		redeemScript, err := ParseRaw(top)
+		if err != nil {
+			return ErrBadOpcode
+		}
+		e.next = e.afterRedeemScript
+		return e.load(PhaseRedeemScript, redeemScript)
+	}
+	return e.checkFinalStack()
+}
+
+// afterRedeemScript verifies the witness program nested in P2SH, if the redeem script is one.
+func (e *Engine) afterRedeemScript() error {
+	if !e.success() {
+		return ErrEvalFalse
+	}
+	if e.flags&VerifyWitness != 0 {
+		if version, program, ok := e.script.witnessProgram(); ok {
+			// the scriptSig has to be exactly the push of the redeem script, for non-malleability
+			if len(e.scriptSig) != 1 {
+				return ErrWitnessMalleatedP2SH
+			}
+			return e.verifyWitnessProgram(version, program, true)
+		}
+	}
+	return e.checkFinalStack()
+}
+
+// checkFinalStack checks the stack left by the spends of scripts which are not witness programs,
+// that of witness programs is checked by afterWitnessScript.
+func (e *Engine) checkFinalStack() error {
+	if e.flags&VerifyCleanStack != 0 && len(e.stack) != 1 {
+		return ErrCleanStack
+	}
+	// a witness is only allowed for witness programs
+	if e.flags&VerifyWitness != 0 && len(e.witness) != 0 {
+		return ErrWitnessUnexpected
+	}
+	return nil
+}
+
+// executing returns whether all of the enclosing conditional branches are taken.
+func (e *Engine) executing() bool {
+	for _, taken := range e.condStack {
+		if !taken {
+			return false
+		}
+	}
+	return true
+}
+
+// step executes the next command. Returns error if the script fails.
+//
+// Commands in branches which are not taken are skipped,
+// except for the flow control opcodes which keep track of the nesting.
+// The limits apply to the skipped commands as well.
+func (e *Engine) step() error {
+	cmd := e.cmds[0]
+	e.cmds = e.cmds[1:]
+	e.pc++
+
+	switch cmd := cmd.(type) {
+	case opcode:
+		// tapscripts are limited by the validation weight instead
+		if cmd > OP_16 && e.sigVersion != SigVersionTapscript {
+			e.opCount++
+			if e.opCount > MaxOpsPerScript {
+				return ErrOpCount
+			}
+		}
+		if isDisabled(cmd) {
+			return ErrDisabledOpcode
+		}
+		if e.executing() || (OP_IF <= cmd && cmd <= OP_ENDIF) {
+			op, ok := OpcodeFunctions[cmd]
+			if !ok {
+				return ErrBadOpcode
+			}
+			if err := op(e); err != nil {
+				return err
+			}
+		}
+	case element, pushData:
+		el, _ := asElement(cmd)
+		if len(el) > MaxScriptElementSize {
+			return ErrPushSize
+		}
+		if e.executing() {
+			if e.flags&VerifyMinimalData != 0 && !isMinimalPush(cmd) {
+				return ErrMinimalData
+			}
+			e.stack.Push(el)
+		}
+	case unparsed:
+		return ErrBadOpcode
+	}
+
+	if len(e.stack)+len(e.altstack) > MaxStackSize {
+		return ErrStackSize
+	}
+	return nil
+}
+
+// load starts the execution of the script on top of the current stack.
+// The alt stack is not shared between scripts.
+func (e *Engine) load(phase Phase, s Script) error {
+	if e.sigVersion != SigVersionTapscript && s.size() > MaxScriptSize {
+		return ErrScriptSize
+	}
+	e.phase = phase
+	e.script = s
+	e.cmds = s.copy()
+	e.scriptCode = e.cmds
+	e.pc = 0
+	e.opCount = 0
+	e.altstack = nil
+	e.condStack = nil
+	return nil
+}
+
+// success returns whether the top of the stack is true.
+func (e *Engine) success() bool {
+	top, err := e.stack.Peek()
+	return err == nil && castToBool(top)
+}
+
+// VerifyScript returns nil if the scriptSig and the witness satisfy the scriptPubKey,
+// otherwise the ErrorCode of the reason they do not, see Engine.
+func VerifyScript(scriptSig, scriptPubKey Script, witness [][]byte, checker SignatureChecker, flags Flags) error {
+	return NewEngine(scriptSig, scriptPubKey, witness, checker, flags).Execute()
+}
+
+// verifyWitnessProgram verifies the witness program with the witness, loading the witness script if any.
+// Programs of unknown versions succeed, they are reserved for soft-fork upgrades.
+// Taproot outputs can't be nested in P2SH, those are left unencumbered as well.
+func (e *Engine) verifyWitnessProgram(version int, program []byte, isP2SH bool) error {
+	switch {
+	case version == 0:
+		return e.verifyWitnessV0(program)
+	case version == 1 && len(program) == 32 && !isP2SH && e.flags&VerifyTaproot != 0:
+		return e.verifyTaproot(program)
+	}
+	return nil
+}
+
+// verifyWitnessV0 verifies the P2WPKH and P2WSH programs, see BIP141.
+func (e *Engine) verifyWitnessV0(program []byte) error {
+	witness := e.witness
+	var s Script
+	switch len(program) {
+	case 20:
+		// P2WPKH: the witness is <signature> <public key>
+		if len(witness) != 2 {
+			return ErrWitnessProgramMismatch
+		}
+		var h160 [20]byte
+		copy(h160[:], program)
+		s = NewP2PKHScript(h160)
+	case 32:
+		// P2WSH: the witness is the stack for the witness script, followed by the witness script
+		if len(witness) == 0 {
+			return ErrWitnessProgramWitnessEmpty
+		}
+		witnessScript := witness[len(witness)-1]
+		witness = witness[:len(witness)-1]
+		if len(witnessScript) > MaxScriptSize {
+			return ErrScriptSize
+		}
+		if h := hash.Sha256(witnessScript); !bytes.Equal(h[:], program) {
+			return ErrWitnessProgramMismatch
+		}
+		var err error
+		if s, err = ParseRaw(witnessScript); err != nil {
+			return ErrBadOpcode
+		}
+	default:
+		return ErrWitnessProgramWrongLength
+	}
+
+	e.sigVersion = SigVersionWitnessV0
+	return e.executeWitnessScript(s, witness)
+}
+
+// verifyTaproot verifies the spend of the Taproot output key, see BIP341.
+//
+// A key path spend is a single signature by the output key. A script path spend is
+// the stack for the leaf script, followed by the leaf script and the control block,
+// which proves the leaf is committed to by the output key.
+// Either may be followed by the annex, which is reserved for future extensions.
+func (e *Engine) verifyTaproot(outputKey []byte) error {
+	witness := e.witness
+	if len(witness) == 0 {
+		return ErrWitnessProgramWitnessEmpty
+	}
+	budget := tapscriptSigOpCost + witnessSize(witness)
+	if last := witness[len(witness)-1]; len(witness) >= 2 && len(last) > 0 && last[0] == annexTag {
+		e.execData.Annex = last
+		witness = witness[:len(witness)-1]
+	}
+
+	if len(witness) == 1 {
+		e.sigVersion = SigVersionTaproot
+		return checkSchnorrSig(e, witness[0], outputKey)
+	}
+
+	control := witness[len(witness)-1]
+	leafScript := witness[len(witness)-2]
+	witness = witness[:len(witness)-2]
+	if len(control) < controlBaseSize || len(control) > controlBaseSize+controlNodeSize*controlMaxNodes ||
+		(len(control)-controlBaseSize)%controlNodeSize != 0 {
+		return ErrTaprootWrongControlSize
+	}
+	leafVersion := control[0] & leafVersionMask
+	leafHash := TapLeafHashRaw(leafVersion, leafScript)
+	if !verifyTaprootCommitment(control, outputKey, leafHash) {
+		return ErrWitnessProgramMismatch
+	}
+	// leaf versions other than tapscript are reserved for soft-fork upgrades
+	if leafVersion != TapscriptLeafVersion {
+		return nil
+	}
+
+	// OP_SUCCESSx make the script succeed, without executing it, see BIP342,
+	// even if the rest of the script can't be parsed
+	s, err := parseRaw(leafScript)
+	for _, cmd := range s {
+		if op, ok := cmd.(opcode); ok && isOpSuccess(op) {
+			return nil
+		}
+	}
+	if err != nil {
+		return ErrBadOpcode
+	}
+	e.sigVersion = SigVersionTapscript
+	e.sigOpsBudget = budget
+	e.execData.LeafHash = leafHash[:]
+	e.execData.CodeSepPos = 0xffffffff
+	return e.executeWitnessScript(s, witness)
+}
+
+// executeWitnessScript loads the witness script, which is executed on the witness stack.
+func (e *Engine) executeWitnessScript(s Script, witness [][]byte) error {
+	// in tapscripts the initial stack is limited too, see BIP342
+	if e.sigVersion == SigVersionTapscript && len(witness) > MaxStackSize {
+		return ErrStackSize
+	}
+	e.stack = nil
+	for _, item := range witness {
+		if len(item) > MaxScriptElementSize {
+			return ErrPushSize
+		}
+		e.stack.Push(element(item))
+	}
+	e.next = e.afterWitnessScript
+	return e.load(PhaseWitness, s)
+}
+
+// afterWitnessScript checks that the witness script has left exactly a single true element.
+func (e *Engine) afterWitnessScript() error {
+	if len(e.stack) != 1 {
+		return ErrCleanStack
+	}
+	if !e.success() {
+		return ErrEvalFalse
+	}
+	return nil
+}
+
+// witnessSize returns the size of the serialized witness.
+func witnessSize(witness [][]byte) int {
+	varIntSize := func(n int) int {
+		b, _ := encoding.EncodeVarInt(big.NewInt(int64(n)))
+		return len(b)
+	}
+	size := varIntSize(len(witness))
+	for _, item := range witness {
+		size += varIntSize(len(item)) + len(item)
+	}
+	return size
+}
+
+// castToBool interprets the element as a boolean. It is false for any encoding of zero,
+// including the negative zero, and true otherwise.
+func castToBool(el element) bool {
+	for i, b := range el {
+		if b != 0 {
+			// negative zero
+			if i == len(el)-1 && b == 0x80 {
+				return false
+			}
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,384 @@
+package script
+
+import (
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/VIVelev/btcd/crypto/ecdsa"
+	"github.com/VIVelev/btcd/crypto/elliptic"
+	"github.com/VIVelev/btcd/crypto/hash"
+)
+
+// execute runs the whole script on top of the current stack, in the current phase.
+// Returns error if the script fails.
+func (e *Engine) execute(s Script) error {
+	if err := e.load(e.phase, s); err != nil {
+		return err
+	}
+	for len(e.cmds) > 0 {
+		if err := e.step(); err != nil {
+			return err
+		}
+	}
+	// every OP_IF and OP_NOTIF has to be closed by an OP_ENDIF
+	if len(e.condStack) != 0 {
+		return ErrUnbalancedConditional
+	}
+	return nil
+}
+
+// run executes the script and returns whether it succeeds.
+func run(s Script, checker SignatureChecker, flags Flags) bool {
+	e := newEngine(checker, flags)
+	return e.execute(s) == nil && e.success()
+}
+
+func TestFlowControl(t *testing.T) {
+	one := element{1}
+	for _, tc := range []struct {
+		s    Script
+		want bool
+	}{
+		{Script{one, OP_IF, one, OP_ELSE, OP_0, OP_ENDIF}, true},
+		{Script{OP_0, OP_IF, one, OP_ELSE, OP_0, OP_ENDIF}, false},
+		{Script{OP_0, OP_NOTIF, one, OP_ELSE, OP_0, OP_ENDIF}, true},
+		{Script{one, OP_IF, one, OP_ELSE, OP_0, OP_ELSE, one, OP_ENDIF}, true},
+		// branches which are not taken are skipped, including nested conditionals
+		{Script{OP_0, OP_IF, OP_RETURN, OP_IF, OP_ELSE, OP_RETURN, OP_ENDIF, OP_ENDIF, one}, true},
+		{Script{one, OP_IF, OP_0, OP_IF, OP_RETURN, OP_ELSE, one, OP_ENDIF, OP_ENDIF}, true},
+		{Script{element{0x80}, OP_IF, one, OP_ELSE, OP_0, OP_ENDIF}, false}, // negative zero
+		{Script{element{2}, OP_IF, one, OP_ENDIF}, true},
+		// unbalanced conditionals
+		{Script{one, OP_IF, one}, false},
+		{Script{one, OP_ELSE, one, OP_ENDIF}, false},
+		{Script{one, OP_ENDIF}, false},
+		{Script{OP_IF, one, OP_ENDIF}, false},
+		{Script{OP_0, OP_IF, OP_ENDIF, OP_ENDIF, one}, false},
+		// unknown opcodes fail when executed
+		{Script{one, opcode(101)}, false},
+		{Script{OP_0, OP_IF, opcode(186), OP_ENDIF, one}, true},
+	} {
+		if (tc.s.Eval(nil, nil) == nil) != tc.want {
+			t.Errorf("FAIL: %v", tc.s)
+		}
+	}
+}
+
+func TestMinimalIf(t *testing.T) {
+	s := Script{element{2}, OP_IF, element{1}, OP_ENDIF}
+	if !run(s, nil, VerifyMinimalIf) {
+		t.Errorf("FAIL")
+	}
+	e := newEngine(nil, VerifyMinimalIf)
+	e.sigVersion = SigVersionWitnessV0
+	if e.execute(s) != ErrMinimalIf {
+		t.Errorf("FAIL")
+	}
+	e = newEngine(nil, VerifyMinimalIf)
+	e.sigVersion = SigVersionWitnessV0
+	if e.execute(Script{element{1}, OP_IF, element{1}, OP_ENDIF}) != nil || !e.success() {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestVerifyP2SH(t *testing.T) {
+	redeemScript := Script{OP_1, OP_ADD, OP_3, OP_EQUAL}
+	raw, _ := redeemScript.Raw()
+	spk := NewP2SHScript(hash.Hash160(raw))
+
+	for _, tc := range []struct {
+		scriptSig Script
+		flags     Flags
+		want      error
+	}{
+		{Script{OP_2, element(raw)}, VerifyP2SH, nil},
+		{Script{OP_1, element(raw)}, VerifyP2SH, ErrEvalFalse},
+		// without BIP16 only the hash of the redeem script is checked
+		{Script{OP_1, element(raw)}, 0, nil},
+		// the scriptSig has to be push only
+		{Script{OP_2, OP_NOP, element(raw)}, VerifyP2SH, ErrSigPushOnly},
+		{Script{OP_2, element{}}, VerifyP2SH, ErrEvalFalse},
+	} {
+		if VerifyScript(tc.scriptSig, spk, nil, nil, tc.flags) != tc.want {
+			t.Errorf("FAIL")
+		}
+	}
+}
+
+func TestVerifyWitnessUnexpected(t *testing.T) {
+	witness := [][]byte{{1}}
+	if VerifyScript(Script{}, Script{OP_1}, witness, nil, VerifyWitness) != ErrWitnessUnexpected {
+		t.Errorf("FAIL")
+	}
+	// a native witness program has to be spent with an empty scriptSig
+	spk := NewP2WPKHScript([20]byte{1})
+	if VerifyScript(Script{OP_1}, spk, [][]byte{{1}, {2}}, nil, VerifyWitness) != ErrWitnessMalleated {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestVerifyP2WSH(t *testing.T) {
+	witnessScript := Script{OP_ADD, OP_3, OP_EQUAL}
+	raw, _ := witnessScript.Raw()
+	spk := NewP2WSHScript(hash.Sha256(raw))
+
+	for _, tc := range []struct {
+		witness [][]byte
+		want    error
+	}{
+		{[][]byte{{1}, {2}, raw}, nil},
+		{[][]byte{{1}, {1}, raw}, ErrEvalFalse},
+		// exactly one element has to be left on the stack
+		{[][]byte{{1}, {1}, {2}, raw}, ErrCleanStack},
+		// the witness script has to match the program
+		{[][]byte{{1}, {2}, raw[1:]}, ErrWitnessProgramMismatch},
+		{[][]byte{}, ErrWitnessProgramWitnessEmpty},
+		// the stack elements are limited in size
+		{[][]byte{make([]byte, MaxScriptElementSize+1), {2}, raw}, ErrPushSize},
+	} {
+		if VerifyScript(Script{}, spk, tc.witness, nil, VerifyWitness) != tc.want {
+			t.Errorf("FAIL")
+		}
+	}
+
+	// nested in P2SH
+	spkRaw, _ := spk.Raw()
+	p2sh := NewP2SHScript(hash.Hash160(spkRaw))
+	if VerifyScript(Script{element(spkRaw)}, p2sh, [][]byte{{1}, {2}, raw}, nil, VerifyP2SH|VerifyWitness) != nil {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	one := element{1}
+	for _, tc := range []struct {
+		s     Script
+		flags Flags
+		want  error
+	}{
+		{Script{OP_0}, 0, ErrEvalFalse},
+		{Script{}, 0, ErrEvalFalse},
+		{Script{one, OP_RETURN}, 0, ErrOpReturn},
+		{Script{OP_DROP}, 0, ErrInvalidStackOperation},
+		{Script{OP_FROMALTSTACK}, 0, ErrInvalidAltstackOperation},
+		{Script{OP_IF}, 0, ErrUnbalancedConditional},
+		{Script{one, OP_IF, one}, 0, ErrUnbalancedConditional},
+		{Script{OP_ENDIF}, 0, ErrUnbalancedConditional},
+		{Script{one, opcode(101)}, 0, ErrBadOpcode},
+		{Script{OP_0, OP_VERIFY}, 0, ErrVerify},
+		{Script{OP_1, OP_2, OP_EQUALVERIFY}, 0, ErrEqualVerify},
+		{Script{OP_1, OP_2, OP_NUMEQUALVERIFY}, 0, ErrNumEqualVerify},
+		{Script{element{1, 2, 3, 4, 5}, OP_1ADD}, 0, ErrUnknown},
+		{Script{OP_0, OP_0, element{21}, OP_CHECKMULTISIG}, 0, ErrPubKeyCount},
+		{Script{OP_0, OP_1, OP_0, OP_CHECKMULTISIG}, 0, ErrSigCount},
+		{Script{OP_1, OP_0, OP_0, OP_CHECKMULTISIG}, VerifyNullDummy, ErrSigNullDummy},
+		{Script{OP_0, OP_0, OP_CHECKSIGVERIFY}, 0, ErrCheckSigVerify},
+		{Script{OP_0, OP_0, OP_0, OP_CHECKMULTISIGVERIFY, one}, 0, nil},
+		{Script{OP_1, OP_NOP4}, VerifyDiscourageUpgradableNops, ErrDiscourageUpgradableNops},
+		{Script{OP_1NEGATE, OP_CHECKLOCKTIMEVERIFY}, VerifyCheckLockTimeVerify, ErrNegativeLockTime},
+		{Script{OP_1, OP_CHECKLOCKTIMEVERIFY}, VerifyCheckLockTimeVerify, ErrUnsatisfiedLockTime},
+		{Script{OP_1, OP_0, OP_0, OP_CHECKSIGADD}, 0, ErrBadOpcode},
+		{Script{OP_0, OP_IF, unparsed{0x4b, 0x07}, OP_ENDIF, OP_1}, 0, ErrBadOpcode},
+		// pushes which should have been opcodes
+		{Script{element{5}}, VerifyMinimalData, ErrMinimalData},
+		{Script{element{0x81}, OP_DROP, OP_1}, VerifyMinimalData, ErrMinimalData},
+		{Script{element{17}}, VerifyMinimalData, nil},
+		// empty elements are serialized as OP_0
+		{Script{element{}, OP_DROP, OP_1}, VerifyMinimalData, nil},
+		// pushes by a longer push opcode than needed
+		{Script{pushData{OP_PUSHDATA1, element{}}, OP_DROP, OP_1}, VerifyMinimalData, ErrMinimalData},
+		{Script{pushData{OP_PUSHDATA1, element{17}}}, VerifyMinimalData, ErrMinimalData},
+		{Script{pushData{OP_PUSHDATA1, element{17}}}, 0, nil},
+		{Script{pushData{OP_PUSHDATA2, element(make([]byte, 76))}, OP_DROP, OP_1}, VerifyMinimalData, ErrMinimalData},
+		{Script{element(make([]byte, 76)), OP_DROP, OP_1}, VerifyMinimalData, nil},
+	} {
+		if got := VerifyScript(Script{}, tc.s, nil, nil, tc.flags); got != tc.want {
+			t.Errorf("FAIL: %v: %v", tc.s, got)
+		}
+	}
+
+	if ErrEvalFalse.String() != "EVAL_FALSE" || ErrSigNullFail.String() != "NULLFAIL" || ErrorCode(0).String() != "UNKNOWN_ERROR" {
+		t.Errorf("FAIL")
+	}
+}
+
+// highS returns the signature with its S value replaced by N - S, which is also valid.
+func highS(sig []byte) []byte {
+	lenR := int(sig[3])
+	s := new(big.Int).SetBytes(sig[6+lenR : len(sig)-1])
+	s.Sub(elliptic.Secp256k1.Params().N, s)
+	sb := s.Bytes()
+	if sb[0]&0x80 != 0 {
+		sb = append([]byte{0}, sb...)
+	}
+	r := sig[4 : 4+lenR]
+	der := append([]byte{0x30, byte(4 + len(r) + len(sb)), 0x02, byte(len(r))}, r...)
+	der = append(der, 0x02, byte(len(sb)))
+	der = append(der, sb...)
+	return append(der, sig[len(sig)-1])
+}
+
+func TestSignatureEncoding(t *testing.T) {
+	sighash := hash.Sha256([]byte("encoding"))
+	priv := ecdsa.GenerateKeyFromSecret(elliptic.Secp256k1, big.NewInt(1))
+	pubKey := priv.PublicKey.MarshalCompressed()
+	der := priv.Sign(sighash[:]).Marshal()
+	sig := append(der, 0x01)
+	checker := &MockChecker{Sighash: sighash[:]}
+
+	// a trailing byte after S is not strict DER
+	padded := append([]byte{0x30, der[1] + 1}, der[2:]...)
+	padded = append(padded, 0x00, 0x01)
+	// an extra leading zero in R
+	longR := append([]byte{0x30, der[1] + 1, 0x02, der[3] + 1, 0x00}, der[4:]...)
+	longR = append(longR, 0x01)
+	wrongKey := append([]byte{0x05}, pubKey[1:]...)
+
+	for _, tc := range []struct {
+		sig, pubKey []byte
+		flags       Flags
+		want        error
+	}{
+		{sig, pubKey, VerifyStrictEncoding | VerifyLowS, nil},
+		// before BIP66 the padding is accepted
+		{longR, pubKey, 0, nil},
+		{longR, pubKey, VerifyDERSignatures, ErrSigDER},
+		{padded, pubKey, VerifyStrictEncoding, ErrSigDER},
+		{highS(sig), pubKey, VerifyDERSignatures, nil},
+		{highS(sig), pubKey, VerifyLowS, ErrSigHighS},
+		{append(der, 0x05), pubKey, 0, nil},
+		{append(der, 0x05), pubKey, VerifyStrictEncoding, ErrSigHashType},
+		{sig, wrongKey, 0, ErrEvalFalse},
+		{sig, wrongKey, VerifyStrictEncoding, ErrPubKeyType},
+		// failed signature checks have to be empty under NULLFAIL
+		{sig[1:], pubKey, VerifyNullFail, ErrSigNullFail},
+		{[]byte{}, pubKey, VerifyNullFail, ErrEvalFalse},
+	} {
+		s := Script{element(tc.sig), element(tc.pubKey), OP_CHECKSIG}
+		if got := VerifyScript(Script{}, s, nil, checker, tc.flags); got != tc.want {
+			t.Errorf("FAIL: %x: %v", tc.sig, got)
+		}
+	}
+
+	// uncompressed keys in segwit v0
+	uncompressed := priv.PublicKey.Marshal()
+	s := Script{element(sig), element(uncompressed), OP_CHECKSIG}
+	raw, _ := s.Raw()
+	spk := NewP2WSHScript(hash.Sha256(raw))
+	if VerifyScript(Script{}, spk, [][]byte{raw}, checker, VerifyWitness) != nil {
+		t.Errorf("FAIL")
+	}
+	if VerifyScript(Script{}, spk, [][]byte{raw}, checker, VerifyWitness|VerifyWitnessPubKeyType) != ErrWitnessPubKeyType {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestCleanStack(t *testing.T) {
+	flags := VerifyP2SH | VerifyWitness | VerifyCleanStack
+	if VerifyScript(Script{OP_1}, Script{OP_1}, nil, nil, flags) != ErrCleanStack {
+		t.Errorf("FAIL")
+	}
+	if VerifyScript(Script{}, Script{OP_1}, nil, nil, flags) != nil {
+		t.Errorf("FAIL")
+	}
+	// the stack of P2SH is the one left by the redeem script
+	redeemScript := Script{OP_1}
+	raw, _ := redeemScript.Raw()
+	spk := NewP2SHScript(hash.Hash160(raw))
+	if VerifyScript(Script{element(raw)}, spk, nil, nil, flags) != nil {
+		t.Errorf("FAIL")
+	}
+	if VerifyScript(Script{OP_1, element(raw)}, spk, nil, nil, flags) != ErrCleanStack {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestSigPushOnly(t *testing.T) {
+	if VerifyScript(Script{OP_1, OP_DUP}, Script{OP_DROP}, nil, nil, 0) != nil {
+		t.Errorf("FAIL")
+	}
+	if VerifyScript(Script{OP_1, OP_DUP}, Script{OP_DROP}, nil, nil, VerifySigPushOnly) != ErrSigPushOnly {
+		t.Errorf("FAIL")
+	}
+}
+
+// TestMalformedScripts executes random scripts, which have to fail without panicking.
+func TestMalformedScripts(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	flags := []Flags{
+		0,
+		VerifyP2SH | VerifyWitness | VerifyTaproot | VerifyStrictEncoding | VerifyLowS |
+			VerifyMinimalData | VerifyNullFail | VerifyNullDummy | VerifyCleanStack |
+			VerifyCheckLockTimeVerify | VerifyCheckSequenceVerify,
+	}
+	for i := 0; i < 5000; i++ {
+		raw := make([]byte, r.Intn(40))
+		for j := range raw {
+			// mostly opcodes, with small pushes and numbers
+			raw[j] = byte(r.Intn(256))
+			if r.Intn(3) == 0 {
+				raw[j] = byte(r.Intn(17)) + byte(OP_1NEGATE)
+			}
+		}
+		s, _ := parseRaw(raw)
+		sig, _ := parseRaw(raw[:len(raw)/2])
+		witness := [][]byte{raw, raw[len(raw)/2:]}
+		for _, f := range flags {
+			VerifyScript(sig, s, witness, &MockChecker{}, f)
+			VerifyScript(Script{}, s, nil, nil, f)
+		}
+	}
+}
+
+// repeat returns the script of n times the command, followed by the rest.
+func repeat(cmd command, n int, rest ...command) Script {
+	s := Script{}
+	for i := 0; i < n; i++ {
+		s = append(s, cmd)
+	}
+	return append(s, rest...)
+}
+
+func TestLimits(t *testing.T) {
+	one := element{1}
+	big := element(make([]byte, 500))
+	multisig := append(Script{OP_0, OP_0}, repeat(element{2}, 20, element{20}, OP_CHECKMULTISIG)...)
+	for _, tc := range []struct {
+		s    Script
+		want error
+	}{
+		// non-push opcodes, even if not executed
+		{repeat(OP_NOP, MaxOpsPerScript, OP_1), nil},
+		{repeat(OP_NOP, MaxOpsPerScript+1, OP_1), ErrOpCount},
+		{append(Script{OP_0, OP_IF}, repeat(OP_NOP, MaxOpsPerScript-1, OP_ENDIF, OP_1)...), ErrOpCount},
+		{repeat(OP_1, MaxOpsPerScript+1), nil},
+		// the public keys of OP_CHECKMULTISIG count too
+		{repeat(OP_NOP, MaxOpsPerScript-21, multisig...), nil},
+		{repeat(OP_NOP, MaxOpsPerScript-20, multisig...), ErrOpCount},
+		// the stack and the alt stack combined
+		{repeat(one, MaxStackSize), nil},
+		{repeat(one, MaxStackSize+1), ErrStackSize},
+		{repeat(one, MaxStackSize, OP_TOALTSTACK, OP_1), ErrStackSize},
+		// pushes, even if not executed
+		{Script{OP_0, OP_IF, element(make([]byte, MaxScriptElementSize)), OP_ENDIF, OP_1}, nil},
+		{Script{OP_0, OP_IF, element(make([]byte, MaxScriptElementSize+1)), OP_ENDIF, OP_1}, ErrPushSize},
+		// the size of the script
+		{repeat(big, 19, OP_1), nil},
+		{repeat(big, 20, OP_1), ErrScriptSize},
+		// as serialized, with the push opcodes the script was parsed with
+		{append(Script{OP_0, OP_IF}, repeat(pushData{OP_PUSHDATA4, one}, 1666, OP_ENDIF, OP_1)...), nil},
+		{append(Script{OP_0, OP_IF}, repeat(pushData{OP_PUSHDATA4, one}, 1667, OP_ENDIF, OP_1)...), ErrScriptSize},
+	} {
+		if got := VerifyScript(Script{}, tc.s, nil, nil, 0); got != tc.want {
+			t.Errorf("FAIL: %d commands: %v", len(tc.s), got)
+		}
+	}
+
+	// tapscripts have no opcode limit
+	e := newEngine(nil, 0)
+	e.sigVersion = SigVersionTapscript
+	if e.execute(repeat(OP_NOP, MaxOpsPerScript+1, OP_1)) != nil || !e.success() {
+		t.Errorf("FAIL")
+	}
+}
@@ -0,0 +1,183 @@
+package script
+
+// ErrorCode is the reason a script fails, mirroring the script errors of Bitcoin Core.
+// It implements error, so the failures can be compared with errors.Is.
+type ErrorCode int
+
+const (
+	ErrUnknown ErrorCode = iota + 1
+	ErrEvalFalse
+	ErrOpReturn
+
+	// Limits:
+	ErrScriptSize
+	ErrPushSize
+	ErrOpCount
+	ErrStackSize
+	ErrSigCount
+	ErrPubKeyCount
+
+	// Failed verify operations:
+	ErrVerify
+	ErrEqualVerify
+	ErrCheckMultisigVerify
+	ErrCheckSigVerify
+	ErrNumEqualVerify
+
+	// Logical and format errors:
+	ErrBadOpcode
+	ErrDisabledOpcode
+	ErrInvalidStackOperation
+	ErrInvalidAltstackOperation
+	ErrUnbalancedConditional
+
+	// Timelocks:
+	ErrNegativeLockTime
+	ErrUnsatisfiedLockTime
+
+	// Malleability:
+	ErrSigHashType
+	ErrSigDER
+	ErrMinimalData
+	ErrSigPushOnly
+	ErrSigHighS
+	ErrSigNullDummy
+	ErrPubKeyType
+	ErrCleanStack
+	ErrMinimalIf
+	ErrSigNullFail
+
+	// Soft-fork safeness:
+	ErrDiscourageUpgradableNops
+
+	// Segregated witness:
+	ErrWitnessProgramWrongLength
+	ErrWitnessProgramWitnessEmpty
+	ErrWitnessProgramMismatch
+	ErrWitnessMalleated
+	ErrWitnessMalleatedP2SH
+	ErrWitnessUnexpected
+	ErrWitnessPubKeyType
+
+	// Taproot:
+	ErrSchnorrSigSize
+	ErrSchnorrSigHashType
+	ErrSchnorrSig
+	ErrTaprootWrongControlSize
+	ErrTapscriptValidationWeight
+	ErrTapscriptCheckMultisig
+	ErrTapscriptMinimalIf
+)
+
+var errorNames = map[ErrorCode]string{
+	ErrUnknown:                    "UNKNOWN_ERROR",
+	ErrEvalFalse:                  "EVAL_FALSE",
+	ErrOpReturn:                   "OP_RETURN",
+	ErrScriptSize:                 "SCRIPT_SIZE",
+	ErrPushSize:                   "PUSH_SIZE",
+	ErrOpCount:                    "OP_COUNT",
+	ErrStackSize:                  "STACK_SIZE",
+	ErrSigCount:                   "SIG_COUNT",
+	ErrPubKeyCount:                "PUBKEY_COUNT",
+	ErrVerify:                     "VERIFY",
+	ErrEqualVerify:                "EQUALVERIFY",
+	ErrCheckMultisigVerify:        "CHECKMULTISIGVERIFY",
+	ErrCheckSigVerify:             "CHECKSIGVERIFY",
+	ErrNumEqualVerify:             "NUMEQUALVERIFY",
+	ErrBadOpcode:                  "BAD_OPCODE",
+	ErrDisabledOpcode:             "DISABLED_OPCODE",
+	ErrInvalidStackOperation:      "INVALID_STACK_OPERATION",
+	ErrInvalidAltstackOperation:   "INVALID_ALTSTACK_OPERATION",
+	ErrUnbalancedConditional:      "UNBALANCED_CONDITIONAL",
+	ErrNegativeLockTime:           "NEGATIVE_LOCKTIME",
+	ErrUnsatisfiedLockTime:        "UNSATISFIED_LOCKTIME",
+	ErrSigHashType:                "SIG_HASHTYPE",
+	ErrSigDER:                     "SIG_DER",
+	ErrMinimalData:                "MINIMALDATA",
+	ErrSigPushOnly:                "SIG_PUSHONLY",
+	ErrSigHighS:                   "SIG_HIGH_S",
+	ErrSigNullDummy:               "SIG_NULLDUMMY",
+	ErrPubKeyType:                 "PUBKEYTYPE",
+	ErrCleanStack:                 "CLEANSTACK",
+	ErrMinimalIf:                  "MINIMALIF",
+	ErrSigNullFail:                "NULLFAIL",
+	ErrDiscourageUpgradableNops:   "DISCOURAGE_UPGRADABLE_NOPS",
+	ErrWitnessProgramWrongLength:  "WITNESS_PROGRAM_WRONG_LENGTH",
+	ErrWitnessProgramWitnessEmpty: "WITNESS_PROGRAM_WITNESS_EMPTY",
+	ErrWitnessProgramMismatch:     "WITNESS_PROGRAM_MISMATCH",
+	ErrWitnessMalleated:           "WITNESS_MALLEATED",
+	ErrWitnessMalleatedP2SH:       "WITNESS_MALLEATED_P2SH",
+	ErrWitnessUnexpected:          "WITNESS_UNEXPECTED",
+	ErrWitnessPubKeyType:          "WITNESS_PUBKEYTYPE",
+	ErrSchnorrSigSize:             "SCHNORR_SIG_SIZE",
+	ErrSchnorrSigHashType:         "SCHNORR_SIG_HASHTYPE",
+	ErrSchnorrSig:                 "SCHNORR_SIG",
+	ErrTaprootWrongControlSize:    "TAPROOT_WRONG_CONTROL_SIZE",
+	ErrTapscriptValidationWeight:  "TAPSCRIPT_VALIDATION_WEIGHT",
+	ErrTapscriptCheckMultisig:     "TAPSCRIPT_CHECKMULTISIG",
+	ErrTapscriptMinimalIf:         "TAPSCRIPT_MINIMALIF",
+}
+
+var errorDescriptions = map[ErrorCode]string{
+	ErrUnknown:                    "unknown error",
+	ErrEvalFalse:                  "script evaluated without error but finished with a false/empty top stack element",
+	ErrOpReturn:                   "OP_RETURN was encountered",
+	ErrScriptSize:                 "script is too big",
+	ErrPushSize:                   "push value size limit exceeded",
+	ErrOpCount:                    "operation limit exceeded",
+	ErrStackSize:                  "stack size limit exceeded",
+	ErrSigCount:                   "signature count negative or greater than pubkey count",
+	ErrPubKeyCount:                "pubkey count negative or limit exceeded",
+	ErrVerify:                     "script failed an OP_VERIFY operation",
+	ErrEqualVerify:                "script failed an OP_EQUALVERIFY operation",
+	ErrCheckMultisigVerify:        "script failed an OP_CHECKMULTISIGVERIFY operation",
+	ErrCheckSigVerify:             "script failed an OP_CHECKSIGVERIFY operation",
+	ErrNumEqualVerify:             "script failed an OP_NUMEQUALVERIFY operation",
+	ErrBadOpcode:                  "opcode missing or not understood",
+	ErrDisabledOpcode:             "attempted to use a disabled opcode",
+	ErrInvalidStackOperation:      "operation not valid with the current stack size",
+	ErrInvalidAltstackOperation:   "operation not valid with the current altstack size",
+	ErrUnbalancedConditional:      "invalid OP_IF construction",
+	ErrNegativeLockTime:           "negative locktime",
+	ErrUnsatisfiedLockTime:        "locktime requirement not satisfied",
+	ErrSigHashType:                "signature hash type missing or not understood",
+	ErrSigDER:                     "non-canonical DER signature",
+	ErrMinimalData:                "data push larger than necessary",
+	ErrSigPushOnly:                "only push operators allowed in signatures",
+	ErrSigHighS:                   "non-canonical signature: S value is unnecessarily high",
+	ErrSigNullDummy:               "dummy CHECKMULTISIG argument must be zero",
+	ErrPubKeyType:                 "public key is neither compressed or uncompressed",
+	ErrCleanStack:                 "stack size must be exactly one after execution",
+	ErrMinimalIf:                  "OP_IF/NOTIF argument must be minimal",
+	ErrSigNullFail:                "signature must be zero for failed CHECK(MULTI)SIG operation",
+	ErrDiscourageUpgradableNops:   "NOPx reserved for soft-fork upgrades",
+	ErrWitnessProgramWrongLength:  "witness program has incorrect length",
+	ErrWitnessProgramWitnessEmpty: "witness program was passed an empty witness",
+	ErrWitnessProgramMismatch:     "witness program hash mismatch",
+	ErrWitnessMalleated:           "witness requires empty scriptSig",
+	ErrWitnessMalleatedP2SH:       "witness requires only-redeemscript scriptSig",
+	ErrWitnessUnexpected:          "witness provided for non-witness script",
+	ErrWitnessPubKeyType:          "using non-compressed keys in segwit",
+	ErrSchnorrSigSize:             "invalid Schnorr signature size",
+	ErrSchnorrSigHashType:         "invalid Schnorr signature hash type",
+	ErrSchnorrSig:                 "invalid Schnorr signature",
+	ErrTaprootWrongControlSize:    "invalid Taproot control block size",
+	ErrTapscriptValidationWeight:  "too much signature validation relative to witness weight",
+	ErrTapscriptCheckMultisig:     "OP_CHECKMULTISIG(VERIFY) is not available in tapscript",
+	ErrTapscriptMinimalIf:         "OP_IF/NOTIF argument must be minimal in tapscript",
+}
+
+// String returns the name of the error in Bitcoin Core, for example "EVAL_FALSE".
+func (c ErrorCode) String() string {
+	if name, ok := errorNames[c]; ok {
+		return name
+	}
+	return "UNKNOWN_ERROR"
+}
+
+func (c ErrorCode) Error() string {
+	if desc, ok := errorDescriptions[c]; ok {
+		return "script: " + desc
+	}
+	return "script: unknown error"
+}
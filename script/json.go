@@ -0,0 +1,60 @@
+package script
+
+import (
+	"encoding/hex"
+	"encoding/json"
+)
+
+// String returns the script in the ASM format of Bitcoin Core RPC, see Disassemble.
+func (s Script) String() string {
+	return Disassemble(s)
+}
+
+// Type returns the standard type of the script, named as in Bitcoin Core RPC,
+// for example "pubkeyhash" or "witness_v0_keyhash", see Classify.
+func (s Script) Type() string {
+	class, _ := Classify(s)
+	return class.String()
+}
+
+// jsonScript is the scriptPubKey object of Bitcoin Core RPC.
+type jsonScript struct {
+	Asm     string `json:"asm"`
+	Hex     string `json:"hex"`
+	Type    string `json:"type,omitempty"`
+	Address string `json:"address,omitempty"`
+}
+
+// MarshalJSON encodes the script as {"asm", "hex", "type", "address"},
+// the address is for mainnet and omitted if the script has none.
+func (s Script) MarshalJSON() ([]byte, error) {
+	raw, err := s.Raw()
+	if err != nil {
+		return nil, err
+	}
+	addr, _ := s.Address(false)
+	return json.Marshal(jsonScript{
+		Asm:     s.String(),
+		Hex:     hex.EncodeToString(raw),
+		Type:    s.Type(),
+		Address: addr,
+	})
+}
+
+// UnmarshalJSON decodes the script from the "hex" field, the rest of the fields are ignored.
+func (s *Script) UnmarshalJSON(data []byte) error {
+	var v jsonScript
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	raw, err := hex.DecodeString(v.Hex)
+	if err != nil {
+		return err
+	}
+	parsed, err := ParseRaw(raw)
+	if err != nil {
+		return err
+	}
+	*s = parsed
+	return nil
+}
@@ -0,0 +1,60 @@
+package script
+
+// Timelocks, as defined in BIP65 and BIP112.
+// Reference: https://github.com/bitcoin/bips/blob/master/bip-0065.mediawiki
+// Reference: https://github.com/bitcoin/bips/blob/master/bip-0112.mediawiki
+
+const (
+	// LockTimeThreshold separates the lock times which are block heights (below it)
+	// from the ones which are UNIX timestamps.
+	LockTimeThreshold = 500000000
+
+	// SequenceFinal is the sequence of the inputs which opt out of the lock time of the transaction.
+	SequenceFinal = 0xffffffff
+	// SequenceLockTimeDisableFlag is set on the sequences which are not relative lock times, see BIP68.
+	SequenceLockTimeDisableFlag = 1 << 31
+	// SequenceLockTimeTypeFlag is set on the relative lock times in units of 512 seconds,
+	// rather than blocks.
+	SequenceLockTimeTypeFlag = 1 << 22
+	// SequenceLockTimeMask extracts the relative lock time from the sequence.
+	SequenceLockTimeMask = 0x0000ffff
+)
+
+// TxContext is the part of the spending transaction checked by the timelock opcodes,
+// its methods implement the timelock checks of SignatureChecker.
+type TxContext struct {
+	Version  uint32
+	LockTime uint32
+	// Sequence is of the input being verified.
+	Sequence uint32
+}
+
+// CheckLockTime returns whether the transaction is locked until at least lockTime, see BIP65.
+//
+// Both have to be either block heights or timestamps, and the input must not be final,
+// since that disables the lock time of the transaction.
+func (c *TxContext) CheckLockTime(lockTime ScriptNum) bool {
+	txLockTime := ScriptNum(c.LockTime)
+	if (txLockTime < LockTimeThreshold) != (lockTime < LockTimeThreshold) {
+		return false
+	}
+	return lockTime <= txLockTime && c.Sequence != SequenceFinal
+}
+
+// CheckSequence returns whether the input is locked for at least the relative lock time
+// of sequence, see BIP112.
+//
+// Both have to be either in blocks or in units of 512 seconds,
+// and relative lock times are only enforced from transaction version 2, see BIP68.
+func (c *TxContext) CheckSequence(sequence ScriptNum) bool {
+	if c.Version < 2 || c.Sequence&SequenceLockTimeDisableFlag != 0 {
+		return false
+	}
+	const mask = SequenceLockTimeTypeFlag | SequenceLockTimeMask
+	txSequence := ScriptNum(c.Sequence & mask)
+	sequence &= mask
+	if (txSequence < SequenceLockTimeTypeFlag) != (sequence < SequenceLockTimeTypeFlag) {
+		return false
+	}
+	return sequence <= txSequence
+}
@@ -0,0 +1,88 @@
+package script
+
+import "testing"
+
+func TestCheckLockTime(t *testing.T) {
+	for _, tc := range []struct {
+		ctx      TxContext
+		lockTime ScriptNum
+		want     bool
+	}{
+		{TxContext{LockTime: 100, Sequence: 0}, 100, true},
+		{TxContext{LockTime: 100, Sequence: 0}, 99, true},
+		{TxContext{LockTime: 100, Sequence: 0}, 101, false},
+		// a final input disables the lock time of the transaction
+		{TxContext{LockTime: 100, Sequence: SequenceFinal}, 100, false},
+		// block heights and timestamps don't compare
+		{TxContext{LockTime: LockTimeThreshold, Sequence: 0}, 100, false},
+		{TxContext{LockTime: 100, Sequence: 0}, LockTimeThreshold, false},
+		{TxContext{LockTime: LockTimeThreshold + 1, Sequence: 0}, LockTimeThreshold, true},
+	} {
+		if tc.ctx.CheckLockTime(tc.lockTime) != tc.want {
+			t.Errorf("FAIL")
+		}
+	}
+}
+
+func TestCheckSequence(t *testing.T) {
+	for _, tc := range []struct {
+		ctx      TxContext
+		sequence ScriptNum
+		want     bool
+	}{
+		{TxContext{Version: 2, Sequence: 10}, 10, true},
+		{TxContext{Version: 2, Sequence: 10}, 11, false},
+		// relative lock times are only enforced from version 2
+		{TxContext{Version: 1, Sequence: 10}, 10, false},
+		{TxContext{Version: 2, Sequence: SequenceLockTimeDisableFlag | 10}, 10, false},
+		// blocks and units of 512 seconds don't compare
+		{TxContext{Version: 2, Sequence: SequenceLockTimeTypeFlag | 10}, 10, false},
+		{TxContext{Version: 2, Sequence: SequenceLockTimeTypeFlag | 10}, SequenceLockTimeTypeFlag | 5, true},
+		// the bits outside of the mask are ignored
+		{TxContext{Version: 2, Sequence: 1<<16 | 10}, 1<<17 | 10, true},
+	} {
+		if tc.ctx.CheckSequence(tc.sequence) != tc.want {
+			t.Errorf("FAIL")
+		}
+	}
+}
+
+func TestTimelockOpcodes(t *testing.T) {
+	cltv := Script{element(ScriptNum(100).Bytes()), OP_CHECKLOCKTIMEVERIFY}
+	csv := Script{element(ScriptNum(10).Bytes()), OP_CHECKSEQUENCEVERIFY}
+	ctx := &TxContext{Version: 2, LockTime: 100, Sequence: 10}
+	flags := VerifyCheckLockTimeVerify | VerifyCheckSequenceVerify
+	for _, tc := range []struct {
+		s     Script
+		ctx   *TxContext
+		flags Flags
+		want  bool
+	}{
+		{cltv, ctx, flags, true},
+		{csv, ctx, flags, true},
+		{cltv, &TxContext{LockTime: 99}, flags, false},
+		{csv, &TxContext{Version: 2, Sequence: 9}, flags, false},
+		// without a transaction
+		{cltv, nil, flags, false},
+		// negative lock times
+		{Script{OP_1NEGATE, OP_CHECKLOCKTIMEVERIFY}, ctx, flags, false},
+		{Script{OP_1NEGATE, OP_CHECKSEQUENCEVERIFY}, ctx, flags, false},
+		// the disable flag makes it a NOP
+		{Script{element(ScriptNum(SequenceLockTimeDisableFlag).Bytes()), OP_CHECKSEQUENCEVERIFY}, nil, flags, true},
+		// before the soft forks they are NOPs
+		{cltv, nil, 0, true},
+		{csv, nil, 0, true},
+		// which are not discouraged, unlike the other upgradable NOPs
+		{cltv, nil, VerifyDiscourageUpgradableNops, true},
+		{csv, nil, VerifyDiscourageUpgradableNops, true},
+	} {
+		var checker SignatureChecker
+		if tc.ctx != nil {
+			checker = &MockChecker{TxContext: *tc.ctx}
+		}
+		e := newEngine(checker, tc.flags)
+		if (e.execute(tc.s) == nil && e.success()) != tc.want {
+			t.Errorf("FAIL")
+		}
+	}
+}
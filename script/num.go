@@ -0,0 +1,94 @@
+package script
+
+import (
+	"errors"
+	"math"
+)
+
+// maxNumSize is the maximum size in bytes of the operands of the arithmetic opcodes.
+const maxNumSize = 4
+
+// ScriptNum is a number as interpreted by the arithmetic opcodes.
+//
+// Numbers are encoded as little-endian sign-magnitude byte slices: the most significant bit
+// of the last byte is the sign. Zero is encoded as an empty slice.
+// The operands are limited to 4 bytes, but the results may overflow that,
+// they can still be pushed on the stack, but not used as operands again.
+type ScriptNum int64
+
+// ParseScriptNum decodes the number from the byte slice.
+//
+// Returns error if b is longer than maxSize or, if requireMinimal is set,
+// b is not the shortest possible encoding of the number.
+func ParseScriptNum(b []byte, requireMinimal bool, maxSize int) (ScriptNum, error) {
+	if len(b) > maxSize {
+		return 0, errors.New("ParseScriptNum: number overflow")
+	}
+	if requireMinimal && len(b) > 0 {
+		// the last byte can only be 0x00 or 0x80 if it is needed for the sign bit
+		if b[len(b)-1]&0x7f == 0 && (len(b) == 1 || b[len(b)-2]&0x80 == 0) {
+			return 0, errors.New("ParseScriptNum: non-minimally encoded number")
+		}
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	var n int64
+	for i, c := range b {
+		n |= int64(c) << (8 * i)
+	}
+	// clear the sign bit and negate if it was set
+	if b[len(b)-1]&0x80 != 0 {
+		return ScriptNum(-(n &^ (0x80 << (8 * (len(b) - 1))))), nil
+	}
+	return ScriptNum(n), nil
+}
+
+// Bytes returns the minimal encoding of the number.
+func (n ScriptNum) Bytes() []byte {
+	if n == 0 {
+		return []byte{}
+	}
+
+	negative := n < 0
+	abs := uint64(n)
+	if negative {
+		abs = uint64(-n)
+	}
+	var b []byte
+	for abs > 0 {
+		b = append(b, byte(abs&0xff))
+		abs >>= 8
+	}
+
+	// if the most significant byte has its sign bit set, an extra byte is needed for the sign
+	if b[len(b)-1]&0x80 != 0 {
+		if negative {
+			b = append(b, 0x80)
+		} else {
+			b = append(b, 0x00)
+		}
+	} else if negative {
+		b[len(b)-1] |= 0x80
+	}
+	return b
+}
+
+// Int32 returns the number clamped to the int32 range.
+func (n ScriptNum) Int32() int32 {
+	if n > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	if n < math.MinInt32 {
+		return math.MinInt32
+	}
+	return int32(n)
+}
+
+func boolNum(b bool) ScriptNum {
+	if b {
+		return 1
+	}
+	return 0
+}
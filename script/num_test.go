@@ -0,0 +1,56 @@
+package script
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestScriptNum(t *testing.T) {
+	for _, tc := range []struct {
+		n ScriptNum
+		b []byte
+	}{
+		{0, []byte{}},
+		{1, []byte{0x01}},
+		{-1, []byte{0x81}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x80, 0x00}},
+		{-128, []byte{0x80, 0x80}},
+		{255, []byte{0xff, 0x00}},
+		{256, []byte{0x00, 0x01}},
+		{-32768, []byte{0x00, 0x80, 0x80}},
+		{math.MaxInt32, []byte{0xff, 0xff, 0xff, 0x7f}},
+		{-math.MaxInt32, []byte{0xff, 0xff, 0xff, 0xff}},
+		{math.MaxInt32 + 1, []byte{0x00, 0x00, 0x00, 0x80, 0x00}},
+	} {
+		if !bytes.Equal(tc.n.Bytes(), tc.b) {
+			t.Errorf("FAIL: %d", tc.n)
+		}
+		n, err := ParseScriptNum(tc.b, true, 5)
+		if err != nil || n != tc.n {
+			t.Errorf("FAIL: %d", tc.n)
+		}
+	}
+}
+
+func TestParseScriptNum(t *testing.T) {
+	// non-minimal encodings are accepted unless required
+	for _, b := range [][]byte{{0x00}, {0x80}, {0x01, 0x00}, {0x01, 0x80}, {0x7f, 0x00}} {
+		if _, err := ParseScriptNum(b, false, maxNumSize); err != nil {
+			t.Errorf("FAIL: %x", b)
+		}
+		if _, err := ParseScriptNum(b, true, maxNumSize); err == nil {
+			t.Errorf("FAIL: %x", b)
+		}
+	}
+	if n, _ := ParseScriptNum([]byte{0x01, 0x80}, false, maxNumSize); n != -1 {
+		t.Errorf("FAIL")
+	}
+	if _, err := ParseScriptNum([]byte{1, 2, 3, 4, 5}, false, maxNumSize); err == nil {
+		t.Errorf("FAIL")
+	}
+	if ScriptNum(math.MaxInt32+1).Int32() != math.MaxInt32 || ScriptNum(math.MinInt32-1).Int32() != math.MinInt32 {
+		t.Errorf("FAIL")
+	}
+}
@@ -2,143 +2,812 @@ package script
 
 import (
 	"bytes"
-	"math"
 
-	"github.com/VIVelev/btcd/crypto/ecdsa"
-	"github.com/VIVelev/btcd/crypto/elliptic"
 	"github.com/VIVelev/btcd/crypto/hash"
-	"github.com/VIVelev/btcd/utils"
 )
 
-type operation func(st, altst *stack, cmds Script, sighash []byte) bool
+// operation executes an opcode. Returns error if the script fails.
+type operation func(e *Engine) error
 
-func encodeNum(n int) (b element) {
-	if n == 0 {
-		return []byte("")
+func op0(e *Engine) error {
+	e.stack.Push(element{})
+	return nil
+}
+
+// opSmallInt returns the operation pushing n, for OP_1NEGATE and OP_1 through OP_16.
+func opSmallInt(n ScriptNum) operation {
+	return func(e *Engine) error {
+		e.stack.Push(element(n.Bytes()))
+		return nil
 	}
+}
+
+func opNop(_ *Engine) error {
+	return nil
+}
 
-	absNum := int(math.Abs(float64(n)))
-	for absNum > 0 {
-		b = append(b, byte(absNum&0xff))
-		absNum >>= 8
+// opUpgradableNop is for the NOPs reserved for soft-fork upgrades,
+// using them is discouraged by VerifyDiscourageUpgradableNops.
+func opUpgradableNop(e *Engine) error {
+	if e.flags&VerifyDiscourageUpgradableNops != 0 {
+		return ErrDiscourageUpgradableNops
 	}
+	return nil
+}
 
-	negative := n < 0
-	if b[len(b)-1]&0x80 != 0 {
-		if negative {
-			b = append(b, 0x80)
-		} else {
-			b = append(b, 0x00)
+// conditional pops the condition and enters the branch, if the enclosing branches are taken.
+// Otherwise the branch is entered as not taken, without touching the stack.
+func conditional(e *Engine, negate bool) error {
+	taken := false
+	if e.executing() {
+		if len(e.stack) < 1 {
+			return ErrUnbalancedConditional
 		}
-	} else if negative {
-		b[len(b)-1] |= 0x80
+		el, _ := e.stack.Pop()
+		// minimal if is a consensus rule in tapscripts
+		if len(el) > 1 || (len(el) == 1 && el[0] != 1) {
+			if e.sigVersion == SigVersionTapscript {
+				return ErrTapscriptMinimalIf
+			}
+			if e.sigVersion == SigVersionWitnessV0 && e.flags&VerifyMinimalIf != 0 {
+				return ErrMinimalIf
+			}
+		}
+		taken = castToBool(el) != negate
 	}
+	e.condStack = append(e.condStack, taken)
+	return nil
+}
 
-	return
+func opIf(e *Engine) error {
+	return conditional(e, false)
 }
 
-func decodeNum(b element) (n int) {
-	if bytes.Equal(b, []byte("")) {
-		return 0
+func opNotif(e *Engine) error {
+	return conditional(e, true)
+}
+
+func opElse(e *Engine) error {
+	if len(e.condStack) == 0 {
+		return ErrUnbalancedConditional
 	}
+	top := len(e.condStack) - 1
+	e.condStack[top] = !e.condStack[top]
+	return nil
+}
 
-	b = utils.Reversed(b) // convert to big-endian
-	var negative bool
-	if b[0]&0x80 != 0 {
-		negative = true
-		n = int(b[0] & 0x7f)
-	} else {
-		negative = false
-		n = int(b[0])
+func opEndif(e *Engine) error {
+	if len(e.condStack) == 0 {
+		return ErrUnbalancedConditional
+	}
+	e.condStack = e.condStack[:len(e.condStack)-1]
+	return nil
+}
+
+func opReturn(_ *Engine) error {
+	return ErrOpReturn
+}
+
+func opToaltstack(e *Engine) error {
+	if len(e.stack) < 1 {
+		return ErrInvalidStackOperation
 	}
+	el, _ := e.stack.Pop()
+	e.altstack.Push(el)
+	return nil
+}
 
-	for _, c := range b[1:] {
-		n <<= 8
-		n += int(c)
+func opFromaltstack(e *Engine) error {
+	if len(e.altstack) < 1 {
+		return ErrInvalidAltstackOperation
 	}
-	if negative {
-		n = -n
+	el, _ := e.altstack.Pop()
+	e.stack.Push(el)
+	return nil
+}
+
+func op2drop(e *Engine) error {
+	if len(e.stack) < 2 {
+		return ErrInvalidStackOperation
 	}
+	e.stack.Pop()
+	e.stack.Pop()
+	return nil
+}
 
-	return
+func op2dup(e *Engine) error {
+	if len(e.stack) < 2 {
+		return ErrInvalidStackOperation
+	}
+	x1, x2 := e.stack.at(1), e.stack.at(0)
+	e.stack.Push(x1).Push(x2)
+	return nil
 }
 
-func op0(st, _ *stack, _ Script, _ []byte) bool {
-	st.Push(OP_0)
-	return true
+func op3dup(e *Engine) error {
+	if len(e.stack) < 3 {
+		return ErrInvalidStackOperation
+	}
+	x1, x2, x3 := e.stack.at(2), e.stack.at(1), e.stack.at(0)
+	e.stack.Push(x1).Push(x2).Push(x3)
+	return nil
 }
 
-func opDup(st, _ *stack, _ Script, _ []byte) bool {
-	if len(*st) < 1 {
-		return false
+func op2over(e *Engine) error {
+	if len(e.stack) < 4 {
+		return ErrInvalidStackOperation
 	}
-	st.Push(st.Peek())
-	return true
+	x1, x2 := e.stack.at(3), e.stack.at(2)
+	e.stack.Push(x1).Push(x2)
+	return nil
 }
 
-func opHash160(st, _ *stack, _ Script, _ []byte) bool {
-	if len(*st) < 1 {
-		return false
+func op2rot(e *Engine) error {
+	if len(e.stack) < 6 {
+		return ErrInvalidStackOperation
 	}
-	_, c := st.Pop()
-	el := c.(element)
-	h160 := hash.Hash160(el)
-	st.Push(element(h160[:]))
-	return true
+	x1 := e.stack.remove(5)
+	x2 := e.stack.remove(4)
+	e.stack.Push(x1).Push(x2)
+	return nil
 }
 
-func opEqual(st, _ *stack, _ Script, _ []byte) bool {
-	if len(*st) < 2 {
-		return false
+func op2swap(e *Engine) error {
+	if len(e.stack) < 4 {
+		return ErrInvalidStackOperation
 	}
-	_, c1 := st.Pop()
-	_, c2 := st.Pop()
-	if c1.Equal(c2) {
-		st.Push(encodeNum(1))
-	} else {
-		st.Push(encodeNum(0))
+	x1 := e.stack.remove(3)
+	x2 := e.stack.remove(2)
+	e.stack.Push(x1).Push(x2)
+	return nil
+}
+
+func opIfdup(e *Engine) error {
+	if len(e.stack) < 1 {
+		return ErrInvalidStackOperation
+	}
+	if top := e.stack.at(0); castToBool(top) {
+		e.stack.Push(top)
+	}
+	return nil
+}
+
+func opDepth(e *Engine) error {
+	e.stack.Push(element(ScriptNum(len(e.stack)).Bytes()))
+	return nil
+}
+
+func opDrop(e *Engine) error {
+	if len(e.stack) < 1 {
+		return ErrInvalidStackOperation
+	}
+	e.stack.Pop()
+	return nil
+}
+
+func opDup(e *Engine) error {
+	if len(e.stack) < 1 {
+		return ErrInvalidStackOperation
+	}
+	e.stack.Push(e.stack.at(0))
+	return nil
+}
+
+func opNip(e *Engine) error {
+	if len(e.stack) < 2 {
+		return ErrInvalidStackOperation
+	}
+	e.stack.remove(1)
+	return nil
+}
+
+func opOver(e *Engine) error {
+	if len(e.stack) < 2 {
+		return ErrInvalidStackOperation
+	}
+	e.stack.Push(e.stack.at(1))
+	return nil
+}
+
+// popDepth pops the depth argument of OP_PICK and OP_ROLL,
+// returns error if there is no element at that depth.
+func popDepth(e *Engine) (int, error) {
+	if len(e.stack) < 2 {
+		return 0, ErrInvalidStackOperation
+	}
+	num, err := popNum(e)
+	if err != nil {
+		return 0, err
+	}
+	n := int(num.Int32())
+	if n < 0 || n >= len(e.stack) {
+		return 0, ErrInvalidStackOperation
+	}
+	return n, nil
+}
+
+func opPick(e *Engine) error {
+	n, err := popDepth(e)
+	if err != nil {
+		return err
+	}
+	e.stack.Push(e.stack.at(n))
+	return nil
+}
+
+func opRoll(e *Engine) error {
+	n, err := popDepth(e)
+	if err != nil {
+		return err
+	}
+	e.stack.Push(e.stack.remove(n))
+	return nil
+}
+
+func opRot(e *Engine) error {
+	if len(e.stack) < 3 {
+		return ErrInvalidStackOperation
+	}
+	e.stack.Push(e.stack.remove(2))
+	return nil
+}
+
+func opSwap(e *Engine) error {
+	if len(e.stack) < 2 {
+		return ErrInvalidStackOperation
+	}
+	e.stack.Push(e.stack.remove(1))
+	return nil
+}
+
+func opTuck(e *Engine) error {
+	if len(e.stack) < 2 {
+		return ErrInvalidStackOperation
+	}
+	x2, _ := e.stack.Pop()
+	x1, _ := e.stack.Pop()
+	e.stack.Push(x2).Push(x1).Push(x2)
+	return nil
+}
+
+func opSize(e *Engine) error {
+	if len(e.stack) < 1 {
+		return ErrInvalidStackOperation
+	}
+	e.stack.Push(element(ScriptNum(len(e.stack.at(0))).Bytes()))
+	return nil
+}
+
+// hashOp replaces the top of the stack with its hash.
+func hashOp(e *Engine, f func(data []byte) []byte) error {
+	if len(e.stack) < 1 {
+		return ErrInvalidStackOperation
+	}
+	el, _ := e.stack.Pop()
+	e.stack.Push(element(f(el)))
+	return nil
+}
+
+func opRipemd160(e *Engine) error {
+	return hashOp(e, func(data []byte) []byte {
+		h := hash.Ripemd160(data)
+		return h[:]
+	})
+}
+
+func opSha1(e *Engine) error {
+	return hashOp(e, func(data []byte) []byte {
+		h := hash.Sha1(data)
+		return h[:]
+	})
+}
+
+func opSha256(e *Engine) error {
+	return hashOp(e, func(data []byte) []byte {
+		h := hash.Sha256(data)
+		return h[:]
+	})
+}
+
+func opHash160(e *Engine) error {
+	return hashOp(e, func(data []byte) []byte {
+		h := hash.Hash160(data)
+		return h[:]
+	})
+}
+
+func opHash256(e *Engine) error {
+	return hashOp(e, func(data []byte) []byte {
+		h := hash.Hash256(data)
+		return h[:]
+	})
+}
+
+// opCodeseparator makes the signatures commit only to the commands after it.
+func opCodeseparator(e *Engine) error {
+	e.scriptCode = e.cmds
+	e.execData.CodeSepPos = uint32(e.pc - 1)
+	return nil
+}
+
+func opEqual(e *Engine) error {
+	if len(e.stack) < 2 {
+		return ErrInvalidStackOperation
+	}
+	x1, _ := e.stack.Pop()
+	x2, _ := e.stack.Pop()
+	e.stack.Push(element(boolNum(bytes.Equal(x1, x2)).Bytes()))
+	return nil
+}
+
+// verify pops the top of the stack and fails with err if it is false.
+func verify(e *Engine, err error) error {
+	if len(e.stack) < 1 {
+		return ErrInvalidStackOperation
+	}
+	el, _ := e.stack.Pop()
+	if !castToBool(el) {
+		return err
 	}
-	return true
+	return nil
 }
 
-func opVerify(st, _ *stack, _ Script, _ []byte) bool {
-	if len(*st) < 1 {
-		return false
+// verifyAfter executes the operation followed by OP_VERIFY, which fails with err.
+func verifyAfter(e *Engine, op operation, err error) error {
+	if opErr := op(e); opErr != nil {
+		return opErr
 	}
-	_, c := st.Pop()
-	el := c.(element)
-	return decodeNum(el) != 0
+	return verify(e, err)
+}
+
+func opVerify(e *Engine) error {
+	return verify(e, ErrVerify)
 }
 
-func opEqualverify(st, altst *stack, cmds Script, sighash []byte) bool {
-	return opEqual(st, altst, cmds, sighash) && opVerify(st, altst, cmds, sighash)
+func opEqualverify(e *Engine) error {
+	return verifyAfter(e, opEqual, ErrEqualVerify)
 }
 
-func opChecksig(st, _ *stack, _ Script, sighash []byte) bool {
-	if len(*st) < 2 {
-		return false
+// popNum pops an arithmetic operand. It has to be minimally encoded under VerifyMinimalData.
+func popNum(e *Engine) (ScriptNum, error) {
+	if len(e.stack) < 1 {
+		return 0, ErrInvalidStackOperation
 	}
+	el, _ := e.stack.Pop()
+	n, err := ParseScriptNum(el, e.flags&VerifyMinimalData != 0, maxNumSize)
+	if err != nil {
+		return 0, ErrUnknown
+	}
+	return n, nil
+}
+
+// unaryOp replaces the top of the stack a with f(a).
+func unaryOp(e *Engine, f func(a ScriptNum) ScriptNum) error {
+	a, err := popNum(e)
+	if err != nil {
+		return err
+	}
+	e.stack.Push(element(f(a).Bytes()))
+	return nil
+}
+
+// binaryOp replaces the two numbers on top of the stack a b with f(a, b).
+func binaryOp(e *Engine, f func(a, b ScriptNum) ScriptNum) error {
+	if len(e.stack) < 2 {
+		return ErrInvalidStackOperation
+	}
+	b, err := popNum(e)
+	if err != nil {
+		return err
+	}
+	a, err := popNum(e)
+	if err != nil {
+		return err
+	}
+	e.stack.Push(element(f(a, b).Bytes()))
+	return nil
+}
+
+func op1add(e *Engine) error {
+	return unaryOp(e, func(a ScriptNum) ScriptNum { return a + 1 })
+}
+
+func op1sub(e *Engine) error {
+	return unaryOp(e, func(a ScriptNum) ScriptNum { return a - 1 })
+}
+
+func opNegate(e *Engine) error {
+	return unaryOp(e, func(a ScriptNum) ScriptNum { return -a })
+}
+
+func opAbs(e *Engine) error {
+	return unaryOp(e, func(a ScriptNum) ScriptNum {
+		if a < 0 {
+			return -a
+		}
+		return a
+	})
+}
+
+func opNot(e *Engine) error {
+	return unaryOp(e, func(a ScriptNum) ScriptNum { return boolNum(a == 0) })
+}
+
+func op0notequal(e *Engine) error {
+	return unaryOp(e, func(a ScriptNum) ScriptNum { return boolNum(a != 0) })
+}
+
+func opAdd(e *Engine) error {
+	return binaryOp(e, func(a, b ScriptNum) ScriptNum { return a + b })
+}
+
+func opSub(e *Engine) error {
+	return binaryOp(e, func(a, b ScriptNum) ScriptNum { return a - b })
+}
+
+func opBooland(e *Engine) error {
+	return binaryOp(e, func(a, b ScriptNum) ScriptNum { return boolNum(a != 0 && b != 0) })
+}
+
+func opBoolor(e *Engine) error {
+	return binaryOp(e, func(a, b ScriptNum) ScriptNum { return boolNum(a != 0 || b != 0) })
+}
 
-	_, c := st.Pop()
-	secPubKey := c.(element)
-	_, c = st.Pop()
-	derSig := c.(element)
-	derSig = derSig[:len(derSig)-1] // last byte is the HashType
+func opNumequal(e *Engine) error {
+	return binaryOp(e, func(a, b ScriptNum) ScriptNum { return boolNum(a == b) })
+}
+
+func opNumequalverify(e *Engine) error {
+	return verifyAfter(e, opNumequal, ErrNumEqualVerify)
+}
+
+func opNumnotequal(e *Engine) error {
+	return binaryOp(e, func(a, b ScriptNum) ScriptNum { return boolNum(a != b) })
+}
+
+func opLessthan(e *Engine) error {
+	return binaryOp(e, func(a, b ScriptNum) ScriptNum { return boolNum(a < b) })
+}
+
+func opGreaterthan(e *Engine) error {
+	return binaryOp(e, func(a, b ScriptNum) ScriptNum { return boolNum(a > b) })
+}
+
+func opLessthanorequal(e *Engine) error {
+	return binaryOp(e, func(a, b ScriptNum) ScriptNum { return boolNum(a <= b) })
+}
+
+func opGreaterthanorequal(e *Engine) error {
+	return binaryOp(e, func(a, b ScriptNum) ScriptNum { return boolNum(a >= b) })
+}
+
+func opMin(e *Engine) error {
+	return binaryOp(e, func(a, b ScriptNum) ScriptNum {
+		if a < b {
+			return a
+		}
+		return b
+	})
+}
 
-	pubKey := new(ecdsa.PublicKey)
-	pubKey.Curve = elliptic.Secp256k1
-	pubKey.Unmarshal(secPubKey)
-	sig, err := new(ecdsa.Signature).Unmarshal(derSig)
+func opMax(e *Engine) error {
+	return binaryOp(e, func(a, b ScriptNum) ScriptNum {
+		if a > b {
+			return a
+		}
+		return b
+	})
+}
+
+// opWithin pushes whether x is in the range [min, max):
+//
+//	`<x> <min> <max> OP_WITHIN`
+func opWithin(e *Engine) error {
+	if len(e.stack) < 3 {
+		return ErrInvalidStackOperation
+	}
+	max, err := popNum(e)
+	if err != nil {
+		return err
+	}
+	min, err := popNum(e)
+	if err != nil {
+		return err
+	}
+	x, err := popNum(e)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	e.stack.Push(element(boolNum(min <= x && x < max).Bytes()))
+	return nil
+}
+
+// subscript returns the script code signatures commit to. In legacy scripts the signatures
+// are removed from it, since a signature can't commit to itself.
+func (e *Engine) subscript(sigs ...[]byte) Script {
+	if e.sigVersion != SigVersionBase {
+		return e.scriptCode
+	}
+	s := Script{}
+	for _, cmd := range e.scriptCode {
+		isSig := false
+		if el, ok := cmd.(element); ok {
+			for _, sig := range sigs {
+				isSig = isSig || bytes.Equal(el, sig)
+			}
+		}
+		if !isSig {
+			s = append(s, cmd)
+		}
+	}
+	return s
+}
 
-	if sig.Verify(pubKey, sighash) {
-		st.Push(encodeNum(1))
+// checkSig returns whether sig, which ends with the sighash type byte,
+// is a valid signature of the transaction by the SEC encoded public key.
+// Returns error if the encoding of either is not allowed by the flags.
+func checkSig(e *Engine, sig, secPubKey []byte, scriptCode Script) (bool, error) {
+	if err := checkSignatureEncoding(e, sig); err != nil {
+		return false, err
+	}
+	if err := checkPubKeyEncoding(e, secPubKey); err != nil {
+		return false, err
+	}
+	if len(sig) == 0 {
+		return false, nil
+	}
+	return e.checker.CheckSig(sig, secPubKey, scriptCode, e.sigVersion), nil
+}
+
+// checkSchnorrSig verifies the BIP340 signature of the transaction by the x-only public key.
+// The signature may be followed by the hash type byte, see BIP341.
+func checkSchnorrSig(e *Engine, sig, pubKey []byte) error {
+	switch len(sig) {
+	case 64:
+	case 65:
+		// SIGHASH_DEFAULT can only be implied, by omitting the hash type byte
+		switch sig[64] {
+		case 0x01, 0x02, 0x03, 0x81, 0x82, 0x83:
+		default:
+			return ErrSchnorrSigHashType
+		}
+	default:
+		return ErrSchnorrSigSize
+	}
+	if !e.checker.CheckSchnorrSig(sig, pubKey, e.sigVersion, &e.execData) {
+		return ErrSchnorrSig
+	}
+	return nil
+}
+
+// checkSigTapscript returns whether the signature is non-empty, see BIP342.
+// Returns error if the signature is invalid, the public key is empty
+// or the signature checks exceed the budget. Public keys which are not 32 bytes
+// are of unknown types, reserved for soft-fork upgrades, and their signatures are accepted.
+func checkSigTapscript(e *Engine, sig, pubKey []byte) (bool, error) {
+	success := len(sig) > 0
+	if success {
+		e.sigOpsBudget -= tapscriptSigOpCost
+		if e.sigOpsBudget < 0 {
+			return false, ErrTapscriptValidationWeight
+		}
+	}
+	if len(pubKey) == 0 {
+		return false, ErrPubKeyType
+	}
+	if len(pubKey) == 32 && success {
+		if err := checkSchnorrSig(e, sig, pubKey); err != nil {
+			return false, err
+		}
+	}
+	return success, nil
+}
+
+func opChecksig(e *Engine) error {
+	if len(e.stack) < 2 {
+		return ErrInvalidStackOperation
+	}
+	pubKey, _ := e.stack.Pop()
+	sig, _ := e.stack.Pop()
+	var success bool
+	var err error
+	if e.sigVersion == SigVersionTapscript {
+		success, err = checkSigTapscript(e, sig, pubKey)
 	} else {
-		st.Push(encodeNum(0))
+		success, err = checkSig(e, sig, pubKey, e.subscript(sig))
+		if err == nil && !success && len(sig) > 0 && e.flags&VerifyNullFail != 0 {
+			err = ErrSigNullFail
+		}
+	}
+	if err != nil {
+		return err
+	}
+	e.stack.Push(element(boolNum(success).Bytes()))
+	return nil
+}
+
+func opChecksigverify(e *Engine) error {
+	return verifyAfter(e, opChecksig, ErrCheckSigVerify)
+}
+
+// opCheckmultisig checks m of the n signatures:
+//     `<dummy> <sig 1> ... <sig m> <m> <pubkey 1> ... <pubkey n> <n> OP_CHECKMULTISIG`
+// The signatures have to be in the same order as their public keys.
+// Because of an off-by-one error in the original implementation, an extra dummy element is
+// consumed, which has to be empty under VerifyNullDummy.
+// It is disabled in tapscripts, in favour of OP_CHECKSIGADD.
+func opCheckmultisig(e *Engine) error {
+	if e.sigVersion == SigVersionTapscript {
+		return ErrTapscriptCheckMultisig
+	}
+	i := 0 // depth of the next argument
+	if len(e.stack) < i+1 {
+		return ErrInvalidStackOperation
+	}
+	n, err := ParseScriptNum(e.stack.at(i), e.flags&VerifyMinimalData != 0, maxNumSize)
+	if err != nil {
+		return ErrUnknown
+	}
+	if n < 0 || n > MaxPubKeysPerMultisig {
+		return ErrPubKeyCount
+	}
+	// each of the public keys counts as an opcode
+	e.opCount += int(n)
+	if e.opCount > MaxOpsPerScript {
+		return ErrOpCount
+	}
+	i++
+	keys := i
+	i += int(n)
+	if len(e.stack) < i+1 {
+		return ErrInvalidStackOperation
 	}
-	return true
+	m, err := ParseScriptNum(e.stack.at(i), e.flags&VerifyMinimalData != 0, maxNumSize)
+	if err != nil {
+		return ErrUnknown
+	}
+	if m < 0 || m > n {
+		return ErrSigCount
+	}
+	i++
+	firstSig := i
+	i += int(m)
+	// one more for the dummy
+	if len(e.stack) < i+1 {
+		return ErrInvalidStackOperation
+	}
+
+	var sigElements [][]byte
+	for j := firstSig; j < i; j++ {
+		sigElements = append(sigElements, e.stack.at(j))
+	}
+	scriptCode := e.subscript(sigElements...)
+
+	// the keys are tried from the last to the first, each signature against the remaining keys
+	success := true
+	sigs := firstSig
+	for success && m > 0 {
+		ok, err := checkSig(e, e.stack.at(sigs), e.stack.at(keys), scriptCode)
+		if err != nil {
+			return err
+		}
+		if ok {
+			sigs++
+			m--
+		}
+		keys++
+		n--
+		// there are more signatures left than keys
+		if m > n {
+			success = false
+		}
+	}
+	if !success && e.flags&VerifyNullFail != 0 {
+		for _, sig := range sigElements {
+			if len(sig) > 0 {
+				return ErrSigNullFail
+			}
+		}
+	}
+
+	e.stack = e.stack[:len(e.stack)-i]
+	dummy, _ := e.stack.Pop()
+	if e.flags&VerifyNullDummy != 0 && len(dummy) != 0 {
+		return ErrSigNullDummy
+	}
+	e.stack.Push(element(boolNum(success).Bytes()))
+	return nil
+}
+
+func opCheckmultisigverify(e *Engine) error {
+	return verifyAfter(e, opCheckmultisig, ErrCheckMultisigVerify)
+}
+
+// peekLockTime reads the lock time operand of the timelock opcodes, without popping it.
+// Unlike the arithmetic operands, it can be 5 bytes long, to cover all of the uint32 lock times.
+func peekLockTime(e *Engine) (ScriptNum, error) {
+	top, err := e.stack.Peek()
+	if err != nil {
+		return 0, err
+	}
+	n, err := ParseScriptNum(top, e.flags&VerifyMinimalData != 0, 5)
+	if err != nil {
+		return 0, ErrUnknown
+	}
+	if n < 0 {
+		return 0, ErrNegativeLockTime
+	}
+	return n, nil
+}
+
+// opChecklocktimeverify fails unless the transaction is locked until at least the top of the stack,
+// see BIP65. Before VerifyCheckLockTimeVerify it is OP_NOP2,
+// which is not discouraged by VerifyDiscourageUpgradableNops.
+func opChecklocktimeverify(e *Engine) error {
+	if e.flags&VerifyCheckLockTimeVerify == 0 {
+		return nil
+	}
+	lockTime, err := peekLockTime(e)
+	if err != nil {
+		return err
+	}
+	if !e.checker.CheckLockTime(lockTime) {
+		return ErrUnsatisfiedLockTime
+	}
+	return nil
+}
+
+// opChecksequenceverify fails unless the input is locked for at least the relative lock time
+// on the top of the stack, see BIP112. Before VerifyCheckSequenceVerify it is OP_NOP3,
+// which is not discouraged by VerifyDiscourageUpgradableNops.
+func opChecksequenceverify(e *Engine) error {
+	if e.flags&VerifyCheckSequenceVerify == 0 {
+		return nil
+	}
+	sequence, err := peekLockTime(e)
+	if err != nil {
+		return err
+	}
+	// the disable flag is reserved for soft-fork upgrades
+	if sequence&SequenceLockTimeDisableFlag != 0 {
+		return nil
+	}
+	if !e.checker.CheckSequence(sequence) {
+		return ErrUnsatisfiedLockTime
+	}
+	return nil
+}
+
+// opChecksigadd adds the result of a signature check to a number:
+//     `<sig> <n> <pubkey> OP_CHECKSIGADD` -> `<n + 1>` if the signature is not empty, `<n>` otherwise
+// It is only available in tapscripts, see BIP342.
+func opChecksigadd(e *Engine) error {
+	if e.sigVersion != SigVersionTapscript {
+		return ErrBadOpcode
+	}
+	if len(e.stack) < 3 {
+		return ErrInvalidStackOperation
+	}
+	pubKey, _ := e.stack.Pop()
+	n, err := popNum(e)
+	if err != nil {
+		return err
+	}
+	sig, _ := e.stack.Pop()
+	success, err := checkSigTapscript(e, sig, pubKey)
+	if err != nil {
+		return err
+	}
+	e.stack.Push(element((n + boolNum(success)).Bytes()))
+	return nil
+}
+
+// isOpSuccess returns whether the opcode is one of the OP_SUCCESSx of tapscripts,
+// which are reserved for soft-fork upgrades, see BIP342.
+func isOpSuccess(op opcode) bool {
+	return op == 80 || op == 98 || (126 <= op && op <= 129) || (131 <= op && op <= 134) ||
+		(137 <= op && op <= 138) || (141 <= op && op <= 142) || (149 <= op && op <= 153) ||
+		(187 <= op && op <= 254)
 }
 
 const (
@@ -265,99 +934,142 @@ const (
 	OP_NOP9
 	OP_NOP10
 	//
+	// Tapscript:
+	OP_CHECKSIGADD
+	//
 	// Those are all the OPs as of 2021.
 )
 
+// The reserved opcodes, which fail the script when executed.
+// OP_VERIF and OP_VERNOTIF fail it even in branches which are not taken.
+const (
+	OP_RESERVED  = opcode(80)
+	OP_VER       = opcode(98)
+	OP_VERIF     = opcode(101)
+	OP_VERNOTIF  = opcode(102)
+	OP_RESERVED1 = opcode(137)
+	OP_RESERVED2 = opcode(138)
+)
+
+// The disabled opcodes, which fail the script even in branches which are not taken, see CVE-2010-5137.
+const (
+	OP_CAT = opcode(iota + 126)
+	OP_SUBSTR
+	OP_LEFT
+	OP_RIGHT
+	OP_INVERT = opcode(iota + 127)
+	OP_AND
+	OP_OR
+	OP_XOR
+	OP_2MUL = opcode(iota + 133)
+	OP_2DIV
+	OP_MUL = opcode(iota + 139)
+	OP_DIV
+	OP_MOD
+	OP_LSHIFT
+	OP_RSHIFT
+)
+
+// isDisabled returns whether the opcode is one of the disabled opcodes.
+func isDisabled(op opcode) bool {
+	return (OP_CAT <= op && op <= OP_RIGHT) || (OP_INVERT <= op && op <= OP_XOR) ||
+		op == OP_2MUL || op == OP_2DIV || (OP_MUL <= op && op <= OP_RSHIFT)
+}
+
 var OpcodeFunctions = map[opcode]operation{
 	OP_0: op0,
 	// 76: opPushdata1,
 	// 77: opPushdata2,
 	// 78: opPushdata4,
-	// 79:  op1negate,
-	// 81:  op1,
-	// 82:  op2,
-	// 83:  op3,
-	// 84:  op4,
-	// 85:  op5,
-	// 86:  op6,
-	// 87:  op7,
-	// 88:  op8,
-	// 89:  op9,
-	// 90:  op10,
-	// 91:  op11,
-	// 92:  op12,
-	// 93:  op13,
-	// 94:  op14,
-	// 95:  op15,
-	// 96:  op16,
-	// 97:  opNop,
-	// 99:  opIf,
-	// 100: opNotif,
-	// 103: opElse,
-	// 104: opEndif,
-	OP_VERIFY: opVerify,
-	// 106: opReturn,
-	// 107: opToaltstack,
-	// 108: opFromaltstack,
-	// 109: op2drop,
-	// 110: op2dup,
-	// 111: op3dup,
-	// 112: op2over,
-	// 113: op2rot,
-	// 114: op2swap,
-	// 115: opIfdup,
-	// 116: opDepth,
-	// 117: opDrop,
-	OP_DUP: opDup,
-	// 119: opNip,
-	// 120: opOver,
-	// 121: opPick,
-	// 122: opRoll,
-	// 123: opRot,
-	// 124: opSwap,
-	// 125: opTuck,
-	// 130: opSize,
-	OP_EQUAL:       opEqual,
-	OP_EQUALVERIFY: opEqualverify,
-	// 139: op1add,
-	// 140: op1sub,
-	// 143: opNegate,
-	// 144: opAbs,
-	// 145: opNot,
-	// 146: op0notequal,
-	// 147: opAdd,
-	// 148: opSub,
-	// 154: opBooland,
-	// 155: opBoolor,
-	// 156: opNumequal,
-	// 157: opNumequalverify,
-	// 158: opNumnotequal,
-	// 159: opLessthan,
-	// 160: opGreaterthan,
-	// 161: opLessthanorequal,
-	// 162: opGreaterthanorequal,
-	// 163: opMin,
-	// 164: opMax,
-	// 165: opWithin,
-	// 166: opRipemd160,
-	// 167: opSha1,
-	// 168: opSha256,
-	OP_HASH160: opHash160,
-	// 170: opHash256,
-	OP_CHECKSIG: opChecksig,
-	// 173: opChecksigverify,
-	// 174: opCheckmultisig,
-	// 175: opCheckmultisigverify,
-	// 176: opNop,
-	// 177: opChecklocktimeverify,
-	// 178: opChecksequenceverify,
-	// 179: opNop,
-	// 180: opNop,
-	// 181: opNop,
-	// 182: opNop,
-	// 183: opNop,
-	// 184: opNop,
-	// 185: opNop,
+	OP_1NEGATE:             opSmallInt(-1),
+	OP_1:                   opSmallInt(1),
+	OP_2:                   opSmallInt(2),
+	OP_3:                   opSmallInt(3),
+	OP_4:                   opSmallInt(4),
+	OP_5:                   opSmallInt(5),
+	OP_6:                   opSmallInt(6),
+	OP_7:                   opSmallInt(7),
+	OP_8:                   opSmallInt(8),
+	OP_9:                   opSmallInt(9),
+	OP_10:                  opSmallInt(10),
+	OP_11:                  opSmallInt(11),
+	OP_12:                  opSmallInt(12),
+	OP_13:                  opSmallInt(13),
+	OP_14:                  opSmallInt(14),
+	OP_15:                  opSmallInt(15),
+	OP_16:                  opSmallInt(16),
+	OP_NOP:                 opNop,
+	OP_IF:                  opIf,
+	OP_NOTIF:               opNotif,
+	OP_ELSE:                opElse,
+	OP_ENDIF:               opEndif,
+	OP_VERIFY:              opVerify,
+	OP_RETURN:              opReturn,
+	OP_TOALTSTACK:          opToaltstack,
+	OP_FROMALTSTACK:        opFromaltstack,
+	OP_2DROP:               op2drop,
+	OP_2DUP:                op2dup,
+	OP_3DUP:                op3dup,
+	OP_2OVER:               op2over,
+	OP_2ROT:                op2rot,
+	OP_2SWAP:               op2swap,
+	OP_IFDUP:               opIfdup,
+	OP_DEPTH:               opDepth,
+	OP_DROP:                opDrop,
+	OP_DUP:                 opDup,
+	OP_NIP:                 opNip,
+	OP_OVER:                opOver,
+	OP_PICK:                opPick,
+	OP_ROLL:                opRoll,
+	OP_ROT:                 opRot,
+	OP_SWAP:                opSwap,
+	OP_TUCK:                opTuck,
+	OP_SIZE:                opSize,
+	OP_EQUAL:               opEqual,
+	OP_EQUALVERIFY:         opEqualverify,
+	OP_1ADD:                op1add,
+	OP_1SUB:                op1sub,
+	OP_NEGATE:              opNegate,
+	OP_ABS:                 opAbs,
+	OP_NOT:                 opNot,
+	OP_0NOTEQUAL:           op0notequal,
+	OP_ADD:                 opAdd,
+	OP_SUB:                 opSub,
+	OP_BOOLAND:             opBooland,
+	OP_BOOLOR:              opBoolor,
+	OP_NUMEQUAL:            opNumequal,
+	OP_NUMEQUALVERIFY:      opNumequalverify,
+	OP_NUMNOTEQUAL:         opNumnotequal,
+	OP_LESSTHAN:            opLessthan,
+	OP_GREATERTHAN:         opGreaterthan,
+	OP_LESSTHANOREQUAL:     opLessthanorequal,
+	OP_GREATERTHANOREQUAL:  opGreaterthanorequal,
+	OP_MIN:                 opMin,
+	OP_MAX:                 opMax,
+	OP_WITHIN:              opWithin,
+	OP_RIPEMD160:           opRipemd160,
+	OP_SHA1:                opSha1,
+	OP_SHA256:              opSha256,
+	OP_HASH160:             opHash160,
+	OP_HASH256:             opHash256,
+	OP_CODESEPARATOR:       opCodeseparator,
+	OP_CHECKSIG:            opChecksig,
+	OP_CHECKSIGVERIFY:      opChecksigverify,
+	OP_CHECKMULTISIG:       opCheckmultisig,
+	OP_CHECKMULTISIGVERIFY: opCheckmultisigverify,
+	OP_NOP1:                opUpgradableNop,
+	OP_CHECKLOCKTIMEVERIFY: opChecklocktimeverify,
+	OP_CHECKSEQUENCEVERIFY: opChecksequenceverify,
+	OP_NOP4:                opUpgradableNop,
+	OP_NOP5:                opUpgradableNop,
+	OP_NOP6:                opUpgradableNop,
+	OP_NOP7:                opUpgradableNop,
+	OP_NOP8:                opUpgradableNop,
+	OP_NOP9:                opUpgradableNop,
+	OP_NOP10:               opUpgradableNop,
+	//
+	// Tapscript:
+	OP_CHECKSIGADD: opChecksigadd,
 }
 
 var OpcodeNames = map[opcode]string{
@@ -370,6 +1082,7 @@ var OpcodeNames = map[opcode]string{
 	OP_PUSHDATA4: "OP_PUSHDATA4",
 	OP_1NEGATE:   "OP_1NEGATE",
 	// 80: reserved
+	80: "OP_RESERVED",
 	81: "OP_1",
 	82: "OP_2",
 	83: "OP_3",
@@ -390,10 +1103,13 @@ var OpcodeNames = map[opcode]string{
 	// Flow control:
 	97: "OP_NOP",
 	// 98: reserved
+	98:  "OP_VER",
 	99:  "OP_IF",
 	100: "OP_NOTIF",
 	// 101: reserved
 	// 102: reserved
+	101:       "OP_VERIF",
+	102:       "OP_VERNOTIF",
 	103:       "OP_ELSE",
 	104:       "OP_ENDIF",
 	OP_VERIFY: "OP_VERIFY",
@@ -421,28 +1137,40 @@ var OpcodeNames = map[opcode]string{
 	125:    "OP_TUCK",
 	//
 	// Splice:
-	// 126-129: disabled
+	126: "OP_CAT",    // disabled
+	127: "OP_SUBSTR", // disabled
+	128: "OP_LEFT",   // disabled
+	129: "OP_RIGHT",  // disabled
 	130: "OP_SIZE",
 	//
 	// Bitwise logic:
-	// 131-134: disabled
+	131:            "OP_INVERT", // disabled
+	132:            "OP_AND",    // disabled
+	133:            "OP_OR",     // disabled
+	134:            "OP_XOR",    // disabled
 	OP_EQUAL:       "OP_EQUAL",
 	OP_EQUALVERIFY: "OP_EQUALVERIFY",
 	// 137: reserved
 	// 138: reserved
+	137: "OP_RESERVED1",
+	138: "OP_RESERVED2",
 	//
 	// Arithmetic:
 	139: "OP_1ADD",
 	140: "OP_1SUB",
-	// 141: disabled
-	// 142: disabled
+	141: "OP_2MUL", // disabled
+	142: "OP_2DIV", // disabled
 	143: "OP_NEGATE",
 	144: "OP_ABS",
 	145: "OP_NOT",
 	146: "OP_0NOTEQUAL",
 	147: "OP_ADD",
 	148: "OP_SUB",
-	// 149-153: disabled
+	149: "OP_MUL",    // disabled
+	150: "OP_DIV",    // disabled
+	151: "OP_MOD",    // disabled
+	152: "OP_LSHIFT", // disabled
+	153: "OP_RSHIFT", // disabled
 	154: "OP_BOOLAND",
 	155: "OP_BOOLOR",
 	156: "OP_NUMEQUAL",
@@ -484,5 +1212,8 @@ var OpcodeNames = map[opcode]string{
 	184: "OP_NOP9",
 	185: "OP_NOP10",
 	//
+	// Tapscript:
+	186: "OP_CHECKSIGADD",
+	//
 	// Those are all the OPs as of 2021.
 }
@@ -0,0 +1,282 @@
+package script
+
+import (
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/VIVelev/btcd/crypto/ecdsa"
+	"github.com/VIVelev/btcd/crypto/elliptic"
+	"github.com/VIVelev/btcd/crypto/hash"
+)
+
+// execute runs the commands and returns the resulting stack, nil if the script fails.
+func execute(cmds ...command) []command {
+	e := newEngine(nil, 0)
+	if e.execute(cmds) != nil {
+		return nil
+	}
+	stack := []command{}
+	for _, el := range e.stack {
+		stack = append(stack, el)
+	}
+	return stack
+}
+
+func equalStacks(a, b []command) bool {
+	if a == nil || len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestStackOps(t *testing.T) {
+	x1, x2, x3, x4, x5, x6 := element{1}, element{2}, element{3}, element{4}, element{5}, element{6}
+	for _, tc := range []struct {
+		cmds []command
+		want []command
+	}{
+		{[]command{x1, OP_TOALTSTACK, x2, OP_FROMALTSTACK}, []command{x2, x1}},
+		{[]command{x1, x2, x3, OP_2DROP}, []command{x1}},
+		{[]command{x1, x2, OP_2DUP}, []command{x1, x2, x1, x2}},
+		{[]command{x1, x2, x3, OP_3DUP}, []command{x1, x2, x3, x1, x2, x3}},
+		{[]command{x1, x2, x3, x4, OP_2OVER}, []command{x1, x2, x3, x4, x1, x2}},
+		{[]command{x1, x2, x3, x4, x5, x6, OP_2ROT}, []command{x3, x4, x5, x6, x1, x2}},
+		{[]command{x1, x2, x3, x4, OP_2SWAP}, []command{x3, x4, x1, x2}},
+		{[]command{x1, OP_IFDUP}, []command{x1, x1}},
+		{[]command{element{}, OP_IFDUP}, []command{element{}}},
+		{[]command{OP_DEPTH, x1, x2, OP_DEPTH}, []command{element{}, x1, x2, x3}},
+		{[]command{x1, x2, OP_DROP}, []command{x1}},
+		{[]command{x1, OP_DUP}, []command{x1, x1}},
+		{[]command{x1, x2, OP_NIP}, []command{x2}},
+		{[]command{x1, x2, OP_OVER}, []command{x1, x2, x1}},
+		{[]command{x1, x2, x3, element{2}, OP_PICK}, []command{x1, x2, x3, x1}},
+		{[]command{x1, x2, x3, element{}, OP_PICK}, []command{x1, x2, x3, x3}},
+		{[]command{x1, x2, x3, element{2}, OP_ROLL}, []command{x2, x3, x1}},
+		{[]command{x1, x2, x3, OP_ROT}, []command{x2, x3, x1}},
+		{[]command{x1, x2, OP_SWAP}, []command{x2, x1}},
+		{[]command{x1, x2, OP_TUCK}, []command{x2, x1, x2}},
+		{[]command{element{1, 2, 3}, OP_SIZE}, []command{element{1, 2, 3}, x3}},
+	} {
+		if got := execute(tc.cmds...); !equalStacks(got, tc.want) {
+			t.Errorf("FAIL: %v", Script(tc.cmds))
+		}
+	}
+}
+
+func TestStackOpsUnderflow(t *testing.T) {
+	x := element{1}
+	for _, cmds := range [][]command{
+		{OP_TOALTSTACK},
+		{x, OP_FROMALTSTACK},
+		{x, OP_2DROP},
+		{x, OP_2DUP},
+		{x, x, OP_3DUP},
+		{x, x, x, OP_2OVER},
+		{x, x, x, x, x, OP_2ROT},
+		{x, x, x, OP_2SWAP},
+		{OP_IFDUP},
+		{OP_DROP},
+		{OP_DUP},
+		{x, OP_NIP},
+		{x, OP_OVER},
+		{x, x, element{2}, OP_PICK},
+		{x, x, element{0x81}, OP_PICK},
+		{x, element{}, element{1, 0, 0, 0, 0}, OP_PICK},
+		{x, x, element{2}, OP_ROLL},
+		{x, x, OP_ROT},
+		{x, OP_SWAP},
+		{x, OP_TUCK},
+		{OP_SIZE},
+	} {
+		if execute(cmds...) != nil {
+			t.Errorf("FAIL: %v", Script(cmds))
+		}
+	}
+}
+
+func num(n ScriptNum) element {
+	return element(n.Bytes())
+}
+
+func TestArithmeticOps(t *testing.T) {
+	for _, tc := range []struct {
+		cmds []command
+		want command
+	}{
+		{[]command{OP_1NEGATE}, num(-1)},
+		{[]command{OP_16}, num(16)},
+		{[]command{OP_5, OP_1ADD}, num(6)},
+		{[]command{OP_5, OP_1SUB}, num(4)},
+		{[]command{OP_5, OP_NEGATE}, num(-5)},
+		{[]command{num(-5), OP_ABS}, num(5)},
+		{[]command{OP_0, OP_NOT}, num(1)},
+		{[]command{OP_2, OP_NOT}, num(0)},
+		{[]command{element{0x80}, OP_NOT}, num(1)},
+		{[]command{OP_2, OP_0NOTEQUAL}, num(1)},
+		{[]command{OP_2, OP_3, OP_ADD}, num(5)},
+		{[]command{OP_2, OP_3, OP_SUB}, num(-1)},
+		{[]command{OP_2, OP_0, OP_BOOLAND}, num(0)},
+		{[]command{OP_2, OP_0, OP_BOOLOR}, num(1)},
+		{[]command{OP_2, num(2), OP_NUMEQUAL}, num(1)},
+		{[]command{OP_2, OP_3, OP_NUMNOTEQUAL}, num(1)},
+		{[]command{OP_2, OP_3, OP_LESSTHAN}, num(1)},
+		{[]command{OP_2, OP_3, OP_GREATERTHAN}, num(0)},
+		{[]command{OP_3, OP_3, OP_LESSTHANOREQUAL}, num(1)},
+		{[]command{OP_2, OP_3, OP_GREATERTHANOREQUAL}, num(0)},
+		{[]command{OP_2, OP_3, OP_MIN}, num(2)},
+		{[]command{OP_2, OP_3, OP_MAX}, num(3)},
+		{[]command{OP_2, OP_2, OP_3, OP_WITHIN}, num(1)},
+		{[]command{OP_3, OP_2, OP_3, OP_WITHIN}, num(0)},
+		// results may overflow 4 bytes
+		{[]command{num(math.MaxInt32), num(math.MaxInt32), OP_ADD}, num(2 * math.MaxInt32)},
+	} {
+		got := execute(tc.cmds...)
+		if len(got) != 1 || !got[0].Equal(tc.want) {
+			t.Errorf("FAIL: %v", Script(tc.cmds))
+		}
+	}
+}
+
+func TestArithmeticOpsInvalid(t *testing.T) {
+	for _, cmds := range [][]command{
+		{OP_1ADD},
+		{OP_1, OP_ADD},
+		{OP_1, OP_2, OP_WITHIN},
+		{element{1, 2, 3, 4, 5}, OP_1ADD},
+		{num(math.MaxInt32), num(math.MaxInt32), OP_ADD, OP_1ADD},
+		{OP_1, OP_2, OP_NUMEQUALVERIFY},
+	} {
+		if execute(cmds...) != nil {
+			t.Errorf("FAIL: %v", Script(cmds))
+		}
+	}
+
+	// non-minimal operands
+	if !run(Script{element{1, 0}, OP_1ADD}, nil, 0) {
+		t.Errorf("FAIL")
+	}
+	if run(Script{element{1, 0}, OP_1ADD}, nil, VerifyMinimalData) {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestHashOps(t *testing.T) {
+	for op, want := range map[opcode]string{
+		OP_RIPEMD160: "9c1185a5c5e9fc54612808977ee8f548b2258d31",
+		OP_SHA1:      "da39a3ee5e6b4b0d3255bfef95601890afd80709",
+		OP_SHA256:    "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		OP_HASH160:   "b472a266d0bd89c13706a4132ccfb16f7c3b9fcb",
+		OP_HASH256:   "5df6e0e2761359d30a8275058e299fcc0381534545f55cf43e41983f5d4c9456",
+	} {
+		got := execute(OP_0, op)
+		if len(got) != 1 || got[0].String() != want {
+			t.Errorf("FAIL: %s", op)
+		}
+		if execute(op) != nil {
+			t.Errorf("FAIL: %s", op)
+		}
+	}
+}
+
+func TestCodeseparator(t *testing.T) {
+	s := Script{OP_1, OP_CODESEPARATOR, OP_2, OP_0, OP_IF, OP_CODESEPARATOR, OP_ENDIF, OP_3}
+	e := newEngine(nil, 0)
+	if e.execute(s) != nil || len(e.scriptCode) != 6 || e.scriptCode[0] != OP_2 {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestUpgradableNops(t *testing.T) {
+	for _, op := range []opcode{OP_NOP, OP_NOP1, OP_NOP4, OP_NOP10} {
+		if !run(Script{OP_1, op}, nil, 0) {
+			t.Errorf("FAIL: %s", op)
+		}
+		want := op == OP_NOP
+		if run(Script{OP_1, op}, nil, VerifyDiscourageUpgradableNops) != want {
+			t.Errorf("FAIL: %s", op)
+		}
+	}
+	// not executed
+	if !run(Script{OP_1, OP_0, OP_IF, OP_NOP1, OP_ENDIF}, nil, VerifyDiscourageUpgradableNops) {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestCheckmultisig(t *testing.T) {
+	sighash := hash.Sha256([]byte("multisig"))
+	var pubKeys [][]byte
+	var sigs []element
+	for i := int64(1); i <= 3; i++ {
+		priv := ecdsa.GenerateKeyFromSecret(elliptic.Secp256k1, big.NewInt(i))
+		pubKeys = append(pubKeys, priv.PublicKey.MarshalCompressed())
+		sigs = append(sigs, append(priv.Sign(sighash[:]).Marshal(), 0x01))
+	}
+	multi, _ := NewMultisigScript(2, pubKeys)
+
+	for _, tc := range []struct {
+		scriptSig Script
+		flags     Flags
+		want      bool
+	}{
+		{Script{OP_0, sigs[0], sigs[1]}, VerifyNullDummy, true},
+		{Script{OP_0, sigs[0], sigs[2]}, VerifyNullDummy, true},
+		{Script{OP_0, sigs[1], sigs[2]}, VerifyNullDummy, true},
+		// the signatures have to be in the order of the keys
+		{Script{OP_0, sigs[1], sigs[0]}, VerifyNullDummy, false},
+		{Script{OP_0, sigs[0], sigs[0]}, VerifyNullDummy, false},
+		{Script{OP_0, sigs[0]}, VerifyNullDummy, false},
+		// the dummy
+		{Script{sigs[0], sigs[1]}, 0, false},
+		{Script{OP_1, sigs[0], sigs[1]}, 0, true},
+		{Script{OP_1, sigs[0], sigs[1]}, VerifyNullDummy, false},
+	} {
+		s := tc.scriptSig.Add(multi...)
+		if run(s, &MockChecker{Sighash: sighash[:]}, tc.flags) != tc.want {
+			t.Errorf("FAIL: %v", tc.scriptSig)
+		}
+	}
+
+	// 0-of-0
+	if !run(Script{OP_0, OP_0, OP_0, OP_CHECKMULTISIG}, nil, 0) {
+		t.Errorf("FAIL")
+	}
+	// invalid counts
+	for _, s := range []Script{
+		{OP_0, OP_0, OP_1NEGATE, OP_CHECKMULTISIG},
+		{OP_0, OP_0, element{21}, OP_CHECKMULTISIG},
+		{OP_0, OP_1, OP_0, OP_CHECKMULTISIG},
+		{OP_0, OP_1, element(pubKeys[0]), OP_1, OP_CHECKMULTISIG},
+		{OP_0, OP_0, OP_CHECKMULTISIGVERIFY, OP_1},
+	} {
+		if run(s, nil, 0) {
+			t.Errorf("FAIL: %v", s)
+		}
+	}
+}
+
+func TestDisabledOpcodes(t *testing.T) {
+	disabled := []opcode{
+		OP_CAT, OP_SUBSTR, OP_LEFT, OP_RIGHT, OP_INVERT, OP_AND, OP_OR, OP_XOR,
+		OP_2MUL, OP_2DIV, OP_MUL, OP_DIV, OP_MOD, OP_LSHIFT, OP_RSHIFT,
+	}
+	for i, op := range disabled {
+		if op != []opcode{126, 127, 128, 129, 131, 132, 133, 134, 141, 142, 149, 150, 151, 152, 153}[i] {
+			t.Errorf("FAIL: %s", op)
+		}
+		// even in branches which are not taken
+		s := Script{OP_1, OP_0, OP_IF, op, OP_ENDIF}
+		if VerifyScript(Script{}, s, nil, nil, 0) != ErrDisabledOpcode {
+			t.Errorf("FAIL: %s", op)
+		}
+	}
+	// they are OP_SUCCESSx in tapscripts
+	if !isOpSuccess(OP_CAT) {
+		t.Errorf("FAIL")
+	}
+}
@@ -3,7 +3,6 @@
 package script
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
 	"io"
@@ -15,6 +14,19 @@ import (
 // Script is simply a slice of commands.
 type Script []command
 
+const (
+	// MaxPubKeysPerMultisig is the maximum number of public keys in OP_CHECKMULTISIG.
+	MaxPubKeysPerMultisig = 20
+	// MaxScriptElementSize is the maximum size in bytes of a stack element.
+	MaxScriptElementSize = 520
+	// MaxScriptSize is the maximum size in bytes of a script.
+	MaxScriptSize = 10000
+	// MaxOpsPerScript is the maximum number of non-push opcodes in a script.
+	MaxOpsPerScript = 201
+	// MaxStackSize is the maximum number of elements on the stack and the alt stack combined.
+	MaxStackSize = 1000
+)
+
 // NewP2PKHScript returns a Pay-to-PubkeyHash Script
 func NewP2PKHScript(h160 [20]byte) Script {
 	return []command{
@@ -34,12 +46,69 @@ func NewP2WPKHScript(h160 [20]byte) Script {
 	}
 }
 
+// NewP2SHScript returns a Pay-to-ScriptHash Script
+func NewP2SHScript(h160 [20]byte) Script {
+	return []command{
+		OP_HASH160,
+		element(h160[:]),
+		OP_EQUAL,
+	}
+}
+
+// NewP2WSHScript returns a Pay-to-Witness-ScriptHash Script
+func NewP2WSHScript(h256 [32]byte) Script {
+	return []command{
+		OP_0,
+		element(h256[:]),
+	}
+}
+
+// NewP2TRScript returns a Pay-to-Taproot Script for the x-only output key
+func NewP2TRScript(outputKey [32]byte) Script {
+	return []command{
+		OP_1,
+		element(outputKey[:]),
+	}
+}
+
+// NewWitnessScript returns the Script locking coins to a witness program of any version:
+//     `OP_version <program>`
+func NewWitnessScript(version byte, program []byte) Script {
+	return []command{
+		smallInt(int(version)),
+		element(program),
+	}
+}
+
+// NewMultisigScript returns a bare m-of-n multisig Script:
+//     `OP_m <pubkey 1> ... <pubkey n> OP_n OP_CHECKMULTISIG`
+func NewMultisigScript(m int, pubKeys [][]byte) (Script, error) {
+	n := len(pubKeys)
+	if m < 1 || m > n || n > MaxPubKeysPerMultisig {
+		return nil, errors.New("NewMultisigScript: invalid m-of-n")
+	}
+	s := Script{smallInt(m)}
+	s = s.AddBytes(pubKeys...)
+	return s.Add(smallInt(n), OP_CHECKMULTISIG), nil
+}
+
+// smallInt returns the command pushing n, using OP_0 and OP_1 through OP_16 when possible.
+func smallInt(n int) command {
+	if n == 0 {
+		return OP_0
+	}
+	if 1 <= n && n <= 16 {
+		return OP_1 + opcode(n-1)
+	}
+	return element(ScriptNum(n).Bytes())
+}
+
 // IsP2PKH returns whether this follows the:
 //     `OP_DUP OP_HASH160 <20 byte hash> OP_EQUALVERIFY OP_CHECKSIG` pattern
 func (s *Script) IsP2PKH() bool {
 	cmds := *s
 	return len(cmds) == 5 && cmds[0] == OP_DUP && cmds[1] == OP_HASH160 &&
-		len(cmds[2].(element)) == 20 && cmds[3] == OP_EQUALVERIFY &&
+		cmds.isPush(2, 20) && cmds[3] == OP_EQUALVERIFY &&
 		cmds[4] == OP_CHECKSIG
 }
 
@@ -47,8 +116,149 @@ func (s *Script) IsP2PKH() bool {
 //     `OP_0 <20 byte hash>` pattern
 func (s *Script) IsP2WPKH() bool {
 	cmds := *s
-	return len(cmds) == 2 && cmds[0] == OP_0 &&
-		len(cmds[1].(element)) == 20
+	return len(cmds) == 2 && cmds[0] == OP_0 && cmds.isPush(1, 20)
+}
+
+// IsP2WSH returns whether this follows the:
+//     `OP_0 <32 byte hash>` pattern
+func (s *Script) IsP2WSH() bool {
+	cmds := *s
+	return len(cmds) == 2 && cmds[0] == OP_0 && cmds.isPush(1, 32)
+}
+
+// IsP2TR returns whether this follows the:
+//     `OP_1 <32 byte output key>` pattern
+func (s *Script) IsP2TR() bool {
+	cmds := *s
+	return len(cmds) == 2 && cmds[0] == OP_1 && cmds.isPush(1, 32)
+}
+
+// IsP2SH returns whether this follows the:
+//     `OP_HASH160 <20 byte hash> OP_EQUAL` pattern
+func (s *Script) IsP2SH() bool {
+	cmds := *s
+	return len(cmds) == 3 && cmds[0] == OP_HASH160 && cmds.isPush(1, 20) && cmds[2] == OP_EQUAL
+}
+
+// IsPushOnly returns whether the script consists only of pushes,
+// counting OP_1NEGATE and OP_1 through OP_16 as pushes too.
+// The unparsed end of a script is not a push.
+func (s *Script) IsPushOnly() bool {
+	for _, cmd := range *s {
+		switch cmd := cmd.(type) {
+		case opcode:
+			if cmd > OP_16 {
+				return false
+			}
+		case unparsed:
+			return false
+		}
+	}
+	return true
+}
+
+// RedeemScript returns the redeem script of a Pay-to-ScriptHash scriptSig, which is its last push.
+//
+// Returns error if the scriptSig is not push only or the redeem script can't be parsed.
+func (s *Script) RedeemScript() (Script, error) {
+	cmds := *s
+	if len(cmds) == 0 || !cmds.IsPushOnly() {
+		return nil, errors.New("RedeemScript: the scriptSig should be push only")
+	}
+	el, ok := asElement(cmds[len(cmds)-1])
+	if !ok {
+		return nil, errors.New("RedeemScript: the last command should be a push")
+	}
+	return ParseRaw(el)
+}
+
+// ScriptCode returns the part of the script after its last OP_CODESEPARATOR,
+// which the signatures in witness scripts commit to, see BIP143.
+// It is the whole script if there is no OP_CODESEPARATOR.
+func (s *Script) ScriptCode() Script {
+	cmds := *s
+	for i := len(cmds) - 1; i >= 0; i-- {
+		if cmds[i] == OP_CODESEPARATOR {
+			return append(Script{}, cmds[i+1:]...)
+		}
+	}
+	return s.copy()
+}
+
+// isPush returns whether the command with the index is a push of size bytes.
+func (s Script) isPush(index, size int) bool {
+	el, ok := s[index].(element)
+	return ok && len(el) == size
+}
+
+// witnessProgram returns the version and program, if the script is a witness program:
+//     `OP_version <2 to 40 byte program>`
+func (s Script) witnessProgram() (version int, program []byte, ok bool) {
+	if len(s) != 2 {
+		return 0, nil, false
+	}
+	op, isOp := s[0].(opcode)
+	el, isEl := s[1].(element)
+	if !isOp || !isEl || len(el) < 2 || len(el) > 40 {
+		return 0, nil, false
+	}
+	switch {
+	case op == OP_0:
+		return 0, el, true
+	case OP_1 <= op && op <= OP_16:
+		return int(op-OP_1) + 1, el, true
+	}
+	return 0, nil, false
+}
+
+// IsMultisig returns whether this follows the:
+//     `OP_m <pubkey 1> ... <pubkey n> OP_n OP_CHECKMULTISIG` pattern
+// and if so m and the n public keys.
+func (s *Script) IsMultisig() (m int, pubKeys [][]byte, ok bool) {
+	cmds := *s
+	l := len(cmds)
+	if l < 4 || cmds[l-1] != OP_CHECKMULTISIG {
+		return 0, nil, false
+	}
+	first, isOp := cmds[0].(opcode)
+	last, isOp2 := cmds[l-2].(opcode)
+	if !isOp || !isOp2 || first < OP_1 || first > OP_16 || last < OP_1 || last > OP_16 {
+		return 0, nil, false
+	}
+	m, n := int(first-OP_1)+1, int(last-OP_1)+1
+	if m > n || n != l-3 {
+		return 0, nil, false
+	}
+	for _, cmd := range cmds[1 : l-2] {
+		el, isEl := cmd.(element)
+		if !isEl || (len(el) != 33 && len(el) != 65) {
+			return 0, nil, false
+		}
+		pubKeys = append(pubKeys, append([]byte{}, el...))
+	}
+	return m, pubKeys, true
+}
+
+// SigOpCount returns the number of signature operations in the script, which are limited per block.
+// OP_CHECKMULTISIG counts as MaxPubKeysPerMultisig, unless accurate is set and it is preceded
+// by OP_1 through OP_16, as in P2SH redeem scripts.
+func (s *Script) SigOpCount(accurate bool) int {
+	count := 0
+	var prev command
+	for _, cmd := range *s {
+		switch cmd {
+		case OP_CHECKSIG, OP_CHECKSIGVERIFY:
+			count++
+		case OP_CHECKMULTISIG, OP_CHECKMULTISIGVERIFY:
+			if op, ok := prev.(opcode); accurate && ok && OP_1 <= op && op <= OP_16 {
+				count += int(op-OP_1) + 1
+			} else {
+				count += MaxPubKeysPerMultisig
+			}
+		}
+		prev = cmd
+	}
+	return count
 }
 
 func (s *Script) Add(cmds ...command) Script {
@@ -63,124 +273,194 @@ func (s *Script) AddBytes(b ...[]byte) Script {
 	return s.Add(els...)
 }
 
+// GetBytes returns a copy of the data pushed by the command with the index,
+// nil if the command is not a push.
 func (s *Script) GetBytes(index int) []byte {
-	el := (*s)[index].(element)
+	el, ok := asElement((*s)[index])
+	if !ok {
+		return nil
+	}
 	buf := make([]byte, len(el))
 	copy(buf, el)
 	return buf
 }
 
+// size returns the size in bytes of the serialized script.
+func (s Script) size() int {
+	n := 0
+	for _, cmd := range s {
+		switch cmd := cmd.(type) {
+		case element:
+			n += pushSize(pushOpcode(len(cmd)), len(cmd))
+		case pushData:
+			n += pushSize(cmd.op, len(cmd.el))
+		case unparsed:
+			n += len(cmd)
+		default:
+			n += 1
+		}
+	}
+	return n
+}
+
+// pushOpcode returns the shortest push opcode for elements of length n:
+// n itself for up to 75 bytes, otherwise OP_PUSHDATA1, OP_PUSHDATA2 or OP_PUSHDATA4.
+func pushOpcode(n int) opcode {
+	switch {
+	case n < int(OP_PUSHDATA1):
+		return opcode(n)
+	case n <= 0xff:
+		return OP_PUSHDATA1
+	case n <= 0xffff:
+		return OP_PUSHDATA2
+	}
+	return OP_PUSHDATA4
+}
+
+// pushSize returns the size in bytes of the push of n bytes by the push opcode op.
+func pushSize(op opcode, n int) int {
+	switch op {
+	case OP_PUSHDATA1:
+		return 2 + n
+	case OP_PUSHDATA2:
+		return 3 + n
+	case OP_PUSHDATA4:
+		return 5 + n
+	}
+	return 1 + n
+}
+
+// appendPush appends to the raw script the push of the data by the push opcode op.
+func appendPush(raw []byte, op opcode, data []byte) []byte {
+	n := len(data)
+	raw = append(raw, byte(op))
+	switch op {
+	case OP_PUSHDATA1:
+		raw = append(raw, byte(n))
+	case OP_PUSHDATA2:
+		raw = append(raw, byte(n), byte(n>>8))
+	case OP_PUSHDATA4:
+		raw = append(raw, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+	return append(raw, data...)
+}
+
 func (s *Script) copy() Script {
 	return s.Add()
 }
 
+// Marshal serializes the script, prefixed with its length as VarInt.
 func (s *Script) Marshal() ([]byte, error) {
-	buf := new(bytes.Buffer)
+	raw, err := s.Raw()
+	if err != nil {
+		return nil, err
+	}
+	encodedLen, err := encoding.EncodeVarInt(big.NewInt(int64(len(raw))))
+	if err != nil {
+		return nil, err
+	}
+	return append(encodedLen, raw...), nil
+}
+
+// Raw serializes the script without the length prefix.
+// This is the form that gets hashed, for example in Pay-to-ScriptHash.
+//
+// Elements are pushed by the shortest push opcode for their length,
+// the pushes of parsed scripts by the push opcodes they were parsed with.
+func (s *Script) Raw() ([]byte, error) {
+	raw := make([]byte, 0, s.size())
 	for _, cmd := range *s {
 		switch cmd := cmd.(type) {
 		case opcode:
-			binary.Write(buf, binary.LittleEndian, cmd)
+			raw = append(raw, byte(cmd))
 		case element:
-			length := len(cmd)
-			// for large lengths, we have to use a pushdata opcode
-			if length <= 75 {
-				binary.Write(buf, binary.LittleEndian, opcode(length))
-			} else if 76 <= length && length <= 0xff {
-				binary.Write(buf, binary.LittleEndian, OP_PUSHDATA1)
-				binary.Write(buf, binary.LittleEndian, uint8(length))
-			} else if 0x100 <= length && length <= 520 {
-				binary.Write(buf, binary.LittleEndian, OP_PUSHDATA2)
-				binary.Write(buf, binary.LittleEndian, uint16(length))
-			} else {
-				return nil, errors.New("Script.Marshal: the command is too long")
-			}
-			buf.Write(cmd)
+			raw = appendPush(raw, pushOpcode(len(cmd)), cmd)
+		case pushData:
+			raw = appendPush(raw, cmd.op, cmd.el)
+		case unparsed:
+			raw = append(raw, cmd...)
 		default:
 			return nil, errors.New("Script.Marshal: unrecognized command")
 		}
 	}
+	return raw, nil
+}
 
-	encodedLen, err := encoding.EncodeVarInt(big.NewInt(int64(buf.Len())))
+// Unmarshal parses the script prefixed with its length as VarInt, see ParseRaw.
+// A push running past the end of the script is kept as it is, so that the script
+// serializes to the same bytes, and the script fails if it gets executed.
+func (s *Script) Unmarshal(r io.Reader) *Script {
+	length := encoding.DecodeVarInt(r).Int64()
+	// the length is not trusted, the buffer grows only as much as there is to read
+	raw, _ := io.ReadAll(io.LimitReader(r, length))
+	parsed, err := parseRaw(raw)
+	if err != nil {
+		parsed = append(parsed, unparsed(raw[parsed.size():]))
+	}
+	*s = parsed
+	return s
+}
+
+// ParseRaw parses a script serialized without the length prefix.
+//
+// Returns error if a push runs past the end of raw.
+func ParseRaw(raw []byte) (Script, error) {
+	s, err := parseRaw(raw)
 	if err != nil {
 		return nil, err
 	}
-	return append(encodedLen, buf.Bytes()...), nil
+	return s, nil
 }
 
-func (s *Script) Unmarshal(r io.Reader) *Script {
-	// TODO: verify command length and command type
-	*s = *new(Script)
-	length := int(encoding.DecodeVarInt(r).Int64())
-	count := 0
+// parseRaw is like ParseRaw, but on error returns the commands parsed before it as well.
+func parseRaw(raw []byte) (Script, error) {
+	s := Script{}
+	for i := 0; i < len(raw); {
+		current := opcode(raw[i])
+		i += 1
 
-	readElement := func(n int) element {
-		el := make(element, n)
-		io.ReadFull(r, el)
-		count += n
-		return el
-	}
-
-	for count < length {
-		var current opcode
-		binary.Read(r, binary.LittleEndian, &current)
-		count += 1
-
-		// push commands, interpreting opcodes 1-77
-		if 1 <= current && current <= 75 {
-			// elements of size [1, 75] bytes
-			*s = append(*s, readElement(int(current)))
-		} else if current == OP_PUSHDATA1 {
-			// OP_PUSHDATA1: elements of size [76, 255] bytes
-			var elementLength uint8
-			binary.Read(r, binary.LittleEndian, &elementLength)
-			count += 1
-			*s = append(*s, readElement(int(elementLength)))
-		} else if current == OP_PUSHDATA2 {
-			// OP_PUSHDATA2: elements of size [256, 520] bytes
-			var elementLength uint16
-			binary.Read(r, binary.LittleEndian, &elementLength)
-			count += 2
-			*s = append(*s, readElement(int(elementLength)))
+		length := 0
+		switch {
+		case 1 <= current && current <= 75:
+			length = int(current)
+		case current == OP_PUSHDATA1 && i+1 <= len(raw):
+			length = int(raw[i])
+			i += 1
+		case current == OP_PUSHDATA2 && i+2 <= len(raw):
+			length = int(binary.LittleEndian.Uint16(raw[i:]))
+			i += 2
+		case current == OP_PUSHDATA4 && i+4 <= len(raw):
+			length = int(binary.LittleEndian.Uint32(raw[i:]))
+			i += 4
+		case current == OP_PUSHDATA1 || current == OP_PUSHDATA2 || current == OP_PUSHDATA4:
+			return s, errors.New("ParseRaw: truncated push length")
+		default:
+			s = append(s, current)
+			continue
+		}
+
+		if length < 0 || i+length > len(raw) {
+			return s, errors.New("ParseRaw: push past the end of the script")
+		}
+		el := element(append([]byte{}, raw[i:i+length]...))
+		if current != pushOpcode(length) {
+			s = append(s, pushData{current, el})
 		} else {
-			// represents an opcode, add it (as int)
-			*s = append(*s, current)
+			s = append(s, el)
 		}
+		i += length
 	}
-
-	return s
+	return s, nil
 }
 
-func (s *Script) Eval(sighash []byte, witness [][]byte) bool {
-	stack, altstack, cmds := new(stack), new(stack), s.copy()
-
-	for len(cmds) > 0 {
-		cmd := cmds[0]
-		cmds = cmds[1:]
-		switch cmd := cmd.(type) {
-		case opcode:
-			if !OpcodeFunctions[cmd](stack, altstack, cmds, sighash) {
-				return false
-			}
-		case element:
-			stack.Push(cmd)
-
-			// witness program version 0 rule
-			// if stack cmds are: OP_0 <20 byte hash> this is p2wpkh
-			if len(*stack) == 2 && (*stack)[0] == OP_0 && len((*stack)[1].(element)) == 20 {
-				_, c := stack.Pop()
-				var h160 [20]byte
-				copy(h160[:], c.(element))
-				stack.Pop() // pop the OP_0
-				cmds = cmds.AddBytes(witness...)
-				cmds = cmds.Add(NewP2PKHScript(h160)...)
-			}
-		}
-	}
+// Eval executes the script, checking the signatures with the checker. Returns nil if it succeeds,
+// otherwise the ErrorCode of the reason it fails.
+// The script may be a P2WPKH or P2WSH witness program, which is verified with the witness.
+func (s *Script) Eval(checker SignatureChecker, witness [][]byte) error {
+	return s.EvalFlags(checker, witness, 0)
+}
 
-	if len(*stack) == 0 {
-		return false
-	}
-	_, c := stack.Pop()
-	el := c.(element)
-	return decodeNum(el) != 0
+// EvalFlags is like Eval, with the optional verification rules selected by flags.
+func (s *Script) EvalFlags(checker SignatureChecker, witness [][]byte, flags Flags) error {
+	return VerifyScript(Script{}, *s, witness, checker, flags|VerifyWitness)
 }
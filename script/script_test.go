@@ -2,7 +2,12 @@ package script
 
 import (
 	"bytes"
+	"encoding/hex"
+	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/VIVelev/btcd/crypto/hash"
 )
 
 var s = Script([]command{
@@ -35,3 +40,190 @@ func TestScriptUnmarshal(t *testing.T) {
 		}
 	}
 }
+
+func TestCommandEqual(t *testing.T) {
+	// commands of different types are never equal
+	cmds := []command{OP_0, element{}, pushData{OP_PUSHDATA1, element{}}, unparsed{0x4c}}
+	for i := range cmds {
+		for j := range cmds {
+			if cmds[i].Equal(cmds[j]) != (i == j) {
+				t.Errorf("FAIL: %d %d", i, j)
+			}
+		}
+	}
+}
+
+func TestGetBytes(t *testing.T) {
+	s := Script{element{1, 2}, pushData{OP_PUSHDATA1, element{3}}, OP_DUP, unparsed{0x4c}}
+	if !bytes.Equal(s.GetBytes(0), []byte{1, 2}) || !bytes.Equal(s.GetBytes(1), []byte{3}) {
+		t.Errorf("FAIL")
+	}
+	if s.GetBytes(2) != nil || s.GetBytes(3) != nil {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestNewMultisigScript(t *testing.T) {
+	pubKeys := [][]byte{make([]byte, 33), make([]byte, 33)}
+	s, err := NewMultisigScript(1, pubKeys)
+	if err != nil || len(s) != 5 || s[0] != OP_1 || s[3] != OP_2 || s[4] != OP_CHECKMULTISIG {
+		t.Errorf("FAIL")
+	}
+	if _, err = NewMultisigScript(3, pubKeys); err == nil {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestParseRaw(t *testing.T) {
+	raw, _ := s.Raw()
+	newS, err := ParseRaw(raw)
+	if err != nil || len(newS) != len(s) {
+		t.Errorf("FAIL")
+	}
+	// OP_PUSHDATA1 with missing data
+	if _, err = ParseRaw([]byte{0x4c, 0x05, 0x01}); err == nil {
+		t.Errorf("FAIL")
+	}
+	// the pushes serialize back by the push opcodes they were parsed with
+	for _, h := range []string{
+		"4c0107",
+		"4c00",
+		"4d010007",
+		"4e0100000007",
+		"4c4c" + strings.Repeat("07", 76),
+		"4d0902" + strings.Repeat("07", 521),
+		"0051604f0105",
+	} {
+		raw, _ := hex.DecodeString(h)
+		s, err := ParseRaw(raw)
+		again, _ := s.Raw()
+		if err != nil || !bytes.Equal(again, raw) || s.size() != len(raw) {
+			t.Errorf("FAIL: %s", h)
+		}
+		buf, _ := s.Marshal()
+		newS := *new(Script).Unmarshal(bytes.NewReader(buf))
+		if !reflect.DeepEqual(newS, s) {
+			t.Errorf("FAIL: %s", h)
+		}
+	}
+	// only the longer push opcodes than needed are kept
+	raw, _ = hex.DecodeString("4c4c" + strings.Repeat("07", 76))
+	if s, _ := ParseRaw(raw); !reflect.DeepEqual(s, Script{element(raw[2:])}) {
+		t.Errorf("FAIL")
+	}
+	// a push running past the end is kept, to serialize to the same bytes
+	raw, _ = hex.DecodeString("ac9a87f5594be208f8532db38cff670c")
+	newS = *new(Script).Unmarshal(bytes.NewReader(append([]byte{byte(len(raw))}, raw...)))
+	if again, _ := newS.Raw(); !bytes.Equal(again, raw) || !reflect.DeepEqual(newS[:5], Script{OP_CHECKSIG, OP_BOOLAND, OP_EQUAL, opcode(0xf5), OP_9}) {
+		t.Errorf("FAIL")
+	}
+	// the length of the script is not trusted
+	newS = *new(Script).Unmarshal(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f, 0x51}))
+	if !reflect.DeepEqual(newS, Script{OP_1}) {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestString(t *testing.T) {
+	raw, _ := hex.DecodeString("0051604f0201024c0401020304060102030405066a")
+	s, _ := ParseRaw(raw)
+	if s.String() != "0 1 16 -1 513 67305985 010203040506 OP_RETURN" {
+		t.Errorf("FAIL: %s", s.String())
+	}
+}
+
+func TestType(t *testing.T) {
+	var h20 [20]byte
+	var h32 [32]byte
+	pub := make([]byte, 33)
+	multi, _ := NewMultisigScript(1, [][]byte{pub, pub})
+	for want, s := range map[string]Script{
+		"pubkeyhash":            NewP2PKHScript(h20),
+		"scripthash":            NewP2SHScript(h20),
+		"pubkey":                {element(pub), OP_CHECKSIG},
+		"multisig":              multi,
+		"nulldata":              {OP_RETURN, element{1}},
+		"witness_v0_keyhash":    NewP2WPKHScript(h20),
+		"witness_v0_scripthash": NewP2WSHScript(h32),
+		"witness_v1_taproot":    NewP2TRScript(h32),
+		"witness_unknown":       NewWitnessScript(2, h20[:]),
+		"nonstandard":           {OP_DUP, OP_HASH160, OP_DUP, OP_EQUALVERIFY, OP_CHECKSIG},
+	} {
+		if s.Type() != want {
+			t.Errorf("FAIL: %s", want)
+		}
+	}
+
+	// example from BIP173
+	program, _ := hex.DecodeString("751e76e8199196d454941c45d1b3a323f1433bd6")
+	copy(h20[:], program)
+	addr, err := NewP2WPKHScript(h20).Address(false)
+	if err != nil || addr != "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4" {
+		t.Errorf("FAIL: %s", addr)
+	}
+	if _, err := multi.Address(false); err == nil {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestIsMultisig(t *testing.T) {
+	pubKeys := [][]byte{make([]byte, 33), make([]byte, 65), make([]byte, 33)}
+	s, _ := NewMultisigScript(2, pubKeys)
+	m, keys, ok := s.IsMultisig()
+	if !ok || m != 2 || len(keys) != 3 || len(keys[1]) != 65 {
+		t.Errorf("FAIL")
+	}
+	for _, s := range []Script{
+		{OP_2, element(pubKeys[0]), OP_1, OP_CHECKMULTISIG},
+		{OP_1, element(pubKeys[0]), OP_2, OP_CHECKMULTISIG},
+		{OP_1, element{1, 2, 3}, OP_1, OP_CHECKMULTISIG},
+		{OP_1, element(pubKeys[0]), OP_1, OP_CHECKSIG},
+	} {
+		if _, _, ok := s.IsMultisig(); ok {
+			t.Errorf("FAIL: %v", s)
+		}
+	}
+}
+
+func TestSigOpCount(t *testing.T) {
+	multi, _ := NewMultisigScript(1, [][]byte{make([]byte, 33), make([]byte, 33)})
+	s := multi.Add(OP_CHECKSIG, OP_CHECKSIGVERIFY)
+	if s.SigOpCount(true) != 4 || s.SigOpCount(false) != 22 {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestRedeemScript(t *testing.T) {
+	redeemScript := Script{OP_1, OP_ADD, OP_3, OP_EQUAL}
+	raw, _ := redeemScript.Raw()
+	spk, notSpk := NewP2SHScript(hash.Hash160(raw)), Script{OP_1}
+	if !spk.IsP2SH() || notSpk.IsP2SH() {
+		t.Errorf("FAIL")
+	}
+
+	for _, tc := range []struct {
+		scriptSig Script
+		ok        bool
+	}{
+		{Script{OP_2, element(raw)}, true},
+		{Script{OP_2, OP_NOP, element(raw)}, false},
+		{Script{OP_2}, false},
+		{Script{}, false},
+	} {
+		got, err := tc.scriptSig.RedeemScript()
+		if (err == nil) != tc.ok || (tc.ok && got.String() != redeemScript.String()) {
+			t.Errorf("FAIL")
+		}
+	}
+}
+
+func TestScriptCode(t *testing.T) {
+	s := Script{OP_1, OP_CODESEPARATOR, OP_2, OP_CODESEPARATOR, OP_3}
+	if got := s.ScriptCode(); got.String() != "3" {
+		t.Errorf("FAIL")
+	}
+	s = Script{OP_1, OP_2}
+	if got := s.ScriptCode(); got.String() != "1 2" {
+		t.Errorf("FAIL")
+	}
+}
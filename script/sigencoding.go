@@ -0,0 +1,109 @@
+package script
+
+import (
+	"math/big"
+
+	"github.com/VIVelev/btcd/crypto/elliptic"
+)
+
+// halfOrder is half the order of secp256k1, the highest S value of low S signatures.
+var halfOrder = new(big.Int).Rsh(elliptic.Secp256k1.Params().N, 1)
+
+// isValidSignatureEncoding returns whether sig, which ends with the hash type byte,
+// is strictly DER encoded, see BIP66:
+//     0x30 <total length> 0x02 <length of R> <R> 0x02 <length of S> <S> <hash type>
+// R and S are positive big-endian integers, without unnecessary leading zeros.
+func isValidSignatureEncoding(sig []byte) bool {
+	l := len(sig)
+	if l < 9 || l > 73 {
+		return false
+	}
+	if sig[0] != 0x30 || int(sig[1]) != l-3 {
+		return false
+	}
+	lenR := int(sig[3])
+	if 5+lenR >= l {
+		return false
+	}
+	lenS := int(sig[5+lenR])
+	if lenR+lenS+7 != l {
+		return false
+	}
+
+	if sig[2] != 0x02 || lenR == 0 || sig[4]&0x80 != 0 {
+		return false
+	}
+	if lenR > 1 && sig[4] == 0 && sig[5]&0x80 == 0 {
+		return false
+	}
+	if sig[lenR+4] != 0x02 || lenS == 0 || sig[lenR+6]&0x80 != 0 {
+		return false
+	}
+	if lenS > 1 && sig[lenR+6] == 0 && sig[lenR+7]&0x80 == 0 {
+		return false
+	}
+	return true
+}
+
+// isLowS returns whether the S value of the strictly DER encoded sig is at most half the curve order.
+func isLowS(sig []byte) bool {
+	lenR := int(sig[3])
+	lenS := int(sig[5+lenR])
+	s := new(big.Int).SetBytes(sig[6+lenR : 6+lenR+lenS])
+	return s.Cmp(halfOrder) <= 0
+}
+
+// isDefinedHashType returns whether the hash type byte ending sig is
+// SIGHASH_ALL, SIGHASH_NONE or SIGHASH_SINGLE, optionally with SIGHASH_ANYONECANPAY.
+func isDefinedHashType(sig []byte) bool {
+	hashType := sig[len(sig)-1] &^ 0x80
+	return 0x01 <= hashType && hashType <= 0x03
+}
+
+// isCompressedPubKey returns whether the public key is compressed SEC.
+func isCompressedPubKey(pubKey []byte) bool {
+	return len(pubKey) == 33 && (pubKey[0] == 0x02 || pubKey[0] == 0x03)
+}
+
+// isCompressedOrUncompressedPubKey returns whether the public key is either compressed or uncompressed SEC.
+func isCompressedOrUncompressedPubKey(pubKey []byte) bool {
+	return isCompressedPubKey(pubKey) || (len(pubKey) == 65 && pubKey[0] == 0x04)
+}
+
+// checkSignatureEncoding returns error if the encoding of the ECDSA signature is not allowed by the flags.
+// Empty signatures are always allowed, they fail the signature checks without failing the script.
+func checkSignatureEncoding(e *Engine, sig []byte) error {
+	if len(sig) == 0 {
+		return nil
+	}
+	if e.flags&(VerifyDERSignatures|VerifyLowS|VerifyStrictEncoding) != 0 && !isValidSignatureEncoding(sig) {
+		return ErrSigDER
+	}
+	if e.flags&VerifyLowS != 0 && !isLowS(sig) {
+		return ErrSigHighS
+	}
+	if e.flags&VerifyStrictEncoding != 0 && !isDefinedHashType(sig) {
+		return ErrSigHashType
+	}
+	return nil
+}
+
+// checkPubKeyEncoding returns error if the encoding of the SEC public key is not allowed by the flags.
+func checkPubKeyEncoding(e *Engine, pubKey []byte) error {
+	if e.flags&VerifyStrictEncoding != 0 && !isCompressedOrUncompressedPubKey(pubKey) {
+		return ErrPubKeyType
+	}
+	if e.flags&VerifyWitnessPubKeyType != 0 && e.sigVersion == SigVersionWitnessV0 && !isCompressedPubKey(pubKey) {
+		return ErrWitnessPubKeyType
+	}
+	return nil
+}
+
+// isMinimalPush returns whether the push can't be done by a shorter command.
+// Single bytes 1 through 16 and 0x81 have to be pushed by OP_1 through OP_16 and OP_1NEGATE,
+// and the other elements by the shortest push opcode for their length.
+// Empty elements are serialized as OP_0.
+func isMinimalPush(cmd command) bool {
+	el, ok := cmd.(element)
+	return ok && !(len(el) == 1 && (1 <= el[0] && el[0] <= 16 || el[0] == 0x81))
+}
@@ -1,26 +1,53 @@
 package script
 
-type stack []command
+// stack holds the elements of a script execution, its top is the last element.
+type stack []element
 
-func (s *stack) Push(c command) *stack {
-	*s = append(*s, c)
+func (s *stack) Push(el element) *stack {
+	*s = append(*s, el)
 	return s
 }
 
-func (s *stack) Pop() (*stack, command) {
+// Pop removes and returns the top of the stack.
+// Returns ErrInvalidStackOperation if the stack is empty.
+func (s *stack) Pop() (element, error) {
 	l := len(*s)
 	if l == 0 {
-		panic("stack is already empty")
+		return nil, ErrInvalidStackOperation
 	}
 	el := (*s)[l-1]
 	*s = (*s)[:l-1]
-	return s, el
+	return el, nil
 }
 
-func (s *stack) Peek() command {
+// Peek returns the top of the stack.
+// Returns ErrInvalidStackOperation if the stack is empty.
+func (s *stack) Peek() (element, error) {
 	l := len(*s)
 	if l == 0 {
-		panic("stack is already empty")
+		return nil, ErrInvalidStackOperation
 	}
-	return (*s)[l-1]
+	return (*s)[l-1], nil
+}
+
+// at returns the element at depth i, the top of the stack is at depth 0.
+func (s *stack) at(i int) element {
+	return (*s)[len(*s)-1-i]
+}
+
+// remove removes and returns the element at depth i.
+func (s *stack) remove(i int) element {
+	j := len(*s) - 1 - i
+	el := (*s)[j]
+	*s = append((*s)[:j], (*s)[j+1:]...)
+	return el
+}
+
+// bytes returns a copy of the elements of the stack.
+func (s *stack) bytes() [][]byte {
+	items := make([][]byte, len(*s))
+	for i, el := range *s {
+		items[i] = append([]byte{}, el...)
+	}
+	return items
 }
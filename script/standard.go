@@ -0,0 +1,168 @@
+package script
+
+import (
+	"errors"
+
+	"github.com/VIVelev/btcd/crypto/hash"
+	"github.com/VIVelev/btcd/encoding"
+)
+
+// Class is the standard type of a scriptPubKey, see Classify.
+type Class int
+
+const (
+	ClassNonStandard         Class = iota // none of the below
+	ClassPubKey                           // `<pubkey> OP_CHECKSIG`
+	ClassPubKeyHash                       // P2PKH
+	ClassScriptHash                       // P2SH, see BIP16
+	ClassMultisig                         // bare `OP_m <pubkey 1> ... <pubkey n> OP_n OP_CHECKMULTISIG`
+	ClassNullData                         // `OP_RETURN <pushes>`, which is unspendable
+	ClassWitnessV0KeyHash                 // P2WPKH, see BIP141
+	ClassWitnessV0ScriptHash              // P2WSH, see BIP141
+	ClassWitnessV1Taproot                 // P2TR, see BIP341
+	ClassWitnessUnknown                   // witness programs of the versions reserved for upgrades
+)
+
+// classNames are the names of the classes in Bitcoin Core RPC.
+var classNames = map[Class]string{
+	ClassNonStandard:         "nonstandard",
+	ClassPubKey:              "pubkey",
+	ClassPubKeyHash:          "pubkeyhash",
+	ClassScriptHash:          "scripthash",
+	ClassMultisig:            "multisig",
+	ClassNullData:            "nulldata",
+	ClassWitnessV0KeyHash:    "witness_v0_keyhash",
+	ClassWitnessV0ScriptHash: "witness_v0_scripthash",
+	ClassWitnessV1Taproot:    "witness_v1_taproot",
+	ClassWitnessUnknown:      "witness_unknown",
+}
+
+func (c Class) String() string {
+	if name, ok := classNames[c]; ok {
+		return name
+	}
+	return "nonstandard"
+}
+
+// Solution is the data extracted from a standard script by Classify.
+// Only the fields meaningful for its class are set.
+type Solution struct {
+	// PubKeys are the public key of pubkey, the public keys of multisig,
+	// and the x-only output key of witness_v1_taproot.
+	PubKeys [][]byte
+	// M is the number of signatures multisig requires, out of len(PubKeys).
+	M int
+	// Hash is the public key hash of pubkeyhash and witness_v0_keyhash,
+	// and the script hash of scripthash and witness_v0_scripthash.
+	Hash []byte
+	// Version and Program are those of the witness programs, including witness_unknown.
+	Version int
+	Program []byte
+	// Data are the pushes of nulldata.
+	Data [][]byte
+}
+
+// Classify returns the standard type of the scriptPubKey, as Solver of Bitcoin Core does,
+// and the data extracted from it.
+func Classify(s Script) (Class, Solution) {
+	n := len(s)
+	switch {
+	case s.IsP2SH():
+		return ClassScriptHash, Solution{Hash: append([]byte{}, s[1].(element)...)}
+	case n > 0 && s[0] == OP_RETURN:
+		data := s[1:]
+		if !data.IsPushOnly() {
+			return ClassNonStandard, Solution{}
+		}
+		return ClassNullData, Solution{Data: pushedData(data)}
+	case s.IsP2PKH():
+		return ClassPubKeyHash, Solution{Hash: append([]byte{}, s[2].(element)...)}
+	case n == 2 && (s.isPush(0, 33) || s.isPush(0, 65)) && s[1] == OP_CHECKSIG:
+		return ClassPubKey, Solution{PubKeys: [][]byte{append([]byte{}, s[0].(element)...)}}
+	}
+	if m, pubKeys, ok := s.IsMultisig(); ok {
+		return ClassMultisig, Solution{M: m, PubKeys: pubKeys}
+	}
+
+	version, program, ok := s.witnessProgram()
+	if !ok {
+		return ClassNonStandard, Solution{}
+	}
+	program = append([]byte{}, program...)
+	sol := Solution{Version: version, Program: program}
+	switch {
+	case version == 0 && len(program) == 20:
+		sol.Hash = program
+		return ClassWitnessV0KeyHash, sol
+	case version == 0 && len(program) == 32:
+		sol.Hash = program
+		return ClassWitnessV0ScriptHash, sol
+	case version == 1 && len(program) == 32:
+		sol.PubKeys = [][]byte{program}
+		return ClassWitnessV1Taproot, sol
+	case version == 0:
+		return ClassNonStandard, Solution{}
+	}
+	return ClassWitnessUnknown, sol
+}
+
+// Addresses returns the addresses the scriptPubKey pays to. Those are the address of the script,
+// or for pubkey and multisig, which have none, the Pay-to-PubkeyHash addresses of their public keys.
+// Returns an empty slice for nulldata and nonstandard.
+func (s Script) Addresses(testnet bool) ([]string, error) {
+	class, sol := Classify(s)
+	switch class {
+	case ClassPubKey, ClassMultisig:
+		addrs := make([]string, len(sol.PubKeys))
+		for i, pubKey := range sol.PubKeys {
+			addrs[i] = encoding.PubKeyHashAddress(hash.Hash160(pubKey), testnet)
+		}
+		return addrs, nil
+	case ClassNullData, ClassNonStandard:
+		return []string{}, nil
+	}
+	addr, err := s.Address(testnet)
+	if err != nil {
+		return nil, err
+	}
+	return []string{addr}, nil
+}
+
+// Address returns the address the script pays to.
+//
+// Returns error if the script type has no address.
+func (s Script) Address(testnet bool) (string, error) {
+	var h160 [20]byte
+	switch class, sol := Classify(s); class {
+	case ClassPubKeyHash:
+		copy(h160[:], sol.Hash)
+		return encoding.PubKeyHashAddress(h160, testnet), nil
+	case ClassScriptHash:
+		copy(h160[:], sol.Hash)
+		return encoding.ScriptHashAddress(h160, testnet), nil
+	case ClassWitnessV0KeyHash, ClassWitnessV0ScriptHash, ClassWitnessV1Taproot, ClassWitnessUnknown:
+		return encoding.SegWitAddress(byte(sol.Version), sol.Program, testnet)
+	}
+	return "", errors.New("Script.Address: the script has no address")
+}
+
+// pushedData returns the data pushed by the push only script.
+func pushedData(s Script) [][]byte {
+	data := [][]byte{}
+	for _, cmd := range s {
+		if el, ok := asElement(cmd); ok {
+			data = append(data, append([]byte{}, el...))
+			continue
+		}
+		switch cmd := cmd.(type) {
+		case opcode:
+			switch {
+			case cmd == OP_0:
+				data = append(data, []byte{})
+			case cmd == OP_1NEGATE || (OP_1 <= cmd && cmd <= OP_16):
+				data = append(data, ScriptNum(int(cmd)-int(OP_1)+1).Bytes())
+			}
+		}
+	}
+	return data
+}
@@ -0,0 +1,95 @@
+package script
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/VIVelev/btcd/crypto/hash"
+	"github.com/VIVelev/btcd/encoding"
+)
+
+func TestClassify(t *testing.T) {
+	h20 := [20]byte{1, 2, 3}
+	h32 := [32]byte{4, 5, 6}
+	pub := append([]byte{2}, make([]byte, 32)...)
+	pub2 := append([]byte{4}, make([]byte, 64)...)
+	multi, _ := NewMultisigScript(1, [][]byte{pub, pub2})
+
+	for _, tc := range []struct {
+		s     Script
+		class Class
+		sol   Solution
+	}{
+		{NewP2PKHScript(h20), ClassPubKeyHash, Solution{Hash: h20[:]}},
+		{NewP2SHScript(h20), ClassScriptHash, Solution{Hash: h20[:]}},
+		{Script{element(pub2), OP_CHECKSIG}, ClassPubKey, Solution{PubKeys: [][]byte{pub2}}},
+		{multi, ClassMultisig, Solution{M: 1, PubKeys: [][]byte{pub, pub2}}},
+		{Script{OP_RETURN}, ClassNullData, Solution{Data: [][]byte{}}},
+		{Script{OP_RETURN, OP_0, OP_1NEGATE, OP_16, element{7, 8}}, ClassNullData,
+			Solution{Data: [][]byte{{}, {0x81}, {16}, {7, 8}}}},
+		{NewP2WPKHScript(h20), ClassWitnessV0KeyHash, Solution{Hash: h20[:], Program: h20[:]}},
+		{NewP2WSHScript(h32), ClassWitnessV0ScriptHash, Solution{Hash: h32[:], Program: h32[:]}},
+		{NewP2TRScript(h32), ClassWitnessV1Taproot, Solution{PubKeys: [][]byte{h32[:]}, Version: 1, Program: h32[:]}},
+		{NewWitnessScript(16, h20[:2]), ClassWitnessUnknown, Solution{Version: 16, Program: h20[:2]}},
+		// v0 programs have to be either 20 or 32 bytes
+		{NewWitnessScript(0, h20[:2]), ClassNonStandard, Solution{}},
+		{Script{OP_RETURN, OP_DUP}, ClassNonStandard, Solution{}},
+		// opcodes in place of the pushes
+		{Script{OP_DUP, OP_HASH160, OP_0, OP_EQUALVERIFY, OP_CHECKSIG}, ClassNonStandard, Solution{}},
+		{Script{OP_HASH160, OP_0, OP_EQUAL}, ClassNonStandard, Solution{}},
+		{Script{OP_DUP, OP_HASH160}, ClassNonStandard, Solution{}},
+		{Script{}, ClassNonStandard, Solution{}},
+	} {
+		class, sol := Classify(tc.s)
+		if class != tc.class || !reflect.DeepEqual(sol, tc.sol) {
+			t.Errorf("FAIL: %s: %s %+v", tc.s, class, sol)
+		}
+		if tc.s.Type() != tc.class.String() {
+			t.Errorf("FAIL: %s", tc.s.Type())
+		}
+	}
+
+	// the extracted data doesn't alias the script
+	s := NewP2PKHScript(h20)
+	_, sol := Classify(s)
+	sol.Hash[0] = 0xff
+	if !bytes.Equal(s[2].(element), h20[:]) {
+		t.Errorf("FAIL")
+	}
+
+	// OP_RETURN followed by a push running past the end is not nulldata
+	unmarshaled := *new(Script).Unmarshal(bytes.NewReader([]byte{2, 0x6a, 0x4c}))
+	if class, _ := Classify(unmarshaled); class != ClassNonStandard || unmarshaled.IsPushOnly() {
+		t.Errorf("FAIL: %s", class)
+	}
+}
+
+func TestAddresses(t *testing.T) {
+	h20 := [20]byte{1, 2, 3}
+	pub := append([]byte{2}, make([]byte, 32)...)
+	pub2 := append([]byte{3}, make([]byte, 32)...)
+	multi, _ := NewMultisigScript(2, [][]byte{pub, pub2})
+	wpkh, _ := encoding.SegWitAddress(0, h20[:], true)
+
+	for _, tc := range []struct {
+		s    Script
+		want []string
+	}{
+		{NewP2PKHScript(h20), []string{encoding.PubKeyHashAddress(h20, true)}},
+		{NewP2SHScript(h20), []string{encoding.ScriptHashAddress(h20, true)}},
+		{Script{element(pub), OP_CHECKSIG}, []string{encoding.PubKeyHashAddress(hash.Hash160(pub), true)}},
+		{multi, []string{
+			encoding.PubKeyHashAddress(hash.Hash160(pub), true),
+			encoding.PubKeyHashAddress(hash.Hash160(pub2), true),
+		}},
+		{NewP2WPKHScript(h20), []string{wpkh}},
+		{Script{OP_RETURN, element{1}}, []string{}},
+		{Script{OP_DUP}, []string{}},
+	} {
+		addrs, err := tc.s.Addresses(true)
+		if err != nil || !reflect.DeepEqual(addrs, tc.want) {
+			t.Errorf("FAIL: %s: %v", tc.s, addrs)
+		}
+	}
+}
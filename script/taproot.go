@@ -0,0 +1,128 @@
+package script
+
+// Taproot commitments, as defined in BIP341.
+// Reference: https://github.com/bitcoin/bips/blob/master/bip-0341.mediawiki
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+
+	"github.com/VIVelev/btcd/crypto/ecdsa"
+	"github.com/VIVelev/btcd/crypto/elliptic"
+	"github.com/VIVelev/btcd/crypto/hash"
+	"github.com/VIVelev/btcd/encoding"
+)
+
+const (
+	// TapscriptLeafVersion is the leaf version of BIP342 tapscripts.
+	TapscriptLeafVersion = 0xc0
+	// annexTag is the first byte of the annex, the optional last witness item of Taproot spends.
+	annexTag = 0x50
+
+	// the control block is the leaf version with the parity of the output key,
+	// the internal key and the merkle path of up to 128 hashes
+	controlBaseSize    = 33
+	controlNodeSize    = 32
+	controlMaxNodes    = 128
+	leafVersionMask    = 0xfe
+	tapscriptSigOpCost = 50 // the validation weight of a signature check in tapscripts
+)
+
+// TapLeafHash returns the hash committing to the leaf script and its version.
+func TapLeafHash(leafVersion byte, s Script) ([32]byte, error) {
+	raw, err := s.Raw()
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return TapLeafHashRaw(leafVersion, raw), nil
+}
+
+// TapLeafHashRaw is like TapLeafHash for the raw script,
+// which doesn't have to parse, as with unknown leaf versions.
+func TapLeafHashRaw(leafVersion byte, raw []byte) [32]byte {
+	encodedLen, _ := encoding.EncodeVarInt(big.NewInt(int64(len(raw))))
+	return hash.TaggedHash("TapLeaf", []byte{leafVersion}, encodedLen, raw)
+}
+
+// TapBranchHash returns the hash of the branch with the two children,
+// which are sorted so that the order in the tree doesn't matter.
+func TapBranchHash(a, b [32]byte) [32]byte {
+	if bytes.Compare(a[:], b[:]) > 0 {
+		a, b = b, a
+	}
+	return hash.TaggedHash("TapBranch", a[:], b[:])
+}
+
+// liftX returns the point with the x coordinate and an even y coordinate.
+func liftX(x [32]byte) (*big.Int, *big.Int, error) {
+	return elliptic.Unmarshal(elliptic.Secp256k1, append([]byte{0x02}, x[:]...))
+}
+
+// TaprootOutputKey tweaks the x-only internal key with the merkle root of the script tree:
+//     Q = P + int(hashTapTweak(P || merkleRoot))G
+// merkleRoot is nil when there is no script tree. Returns the x-only output key Q,
+// and the parity of its y coordinate, which is needed in the control blocks.
+func TaprootOutputKey(internalKey [32]byte, merkleRoot []byte) (outputKey [32]byte, parity byte, err error) {
+	curve := elliptic.Secp256k1
+	px, py, err := liftX(internalKey)
+	if err != nil {
+		return [32]byte{}, 0, err
+	}
+
+	tweak := hash.TaggedHash("TapTweak", internalKey[:], merkleRoot)
+	t := new(big.Int).SetBytes(tweak[:])
+	if t.Cmp(curve.N) >= 0 {
+		return [32]byte{}, 0, errors.New("TaprootOutputKey: tweak out of range")
+	}
+
+	tx, ty := curve.ScalarBaseMult(t)
+	qx, qy := curve.Add(px, py, tx, ty)
+	if qx.Sign() == 0 && qy.Sign() == 0 {
+		return [32]byte{}, 0, errors.New("TaprootOutputKey: output key is the point at infinity")
+	}
+	qx.FillBytes(outputKey[:])
+	return outputKey, byte(qy.Bit(0)), nil
+}
+
+// TaprootPrivateKey returns the private key of the output key, see TaprootOutputKey,
+// which makes key path spends. merkleRoot is nil when there is no script tree.
+func TaprootPrivateKey(priv *ecdsa.PrivateKey, merkleRoot []byte) (*ecdsa.PrivateKey, error) {
+	curve := elliptic.Secp256k1
+	// the internal key is the one with the even y coordinate
+	d := new(big.Int).Set(priv.D)
+	if priv.Y.Bit(0) == 1 {
+		d.Sub(curve.N, d)
+	}
+	var internalKey [32]byte
+	priv.X.FillBytes(internalKey[:])
+
+	tweak := hash.TaggedHash("TapTweak", internalKey[:], merkleRoot)
+	t := new(big.Int).SetBytes(tweak[:])
+	if t.Cmp(curve.N) >= 0 {
+		return nil, errors.New("TaprootPrivateKey: tweak out of range")
+	}
+	d.Add(d, t)
+	d.Mod(d, curve.N)
+	if d.Sign() == 0 {
+		return nil, errors.New("TaprootPrivateKey: the tweaked key is zero")
+	}
+	return ecdsa.GenerateKeyFromSecret(curve, d), nil
+}
+
+// verifyTaprootCommitment returns whether the control block proves that the leaf
+// is committed to by the output key: the merkle path leads from the leaf to the root
+// of the script tree, which tweaks the internal key to the output key.
+func verifyTaprootCommitment(control, outputKey []byte, leafHash [32]byte) bool {
+	k := leafHash
+	for path := control[controlBaseSize:]; len(path) > 0; path = path[controlNodeSize:] {
+		var node [32]byte
+		copy(node[:], path)
+		k = TapBranchHash(k, node)
+	}
+
+	var internalKey [32]byte
+	copy(internalKey[:], control[1:controlBaseSize])
+	q, parity, err := TaprootOutputKey(internalKey, k[:])
+	return err == nil && bytes.Equal(q[:], outputKey) && parity == control[0]&1
+}
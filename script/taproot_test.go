@@ -0,0 +1,274 @@
+package script
+
+import (
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/VIVelev/btcd/crypto/ecdsa"
+	"github.com/VIVelev/btcd/crypto/elliptic"
+	"github.com/VIVelev/btcd/crypto/hash"
+	"github.com/VIVelev/btcd/crypto/schnorr"
+)
+
+func TestTaprootOutputKey(t *testing.T) {
+	// BIP341 wallet test vectors, scriptPubKey without script tree
+	var internalKey [32]byte
+	b, _ := hex.DecodeString("d6889cb081036e0faefa3a35157ad71086b123b2b144b649798b494c300a961d")
+	copy(internalKey[:], b)
+	outputKey, _, err := TaprootOutputKey(internalKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(outputKey[:]) != "53a1f6e454df1aa2776a2814a721372d6258050de330b3c6d10ee8f4e0dda343" {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestTapBranchHash(t *testing.T) {
+	a, b := [32]byte{1}, [32]byte{2}
+	if TapBranchHash(a, b) != TapBranchHash(b, a) {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestTaprootPrivateKey(t *testing.T) {
+	merkleRoot := hash.Sha256([]byte("root"))
+	// the public keys of some of the secrets have odd y coordinates
+	for secret := int64(1); secret <= 8; secret++ {
+		priv := ecdsa.GenerateKeyFromSecret(elliptic.Secp256k1, big.NewInt(secret))
+		for _, root := range [][]byte{nil, merkleRoot[:]} {
+			outputKey, _, _ := TaprootOutputKey(schnorr.XOnly(&priv.PublicKey), root)
+			tweaked, err := TaprootPrivateKey(priv, root)
+			if err != nil || schnorr.XOnly(&tweaked.PublicKey) != outputKey {
+				t.Errorf("FAIL")
+			}
+		}
+	}
+}
+
+func TestVerifyTaprootKeyPath(t *testing.T) {
+	priv := ecdsa.GenerateKeyFromSecret(elliptic.Secp256k1, big.NewInt(1))
+	outputKey, _, _ := TaprootOutputKey(schnorr.XOnly(&priv.PublicKey), nil)
+	spk := NewP2TRScript(outputKey)
+	sighash := hash.Sha256([]byte("sighash"))
+	tweaked, _ := TaprootPrivateKey(priv, nil)
+	sig, _ := schnorr.Sign(tweaked, sighash[:], [32]byte{})
+	untweaked, _ := schnorr.Sign(priv, sighash[:], [32]byte{})
+
+	for _, tc := range []struct {
+		witness [][]byte
+		flags   Flags
+		want    error
+	}{
+		{[][]byte{sig[:]}, VerifyWitness | VerifyTaproot, nil},
+		{[][]byte{append(sig[:], 0x01)}, VerifyWitness | VerifyTaproot, nil},
+		// the default hash type can't be explicit
+		{[][]byte{append(sig[:], 0x00)}, VerifyWitness | VerifyTaproot, ErrSchnorrSigHashType},
+		{[][]byte{sig[:63]}, VerifyWitness | VerifyTaproot, ErrSchnorrSigSize},
+		{[][]byte{untweaked[:]}, VerifyWitness | VerifyTaproot, ErrSchnorrSig},
+		{[][]byte{}, VerifyWitness | VerifyTaproot, ErrWitnessProgramWitnessEmpty},
+		// with the annex
+		{[][]byte{sig[:], {annexTag, 1}}, VerifyWitness | VerifyTaproot, nil},
+		// witness v1 programs are unencumbered without BIP341
+		{[][]byte{untweaked[:]}, VerifyWitness, nil},
+	} {
+		if VerifyScript(Script{}, spk, tc.witness, &MockChecker{Sighash: sighash[:]}, tc.flags) != tc.want {
+			t.Errorf("FAIL")
+		}
+	}
+}
+
+func TestVerifyTaprootScriptPath(t *testing.T) {
+	priv1 := ecdsa.GenerateKeyFromSecret(elliptic.Secp256k1, big.NewInt(1))
+	priv2 := ecdsa.GenerateKeyFromSecret(elliptic.Secp256k1, big.NewInt(2))
+	pk1, pk2 := schnorr.XOnly(&priv1.PublicKey), schnorr.XOnly(&priv2.PublicKey)
+	internal := ecdsa.GenerateKeyFromSecret(elliptic.Secp256k1, big.NewInt(3))
+	internalKey := schnorr.XOnly(&internal.PublicKey)
+	sighash := hash.Sha256([]byte("sighash"))
+	sig1, _ := schnorr.Sign(priv1, sighash[:], [32]byte{})
+	sig2, _ := schnorr.Sign(priv2, sighash[:], [32]byte{})
+
+	// a tree of the leaves:
+	//     <pk1> OP_CHECKSIG <pk2> OP_CHECKSIGADD OP_2 OP_NUMEQUAL
+	//     OP_IF OP_1 OP_ENDIF
+	//     <unknown leaf version>
+	//     OP_SUCCESS80
+	//     OP_0 OP_0 OP_0 OP_CHECKMULTISIG
+	leaves := []struct {
+		version byte
+		s       Script
+	}{
+		{TapscriptLeafVersion, Script{element(pk1[:]), OP_CHECKSIG, element(pk2[:]), OP_CHECKSIGADD, OP_2, OP_NUMEQUAL}},
+		{TapscriptLeafVersion, Script{OP_IF, OP_1, OP_ENDIF}},
+		{0xc2, Script{OP_RETURN}},
+		{TapscriptLeafVersion, Script{OP_RETURN, opcode(80)}},
+		{TapscriptLeafVersion, Script{OP_0, OP_0, OP_0, OP_CHECKMULTISIG}},
+	}
+	hashes := make([][32]byte, len(leaves))
+	raws := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		hashes[i], _ = TapLeafHash(leaf.version, leaf.s)
+		raws[i], _ = leaf.s.Raw()
+	}
+	// a linear tree: ((((0, 1), 2), 3), 4)
+	root := hashes[0]
+	for _, h := range hashes[1:] {
+		root = TapBranchHash(root, h)
+	}
+	outputKey, parity, _ := TaprootOutputKey(internalKey, root[:])
+	spk := NewP2TRScript(outputKey)
+
+	// the merkle path of the leaf i is its sibling, followed by the leaves above it
+	control := func(i int) []byte {
+		var sibling [32]byte
+		switch i {
+		case 0:
+			sibling = hashes[1]
+		case 1:
+			sibling = hashes[0]
+		default:
+			sibling = hashes[0]
+			for _, h := range hashes[1:i] {
+				sibling = TapBranchHash(sibling, h)
+			}
+		}
+		c := append([]byte{leaves[i].version | parity}, internalKey[:]...)
+		c = append(c, sibling[:]...)
+		start := i + 1
+		if i == 0 {
+			start = 2
+		}
+		for _, h := range hashes[start:] {
+			c = append(c, h[:]...)
+		}
+		return c
+	}
+	badParity := control(0)
+	badParity[0] ^= 1
+
+	flags := VerifyWitness | VerifyTaproot
+	for _, tc := range []struct {
+		witness [][]byte
+		want    bool
+	}{
+		{[][]byte{sig2[:], sig1[:], raws[0], control(0)}, true},
+		{[][]byte{sig2[:], sig1[:], raws[0], control(0), {annexTag}}, true},
+		// an empty signature fails the check without failing the script
+		{[][]byte{{}, sig1[:], raws[0], control(0)}, false},
+		{[][]byte{sig1[:], sig2[:], raws[0], control(0)}, false},
+		{[][]byte{sig2[:], sig1[:], raws[0], badParity}, false},
+		{[][]byte{sig2[:], sig1[:], raws[0], control(0)[:40]}, false},
+		{[][]byte{sig2[:], sig1[:], raws[1], control(0)}, false},
+		// minimal if is mandatory
+		{[][]byte{{1}, raws[1], control(1)}, true},
+		{[][]byte{{2}, raws[1], control(1)}, false},
+		// unknown leaf versions and OP_SUCCESSx succeed
+		{[][]byte{raws[2], control(2)}, true},
+		{[][]byte{raws[3], control(3)}, true},
+		{[][]byte{raws[4], control(4)}, false},
+	} {
+		if (VerifyScript(Script{}, spk, tc.witness, &MockChecker{Sighash: sighash[:]}, flags) == nil) != tc.want {
+			t.Errorf("FAIL")
+		}
+	}
+}
+
+func TestChecksigaddBudget(t *testing.T) {
+	priv := ecdsa.GenerateKeyFromSecret(elliptic.Secp256k1, big.NewInt(1))
+	pk := schnorr.XOnly(&priv.PublicKey)
+	sighash := hash.Sha256([]byte("sighash"))
+	sig, _ := schnorr.Sign(priv, sighash[:], [32]byte{})
+
+	s := Script{element(sig[:]), OP_0, element(pk[:]), OP_CHECKSIGADD, OP_1, OP_NUMEQUAL}
+	e := newEngine(&MockChecker{Sighash: sighash[:]}, 0)
+	e.sigVersion = SigVersionTapscript
+	e.sigOpsBudget = tapscriptSigOpCost
+	if e.execute(s) != nil || !e.success() {
+		t.Errorf("FAIL")
+	}
+	// each signature check costs from the budget
+	e = newEngine(&MockChecker{Sighash: sighash[:]}, 0)
+	e.sigVersion = SigVersionTapscript
+	e.sigOpsBudget = tapscriptSigOpCost - 1
+	if e.execute(s) != ErrTapscriptValidationWeight {
+		t.Errorf("FAIL")
+	}
+	// only available in tapscripts
+	if run(s, &MockChecker{Sighash: sighash[:]}, 0) {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestTapTree(t *testing.T) {
+	// BIP341 wallet test vectors, scriptPubKey with a single leaf
+	var internalKey [32]byte
+	b, _ := hex.DecodeString("187791b6f712a8ea41c8ecdd0ee77fab3e85263b37e1ec18a3651926b3a6cf27")
+	copy(internalKey[:], b)
+	raw, _ := hex.DecodeString("20d85a959b0290bf19bb89ed43c916be835475d013da4b362117393e25a48229b8ac")
+	s, _ := ParseRaw(raw)
+	tree, err := NewTapTree(internalKey, NewTapLeaf(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafHash := tree.LeafHash(0)
+	if hex.EncodeToString(leafHash[:]) != "5b75adecf53548f3ec6ad7d78383bf84cc57b55a3127c72b9a2481752dd88b21" {
+		t.Errorf("FAIL")
+	}
+	if hex.EncodeToString(tree.OutputKey[:]) != "147c9c57132f6e7ecddba9800bb0c4449251c92a1e60371ee77557b6620f3ea3" {
+		t.Errorf("FAIL")
+	}
+	if addr, _ := tree.Address(false); addr != "bc1pz37fc4cn9ah8anwm4xqqhvxygjf9rjf2resrw8h8w4tmvcs0863sa2e586" {
+		t.Errorf("FAIL")
+	}
+	if hex.EncodeToString(tree.ControlBlock(0)) != "c1187791b6f712a8ea41c8ecdd0ee77fab3e85263b37e1ec18a3651926b3a6cf27" {
+		t.Errorf("FAIL")
+	}
+
+	// without leaves only the key path is possible
+	tree, _ = NewTapTree(internalKey)
+	outputKey, _, _ := TaprootOutputKey(internalKey, nil)
+	if tree.MerkleRoot != nil || tree.OutputKey != outputKey {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestTapTreeSpend(t *testing.T) {
+	internal := ecdsa.GenerateKeyFromSecret(elliptic.Secp256k1, big.NewInt(3))
+	var leaves []TapLeaf
+	for i := 1; i <= 5; i++ {
+		leaves = append(leaves, NewTapLeaf(Script{element(ScriptNum(i).Bytes()), OP_EQUAL}))
+	}
+	// the likely leaf is next to the root
+	leaves[3].Weight = 10
+	tree, err := NewTapTree(schnorr.XOnly(&internal.PublicKey), leaves...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tree.ControlBlock(3)) != controlBaseSize+controlNodeSize {
+		t.Errorf("FAIL")
+	}
+
+	spk := tree.ScriptPubKey()
+	flags := VerifyWitness | VerifyTaproot
+	for i := range leaves {
+		for j := range leaves {
+			witness, _ := tree.ScriptPathWitness(i, ScriptNum(j+1).Bytes())
+			if (VerifyScript(Script{}, spk, witness, nil, flags) == nil) != (i == j) {
+				t.Errorf("FAIL")
+			}
+		}
+	}
+
+	// the key path with the tweak of the merkle root
+	sighash := hash.Sha256([]byte("sighash"))
+	tweaked, _ := TaprootPrivateKey(internal, tree.MerkleRoot)
+	sig, _ := schnorr.Sign(tweaked, sighash[:], [32]byte{})
+	if VerifyScript(Script{}, spk, [][]byte{sig[:]}, &MockChecker{Sighash: sighash[:]}, flags) != nil {
+		t.Errorf("FAIL")
+	}
+
+	if _, err := NewTapTree(tree.InternalKey, TapLeaf{Script: Script{OP_1}, Weight: -1}); err == nil {
+		t.Errorf("FAIL")
+	}
+}
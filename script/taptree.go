@@ -0,0 +1,141 @@
+package script
+
+import (
+	"errors"
+	"sort"
+)
+
+// TapLeaf is a leaf of a Taproot script tree.
+type TapLeaf struct {
+	Version byte // TapscriptLeafVersion for BIP342 tapscripts
+	Script  Script
+	// Weight is how likely the leaf is to be spent, relative to the other leaves.
+	// The more likely leaves are placed closer to the root, so that their control blocks are shorter.
+	// Zero is the same as one.
+	Weight int
+}
+
+// NewTapLeaf returns the tapscript leaf with the default weight.
+func NewTapLeaf(s Script) TapLeaf {
+	return TapLeaf{Version: TapscriptLeafVersion, Script: s}
+}
+
+// TapTree is a Taproot output committing to the internal key and a tree of leaf scripts, see BIP341.
+//
+// The output can be spent either with a signature by the tweaked internal key (the key path),
+// see TaprootPrivateKey, or by satisfying one of the leaves (the script path), see ScriptPathWitness.
+type TapTree struct {
+	InternalKey [32]byte
+	Leaves      []TapLeaf
+	// MerkleRoot is nil when there are no leaves.
+	MerkleRoot []byte
+	OutputKey  [32]byte
+	// Parity is the parity of the y coordinate of the output key.
+	Parity byte
+
+	leafHashes [][32]byte
+	// paths holds for each leaf the hashes of its merkle path, from the leaf up to the root.
+	paths [][][32]byte
+}
+
+// NewTapTree builds the script tree of the leaves, in Huffman order by their weights,
+// and tweaks the x-only internal key with its root. With no leaves the output can only be spent
+// through the key path.
+//
+// Returns error if a leaf script can't be serialized, a weight is negative
+// or the tree would be deeper than the control blocks allow.
+func NewTapTree(internalKey [32]byte, leaves ...TapLeaf) (*TapTree, error) {
+	t := &TapTree{
+		InternalKey: internalKey,
+		Leaves:      leaves,
+		leafHashes:  make([][32]byte, len(leaves)),
+		paths:       make([][][32]byte, len(leaves)),
+	}
+
+	// node is a subtree, with the indices of the leaves under it
+	type node struct {
+		weight int
+		hash   [32]byte
+		leaves []int
+	}
+	nodes := make([]node, len(leaves))
+	for i, leaf := range leaves {
+		if leaf.Weight < 0 {
+			return nil, errors.New("NewTapTree: negative leaf weight")
+		}
+		h, err := TapLeafHash(leaf.Version, leaf.Script)
+		if err != nil {
+			return nil, err
+		}
+		weight := leaf.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		t.leafHashes[i] = h
+		nodes[i] = node{weight, h, []int{i}}
+	}
+
+	// repeatedly join the two lightest subtrees, the order of the leaves breaks the ties
+	for len(nodes) > 1 {
+		sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].weight < nodes[j].weight })
+		a, b := nodes[0], nodes[1]
+		for _, i := range a.leaves {
+			t.paths[i] = append(t.paths[i], b.hash)
+		}
+		for _, i := range b.leaves {
+			t.paths[i] = append(t.paths[i], a.hash)
+		}
+		joined := node{a.weight + b.weight, TapBranchHash(a.hash, b.hash), append(a.leaves, b.leaves...)}
+		nodes = append(nodes[2:], joined)
+	}
+	for _, path := range t.paths {
+		if len(path) > controlMaxNodes {
+			return nil, errors.New("NewTapTree: the tree is too deep")
+		}
+	}
+
+	if len(nodes) == 1 {
+		t.MerkleRoot = nodes[0].hash[:]
+	}
+	var err error
+	if t.OutputKey, t.Parity, err = TaprootOutputKey(internalKey, t.MerkleRoot); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ScriptPubKey returns the P2TR script of the output key.
+func (t *TapTree) ScriptPubKey() Script {
+	return NewP2TRScript(t.OutputKey)
+}
+
+// Address returns the P2TR address of the output key.
+func (t *TapTree) Address(testnet bool) (string, error) {
+	return t.ScriptPubKey().Address(testnet)
+}
+
+// LeafHash returns the hash of the i-th leaf, which script path signatures commit to.
+func (t *TapTree) LeafHash(i int) [32]byte {
+	return t.leafHashes[i]
+}
+
+// ControlBlock returns the control block proving that the i-th leaf is committed to by the output key:
+//     (leaf version | parity) || internal key || merkle path
+func (t *TapTree) ControlBlock(i int) []byte {
+	c := append([]byte{t.Leaves[i].Version | t.Parity}, t.InternalKey[:]...)
+	for _, h := range t.paths[i] {
+		c = append(c, h[:]...)
+	}
+	return c
+}
+
+// ScriptPathWitness returns the witness spending the i-th leaf:
+// the stack for the leaf script, followed by the leaf script and its control block.
+func (t *TapTree) ScriptPathWitness(i int, stack ...[]byte) ([][]byte, error) {
+	raw, err := t.Leaves[i].Script.Raw()
+	if err != nil {
+		return nil, err
+	}
+	witness := append([][]byte{}, stack...)
+	return append(witness, raw, t.ControlBlock(i)), nil
+}
@@ -16,8 +16,8 @@ type opcode uint8
 type element []byte
 
 func (op opcode) Equal(other command) bool {
-	x := other.(opcode)
-	return op == x
+	x, ok := other.(opcode)
+	return ok && op == x
 }
 
 func (op opcode) String() string {
@@ -25,10 +25,51 @@ func (op opcode) String() string {
 }
 
 func (el element) Equal(other command) bool {
-	x := other.(element)
-	return bytes.Equal(el, x)
+	x, ok := other.(element)
+	return ok && bytes.Equal(el, x)
 }
 
 func (el element) String() string {
 	return hex.EncodeToString(el)
 }
+
+// pushData is an element pushed by OP_PUSHDATA1, OP_PUSHDATA2 or OP_PUSHDATA4
+// although it is short enough for a shorter push. Elements are serialized
+// by the shortest push, so these are kept apart to serialize the script to the same bytes.
+type pushData struct {
+	op opcode
+	el element
+}
+
+func (p pushData) Equal(other command) bool {
+	x, ok := other.(pushData)
+	return ok && p.op == x.op && bytes.Equal(p.el, x.el)
+}
+
+func (p pushData) String() string {
+	return p.el.String()
+}
+
+// unparsed is the end of a script which doesn't parse, because a push runs past it.
+// Unmarshaled scripts keep it to serialize to the same bytes, it fails when executed.
+type unparsed []byte
+
+func (u unparsed) Equal(other command) bool {
+	x, ok := other.(unparsed)
+	return ok && bytes.Equal(u, x)
+}
+
+func (u unparsed) String() string {
+	return "[error]"
+}
+
+// asElement returns the element pushed by the command, if it is a push.
+func asElement(cmd command) (element, bool) {
+	switch cmd := cmd.(type) {
+	case element:
+		return cmd, true
+	case pushData:
+		return cmd.el, true
+	}
+	return nil, false
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/VIVelev/btcd/tx"
+)
+
+// traceInput prints the execution trace of the scripts verifying the transaction input:
+//     trace [-testnet] [-json] <txid> <input index>
+func traceInput(args []string) error {
+	flags := flag.NewFlagSet("trace", flag.ContinueOnError)
+	testnet := flags.Bool("testnet", false, "fetch the transaction from the testnet")
+	asJSON := flags.Bool("json", false, "print the trace as JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return errors.New("usage: trace [-testnet] [-json] <txid> <input index>")
+	}
+	index, err := strconv.Atoi(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	t, err := tx.Fetch(flags.Arg(0), *testnet, false)
+	if err != nil {
+		return err
+	}
+	if index < 0 || index >= len(t.TxIns) {
+		return errors.New("trace: input index out of range")
+	}
+	e, err := t.InputEngine(index)
+	if err != nil {
+		return err
+	}
+	e.EnableTrace()
+	verifyErr := e.Execute()
+
+	if *asJSON {
+		b, err := json.MarshalIndent(e.Trace(), "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "PHASE\tPC\tCOMMAND\tSTACK\tALTSTACK")
+		for _, step := range e.Trace() {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", step.Phase, step.PC, abbreviate(step.Command),
+				hexItems(step.Stack), hexItems(step.AltStack))
+		}
+		w.Flush()
+	}
+
+	if verifyErr != nil {
+		fmt.Printf("input %d is invalid: %v\n", index, verifyErr)
+	} else {
+		fmt.Printf("input %d is valid\n", index)
+	}
+	return nil
+}
+
+// hexItems returns the stack elements in hex, separated by spaces, the top last.
+func hexItems(items [][]byte) string {
+	words := make([]string, len(items))
+	for i, item := range items {
+		words[i] = abbreviate(hex.EncodeToString(item))
+		if len(item) == 0 {
+			words[i] = "[]"
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// abbreviate shortens the long pushes, such as signatures and scripts, to fit on a line.
+func abbreviate(s string) string {
+	if len(s) <= 20 {
+		return s
+	}
+	return s[:8] + ".." + s[len(s)-8:]
+}
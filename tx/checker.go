@@ -0,0 +1,76 @@
+package tx
+
+import (
+	"github.com/VIVelev/btcd/btcutil"
+	"github.com/VIVelev/btcd/crypto/schnorr"
+	"github.com/VIVelev/btcd/script"
+)
+
+// InputChecker is the script.SignatureChecker of an input of the transaction:
+// it computes the sighashes the signatures of the input commit to.
+type InputChecker struct {
+	script.TxContext
+
+	tx     *Tx
+	index  int
+	amount btcutil.Amount
+	// prevOuts are the outputs spent by all of the inputs, which the Taproot signatures commit to.
+	prevOuts []TxOut
+}
+
+// NewInputChecker returns the checker of the input with the index, spending the amount.
+// prevOuts are the outputs spent by all of the inputs, see PrevOuts,
+// they are only needed for Taproot signatures and may be nil otherwise.
+func NewInputChecker(t *Tx, index int, amount btcutil.Amount, prevOuts []TxOut) *InputChecker {
+	return &InputChecker{
+		TxContext: script.TxContext{
+			Version:  t.Version,
+			LockTime: t.LockTime,
+			Sequence: t.TxIns[index].Sequence,
+		},
+		tx:       t,
+		index:    index,
+		amount:   amount,
+		prevOuts: prevOuts,
+	}
+}
+
+func (c *InputChecker) CheckSig(sig, pubKey []byte, scriptCode script.Script, sigVersion script.SigVersion) bool {
+	if len(sig) == 0 {
+		return false
+	}
+	hashType := uint32(sig[len(sig)-1])
+	var sighash [32]byte
+	var err error
+	switch sigVersion {
+	case script.SigVersionBase:
+		if scriptCode == nil {
+			scriptCode = script.Script{}
+		}
+		sighash, err = c.tx.SighashLegacy(c.index, scriptCode, hashType)
+	case script.SigVersionWitnessV0:
+		sighash, err = c.tx.sighashBip143(c.index, scriptCode, c.amount, hashType)
+	default:
+		return false
+	}
+	return err == nil && script.CheckECDSASig(sig, pubKey, sighash[:])
+}
+
+func (c *InputChecker) CheckSchnorrSig(sig, pubKey []byte, sigVersion script.SigVersion, execData *script.TaprootExecData) bool {
+	hashType := SighashDefault
+	switch len(sig) {
+	case 64:
+	case 65:
+		hashType = uint32(sig[64])
+	default:
+		return false
+	}
+	var leafHash []byte
+	codeSepPos := uint32(0xffffffff)
+	if sigVersion == script.SigVersionTapscript {
+		leafHash = execData.LeafHash
+		codeSepPos = execData.CodeSepPos
+	}
+	sighash, err := c.tx.sighashTaproot(c.index, c.prevOuts, hashType, leafHash, execData.Annex, codeSepPos)
+	return err == nil && schnorr.Verify(pubKey, sighash[:], sig[:64])
+}
@@ -0,0 +1,65 @@
+package tx
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/VIVelev/btcd/crypto/ecdsa"
+	"github.com/VIVelev/btcd/crypto/elliptic"
+	"github.com/VIVelev/btcd/crypto/schnorr"
+	"github.com/VIVelev/btcd/script"
+)
+
+func TestInputCheckerTapscript(t *testing.T) {
+	// <pk1> OP_CHECKSIGVERIFY OP_CODESEPARATOR <pk2> OP_CHECKSIG,
+	// the second signature commits to the OP_CODESEPARATOR at position 2
+	priv1 := ecdsa.GenerateKeyFromSecret(elliptic.Secp256k1, big.NewInt(1))
+	priv2 := ecdsa.GenerateKeyFromSecret(elliptic.Secp256k1, big.NewInt(2))
+	pk1, pk2 := schnorr.XOnly(&priv1.PublicKey), schnorr.XOnly(&priv2.PublicKey)
+	var leaf script.Script
+	leaf = leaf.AddBytes(pk1[:])
+	leaf = leaf.Add(script.OP_CHECKSIGVERIFY, script.OP_CODESEPARATOR)
+	leaf = leaf.AddBytes(pk2[:])
+	leaf = leaf.Add(script.OP_CHECKSIG)
+	internal := ecdsa.GenerateKeyFromSecret(elliptic.Secp256k1, big.NewInt(3))
+	tree, err := script.NewTapTree(schnorr.XOnly(&internal.PublicKey), script.NewTapLeaf(leaf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prevOut := OutPoint{TxId: [32]byte{1}}
+	prevOuts := []TxOut{{Amount: 100000, ScriptPubKey: tree.ScriptPubKey()}}
+	defer func(f PrevOutFetcher) { DefaultFetcher = f }(DefaultFetcher)
+	DefaultFetcher = PrevOutMap{prevOut: prevOuts[0]}
+	newTx := Tx{
+		Version: 2,
+		TxIns:   []TxIn{{PrevTxId: prevOut.TxId, Sequence: 0xffffffff}},
+		TxOuts:  []TxOut{{Amount: 90000, ScriptPubKey: tree.ScriptPubKey()}},
+		SegWit:  true,
+	}
+
+	leafHash := tree.LeafHash(0)
+	sign := func(priv *ecdsa.PrivateKey, hashType uint32, codeSepPos uint32) []byte {
+		sighash, _ := newTx.sighashTaproot(0, prevOuts, hashType, leafHash[:], nil, codeSepPos)
+		sig, _ := schnorr.Sign(priv, sighash[:], [32]byte{})
+		if hashType == SighashDefault {
+			return sig[:]
+		}
+		return append(sig[:], byte(hashType))
+	}
+	for _, tc := range []struct {
+		sig1, sig2 []byte
+		want       bool
+	}{
+		{sign(priv1, SighashDefault, 0xffffffff), sign(priv2, SighashDefault, 2), true},
+		// each signature has its own hash type
+		{sign(priv1, SighashSingle, 0xffffffff), sign(priv2, SighashAll, 2), true},
+		{sign(priv1, SighashDefault, 0xffffffff), sign(priv2, SighashDefault, 0xffffffff), false},
+		{sign(priv1, SighashDefault, 2), sign(priv2, SighashDefault, 2), false},
+	} {
+		newTx.TxIns[0].Witness, _ = tree.ScriptPathWitness(0, tc.sig2, tc.sig1)
+		if ok, err := newTx.VerifyInput(0); err != nil || ok != tc.want {
+			t.Errorf("FAIL: %v", err)
+		}
+	}
+}
@@ -45,3 +45,43 @@ func Fetch(txId string, testnet, fresh bool) (Tx, error) {
 	}
 	return tx, nil
 }
+
+// OutPoint identifies an output by the ID of its transaction and its index.
+type OutPoint struct {
+	TxId  [32]byte
+	Index uint32
+}
+
+// PrevOutFetcher looks up the outputs spent by the inputs.
+type PrevOutFetcher interface {
+	// PrevOut returns the output spent by the input.
+	PrevOut(in *TxIn) (TxOut, error)
+}
+
+// DefaultFetcher is used to look up the spent outputs when no fetcher is given.
+var DefaultFetcher PrevOutFetcher = ExplorerFetcher{}
+
+// ExplorerFetcher fetches the previous transactions from the block explorer, see Fetch.
+type ExplorerFetcher struct{}
+
+func (ExplorerFetcher) PrevOut(in *TxIn) (TxOut, error) {
+	tx, err := Fetch(hex.EncodeToString(in.PrevTxId[:]), in.TestNet, false)
+	if err != nil {
+		return TxOut{}, err
+	}
+	if int(in.PrevIndex) >= len(tx.TxOuts) {
+		return TxOut{}, errors.New("ExplorerFetcher: output index out of range")
+	}
+	return tx.TxOuts[in.PrevIndex], nil
+}
+
+// PrevOutMap is a PrevOutFetcher of known outputs.
+type PrevOutMap map[OutPoint]TxOut
+
+func (m PrevOutMap) PrevOut(in *TxIn) (TxOut, error) {
+	out, ok := m[OutPoint{in.PrevTxId, in.PrevIndex}]
+	if !ok {
+		return TxOut{}, errors.New("PrevOutMap: unknown output")
+	}
+	return out, nil
+}
@@ -0,0 +1,295 @@
+package tx
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+
+	"github.com/VIVelev/btcd/btcutil"
+	"github.com/VIVelev/btcd/crypto/hash"
+	"github.com/VIVelev/btcd/script"
+	"github.com/VIVelev/btcd/utils"
+)
+
+// The JSON representations follow the layout of Bitcoin Core's decoderawtransaction RPC.
+
+type jsonScriptSig struct {
+	Asm string `json:"asm"`
+	Hex string `json:"hex"`
+}
+
+type jsonScriptPubKey struct {
+	Asm     string `json:"asm"`
+	Hex     string `json:"hex"`
+	Type    string `json:"type"`
+	Address string `json:"address,omitempty"`
+}
+
+type jsonTxIn struct {
+	Coinbase  string         `json:"coinbase,omitempty"`
+	TxId      string         `json:"txid,omitempty"`
+	Vout      *uint32        `json:"vout,omitempty"`
+	ScriptSig *jsonScriptSig `json:"scriptSig,omitempty"`
+	Witness   []string       `json:"txinwitness,omitempty"`
+	Sequence  uint32         `json:"sequence"`
+}
+
+type jsonTxOut struct {
+	Value        btcutil.Amount   `json:"value"`
+	N            *int             `json:"n,omitempty"`
+	ScriptPubKey jsonScriptPubKey `json:"scriptPubKey"`
+}
+
+type jsonTx struct {
+	TxId     string      `json:"txid"`
+	Hash     string      `json:"hash"`
+	Version  uint32      `json:"version"`
+	Size     int         `json:"size"`
+	VSize    int         `json:"vsize"`
+	Weight   int         `json:"weight"`
+	LockTime uint32      `json:"locktime"`
+	Vin      []jsonTxIn  `json:"vin"`
+	Vout     []jsonTxOut `json:"vout"`
+}
+
+// Wtxid returns the witness transaction ID, which commits to the witness as well.
+// It is the same as the Id for non-SegWit transactions.
+func (t *Tx) Wtxid() (string, error) {
+	b, err := t.Marshal()
+	if err != nil {
+		return "", err
+	}
+	b32 := hash.Hash256(b)
+	return hex.EncodeToString(utils.Reversed(b32[:])), nil
+}
+
+// Weight returns the weight of the transaction in weight units, as defined in BIP141:
+//     weight = 3 * <size without witness> + <size with witness>
+func (t *Tx) Weight() (int, error) {
+	tmp := t.SegWit
+	t.SegWit = false
+	base, err := t.Marshal()
+	t.SegWit = tmp
+	if err != nil {
+		return 0, err
+	}
+	total, err := t.Marshal()
+	if err != nil {
+		return 0, err
+	}
+	return 3*len(base) + len(total), nil
+}
+
+// MarshalJSON encodes the transaction with its txid, hash (wtxid), size, vsize, weight,
+// inputs and outputs. Output addresses are for the network given by TestNet.
+func (t *Tx) MarshalJSON() ([]byte, error) {
+	txId, err := t.Id()
+	if err != nil {
+		return nil, err
+	}
+	wtxid, err := t.Wtxid()
+	if err != nil {
+		return nil, err
+	}
+	b, err := t.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	weight, err := t.Weight()
+	if err != nil {
+		return nil, err
+	}
+
+	v := jsonTx{
+		TxId:     txId,
+		Hash:     wtxid,
+		Version:  t.Version,
+		Size:     len(b),
+		VSize:    (weight + 3) / 4,
+		Weight:   weight,
+		LockTime: t.LockTime,
+		Vin:      make([]jsonTxIn, len(t.TxIns)),
+		Vout:     make([]jsonTxOut, len(t.TxOuts)),
+	}
+	for i := range t.TxIns {
+		if v.Vin[i], err = t.TxIns[i].json(); err != nil {
+			return nil, err
+		}
+	}
+	for i := range t.TxOuts {
+		if v.Vout[i], err = t.TxOuts[i].json(t.TestNet); err != nil {
+			return nil, err
+		}
+		n := i
+		v.Vout[i].N = &n
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON decodes the transaction from its version, locktime, inputs and outputs.
+//
+// Returns error if the given txid does not match the decoded transaction.
+func (t *Tx) UnmarshalJSON(data []byte) error {
+	var v struct {
+		TxId     string            `json:"txid"`
+		Version  uint32            `json:"version"`
+		LockTime uint32            `json:"locktime"`
+		Vin      []json.RawMessage `json:"vin"`
+		Vout     []json.RawMessage `json:"vout"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	*t = Tx{Version: v.Version, LockTime: v.LockTime, TestNet: t.TestNet}
+	t.TxIns = make([]TxIn, len(v.Vin))
+	for i := range v.Vin {
+		if err := t.TxIns[i].UnmarshalJSON(v.Vin[i]); err != nil {
+			return err
+		}
+		t.TxIns[i].TestNet = t.TestNet
+		if len(t.TxIns[i].Witness) > 0 {
+			t.SegWit = true
+		}
+	}
+	t.TxOuts = make([]TxOut, len(v.Vout))
+	for i := range v.Vout {
+		if err := t.TxOuts[i].UnmarshalJSON(v.Vout[i]); err != nil {
+			return err
+		}
+	}
+
+	if v.TxId != "" {
+		txId, err := t.Id()
+		if err != nil {
+			return err
+		}
+		if txId != v.TxId {
+			return errors.New("Tx.UnmarshalJSON: txid mismatch")
+		}
+	}
+	return nil
+}
+
+// isCoinbase returns whether the input spends no previous output,
+// as the only input of a coinbase transaction does.
+func (in *TxIn) isCoinbase() bool {
+	return in.PrevTxId == [32]byte{} && in.PrevIndex == 0xffffffff
+}
+
+func (in *TxIn) json() (jsonTxIn, error) {
+	raw, err := in.ScriptSig.Raw()
+	if err != nil {
+		return jsonTxIn{}, err
+	}
+	v := jsonTxIn{Sequence: in.Sequence}
+	if in.isCoinbase() {
+		v.Coinbase = hex.EncodeToString(raw)
+	} else {
+		vout := in.PrevIndex
+		v.TxId = hex.EncodeToString(in.PrevTxId[:])
+		v.Vout = &vout
+		v.ScriptSig = &jsonScriptSig{Asm: in.ScriptSig.String(), Hex: hex.EncodeToString(raw)}
+	}
+	for _, item := range in.Witness {
+		v.Witness = append(v.Witness, hex.EncodeToString(item))
+	}
+	return v, nil
+}
+
+// MarshalJSON encodes the input as {"txid", "vout", "scriptSig", "txinwitness", "sequence"},
+// or {"coinbase", "txinwitness", "sequence"} for the input of a coinbase transaction.
+func (in *TxIn) MarshalJSON() ([]byte, error) {
+	v, err := in.json()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+func (in *TxIn) UnmarshalJSON(data []byte) error {
+	var v jsonTxIn
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	*in = TxIn{Sequence: v.Sequence, TestNet: in.TestNet}
+	var scriptSig string
+	if v.TxId == "" && v.Coinbase == "" {
+		return errors.New("TxIn.UnmarshalJSON: missing txid")
+	}
+	if v.TxId == "" {
+		in.PrevIndex = 0xffffffff
+		scriptSig = v.Coinbase
+	} else {
+		b, err := hex.DecodeString(v.TxId)
+		if err != nil || len(b) != 32 {
+			return errors.New("TxIn.UnmarshalJSON: invalid txid")
+		}
+		if v.Vout == nil || v.ScriptSig == nil {
+			return errors.New("TxIn.UnmarshalJSON: missing vout or scriptSig")
+		}
+		copy(in.PrevTxId[:], b)
+		in.PrevIndex = *v.Vout
+		scriptSig = v.ScriptSig.Hex
+	}
+
+	raw, err := hex.DecodeString(scriptSig)
+	if err != nil {
+		return err
+	}
+	if in.ScriptSig, err = script.ParseRaw(raw); err != nil {
+		return err
+	}
+	for _, item := range v.Witness {
+		b, err := hex.DecodeString(item)
+		if err != nil {
+			return err
+		}
+		in.Witness = append(in.Witness, b)
+	}
+	return nil
+}
+
+func (out *TxOut) json(testnet bool) (jsonTxOut, error) {
+	raw, err := out.ScriptPubKey.Raw()
+	if err != nil {
+		return jsonTxOut{}, err
+	}
+	addr, _ := out.ScriptPubKey.Address(testnet)
+	return jsonTxOut{
+		Value: out.Amount,
+		ScriptPubKey: jsonScriptPubKey{
+			Asm:     out.ScriptPubKey.String(),
+			Hex:     hex.EncodeToString(raw),
+			Type:    out.ScriptPubKey.Type(),
+			Address: addr,
+		},
+	}, nil
+}
+
+// MarshalJSON encodes the output as {"value", "scriptPubKey"}, with the value in BTC
+// and the mainnet address of the ScriptPubKey.
+func (out *TxOut) MarshalJSON() ([]byte, error) {
+	v, err := out.json(false)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+func (out *TxOut) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Value        *btcutil.Amount `json:"value"`
+		ScriptPubKey script.Script   `json:"scriptPubKey"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Value == nil {
+		return errors.New("TxOut.UnmarshalJSON: missing value")
+	}
+	out.Amount = *v.Value
+	out.ScriptPubKey = v.ScriptPubKey
+	return nil
+}
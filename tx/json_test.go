@@ -0,0 +1,85 @@
+package tx
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// signed P2WPKH example from BIP143
+const segWitTxHex = "01000000000102fff7f7881a8099afa6940d42d1e7f6362bec38171ea3edf433541db4e4ad969f00000000494830450221008b9d1dc26ba6a9cb62127b02742fa9d754cd3bebf337f7a55d114c8e5cdd30be022040529b194ba3f9281a99f2b1c0a19c0489bc22ede944ccf4ecbab4cc618ef3ed01eeffffffef51e1b804cc89d182d279655c3aa89e815b1b309fe287d9b2b55d57b90ec68a0100000000ffffffff02202cb206000000001976a9148280b37df378db99f66f85c95a783a76ac7a6d5988ac9093510d000000001976a9143bde42dbee7e4dbe6a21b2d50ce2f0167faa815988ac000247304402203609e17b84f6a7d30c80bfa610b5b4542f32a8a0d5447a12fb1366d7f01cc44a0220573a954c4518331561406f90300e8f3358f51928d43c212a8caed02de67eebee0121025476c2e83188368da1ff3e292e7acafcdb3566bb0ad253f62fc70f07aeee635711000000"
+
+func TestMarshalJSON(t *testing.T) {
+	b, _ := hex.DecodeString(segWitTxHex)
+	tx, _ := new(Tx).Unmarshal(bytes.NewReader(b))
+	j, err := json.Marshal(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v map[string]interface{}
+	json.Unmarshal(j, &v)
+	if v["txid"] != "e8151a2af31c368a35053ddd4bdb285a8595c769a3ad83e0fa02314a602d4609" ||
+		v["hash"] != "c36c38370907df2324d9ce9d149d191192f338b37665a82e78e76a12c909b762" ||
+		v["size"] != 343.0 || v["vsize"] != 261.0 || v["weight"] != 1042.0 || v["locktime"] != 17.0 {
+		t.Errorf("FAIL")
+	}
+	for _, want := range []string{
+		`"txinwitness":["304402203609e17b84f6a7d30c80bfa610b5b4542f32a8a0d5447a12fb1366d7f01cc44a0220573a954c4518331561406f90300e8f3358f51928d43c212a8caed02de67eebee01","025476c2e83188368da1ff3e292e7acafcdb3566bb0ad253f62fc70f07aeee6357"]`,
+		`"value":1.12340000,"n":0`,
+		`"asm":"OP_DUP OP_HASH160 8280b37df378db99f66f85c95a783a76ac7a6d59 OP_EQUALVERIFY OP_CHECKSIG"`,
+		`"type":"pubkeyhash","address":"1Cu32FVupVCgHkMMRJdYJugxwo2Aprgk7H"`,
+	} {
+		if !strings.Contains(string(j), want) {
+			t.Errorf("FAIL: %s", want)
+		}
+	}
+
+	var decoded Tx
+	if err := json.Unmarshal(j, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	m, _ := decoded.Marshal()
+	if !bytes.Equal(m, b) {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestUnmarshalJSONCoinbase(t *testing.T) {
+	j := `{"version":1,"locktime":0,"vin":[{"coinbase":"04ffff001d0104","sequence":4294967295}],
+		"vout":[{"value":50.00000000,"scriptPubKey":{"hex":"6a"}}]}`
+	var tx Tx
+	if err := json.Unmarshal([]byte(j), &tx); err != nil {
+		t.Fatal(err)
+	}
+	if !tx.TxIns[0].isCoinbase() || tx.TxOuts[0].Amount != 5000000000 || tx.SegWit {
+		t.Errorf("FAIL")
+	}
+
+	b, _ := json.Marshal(&tx.TxIns[0])
+	if string(b) != `{"coinbase":"04ffff001d0104","sequence":4294967295}` {
+		t.Errorf("FAIL: %s", b)
+	}
+	b, _ = json.Marshal(&tx.TxOuts[0])
+	if string(b) != `{"value":50.00000000,"scriptPubKey":{"asm":"OP_RETURN","hex":"6a","type":"nulldata"}}` {
+		t.Errorf("FAIL: %s", b)
+	}
+}
+
+func TestUnmarshalJSONInvalid(t *testing.T) {
+	for _, j := range []string{
+		`{"txid":"00","version":1,"vin":[],"vout":[]}`,
+		`{"version":1,"vin":[{"sequence":0}],"vout":[]}`,
+		`{"version":1,"vin":[{"txid":"ab","vout":0,"scriptSig":{"hex":""},"sequence":0}],"vout":[]}`,
+		`{"version":1,"vin":[],"vout":[{"scriptPubKey":{"hex":""}}]}`,
+		`{"version":1,"vin":[],"vout":[{"value":"1","scriptPubKey":{"hex":""}}]}`,
+		`{"version":1,"vin":[],"vout":[{"value":1,"scriptPubKey":{"hex":"4c"}}]}`,
+	} {
+		var tx Tx
+		if err := json.Unmarshal([]byte(j), &tx); err == nil {
+			t.Errorf("FAIL: %s", j)
+		}
+	}
+}
@@ -4,14 +4,17 @@ package tx
 
 import (
 	"bytes"
+	"crypto/rand"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"io"
 	"math/big"
 
+	"github.com/VIVelev/btcd/btcutil"
 	"github.com/VIVelev/btcd/crypto/ecdsa"
 	"github.com/VIVelev/btcd/crypto/hash"
+	"github.com/VIVelev/btcd/crypto/schnorr"
 	"github.com/VIVelev/btcd/encoding"
 	"github.com/VIVelev/btcd/script"
 	"github.com/VIVelev/btcd/utils"
@@ -24,6 +27,14 @@ const (
 	SighashSingle
 )
 
+// SighashAnyoneCanPay is combined with the other sighash types, so that the signature
+// commits to its input alone, and other inputs can be added to the transaction.
+const SighashAnyoneCanPay = uint32(0x80)
+
+// SighashDefault signs the whole transaction, as SighashAll, but is implied by omitting
+// the hash type from the signature. It is only valid for Taproot, see BIP341.
+const SighashDefault = uint32(0)
+
 type Tx struct {
 	Version  uint32
 	TxIns    []TxIn
@@ -34,7 +45,7 @@ type Tx struct {
 	TestNet bool
 	SegWit  bool
 
-	// The following are (re)used in SeghashBip143 to fix the O(n^2) hashing problem.
+	// The following are (re)used in SighashBip143 and SighashTaproot to fix the O(n^2) hashing problem.
 	// The first byte signalizes whether the cache is empty.
 	hashPrevoutsCache [33]byte
 	hashSequenceCache [33]byte
@@ -54,51 +65,111 @@ func (t *Tx) Id() (string, error) {
 	return hex.EncodeToString(utils.Reversed(b32[:])), nil
 }
 
-// Fee returns the fee of this transaction in satoshi
-func (t *Tx) Fee() (int, error) {
-	var inputSum, outputSum uint64 = 0, 0
+// Fee returns the fee of this transaction.
+//
+// Returns error if an output amount is outside of the money range or the sums overflow.
+func (t *Tx) Fee() (btcutil.Amount, error) {
+	var inputSum, outputSum btcutil.Amount
 	for _, in := range t.TxIns {
 		v, err := in.Value()
 		if err != nil {
 			return 0, err
 		}
-		inputSum += v
+		if inputSum, err = inputSum.Add(v); err != nil {
+			return 0, err
+		}
 	}
 	for _, out := range t.TxOuts {
-		outputSum += out.Amount
+		if !out.Amount.IsValid() {
+			return 0, errors.New("Fee: output amount out of range")
+		}
+		var err error
+		if outputSum, err = outputSum.Add(out.Amount); err != nil {
+			return 0, err
+		}
 	}
-
-	a, b := int(inputSum), int(outputSum)
-	return a - b, nil
+	return inputSum.Sub(outputSum)
 }
 
 // SighashLegacy returns the message that needs to get signed for the input with the index.
-func (t *Tx) SighashLegacy(index int) ([32]byte, error) {
+// scriptCode replaces the ScriptSig of the input, if nil the ScriptPubKey of the UTXO is fetched,
+// the OP_CODESEPARATORs in it are skipped.
+//
+// hashType selects the parts of the transaction the signature commits to. With SighashSingle,
+// for an input without an output of the same index, the message is 1, for the compatibility with
+// a bug of the original implementation: such signatures commit to nothing.
+func (t *Tx) SighashLegacy(index int, scriptCode script.Script, hashType uint32) ([32]byte, error) {
+	var err error
+	if scriptCode == nil {
+		scriptCode, err = t.TxIns[index].ScriptPubKey()
+		if err != nil {
+			return [32]byte{}, err
+		}
+	}
+	outputType := hashType & 0x1f
+	anyoneCanPay := hashType&SighashAnyoneCanPay != 0
+	if outputType == SighashSingle && index >= len(t.TxOuts) {
+		return [32]byte{1}, nil
+	}
+	var code script.Script
+	for _, cmd := range scriptCode {
+		if cmd != script.OP_CODESEPARATOR {
+			code = append(code, cmd)
+		}
+	}
+
+	// the signed input alone, with ANYONECANPAY
+	ins := t.TxIns
+	if anyoneCanPay {
+		ins = t.TxIns[index : index+1]
+	}
+	// none of the outputs with NONE, and up to the one of the same index with SINGLE
+	outs := t.TxOuts
+	switch outputType {
+	case SighashNone:
+		outs = nil
+	case SighashSingle:
+		outs = make([]TxOut, index+1)
+		for i := range outs[:index] {
+			// the outputs before are blanked out, with the amount of -1
+			outs[i] = TxOut{Amount: -1, ScriptPubKey: script.Script{}}
+		}
+		outs[index] = t.TxOuts[index]
+	}
+
 	buf := new(bytes.Buffer)
 	// Version, 4 bytes, little-endian
 	binary.Write(buf, binary.LittleEndian, t.Version)
 	// Number of Inputs, VarInt
-	b, err := encoding.EncodeVarInt(big.NewInt(int64(len(t.TxIns))))
+	b, err := encoding.EncodeVarInt(big.NewInt(int64(len(ins))))
 	if err != nil {
 		return [32]byte{}, err
 	}
 	buf.Write(b)
-	// TxIns, overrided
-	for i, in := range t.TxIns {
-		b, err = in.marshalScriptOverride(i == index)
+	// TxIns, with the ScriptSig overridden by the scriptCode for the signed input
+	// and by an empty Script for the rest, the rest of the sequences are zeroed with NONE and SINGLE
+	for i, in := range ins {
+		if anyoneCanPay || i == index {
+			b, err = in.marshal(code)
+		} else {
+			if outputType == SighashNone || outputType == SighashSingle {
+				in.Sequence = 0
+			}
+			b, err = in.marshal(script.Script{})
+		}
 		if err != nil {
 			return [32]byte{}, err
 		}
 		buf.Write(b)
 	}
 	// Number of Outputs, VarInt
-	b, err = encoding.EncodeVarInt(big.NewInt(int64(len(t.TxOuts))))
+	b, err = encoding.EncodeVarInt(big.NewInt(int64(len(outs))))
 	if err != nil {
 		return [32]byte{}, err
 	}
 	buf.Write(b)
 	// TxOuts
-	for _, out := range t.TxOuts {
+	for _, out := range outs {
 		b, err = out.Marshal()
 		if err != nil {
 			return [32]byte{}, err
@@ -108,13 +179,13 @@ func (t *Tx) SighashLegacy(index int) ([32]byte, error) {
 	// LockTime, 4 bytes, little-endian
 	binary.Write(buf, binary.LittleEndian, t.LockTime)
 	// Sighash type, 4 bytes, little-endian
-	binary.Write(buf, binary.LittleEndian, SighashAll)
+	binary.Write(buf, binary.LittleEndian, hashType)
 	// Hash256
 	return hash.Hash256(buf.Bytes()), err
 }
 
-// hashPrevouts returns Hash256(<txIn.PrevTxId> + <txIn.PrevIndex> for all inputs)
-func (t *Tx) hashPrevouts() (ret [32]byte) {
+// shaPrevouts returns Sha256(<txIn.PrevTxId> + <txIn.PrevIndex> for all inputs)
+func (t *Tx) shaPrevouts() (ret [32]byte) {
 	if t.hashPrevoutsCache[0] == 0 {
 		// the cache is empty
 		allPrevouts := make([]byte, 36*len(t.TxIns))
@@ -126,7 +197,7 @@ func (t *Tx) hashPrevouts() (ret [32]byte) {
 			copy(allPrevouts[i*36:(i+1)*36], bytes.Join([][]byte{prevTxId[:], prevIndex[:]}, nil))
 		}
 
-		h := hash.Hash256(allPrevouts)
+		h := hash.Sha256(allPrevouts)
 		t.hashPrevoutsCache[0] = 1
 		copy(t.hashPrevoutsCache[1:], h[:])
 	}
@@ -135,8 +206,14 @@ func (t *Tx) hashPrevouts() (ret [32]byte) {
 	return
 }
 
-// hashSequence returns Hash256(<txIn.Sequence> for all inputs)
-func (t *Tx) hashSequence() (ret [32]byte) {
+// hashPrevouts returns Hash256(<txIn.PrevTxId> + <txIn.PrevIndex> for all inputs)
+func (t *Tx) hashPrevouts() [32]byte {
+	h := t.shaPrevouts()
+	return hash.Sha256(h[:])
+}
+
+// shaSequence returns Sha256(<txIn.Sequence> for all inputs)
+func (t *Tx) shaSequence() (ret [32]byte) {
 	if t.hashSequenceCache[0] == 0 {
 		// the cache is empty
 		allSequence := make([]byte, 4*len(t.TxIns))
@@ -144,7 +221,7 @@ func (t *Tx) hashSequence() (ret [32]byte) {
 			binary.LittleEndian.PutUint32(allSequence[i*4:(i+1)*4], txIn.Sequence)
 		}
 
-		h := hash.Hash256(allSequence)
+		h := hash.Sha256(allSequence)
 		t.hashSequenceCache[0] = 1
 		copy(t.hashSequenceCache[1:], h[:])
 	}
@@ -153,8 +230,14 @@ func (t *Tx) hashSequence() (ret [32]byte) {
 	return
 }
 
-// hashOutputs returns Hash256(<txOut.Marshal()> for all outputs)
-func (t *Tx) hashOutputs() (ret [32]byte, err error) {
+// hashSequence returns Hash256(<txIn.Sequence> for all inputs)
+func (t *Tx) hashSequence() [32]byte {
+	h := t.shaSequence()
+	return hash.Sha256(h[:])
+}
+
+// shaOutputs returns Sha256(<txOut.Marshal()> for all outputs)
+func (t *Tx) shaOutputs() (ret [32]byte, err error) {
 	if t.hashOutputsCache[0] == 0 {
 		// the cache is empty
 		var allOutputs []byte
@@ -166,7 +249,7 @@ func (t *Tx) hashOutputs() (ret [32]byte, err error) {
 			allOutputs = append(allOutputs, b...)
 		}
 
-		h := hash.Hash256(allOutputs)
+		h := hash.Sha256(allOutputs)
 		t.hashOutputsCache[0] = 1
 		copy(t.hashOutputsCache[1:], h[:])
 	}
@@ -175,21 +258,82 @@ func (t *Tx) hashOutputs() (ret [32]byte, err error) {
 	return
 }
 
+// shaAmounts returns Sha256(<prevout.Amount> for all prevouts)
+func shaAmounts(prevouts []TxOut) [32]byte {
+	allAmounts := make([]byte, 8*len(prevouts))
+	for i, out := range prevouts {
+		binary.LittleEndian.PutUint64(allAmounts[i*8:(i+1)*8], uint64(out.Amount))
+	}
+	return hash.Sha256(allAmounts)
+}
+
+// shaScriptPubKeys returns Sha256(<prevout.ScriptPubKey.Marshal()> for all prevouts)
+func shaScriptPubKeys(prevouts []TxOut) ([32]byte, error) {
+	var allScriptPubKeys []byte
+	for _, out := range prevouts {
+		b, err := out.ScriptPubKey.Marshal()
+		if err != nil {
+			return [32]byte{}, err
+		}
+		allScriptPubKeys = append(allScriptPubKeys, b...)
+	}
+	return hash.Sha256(allScriptPubKeys), nil
+}
+
+// hashOutputs returns Hash256(<txOut.Marshal()> for all outputs)
+func (t *Tx) hashOutputs() ([32]byte, error) {
+	h, err := t.shaOutputs()
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return hash.Sha256(h[:]), nil
+}
+
 // SighashBip143 returns the message that needs to get signed for the input with the index.
 // Fixes the O(n^2) hashing problem.
+// scriptPubKey is either the P2WPKH program or the witness script of a P2WSH input
+// and value is the Amount of the spent output, nothing is fetched.
+// Signatures in a witness script commit to its part after the last OP_CODESEPARATOR.
+// hashType selects the parts of the transaction the signature commits to, unlike with SighashLegacy,
+// SighashSingle for an input without an output of the same index commits to no outputs.
 // ref: https://github.com/bitcoin/bips/blob/master/bip-0143.mediawiki#Specification
-func (t *Tx) SighashBip143(index int, scriptPubKey script.Script, value uint64) ([32]byte, error) {
-	var err error
+func (t *Tx) SighashBip143(index int, scriptPubKey script.Script, value btcutil.Amount, hashType uint32) ([32]byte, error) {
+	var s script.Script
+	switch {
+	case scriptPubKey == nil:
+		return [32]byte{}, errors.New("SighashBip143: the scriptPubKey is needed")
+	case scriptPubKey.IsP2WPKH():
+		var h160 [20]byte
+		copy(h160[:], scriptPubKey.GetBytes(1))
+		s = script.NewP2PKHScript(h160)
+	case scriptPubKey.IsP2WSH():
+		return [32]byte{}, errors.New("SighashBip143: the witness script is needed")
+	default:
+		s = scriptPubKey.ScriptCode()
+	}
+	return t.sighashBip143(index, s, value, hashType)
+}
+
+// sighashBip143 is SighashBip143 with the exact scriptCode and value.
+func (t *Tx) sighashBip143(index int, scriptCode script.Script, value btcutil.Amount, hashType uint32) ([32]byte, error) {
 	txIn := t.TxIns[index]
+	outputType := hashType & 0x1f
+	anyoneCanPay := hashType&SighashAnyoneCanPay != 0
 	buf := new(bytes.Buffer)
 
 	// Tx Version, 4 bytes, little-endian
 	binary.Write(buf, binary.LittleEndian, t.Version)
-	// Tx hashPrevouts, 32 bytes, little-endian
-	h := t.hashPrevouts()
+	// Tx hashPrevouts, 32 bytes, little-endian, zero with ANYONECANPAY
+	var h [32]byte
+	if !anyoneCanPay {
+		h = t.hashPrevouts()
+	}
 	buf.Write(h[:])
-	// Tx hashSequence, 32 bytes, little-endian
-	h = t.hashSequence()
+	// Tx hashSequence, 32 bytes, little-endian, zero with ANYONECANPAY, NONE or SINGLE
+	h = [32]byte{}
+	if !anyoneCanPay && outputType != SighashNone && outputType != SighashSingle {
+		h = t.hashSequence()
+	}
 	buf.Write(h[:])
 
 	// txIn PrevTxId, 32 byte, little-endian
@@ -197,75 +341,205 @@ func (t *Tx) SighashBip143(index int, scriptPubKey script.Script, value uint64)
 	buf.Write(h[:])
 	// txIn PrevIndex, 4 byte, little-endian
 	binary.Write(buf, binary.LittleEndian, txIn.PrevIndex)
-	// txIn ScriptPubKey, Script marshalling
-	var spk script.Script
-	if scriptPubKey != nil {
-		spk = scriptPubKey
-	} else {
-		spk, err = txIn.ScriptPubKey()
-		if err != nil {
-			return [32]byte{}, err
-		}
-	}
-	if !spk.IsP2WPKH() {
-		return [32]byte{}, errors.New("unknown script type")
-	}
-	var h160 [20]byte
-	copy(h160[:], spk.GetBytes(1))
-	s := script.NewP2PKHScript(h160)
-	b, err := s.Marshal()
+	// txIn ScriptCode, Script marshalling
+	b, err := scriptCode.Marshal()
 	if err != nil {
 		return [32]byte{}, err
 	}
 	buf.Write(b)
 	// txIn Value, 8 bytes, little-endian
-	var val uint64
-	if value != 0 {
-		val = value
-	} else {
-		val, err = txIn.Value()
-		if err != nil {
-			return [32]byte{}, err
-		}
-	}
-	binary.Write(buf, binary.LittleEndian, val)
+	binary.Write(buf, binary.LittleEndian, int64(value))
 	// txIn Sequence, 4 bytes, little-endian
 	binary.Write(buf, binary.LittleEndian, txIn.Sequence)
 
-	// Tx hashOutputs, 32 bytes, little-endian
-	h, err = t.hashOutputs()
-	if err != nil {
-		return [32]byte{}, err
+	// Tx hashOutputs, 32 bytes, little-endian: of all of the outputs,
+	// of the one with the same index with SINGLE, zero otherwise
+	h = [32]byte{}
+	switch {
+	case outputType != SighashNone && outputType != SighashSingle:
+		if h, err = t.hashOutputs(); err != nil {
+			return [32]byte{}, err
+		}
+	case outputType == SighashSingle && index < len(t.TxOuts):
+		if b, err = t.TxOuts[index].Marshal(); err != nil {
+			return [32]byte{}, err
+		}
+		h = hash.Hash256(b)
 	}
 	buf.Write(h[:])
 	// Tx Locktime, 4 bytes, little-endian
 	binary.Write(buf, binary.LittleEndian, t.LockTime)
 	// Sighash type, 4 bytes, little-endian
-	binary.Write(buf, binary.LittleEndian, SighashAll)
+	binary.Write(buf, binary.LittleEndian, hashType)
 
 	return hash.Hash256(buf.Bytes()), nil
 }
 
-// VerifyInput returns whether the input has a valid signature
-func (t *Tx) VerifyInput(index int) (bool, error) {
-	in := t.TxIns[index]
-	spk, err := in.ScriptPubKey()
-	if err != nil {
-		return false, err
+// PrevOuts returns the outputs spent by the inputs, in order.
+func (t *Tx) PrevOuts(f PrevOutFetcher) ([]TxOut, error) {
+	prevouts := make([]TxOut, len(t.TxIns))
+	for i := range t.TxIns {
+		var err error
+		if prevouts[i], err = f.PrevOut(&t.TxIns[i]); err != nil {
+			return nil, err
+		}
 	}
-	var sighash [32]byte
-	if spk.IsP2WPKH() {
-		sighash, err = t.SighashBip143(index, nil, 0)
-	} else if spk.IsP2PKH() {
-		sighash, err = t.SighashLegacy(index)
+	return prevouts, nil
+}
+
+// SighashTaproot returns the message that needs to get signed for the input with the index.
+// prevouts are the outputs spent by all of the inputs, see PrevOuts.
+// leafHash is the TapLeafHash of the spent leaf for script path spends, nil for key path spends,
+// and annex is the annex of the witness, nil if there is none.
+// Signatures in tapscripts commit to no OP_CODESEPARATOR having been executed.
+// ref: https://github.com/bitcoin/bips/blob/master/bip-0341.mediawiki#common-signature-message
+func (t *Tx) SighashTaproot(index int, prevouts []TxOut, hashType uint32, leafHash, annex []byte) ([32]byte, error) {
+	return t.sighashTaproot(index, prevouts, hashType, leafHash, annex, 0xffffffff)
+}
+
+// sighashTaproot is SighashTaproot with the position of the last executed OP_CODESEPARATOR
+// in the leaf script, for script path spends.
+func (t *Tx) sighashTaproot(index int, prevouts []TxOut, hashType uint32, leafHash, annex []byte, codeSepPos uint32) ([32]byte, error) {
+	if len(prevouts) != len(t.TxIns) {
+		return [32]byte{}, errors.New("SighashTaproot: there should be a prevout for each input")
+	}
+	switch hashType {
+	case SighashDefault, SighashAll, SighashNone, SighashSingle, 0x81, 0x82, 0x83:
+	default:
+		return [32]byte{}, errors.New("SighashTaproot: invalid hash type")
+	}
+	outputType := hashType & 3
+	anyoneCanPay := hashType&0x80 != 0
+	if outputType == SighashSingle && index >= len(t.TxOuts) {
+		return [32]byte{}, errors.New("SighashTaproot: no output for SIGHASH_SINGLE")
+	}
+	buf := new(bytes.Buffer)
+
+	// Epoch, 1 byte
+	buf.WriteByte(0)
+	// Sighash type, 1 byte
+	buf.WriteByte(byte(hashType))
+	// Tx Version and Locktime, 4 bytes each, little-endian
+	binary.Write(buf, binary.LittleEndian, t.Version)
+	binary.Write(buf, binary.LittleEndian, t.LockTime)
+	if !anyoneCanPay {
+		// Tx shaPrevouts, shaAmounts, shaScriptPubKeys and shaSequences, 32 bytes each
+		h := t.shaPrevouts()
+		buf.Write(h[:])
+		h = shaAmounts(prevouts)
+		buf.Write(h[:])
+		h, err := shaScriptPubKeys(prevouts)
+		if err != nil {
+			return [32]byte{}, err
+		}
+		buf.Write(h[:])
+		h = t.shaSequence()
+		buf.Write(h[:])
+	}
+	if outputType != SighashNone && outputType != SighashSingle {
+		// Tx shaOutputs, 32 bytes
+		h, err := t.shaOutputs()
+		if err != nil {
+			return [32]byte{}, err
+		}
+		buf.Write(h[:])
+	}
+
+	// Spend type, 1 byte: whether it is a script path spend and whether there is an annex
+	var spendType byte
+	if leafHash != nil {
+		spendType |= 2
+	}
+	if annex != nil {
+		spendType |= 1
+	}
+	buf.WriteByte(spendType)
+	if anyoneCanPay {
+		// txIn PrevTxId and PrevIndex, the spent output and txIn Sequence
+		txIn := t.TxIns[index]
+		buf.Write(utils.Reversed(append([]byte{}, txIn.PrevTxId[:]...)))
+		binary.Write(buf, binary.LittleEndian, txIn.PrevIndex)
+		b, err := prevouts[index].Marshal()
+		if err != nil {
+			return [32]byte{}, err
+		}
+		buf.Write(b)
+		binary.Write(buf, binary.LittleEndian, txIn.Sequence)
 	} else {
-		return false, errors.New("unknown script type")
+		// Input index, 4 bytes, little-endian
+		binary.Write(buf, binary.LittleEndian, uint32(index))
+	}
+	if annex != nil {
+		// Sha256 of the annex, serialized with its length
+		encodedLen, err := encoding.EncodeVarInt(big.NewInt(int64(len(annex))))
+		if err != nil {
+			return [32]byte{}, err
+		}
+		h := hash.Sha256(append(encodedLen, annex...))
+		buf.Write(h[:])
+	}
+	if outputType == SighashSingle {
+		// Sha256 of the output with the same index
+		b, err := t.TxOuts[index].Marshal()
+		if err != nil {
+			return [32]byte{}, err
+		}
+		h := hash.Sha256(b)
+		buf.Write(h[:])
 	}
+	if leafHash != nil {
+		// the extension of BIP342: the leaf hash, the key version
+		// and the position of the last executed OP_CODESEPARATOR, 0xffffffff for none
+		buf.Write(leafHash)
+		buf.WriteByte(0)
+		binary.Write(buf, binary.LittleEndian, codeSepPos)
+	}
+
+	return hash.TaggedHash("TapSighash", buf.Bytes()), nil
+}
+
+// verifyFlags are the script verification rules of the transactions.
+const verifyFlags = script.VerifyP2SH | script.VerifyDERSignatures | script.VerifyNullDummy |
+	script.VerifyWitness | script.VerifyTaproot |
+	script.VerifyCheckLockTimeVerify | script.VerifyCheckSequenceVerify
+
+// InputEngine returns the script engine verifying the spend of the output by the input with the index.
+// Its Execute returns the reason the spend is invalid.
+func (t *Tx) InputEngine(index int) (*script.Engine, error) {
+	in := &t.TxIns[index]
+	prevOut, err := DefaultFetcher.PrevOut(in)
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+	var prevOuts []TxOut
+	if prevOut.ScriptPubKey.IsP2TR() {
+		// Taproot signatures commit to all of the spent outputs
+		if prevOuts, err = t.PrevOuts(DefaultFetcher); err != nil {
+			return nil, err
+		}
 	}
-	combinedScript := in.ScriptSig.Add(spk...)
-	return combinedScript.Eval(sighash[:], in.Witness), nil
+	checker := NewInputChecker(t, index, prevOut.Amount, prevOuts)
+	return script.NewEngine(in.ScriptSig, prevOut.ScriptPubKey, in.Witness, checker, verifyFlags), nil
+}
+
+// VerifyInput returns whether the input satisfies the output it spends.
+// Returns error only if the spent output can't be fetched, see VerifyInputErr for why the spend is invalid.
+func (t *Tx) VerifyInput(index int) (bool, error) {
+	err := t.VerifyInputErr(index)
+	if _, invalid := err.(script.ErrorCode); invalid {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// VerifyInputErr returns nil if the input satisfies the output it spends, otherwise either the error
+// of fetching the spent output or the script.ErrorCode of the reason the spend is invalid.
+func (t *Tx) VerifyInputErr(index int) error {
+	e, err := t.InputEngine(index)
+	if err != nil {
+		return err
+	}
+	return e.Execute()
 }
 
 // Verify returns whether this transaction is valid
@@ -291,16 +565,30 @@ func (t *Tx) Verify() (bool, error) {
 	return true, nil
 }
 
-// SignInput signs the input with the index using the private key
-// TODO: make SegWit compatible
-func (t *Tx) SignInput(index int, priv *ecdsa.PrivateKey) (bool, error) {
-	var err error
-	// get the signature hash (the message to sign)
+// SignInput signs the input with the index using the private key, with the sighash type,
+// for example SighashAll or SighashSingle|SighashAnyoneCanPay.
+// P2TR inputs are signed with a key path spend, priv being the internal key
+// of an output without a script tree, P2WPKH inputs get a witness and the rest a ScriptSig,
+// neither can use SighashDefault.
+func (t *Tx) SignInput(index int, priv *ecdsa.PrivateKey, hashType uint32) (bool, error) {
+	prevOut, err := DefaultFetcher.PrevOut(&t.TxIns[index])
+	if err != nil {
+		return false, err
+	}
+	if prevOut.ScriptPubKey.IsP2TR() {
+		return t.signTaprootInput(index, priv, hashType)
+	}
+	if hashType == SighashDefault {
+		return false, errors.New("SignInput: SighashDefault is only valid for Taproot inputs")
+	}
+
+	// get the signature hash (the message to sign), P2WPKH inputs commit to the spent amount
+	segWit := prevOut.ScriptPubKey.IsP2WPKH()
 	var sighash [32]byte
-	if t.SegWit {
-		sighash, err = t.SighashBip143(index, nil, 0)
+	if segWit {
+		sighash, err = t.SighashBip143(index, prevOut.ScriptPubKey, prevOut.Amount, hashType)
 	} else {
-		sighash, err = t.SighashLegacy(index)
+		sighash, err = t.SighashLegacy(index, prevOut.ScriptPubKey, hashType)
 	}
 	if err != nil {
 		return false, err
@@ -308,13 +596,50 @@ func (t *Tx) SignInput(index int, priv *ecdsa.PrivateKey) (bool, error) {
 	// get DER signature
 	der := priv.Sign(sighash[:]).Marshal()
 	// append the Sighash type to der
-	sig := append(der, byte(SighashAll))
+	sig := append(der, byte(hashType))
 	// calculate SEC pubkey
 	sec := priv.PublicKey.MarshalCompressed()
-	// initialize a new ScriptSig
-	scriptSig := new(script.Script).AddBytes(sig, sec)
-	// update input's ScriptSig
-	t.TxIns[index].ScriptSig = scriptSig
+	if segWit {
+		// the signature and the SEC pubkey go to the witness, the ScriptSig stays empty
+		t.TxIns[index].ScriptSig = script.Script{}
+		t.TxIns[index].Witness = [][]byte{sig, sec}
+		t.SegWit = true
+	} else {
+		// update input's ScriptSig
+		t.TxIns[index].ScriptSig = new(script.Script).AddBytes(sig, sec)
+	}
+
+	return t.VerifyInput(index)
+}
+
+func (t *Tx) signTaprootInput(index int, priv *ecdsa.PrivateKey, hashType uint32) (bool, error) {
+	prevouts, err := t.PrevOuts(DefaultFetcher)
+	if err != nil {
+		return false, err
+	}
+	sighash, err := t.SighashTaproot(index, prevouts, hashType, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	tweaked, err := script.TaprootPrivateKey(priv, nil)
+	if err != nil {
+		return false, err
+	}
+	// fresh auxiliary randomness protects the nonce against side channels
+	var aux [32]byte
+	if _, err := rand.Read(aux[:]); err != nil {
+		return false, err
+	}
+	sig, err := schnorr.Sign(tweaked, sighash[:], aux)
+	if err != nil {
+		return false, err
+	}
+	t.TxIns[index].ScriptSig = script.Script{}
+	t.TxIns[index].Witness = [][]byte{sig[:]}
+	if hashType != SighashDefault {
+		t.TxIns[index].Witness[0] = append(sig[:], byte(hashType))
+	}
+	t.SegWit = true
 
 	return t.VerifyInput(index)
 }
@@ -369,18 +694,18 @@ func (t *Tx) Marshal() ([]byte, error) {
 	if t.SegWit {
 		// Witness
 		for _, txIn := range t.TxIns {
-			binary.Write(buf, binary.LittleEndian, byte(len(txIn.Witness)))
+			num, err := encoding.EncodeVarInt(big.NewInt(int64(len(txIn.Witness))))
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(num)
 			for _, item := range txIn.Witness {
-				if len(item) == 1 && item[0] == 0 {
-					binary.Write(buf, binary.LittleEndian, item[0])
-				} else {
-					num, err := encoding.EncodeVarInt(big.NewInt(int64(len(item))))
-					if err != nil {
-						return nil, err
-					}
-					buf.Write(num)
-					buf.Write(item)
+				num, err := encoding.EncodeVarInt(big.NewInt(int64(len(item))))
+				if err != nil {
+					return nil, err
 				}
+				buf.Write(num)
+				buf.Write(item)
 			}
 		}
 	}
@@ -398,21 +723,38 @@ func (t *Tx) Marshal() ([]byte, error) {
 // SegWit format:
 //	   [Version][Marker][Flag][NumIns][TxIns][NumOuts][TxOuts][Witness][LockTime]
 func (t *Tx) Unmarshal(r io.Reader) (*Tx, error) {
+	return t.unmarshal(r, true)
+}
+
+// UnmarshalLegacy parses a Tx serialized in the legacy format from the Reader r,
+// as PSBTs serialize the unsigned transaction. Unlike with Unmarshal,
+// a transaction without inputs can have outputs, there is no SegWit marker to confuse them with.
+func (t *Tx) UnmarshalLegacy(r io.Reader) (*Tx, error) {
+	return t.unmarshal(r, false)
+}
+
+func (t *Tx) unmarshal(r io.Reader, allowSegWit bool) (*Tx, error) {
+	// the counts are not trusted, the inputs, outputs and witness elements are read
+	// one by one until the reader runs out
+	sr := &stickyReader{r: r}
+	r = sr
+
 	// Version, 4 bytes, little-endian
 	binary.Read(r, binary.LittleEndian, &t.Version)
 
-	var numIns, numOuts int
+	var numIns, numOuts uint64
 	var hasReadNumOuts bool
 	// VarInt number of inputs
-	numIns = int(encoding.DecodeVarInt(r).Int64())
-	if numIns == 0 {
+	numIns = encoding.DecodeVarInt(r).Uint64()
+	t.SegWit = false
+	if numIns == 0 && allowSegWit {
 		var segWitFlag [1]byte
 		io.ReadFull(r, segWitFlag[:])
 		t.SegWit = segWitFlag[0] == 1
 		if t.SegWit {
-			numIns = int(encoding.DecodeVarInt(r).Int64())
+			numIns = encoding.DecodeVarInt(r).Uint64()
 		} else {
-			numOuts = int(segWitFlag[0])
+			numOuts = uint64(segWitFlag[0])
 			if numOuts != 0 {
 				return nil, errors.New("can't have outputs when there are 0 inputs")
 			}
@@ -420,33 +762,28 @@ func (t *Tx) Unmarshal(r io.Reader) (*Tx, error) {
 		}
 	}
 	// TxIns
-	t.TxIns = make([]TxIn, numIns)
-	for i := range t.TxIns {
-		t.TxIns[i].TestNet = t.TestNet
-		t.TxIns[i].Unmarshal(r)
+	t.TxIns = nil
+	for i := uint64(0); i < numIns && sr.err == nil; i++ {
+		in := TxIn{TestNet: t.TestNet}
+		t.TxIns = append(t.TxIns, *in.Unmarshal(r))
 	}
 	if !hasReadNumOuts {
 		// VarInt number of outputs
-		numOuts = int(encoding.DecodeVarInt(r).Int64())
+		numOuts = encoding.DecodeVarInt(r).Uint64()
 	}
 	// TxOuts
-	t.TxOuts = make([]TxOut, numOuts)
-	for i := range t.TxOuts {
-		t.TxOuts[i].Unmarshal(r)
+	t.TxOuts = nil
+	for i := uint64(0); i < numOuts && sr.err == nil; i++ {
+		t.TxOuts = append(t.TxOuts, *new(TxOut).Unmarshal(r))
 	}
 
 	if t.SegWit {
 		// Witness
 		for i := range t.TxIns {
-			numElements := int(encoding.DecodeVarInt(r).Int64())
-			for j := 0; j < numElements; j++ {
-				elementLen := int(encoding.DecodeVarInt(r).Int64())
-				b := make([]byte, elementLen)
-				if elementLen == 0 {
-					b = []byte{0}
-				} else {
-					io.ReadFull(r, b)
-				}
+			numElements := encoding.DecodeVarInt(r).Uint64()
+			for j := uint64(0); j < numElements && sr.err == nil; j++ {
+				elementLen := encoding.DecodeVarInt(r).Int64()
+				b, _ := io.ReadAll(io.LimitReader(r, elementLen))
 				t.TxIns[i].Witness = append(t.TxIns[i].Witness, b)
 			}
 		}
@@ -455,9 +792,29 @@ func (t *Tx) Unmarshal(r io.Reader) (*Tx, error) {
 	// LockTime, 4 bytes, little-endian
 	binary.Read(r, binary.LittleEndian, &t.LockTime)
 
+	if sr.err != nil {
+		return nil, errors.New("Tx.Unmarshal: unexpected end of the transaction")
+	}
 	return t, nil
 }
 
+// stickyReader remembers the first failed read from r, after which all reads fail.
+type stickyReader struct {
+	r   io.Reader
+	err error
+}
+
+func (sr *stickyReader) Read(p []byte) (int, error) {
+	if sr.err != nil {
+		return 0, sr.err
+	}
+	n, err := sr.r.Read(p)
+	if n == 0 && err != nil {
+		sr.err = err
+	}
+	return n, err
+}
+
 type TxIn struct {
 	PrevTxId  [32]byte      // prev transaction ID: hash256 of prev tx contents
 	PrevIndex uint32        // UTXO output index in the prev transaction
@@ -471,37 +828,17 @@ type TxIn struct {
 	Witness [][]byte // stack elements
 }
 
-func (in *TxIn) marshal(n int) ([]byte, error) {
+// marshal serializes the input with s in place of its ScriptSig.
+func (in *TxIn) marshal(s script.Script) ([]byte, error) {
 	buf := new(bytes.Buffer)
 	// PrevTxId, 32 bytes, little-endian
 	buf.Write(utils.Reversed(append([]byte{}, in.PrevTxId[:]...)))
 	// PrevIndex, 4 bytes, little-endian
 	binary.Write(buf, binary.LittleEndian, in.PrevIndex)
-	var b []byte
-	var err error
-	switch n {
-	case -1:
-		// ScriptSig
-		b, err = in.ScriptSig.Marshal()
-		if err != nil {
-			return nil, err
-		}
-	case 0:
-		// empty Script
-		b, err = new(script.Script).Marshal()
-		if err != nil {
-			return nil, err
-		}
-	case 1:
-		// ScriptPubKey instead of ScriptSig
-		spk, err := in.ScriptPubKey()
-		if err != nil {
-			return nil, err
-		}
-		b, err = spk.Marshal()
-		if err != nil {
-			return nil, err
-		}
+	// ScriptSig
+	b, err := s.Marshal()
+	if err != nil {
+		return nil, err
 	}
 	buf.Write(b)
 	// Sequence, 4 bytes, little-endian
@@ -510,16 +847,8 @@ func (in *TxIn) marshal(n int) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-func (in *TxIn) marshalScriptOverride(pubkeyOverride bool) ([]byte, error) {
-	if pubkeyOverride {
-		return in.marshal(1)
-	}
-
-	return in.marshal(0)
-}
-
 func (in *TxIn) Marshal() ([]byte, error) {
-	return in.marshal(-1)
+	return in.marshal(in.ScriptSig)
 }
 
 func (in *TxIn) Unmarshal(r io.Reader) *TxIn {
@@ -536,33 +865,33 @@ func (in *TxIn) Unmarshal(r io.Reader) *TxIn {
 	return in
 }
 
-// Value returns the Amount of the UTXO from the previous transaction
-func (in *TxIn) Value() (uint64, error) {
-	tx, err := Fetch(hex.EncodeToString(in.PrevTxId[:]), in.TestNet, false)
+// Value returns the Amount of the UTXO from the previous transaction, looked up with DefaultFetcher
+func (in *TxIn) Value() (btcutil.Amount, error) {
+	out, err := DefaultFetcher.PrevOut(in)
 	if err != nil {
 		return 0, err
 	}
-	return tx.TxOuts[in.PrevIndex].Amount, nil
+	return out.Amount, nil
 }
 
-// ScriptPubKey returns the ScriptPubKey of the UTXO from the previous transaction
+// ScriptPubKey returns the ScriptPubKey of the UTXO from the previous transaction, looked up with DefaultFetcher
 func (in *TxIn) ScriptPubKey() (script.Script, error) {
-	tx, err := Fetch(hex.EncodeToString(in.PrevTxId[:]), in.TestNet, false)
+	out, err := DefaultFetcher.PrevOut(in)
 	if err != nil {
 		return script.Script{}, err
 	}
-	return tx.TxOuts[in.PrevIndex].ScriptPubKey, nil
+	return out.ScriptPubKey, nil
 }
 
 type TxOut struct {
-	Amount       uint64        // in units of satoshi (1e-8 of a bitcoin)
-	ScriptPubKey script.Script // locking script
+	Amount       btcutil.Amount // in units of satoshi (1e-8 of a bitcoin)
+	ScriptPubKey script.Script  // locking script
 }
 
 func (out *TxOut) Marshal() ([]byte, error) {
 	buf := new(bytes.Buffer)
 	// marshal Amount, 8 bytes, little-endian
-	binary.Write(buf, binary.LittleEndian, out.Amount)
+	binary.Write(buf, binary.LittleEndian, int64(out.Amount))
 	// marshal ScriptPubKey
 	b, err := out.ScriptPubKey.Marshal()
 	if err != nil {
@@ -575,7 +904,9 @@ func (out *TxOut) Marshal() ([]byte, error) {
 
 func (out *TxOut) Unmarshal(r io.Reader) *TxOut {
 	// Amount is 8 bytes, little-endian
-	binary.Read(r, binary.LittleEndian, &out.Amount)
+	var amount int64
+	binary.Read(r, binary.LittleEndian, &amount)
+	out.Amount = btcutil.Amount(amount)
 	// ScriptPubKey
 	out.ScriptPubKey.Unmarshal(r)
 	// return TxOut
@@ -3,14 +3,20 @@ package tx
 import (
 	"bytes"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"math/big"
 	"os"
 	"strings"
 	"testing"
 
+	"github.com/VIVelev/btcd/btcutil"
 	"github.com/VIVelev/btcd/crypto/ecdsa"
 	"github.com/VIVelev/btcd/crypto/elliptic"
+	"github.com/VIVelev/btcd/crypto/hash"
+	"github.com/VIVelev/btcd/crypto/schnorr"
 	"github.com/VIVelev/btcd/script"
+	"github.com/VIVelev/btcd/utils"
 )
 
 var (
@@ -87,6 +93,38 @@ func TestUnmarshalLocktime(t *testing.T) {
 	}
 }
 
+func TestUnmarshalInvalid(t *testing.T) {
+	for _, b := range [][]byte{
+		// truncated
+		txBytes[:len(txBytes)-1],
+		// 2^63 inputs
+		{1, 0, 0, 0, 0xff, 0, 0, 0, 0, 0, 0, 0, 0x80},
+		// 2^63 outputs
+		append(append([]byte{1, 0, 0, 0, 0x01}, make([]byte, 41)...), 0xff, 0, 0, 0, 0, 0, 0, 0, 0x80),
+		// a witness element of 2^63 bytes
+		append(append([]byte{1, 0, 0, 0, 0x00, 0x01, 0x01}, make([]byte, 41)...), 0x00, 0x01, 0xff, 0, 0, 0, 0, 0, 0, 0, 0x80),
+	} {
+		if _, err := new(Tx).Unmarshal(bytes.NewReader(b)); err == nil {
+			t.Errorf("FAIL: %x", b)
+		}
+	}
+}
+
+func TestUnmarshalLegacy(t *testing.T) {
+	// a transaction without inputs and with an OP_RETURN output, which looks like the SegWit marker
+	b, _ := hex.DecodeString("01000000000100000000000000000d6a0b68656c6c6f20776f726c6400000000")
+	if newTx, _ := new(Tx).Unmarshal(bytes.NewReader(b)); newTx != nil && len(newTx.TxOuts) == 1 {
+		t.Errorf("FAIL")
+	}
+	newTx, err := new(Tx).UnmarshalLegacy(bytes.NewReader(b))
+	if err != nil || len(newTx.TxIns) != 0 || len(newTx.TxOuts) != 1 || newTx.SegWit {
+		t.Fatalf("FAIL: %v", err)
+	}
+	if again, _ := newTx.Marshal(); !bytes.Equal(again, b) {
+		t.Errorf("FAIL")
+	}
+}
+
 func TestMarshal(t *testing.T) {
 	b, _ := tx.Marshal()
 	if !bytes.Equal(b, txBytes) {
@@ -94,6 +132,24 @@ func TestMarshal(t *testing.T) {
 	}
 }
 
+func TestMarshalEmptyWitnessItem(t *testing.T) {
+	newTx := Tx{
+		Version: 1,
+		TxIns:   []TxIn{{Witness: [][]byte{{}, {1, 2}}}},
+		SegWit:  true,
+	}
+	b, _ := newTx.Marshal()
+	// the witness: 2 items, the empty one and the 2 byte one
+	if !bytes.Contains(b, []byte{2, 0, 2, 1, 2}) {
+		t.Errorf("FAIL")
+	}
+	var got Tx
+	got.Unmarshal(bytes.NewReader(b))
+	if len(got.TxIns[0].Witness) != 2 || len(got.TxIns[0].Witness[0]) != 0 {
+		t.Errorf("FAIL")
+	}
+}
+
 func TestInputValue(t *testing.T) {
 	b, _ := hex.DecodeString("d1c789a9c60383bf715f3f6ad9d14b91fe55f3deb369fe5d9280cb1a01793f81")
 	in := TxIn{}
@@ -144,11 +200,11 @@ func TestSighashLegacy(t *testing.T) {
 		false,
 	)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 
 	want, _ := hex.DecodeString("27e0c5994dec7824e56dec6b2fcb342eb7cdb0d0957c2fce9882f715e85d81a6")
-	b, _ := newTx.SighashLegacy(0)
+	b, _ := newTx.SighashLegacy(0, nil, SighashAll)
 	if !bytes.Equal(b[:], want) {
 		t.Errorf("FAIL")
 	}
@@ -181,9 +237,41 @@ func TestHashOutputs(t *testing.T) {
 	}
 }
 
+func TestSighashLegacyVectors(t *testing.T) {
+	// the test vectors of Bitcoin Core, covering all of the sighash types:
+	// [raw transaction, script, input index, hash type, sighash]
+	b, err := os.ReadFile("testdata/sighash.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vectors [][]interface{}
+	if err := json.Unmarshal(b, &vectors); err != nil {
+		t.Fatal(err)
+	}
+	// the first one is a comment
+	for _, v := range vectors[1:] {
+		raw, _ := hex.DecodeString(v[0].(string))
+		var newTx Tx
+		newTx.Unmarshal(bytes.NewReader(raw))
+		scriptRaw, _ := hex.DecodeString(v[1].(string))
+		scriptCode, err := script.ParseRaw(scriptRaw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		index := int(v[2].(float64))
+		hashType := uint32(int32(v[3].(float64)))
+
+		sighash, err := newTx.SighashLegacy(index, scriptCode, hashType)
+		// the sighash is shown in reverse byte order
+		if err != nil || hex.EncodeToString(utils.Reversed(sighash[:])) != v[4].(string) {
+			t.Errorf("FAIL: %v", v)
+		}
+	}
+}
+
 func TestSighashBip143(t *testing.T) {
 	spk := new(script.Script).Unmarshal(hex.NewDecoder(strings.NewReader("1600141d0f172a0ecb48aee1be1f2687d2963ae33f71a1")))
-	h, err := txBip143.SighashBip143(1, *spk, uint64(600000000))
+	h, err := txBip143.SighashBip143(1, *spk, btcutil.Amount(600000000), SighashAll)
 	if err != nil {
 		t.Error(err)
 	}
@@ -192,6 +280,11 @@ func TestSighashBip143(t *testing.T) {
 	if !bytes.Equal(h[:], want) {
 		t.Errorf("FAIL")
 	}
+
+	// the spent output is never fetched
+	if _, err := txBip143.SighashBip143(1, nil, btcutil.Amount(600000000), SighashAll); err == nil {
+		t.Errorf("FAIL")
+	}
 }
 
 func TestVerifyP2PKH(t *testing.T) {
@@ -240,7 +333,7 @@ func TestSignInput(t *testing.T) {
 	newTx.TestNet = true
 	newTx.Unmarshal(hex.NewDecoder(strings.NewReader("010000000199a24308080ab26e6fb65c4eccfadf76749bb5bfa8cb08f291320b3c21e56f0d0d00000000ffffffff02408af701000000001976a914d52ad7ca9b3d096a38e752c2018e6fbc40cdf26f88ac80969800000000001976a914507b27411ccf7f16f10297de6cef3f291623eddf88ac00000000")))
 
-	if ok, _ := newTx.SignInput(0, priv); !ok {
+	if ok, _ := newTx.SignInput(0, priv, SighashAll); !ok {
 		t.Errorf("FAIL")
 	}
 
@@ -250,3 +343,452 @@ func TestSignInput(t *testing.T) {
 		t.Errorf("FAIL")
 	}
 }
+
+func TestSignHashTypes(t *testing.T) {
+	priv := ecdsa.GenerateKeyFromSecret(elliptic.Secp256k1, big.NewInt(1))
+	pub := priv.PublicKey.MarshalCompressed()
+	h160 := hash.Hash160(pub)
+	prevTx := Tx{Version: 1, TxOuts: []TxOut{
+		{Amount: 100000, ScriptPubKey: script.NewP2PKHScript(h160)},
+		{Amount: 100000, ScriptPubKey: script.NewP2WPKHScript(h160)},
+	}}
+	prevTxId, _ := prevTx.Id()
+	cache[prevTxId] = prevTx
+	var prevTxIdBytes [32]byte
+	b, _ := hex.DecodeString(prevTxId)
+	copy(prevTxIdBytes[:], b)
+
+	newTx := func() *Tx {
+		return &Tx{
+			Version: 1,
+			TxIns: []TxIn{
+				{PrevTxId: prevTxIdBytes, PrevIndex: 0, Sequence: 0xffffffff},
+				{PrevTxId: prevTxIdBytes, PrevIndex: 1, Sequence: 0xffffffff},
+			},
+			TxOuts: []TxOut{
+				{Amount: 90000, ScriptPubKey: script.NewP2PKHScript(h160)},
+				{Amount: 90000, ScriptPubKey: script.NewP2PKHScript(h160)},
+			},
+		}
+	}
+	// signs both inputs with the hash type and returns whether they are valid after the change
+	check := func(hashType uint32, change func(t *Tx)) bool {
+		signed := newTx()
+		if ok, err := signed.SignInput(0, priv, hashType); err != nil || !ok {
+			t.Fatalf("FAIL: %v", err)
+		}
+		sighash, _ := signed.SighashBip143(1, prevTx.TxOuts[1].ScriptPubKey, prevTx.TxOuts[1].Amount, hashType)
+		sig := append(priv.Sign(sighash[:]).Marshal(), byte(hashType))
+		signed.TxIns[1].Witness = [][]byte{sig, pub}
+		signed.SegWit = true
+
+		changed := newTx()
+		change(changed)
+		changed.TxIns[0].ScriptSig = signed.TxIns[0].ScriptSig
+		changed.TxIns[1].Witness = signed.TxIns[1].Witness
+		changed.SegWit = true
+		ok0, _ := changed.VerifyInput(0)
+		ok1, _ := changed.VerifyInput(1)
+		return ok0 && ok1
+	}
+	changeOutput := func(i int) func(t *Tx) {
+		return func(t *Tx) { t.TxOuts[i].Amount-- }
+	}
+	for _, tc := range []struct {
+		hashType uint32
+		change   func(t *Tx)
+		want     bool
+	}{
+		{SighashAll, func(t *Tx) {}, true},
+		{SighashAll, changeOutput(0), false},
+		{SighashAll | SighashAnyoneCanPay, changeOutput(1), false},
+		{SighashNone, changeOutput(0), true},
+		{SighashNone | SighashAnyoneCanPay, changeOutput(1), true},
+		{SighashSingle, changeOutput(1), false},
+		{SighashSingle | SighashAnyoneCanPay, changeOutput(0), false},
+	} {
+		if check(tc.hashType, tc.change) != tc.want {
+			t.Errorf("FAIL: %#x", tc.hashType)
+		}
+	}
+
+	// with SINGLE the inputs commit to the outputs of the same index only
+	signed := newTx()
+	signed.SignInput(0, priv, SighashSingle)
+	signed.TxOuts[1].Amount--
+	if ok, _ := signed.VerifyInput(0); !ok {
+		t.Errorf("FAIL")
+	}
+	// with ANYONECANPAY other inputs can be added
+	signed = newTx()
+	signed.TxIns = signed.TxIns[:1]
+	signed.SignInput(0, priv, SighashAll|SighashAnyoneCanPay)
+	signed.TxIns = append(signed.TxIns, TxIn{PrevTxId: prevTxIdBytes, PrevIndex: 1, Sequence: 0xffffffff})
+	if ok, _ := signed.VerifyInput(0); !ok {
+		t.Errorf("FAIL")
+	}
+	if _, err := signed.SignInput(0, priv, SighashDefault); err == nil {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestSignInputMixed(t *testing.T) {
+	priv := ecdsa.GenerateKeyFromSecret(elliptic.Secp256k1, big.NewInt(3))
+	pub := priv.PublicKey.MarshalCompressed()
+	h160 := hash.Hash160(pub)
+	prevTx := Tx{Version: 1, TxOuts: []TxOut{
+		{Amount: 100000, ScriptPubKey: script.NewP2PKHScript(h160)},
+		{Amount: 100000, ScriptPubKey: script.NewP2WPKHScript(h160)},
+	}}
+	prevTxId, _ := prevTx.Id()
+	cache[prevTxId] = prevTx
+	var prevTxIdBytes [32]byte
+	b, _ := hex.DecodeString(prevTxId)
+	copy(prevTxIdBytes[:], b)
+
+	// the kind of signature depends on the spent output, not on whether the transaction is SegWit yet
+	newTx := Tx{
+		Version: 1,
+		TxIns: []TxIn{
+			{PrevTxId: prevTxIdBytes, PrevIndex: 0, Sequence: 0xffffffff},
+			{PrevTxId: prevTxIdBytes, PrevIndex: 1, Sequence: 0xffffffff},
+		},
+		TxOuts: []TxOut{{Amount: 190000, ScriptPubKey: script.NewP2PKHScript(h160)}},
+	}
+	for i := range newTx.TxIns {
+		if ok, err := newTx.SignInput(i, priv, SighashAll); err != nil || !ok {
+			t.Fatalf("FAIL: %v", err)
+		}
+	}
+	if !newTx.SegWit || len(newTx.TxIns[0].Witness) != 0 || len(newTx.TxIns[0].ScriptSig) != 2 {
+		t.Errorf("FAIL")
+	}
+	if len(newTx.TxIns[1].ScriptSig) != 0 || len(newTx.TxIns[1].Witness) != 2 || !bytes.Equal(newTx.TxIns[1].Witness[1], pub) {
+		t.Errorf("FAIL")
+	}
+
+	raw, _ := newTx.Marshal()
+	parsed, err := new(Tx).Unmarshal(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range parsed.TxIns {
+		if ok, err := parsed.VerifyInput(i); err != nil || !ok {
+			t.Errorf("FAIL: %d %v", i, err)
+		}
+	}
+}
+
+func TestVerifyP2SH(t *testing.T) {
+	priv1 := ecdsa.GenerateKeyFromSecret(elliptic.Secp256k1, big.NewInt(1))
+	priv2 := ecdsa.GenerateKeyFromSecret(elliptic.Secp256k1, big.NewInt(2))
+	pub1, pub2 := priv1.PublicKey.MarshalCompressed(), priv2.PublicKey.MarshalCompressed()
+
+	multisig, _ := script.NewMultisigScript(2, [][]byte{pub1, pub2})
+	multisigRaw, _ := multisig.Raw()
+	p2wpkh := script.NewP2WPKHScript(hash.Hash160(pub1))
+	p2wpkhRaw, _ := p2wpkh.Raw()
+
+	// the outputs being spent, made available to the fetcher through its cache
+	prevTx := Tx{Version: 1, TxOuts: []TxOut{
+		{Amount: 100000, ScriptPubKey: script.NewP2SHScript(hash.Hash160(multisigRaw))},
+		{Amount: 100000, ScriptPubKey: script.NewP2SHScript(hash.Hash160(p2wpkhRaw))},
+	}}
+	prevTxId, _ := prevTx.Id()
+	cache[prevTxId] = prevTx
+	var prevTxIdBytes [32]byte
+	b, _ := hex.DecodeString(prevTxId)
+	copy(prevTxIdBytes[:], b)
+
+	newTx := Tx{
+		Version: 1,
+		TxIns: []TxIn{
+			{PrevTxId: prevTxIdBytes, PrevIndex: 0, Sequence: 0xffffffff},
+			{PrevTxId: prevTxIdBytes, PrevIndex: 1, Sequence: 0xffffffff},
+		},
+		TxOuts: []TxOut{{Amount: 150000, ScriptPubKey: script.NewP2PKHScript(hash.Hash160(pub2))}},
+		SegWit: true,
+	}
+
+	// P2SH multisig
+	sighash, _ := newTx.SighashLegacy(0, multisig, SighashAll)
+	sig1 := append(priv1.Sign(sighash[:]).Marshal(), byte(SighashAll))
+	sig2 := append(priv2.Sign(sighash[:]).Marshal(), byte(SighashAll))
+	newTx.TxIns[0].ScriptSig = new(script.Script).AddBytes([]byte{}, sig1, sig2, multisigRaw)
+
+	// P2SH-P2WPKH
+	sighash, _ = newTx.SighashBip143(1, p2wpkh, prevTx.TxOuts[1].Amount, SighashAll)
+	sig := append(priv1.Sign(sighash[:]).Marshal(), byte(SighashAll))
+	newTx.TxIns[1].ScriptSig = new(script.Script).AddBytes(p2wpkhRaw)
+	newTx.TxIns[1].Witness = [][]byte{sig, pub1}
+
+	if ok, err := newTx.Verify(); err != nil || !ok {
+		t.Errorf("FAIL: %v", err)
+	}
+
+	// the signatures in the wrong order
+	newTx.TxIns[0].ScriptSig = new(script.Script).AddBytes([]byte{}, sig2, sig1, multisigRaw)
+	if ok, err := newTx.VerifyInput(0); ok || err != nil {
+		t.Errorf("FAIL")
+	}
+	if err := newTx.VerifyInputErr(0); !errors.Is(err, script.ErrEvalFalse) {
+		t.Errorf("FAIL: %v", err)
+	}
+	// the redeem script does not match the hash
+	newTx.TxIns[1].ScriptSig = new(script.Script).AddBytes(multisigRaw)
+	if ok, _ := newTx.VerifyInput(1); ok {
+		t.Errorf("FAIL")
+	}
+	if err := newTx.VerifyInputErr(1); !errors.Is(err, script.ErrEvalFalse) {
+		t.Errorf("FAIL: %v", err)
+	}
+}
+
+func TestVerifyP2WSH(t *testing.T) {
+	priv1 := ecdsa.GenerateKeyFromSecret(elliptic.Secp256k1, big.NewInt(1))
+	priv2 := ecdsa.GenerateKeyFromSecret(elliptic.Secp256k1, big.NewInt(2))
+	pub1, pub2 := priv1.PublicKey.MarshalCompressed(), priv2.PublicKey.MarshalCompressed()
+	witnessScript, _ := script.NewMultisigScript(2, [][]byte{pub1, pub2})
+	raw, _ := witnessScript.Raw()
+
+	prevTx := Tx{Version: 1, TxOuts: []TxOut{
+		{Amount: 100000, ScriptPubKey: script.NewP2WSHScript(hash.Sha256(raw))},
+	}}
+	prevTxId, _ := prevTx.Id()
+	cache[prevTxId] = prevTx
+	var prevTxIdBytes [32]byte
+	b, _ := hex.DecodeString(prevTxId)
+	copy(prevTxIdBytes[:], b)
+
+	newTx := Tx{
+		Version: 1,
+		TxIns:   []TxIn{{PrevTxId: prevTxIdBytes, PrevIndex: 0, Sequence: 0xffffffff}},
+		TxOuts:  []TxOut{{Amount: 90000, ScriptPubKey: script.NewP2PKHScript(hash.Hash160(pub2))}},
+		SegWit:  true,
+	}
+	sighash, _ := newTx.SighashBip143(0, witnessScript, prevTx.TxOuts[0].Amount, SighashAll)
+	sig1 := append(priv1.Sign(sighash[:]).Marshal(), byte(SighashAll))
+	sig2 := append(priv2.Sign(sighash[:]).Marshal(), byte(SighashAll))
+	newTx.TxIns[0].Witness = [][]byte{{}, sig1, sig2, raw}
+	if ok, err := newTx.Verify(); err != nil || !ok {
+		t.Errorf("FAIL: %v", err)
+	}
+
+	newTx.TxIns[0].Witness = [][]byte{{}, sig1, raw}
+	if ok, _ := newTx.VerifyInput(0); ok {
+		t.Errorf("FAIL")
+	}
+}
+
+func TestVerifyTimelock(t *testing.T) {
+	// a refund script: <100> OP_CHECKLOCKTIMEVERIFY OP_DROP <pubkey> OP_CHECKSIG
+	priv := ecdsa.GenerateKeyFromSecret(elliptic.Secp256k1, big.NewInt(1))
+	pub := priv.PublicKey.MarshalCompressed()
+	raw := append([]byte{0x01, 100, byte(script.OP_CHECKLOCKTIMEVERIFY), byte(script.OP_DROP), 33}, pub...)
+	raw = append(raw, byte(script.OP_CHECKSIG))
+	witnessScript, _ := script.ParseRaw(raw)
+
+	prevTx := Tx{Version: 1, TxOuts: []TxOut{
+		{Amount: 100000, ScriptPubKey: script.NewP2WSHScript(hash.Sha256(raw))},
+	}}
+	prevTxId, _ := prevTx.Id()
+	cache[prevTxId] = prevTx
+	var prevTxIdBytes [32]byte
+	b, _ := hex.DecodeString(prevTxId)
+	copy(prevTxIdBytes[:], b)
+
+	for _, tc := range []struct {
+		lockTime, sequence uint32
+		want               bool
+	}{
+		{100, 0, true},
+		{99, 0, false},
+		{100, 0xffffffff, false},
+	} {
+		newTx := Tx{
+			Version:  1,
+			TxIns:    []TxIn{{PrevTxId: prevTxIdBytes, PrevIndex: 0, Sequence: tc.sequence}},
+			TxOuts:   []TxOut{{Amount: 90000, ScriptPubKey: script.NewP2PKHScript(hash.Hash160(pub))}},
+			LockTime: tc.lockTime,
+			SegWit:   true,
+		}
+		sighash, _ := newTx.SighashBip143(0, witnessScript, prevTx.TxOuts[0].Amount, SighashAll)
+		sig := append(priv.Sign(sighash[:]).Marshal(), byte(SighashAll))
+		newTx.TxIns[0].Witness = [][]byte{sig, raw}
+		if ok, err := newTx.VerifyInput(0); err != nil || ok != tc.want {
+			t.Errorf("FAIL: %v", err)
+		}
+	}
+}
+
+func TestSighashTaproot(t *testing.T) {
+	// the signatures of the BIP371 test vectors, with SIGHASH_DEFAULT
+	decode := func(s string) []byte {
+		b, _ := hex.DecodeString(s)
+		return b
+	}
+	for _, tc := range []struct {
+		tx, prevout string
+		sigs        []struct{ pubKey, leafScript, sig string }
+	}{
+		{
+			"020000000127744ababf3027fe0d6cf23a96eee2efb188ef52301954585883e69b6624b2420000000000ffffffff0148e6052a01000000160014768e1eeb4cf420866033f80aceff0f972074496900000000",
+			"00f2052a010000002251205a2c2cf5b52cf31f83ad2e8da63ff03183ecd8f609c7510ae8a48e03910a0757",
+			[]struct{ pubKey, leafScript, sig string }{
+				// key path
+				{"5a2c2cf5b52cf31f83ad2e8da63ff03183ecd8f609c7510ae8a48e03910a0757", "", "bb53ec917bad9d906af1ba87181c48b86ace5aae2b53605a725ca74625631476fc6f5baedaf4f2ee0f477f36f58f3970d5b8273b7e497b97af2e3f125c97af34"},
+			},
+		},
+		{
+			"02000000019bd48765230bf9a72e662001f972556e54f0c6f97feb56bcb5600d817f6995260100000000ffffffff0148e6052a0100000022512083698e458c6664e1595d75da2597de1e22ee97d798e706c4c0a4b5a9823cd74300000000",
+			"00f2052a01000000225120c2247efbfd92ac47f6f40b8d42d169175a19fa9fa10e4a25d7f35eb4dd85b692",
+			[]struct{ pubKey, leafScript, sig string }{
+				// script path, the leaves are <pubkey> OP_CHECKSIG
+				{"2cb13ac68248de806aa6a3659cf3c03eb6821d09c8114a4e868febde865bb6d2", "202cb13ac68248de806aa6a3659cf3c03eb6821d09c8114a4e868febde865bb6d2ac", "bf818d9757d6ffeb538ba057fb4c1fc4e0f5ef186e765beb564791e02af5fd3d5e2551d4e34e33d86f276b82c99c79aed3f0395a081efcd2cc2c65dd7e693d79"},
+				{"4320b0bf16f011b53ea7be615924aa7f27e5d29ad20ea1155d848676c3bad1b2", "204320b0bf16f011b53ea7be615924aa7f27e5d29ad20ea1155d848676c3bad1b2ac", "e1f1ab6fabfa26b236f21833719dc1d428ab768d80f91f9988d8abef47bfb863bb1f2a529f768c15f00ce34ec283cdc07e88f8428be28f6ef64043c32911811a"},
+				{"fa0f7a3cef3b1d0c0a6ce7d26e17ada0b2e5c92d19efad48b41859cb8a451ca9", "20fa0f7a3cef3b1d0c0a6ce7d26e17ada0b2e5c92d19efad48b41859cb8a451ca9ac", "ec1f0379206461c83342285423326708ab031f0da4a253ee45aafa5b8c92034d8b605490f8cd13e00f989989b97e215faa36f12dee3693d2daccf3781c1757f6"},
+			},
+		},
+	} {
+		var newTx Tx
+		newTx.Unmarshal(bytes.NewReader(decode(tc.tx)))
+		var prevout TxOut
+		prevout.Unmarshal(bytes.NewReader(decode(tc.prevout)))
+
+		for _, sig := range tc.sigs {
+			var leafHash []byte
+			if sig.leafScript != "" {
+				s, _ := script.ParseRaw(decode(sig.leafScript))
+				h, _ := script.TapLeafHash(script.TapscriptLeafVersion, s)
+				leafHash = h[:]
+			}
+			sighash, err := newTx.SighashTaproot(0, []TxOut{prevout}, SighashDefault, leafHash, nil)
+			if err != nil || !schnorr.Verify(decode(sig.pubKey), sighash[:], decode(sig.sig)) {
+				t.Errorf("FAIL")
+			}
+		}
+	}
+}
+
+func TestSighashTaprootKeyPath(t *testing.T) {
+	// the keyPathSpending vectors of BIP341, bip-0341/wallet-test-vectors.json
+	decode := func(s string) []byte {
+		b, _ := hex.DecodeString(s)
+		return b
+	}
+	var newTx Tx
+	newTx.Unmarshal(bytes.NewReader(decode("02000000097de20cbff686da83a54981d2b9bab3586f4ca7e48f57f5b55963115f3b334e9c010000000000000000d7b7cab57b1393ace2d064f4d4a2cb8af6def61273e127517d44759b6dafdd990000000000fffffffff8e1f583384333689228c5d28eac13366be082dc57441760d957275419a418420000000000fffffffff0689180aa63b30cb162a73c6d2a38b7eeda2a83ece74310fda0843ad604853b0100000000feffffffaa5202bdf6d8ccd2ee0f0202afbbb7461d9264a25e5bfd3c5a52ee1239e0ba6c0000000000feffffff956149bdc66faa968eb2be2d2faa29718acbfe3941215893a2a3446d32acd050000000000000000000e664b9773b88c09c32cb70a2a3e4da0ced63b7ba3b22f848531bbb1d5d5f4c94010000000000000000e9aa6b8e6c9de67619e6a3924ae25696bb7b694bb677a632a74ef7eadfd4eabf0000000000ffffffffa778eb6a263dc090464cd125c466b5a99667720b1c110468831d058aa1b82af10100000000ffffffff0200ca9a3b000000001976a91406afd46bcdfd22ef94ac122aa11f241244a37ecc88ac807840cb0000000020ac9a87f5594be208f8532db38cff670c450ed2fea8fcdefcc9a663f78bab962b0065cd1d")))
+	var prevouts []TxOut
+	for _, utxo := range []struct {
+		scriptPubKey string
+		amount       btcutil.Amount
+	}{
+		{"512053a1f6e454df1aa2776a2814a721372d6258050de330b3c6d10ee8f4e0dda343", 420000000},
+		{"5120147c9c57132f6e7ecddba9800bb0c4449251c92a1e60371ee77557b6620f3ea3", 462000000},
+		{"76a914751e76e8199196d454941c45d1b3a323f1433bd688ac", 294000000},
+		{"5120e4d810fd50586274face62b8a807eb9719cef49c04177cc6b76a9a4251d5450e", 504000000},
+		{"512091b64d5324723a985170e4dc5a0f84c041804f2cd12660fa5dec09fc21783605", 630000000},
+		{"00147dd65592d0ab2fe0d0257d571abf032cd9db93dc", 378000000},
+		{"512075169f4001aa68f15bbed28b218df1d0a62cbbcf1188c6665110c293c907b831", 672000000},
+		{"5120712447206d7a5238acc7ff53fbe94a3b64539ad291c7cdbc490b7577e4b17df5", 546000000},
+		{"512077e30a5522dd9f894c3f8b8bd4c4b2cf82ca7da8a3ea6a239655c39c050ab220", 588000000},
+	} {
+		s, _ := script.ParseRaw(decode(utxo.scriptPubKey))
+		prevouts = append(prevouts, TxOut{Amount: utxo.amount, ScriptPubKey: s})
+	}
+
+	// the intermediary hashes, shared by the inputs without SIGHASH_ANYONECANPAY
+	hashAmounts := shaAmounts(prevouts)
+	hashScriptPubKeys, err := shaScriptPubKeys(prevouts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashOutputs, err := newTx.shaOutputs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashPrevouts := newTx.shaPrevouts()
+	hashSequences := newTx.shaSequence()
+	for _, tc := range []struct {
+		got  [32]byte
+		want string
+	}{
+		{hashAmounts, "58a6964a4f5f8f0b642ded0a8a553be7622a719da71d1f5befcefcdee8e0fde6"},
+		{hashOutputs, "a2e6dab7c1f0dcd297c8d61647fd17d821541ea69c3cc37dcbad7f90d4eb4bc5"},
+		{hashPrevouts, "e3b33bb4ef3a52ad1fffb555c0d82828eb22737036eaeb02a235d82b909c4c3f"},
+		{hashScriptPubKeys, "23ad0f61ad2bca5ba6a7693f50fce988e17c3780bf2b1e720cfbb38fbdd52e21"},
+		{hashSequences, "18959c7221ab5ce9e26c3cd67b22c24f8baa54bac281d8e6b05e400e6c3a957e"},
+	} {
+		if hex.EncodeToString(tc.got[:]) != tc.want {
+			t.Errorf("FAIL: %x", tc.got)
+		}
+	}
+
+	for _, tc := range []struct {
+		index    int
+		hashType uint32
+		sigHash  string
+	}{
+		{0, SighashSingle, "2514a6272f85cfa0f45eb907fcb0d121b808ed37c6ea160a5a9046ed5526d555"},
+		{1, SighashSingle | SighashAnyoneCanPay, "325a644af47e8a5a2591cda0ab0723978537318f10e6a63d4eed783b96a71a4d"},
+		{3, SighashAll, "bf013ea93474aa67815b1b6cc441d23b64fa310911d991e713cd34c7f5d46669"},
+		{4, SighashDefault, "4f900a0bae3f1446fd48490c2958b5a023228f01661cda3496a11da502a7f7ef"},
+		{6, SighashNone, "15f25c298eb5cdc7eb1d638dd2d45c97c4c59dcaec6679cfc16ad84f30876b85"},
+		{7, SighashNone | SighashAnyoneCanPay, "cd292de50313804dabe4685e83f923d2969577191a3e1d2882220dca88cbeb10"},
+		{8, SighashAll | SighashAnyoneCanPay, "cccb739eca6c13a8a89e6e5cd317ffe55669bbda23f2fd37b0f18755e008edd2"},
+	} {
+		sighash, err := newTx.SighashTaproot(tc.index, prevouts, tc.hashType, nil, nil)
+		if err != nil || hex.EncodeToString(sighash[:]) != tc.sigHash {
+			t.Errorf("FAIL: input %d: %x %v", tc.index, sighash, err)
+		}
+	}
+}
+
+func TestSignTaproot(t *testing.T) {
+	priv := ecdsa.GenerateKeyFromSecret(elliptic.Secp256k1, big.NewInt(6))
+	outputKey, _, _ := script.TaprootOutputKey(schnorr.XOnly(&priv.PublicKey), nil)
+	prevOut := OutPoint{TxId: [32]byte{1}, Index: 1}
+	fetcher := PrevOutMap{
+		prevOut:             {Amount: 100000, ScriptPubKey: script.NewP2TRScript(outputKey)},
+		{TxId: [32]byte{2}}: {Amount: 50000, ScriptPubKey: script.NewP2PKHScript(hash.Hash160(priv.PublicKey.MarshalCompressed()))},
+	}
+	defer func(f PrevOutFetcher) { DefaultFetcher = f }(DefaultFetcher)
+	DefaultFetcher = fetcher
+
+	newTx := Tx{
+		Version: 2,
+		TxIns: []TxIn{
+			{PrevTxId: prevOut.TxId, PrevIndex: prevOut.Index, Sequence: 0xffffffff},
+			{PrevTxId: [32]byte{2}, Sequence: 0xffffffff},
+		},
+		TxOuts: []TxOut{{Amount: 140000, ScriptPubKey: script.NewP2TRScript(outputKey)}},
+	}
+	if _, err := newTx.PrevOuts(PrevOutMap{}); err == nil {
+		t.Errorf("FAIL")
+	}
+	if ok, err := newTx.SignInput(0, priv, SighashDefault); err != nil || !ok {
+		t.Errorf("FAIL: %v", err)
+	}
+	if !newTx.SegWit || len(newTx.TxIns[0].Witness) != 1 || len(newTx.TxIns[0].Witness[0]) != 64 {
+		t.Errorf("FAIL")
+	}
+	// the explicit hash types are appended to the signature
+	if ok, err := newTx.SignInput(0, priv, SighashSingle|SighashAnyoneCanPay); err != nil || !ok {
+		t.Errorf("FAIL: %v", err)
+	}
+	if w := newTx.TxIns[0].Witness; len(w) != 1 || len(w[0]) != 65 || w[0][64] != 0x83 {
+		t.Errorf("FAIL")
+	}
+	newTx.SignInput(0, priv, SighashDefault)
+
+	// the signature commits to the amounts of all of the inputs
+	fetcher[OutPoint{TxId: [32]byte{2}}] = TxOut{Amount: 50001, ScriptPubKey: fetcher[OutPoint{TxId: [32]byte{2}}].ScriptPubKey}
+	if ok, _ := newTx.VerifyInput(0); ok {
+		t.Errorf("FAIL")
+	}
+	// the hash type can't be explicitly SIGHASH_DEFAULT
+	newTx.TxIns[0].Witness[0] = append(newTx.TxIns[0].Witness[0], 0)
+	if ok, _ := newTx.VerifyInput(0); ok {
+		t.Errorf("FAIL")
+	}
+}